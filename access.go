@@ -0,0 +1,22 @@
+package cdb
+
+import (
+	"time"
+)
+
+// AccessHook is called after every lookup made with Get, Lookup,
+// GetInto, GetAll or GetRange; see WithAccessHook.
+type AccessHook func(key []byte, found bool, bytes int, dur time.Duration)
+
+// WithAccessHook makes the reader call fn after every lookup with the
+// key looked up, whether it was found, the length of its value and how
+// long the lookup took, e.g. to feed lookup telemetry into a logging
+// pipeline or to find the keys that are never read. Lookups that fail
+// with an error are reported as not found. fn is called by the
+// goroutine that made the lookup, so it must be safe for concurrent use
+// and should be quick; key is only valid until it returns.
+func WithAccessHook(fn AccessHook) Option {
+	return func(o *options) {
+		o.accessHook = fn
+	}
+}
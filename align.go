@@ -0,0 +1,111 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// largest alignment accepted by WithAlignment
+const maxAlignment = 1 << 20
+
+// WithAlignment makes the writer pad the data section so that every
+// value starts at a multiple of n bytes from the start of the file,
+// e.g. 4096 for O_DIRECT reads of the values or mmap slices that start
+// on a page. n must be a power of two no larger than 1MiB; 0 or 1 turn
+// alignment off. With WithExpiry, it is the value proper that is
+// aligned, not its expiry time. Values stored in a blob file and empty
+// values are not aligned. It can't be used with WithFrontCoding.
+//
+// The padding is stored as filler records that aren't in the hash
+// tables; readers that don't understand the trailer see them as
+// records with an empty key when they iterate over the database.
+func WithAlignment(n int) Option {
+	return func(o *options) {
+		o.alignment = n
+	}
+}
+
+// checkAlignment validates the alignment asked for with WithAlignment
+func (o *options) checkAlignment() error {
+	n := o.alignment
+	if n <= 1 {
+		return nil
+	}
+	if n > maxAlignment || n&(n-1) != 0 {
+		return fmt.Errorf("cdb: alignment %d isn't a power of two up to %d", n, maxAlignment)
+	}
+	if o.front {
+		return fmt.Errorf("cdb: WithAlignment can't be used with WithFrontCoding")
+	}
+	return nil
+}
+
+// pad writes a filler record, if one is needed for the value of the
+// next record, preceded by n bytes of key and header, to start at the
+// alignment. A filler is at least 8 bytes long, so it may take up to
+// one more alignment.
+func (cdb *Writer) pad(n int64) error {
+	a := int64(cdb.trailer.alignment)
+	p := (a - (cdb.bufferedOffset+8+n)%a) % a
+	if p == 0 {
+		return nil
+	}
+	if p < 8 {
+		p += a
+	}
+
+	if (cdb.bufferedOffset + p + cdb.estimatedFooterSize + 16) > math.MaxUint32 {
+		return ErrTooMuchData
+	}
+
+	err := writeTuple(cdb.bufferedWriter, 0, uint32(p-8))
+	if err != nil {
+		return writeErr(StageData, cdb.bufferedOffset, err)
+	}
+
+	_, err = cdb.bufferedWriter.Write(make([]byte, p-8))
+	if err != nil {
+		return writeErr(StageData, cdb.bufferedOffset, err)
+	}
+
+	cdb.trailer.padding = append(cdb.trailer.padding, uint32(cdb.bufferedOffset))
+	cdb.bufferedOffset += p
+	cdb.estimatedFooterSize += 4
+	return nil
+}
+
+// isPadding returns true if the record at off is a filler written for
+// WithAlignment
+func (cdb *CDB) isPadding(off uint32) bool {
+	return hasOffset(cdb.trailer.padding, off)
+}
+
+// marshalAlignment returns the payload of the alignment section: the
+// alignment, then the offsets of the filler records.
+func marshalAlignment(align uint32, padding []uint32) []byte {
+	b := make([]byte, 4+4*len(padding))
+	binary.LittleEndian.PutUint32(b, align)
+	for i, off := range padding {
+		binary.LittleEndian.PutUint32(b[4+4*i:], off)
+	}
+	return b
+}
+
+func unmarshalAlignment(t *trailer, b []byte) error {
+	if len(b) < 4 || len(b)%4 != 0 {
+		return fmt.Errorf("malformed alignment section")
+	}
+
+	a := binary.LittleEndian.Uint32(b)
+	if a < 2 || a > maxAlignment || a&(a-1) != 0 {
+		return fmt.Errorf("alignment %d isn't a power of two up to %d", a, maxAlignment)
+	}
+
+	t.alignment = a
+	t.padding = make([]uint32, len(b)/4-1)
+	for i := range t.padding {
+		t.padding[i] = binary.LittleEndian.Uint32(b[4+4*i:])
+	}
+	return nil
+}
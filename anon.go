@@ -0,0 +1,146 @@
+package cdb
+
+import (
+	"errors"
+	"os"
+)
+
+// CreateAnonymous creates a database that has no name until it is
+// published, so that a build that crashes or is abandoned never leaves
+// a partial file behind. Finalize it with Freeze, then give it a name
+// with CDB.LinkAt; Close or Abort discard it, as does closing the
+// frozen database without linking it.
+//
+// On Linux the database is an O_TMPFILE file in dir, and LinkAt a
+// single atomic linkat(2). Where O_TMPFILE is not available (other
+// systems, or filesystems that don't support it), it is a hidden
+// temporary file in dir that is removed when the database is
+// discarded, and survives only a crash. With WithBlobs, the blob file
+// is anonymous as well. opts are as for Create.
+func CreateAnonymous(dir string, opts ...Option) (*Writer, error) {
+	a := &anonymous{}
+
+	var err error
+	a.db, err = createAnon(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if o := makeOptions(opts); o.blobThreshold > 0 && o.blobWriter == nil {
+		a.blob, err = createAnon(dir)
+		if err != nil {
+			a.db.f.Close()
+			a.discard()
+			return nil, err
+		}
+		opts = append(opts[:len(opts):len(opts)], WithBlobWriter(a.blob.f))
+	}
+
+	w, err := NewWriter(a.db.f, nil, opts...)
+	if err != nil {
+		a.db.f.Close()
+		if a.blob != nil {
+			a.blob.f.Close()
+		}
+		a.discard()
+		return nil, err
+	}
+
+	if a.blob != nil {
+		w.blob.owned = a.blob.f
+	}
+	w.anon = a
+	return w, nil
+}
+
+// LinkAt publishes a database frozen from a writer made by
+// CreateAnonymous at path, and its blob file at path.blob. It fails if
+// path exists; link to a temporary name and rename it over the old
+// database to replace one. The database can be linked more than once,
+// and stays open for reads.
+func (cdb *CDB) LinkAt(path string) error {
+	if cdb.anon == nil {
+		return errors.New("cdb: LinkAt needs a database frozen from CreateAnonymous")
+	}
+	return cdb.anon.link(path)
+}
+
+// anonymous holds the files of a writer made by CreateAnonymous
+type anonymous struct {
+	db   *anonFile
+	blob *anonFile
+}
+
+// link names the database path, and its blob file path.blob
+func (a *anonymous) link(path string) error {
+	if a.blob != nil {
+		if err := a.blob.link(path + ".blob"); err != nil {
+			return err
+		}
+	}
+
+	err := a.db.link(path)
+	if err != nil && a.blob != nil {
+		os.Remove(path + ".blob")
+	}
+	return err
+}
+
+// discard removes the temporary files that stand in for anonymous ones
+// if they weren't linked; the files must be closed first.
+func (a *anonymous) discard() error {
+	var err error
+	if a.blob != nil {
+		err = a.blob.discard()
+	}
+	return errors.Join(a.db.discard(), err)
+}
+
+// anonFile is an O_TMPFILE file, or a temporary file standing in for one
+type anonFile struct {
+	f *os.File
+
+	// name of the temporary file; empty for an O_TMPFILE one
+	tmp string
+
+	// the temporary file has been linked and must be kept
+	linked bool
+}
+
+func createAnon(dir string) (*anonFile, error) {
+	f, err := openTmpfile(dir)
+	if err == nil {
+		return &anonFile{f: f}, nil
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return nil, err
+	}
+
+	f, err = os.CreateTemp(dir, ".cdb-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &anonFile{f: f, tmp: f.Name()}, nil
+}
+
+func (a *anonFile) link(path string) error {
+	if a.tmp == "" {
+		return linkTmpfile(a.f, path)
+	}
+
+	if err := os.Link(a.tmp, path); err != nil {
+		return err
+	}
+	a.linked = true
+	return nil
+}
+
+func (a *anonFile) discard() error {
+	if a.tmp == "" || a.linked {
+		return nil
+	}
+
+	err := os.Remove(a.tmp)
+	a.tmp, a.linked = "", true
+	return err
+}
@@ -0,0 +1,56 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// open(2) flag for an unnamed file in a directory: __O_TMPFILE
+	// with O_DIRECTORY, as on most architectures
+	oTmpfile = 0x400000 | syscall.O_DIRECTORY
+
+	// linkat(2): paths are relative to the working directory
+	atFdcwd = -0x64
+
+	// linkat(2) flag: follow oldpath if it is a symbolic link
+	atSymlinkFollow = 0x400
+)
+
+// openTmpfile opens an O_TMPFILE file in dir. It returns
+// errors.ErrUnsupported if the kernel or the filesystem of dir can't
+// make one.
+func openTmpfile(dir string) (*os.File, error) {
+	f, err := os.OpenFile(dir, os.O_RDWR|oTmpfile, 0600)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.EISDIR) || errors.Is(err, syscall.EINVAL) {
+		return nil, errors.ErrUnsupported
+	}
+	return f, err
+}
+
+// linkTmpfile gives the O_TMPFILE file f the name path. Linking the
+// descriptor itself needs CAP_DAC_READ_SEARCH; linking its /proc entry
+// doesn't.
+func linkTmpfile(f *os.File, path string) error {
+	old, err := syscall.BytePtrFromString(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+	if err != nil {
+		return err
+	}
+	nw, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+
+	cwd := atFdcwd
+	_, _, e := syscall.Syscall6(syscall.SYS_LINKAT, uintptr(cwd), uintptr(unsafe.Pointer(old)),
+		uintptr(cwd), uintptr(unsafe.Pointer(nw)), atSymlinkFollow, 0)
+	runtime.KeepAlive(f)
+	if e != 0 {
+		return &os.LinkError{Op: "linkat", Old: f.Name(), New: path, Err: e}
+	}
+	return nil
+}
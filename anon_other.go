@@ -0,0 +1,18 @@
+//go:build !linux
+
+package cdb
+
+import (
+	"errors"
+	"os"
+)
+
+// openTmpfile returns errors.ErrUnsupported; there is no O_TMPFILE here.
+func openTmpfile(dir string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// linkTmpfile is never called without O_TMPFILE.
+func linkTmpfile(f *os.File, path string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,158 @@
+package cdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrBatchDone is returned by the methods of a Batch after Commit or
+// Discard.
+var ErrBatchDone = errors.New("cdb: batch already committed or discarded")
+
+// Batch stages records to be added to a database together; see
+// Writer.Batch.
+type Batch struct {
+	w    *Writer
+	recs []heldRecord
+	done bool
+}
+
+// Batch returns a Batch whose records are added to the database only
+// when it is committed, so that a logical unit of several records
+// that fails part way, e.g. because one of its values doesn't pass
+// WithSchema, can be dropped with Discard instead of leaving half its
+// records in the database. The records are held in memory until then.
+//
+// A Batch is not safe for concurrent use, and neither is its writer:
+// records can be added to the writer directly while a batch is being
+// staged, but Commit must not be called concurrently with them.
+func (cdb *Writer) Batch() *Batch {
+	return &Batch{w: cdb}
+}
+
+// Put stages a key/value pair; see Writer.Put. The key and value are
+// copied.
+func (b *Batch) Put(key, value []byte) error {
+	var hdr []byte
+	if b.w.trailer.expiry {
+		hdr = make([]byte, expirySize)
+	}
+	return b.stage(key, hdr, value, false)
+}
+
+// PutTTL stages a key/value pair that expires at expiresAt; see
+// Writer.PutTTL.
+func (b *Batch) PutTTL(key, value []byte, expiresAt time.Time) error {
+	if !b.w.trailer.expiry {
+		return ErrNoExpiry
+	}
+
+	hdr := make([]byte, expirySize)
+	if !expiresAt.IsZero() {
+		binary.LittleEndian.PutUint64(hdr, uint64(expiresAt.Unix()))
+	}
+	return b.stage(key, hdr, value, false)
+}
+
+// Delete stages a tombstone for key; see Writer.Delete.
+func (b *Batch) Delete(key []byte) error {
+	return b.stage(key, nil, nil, true)
+}
+
+func (b *Batch) stage(key, hdr, value []byte, tombstone bool) error {
+	if b.done {
+		return ErrBatchDone
+	}
+	if !tombstone {
+		if err := b.w.checkSchema(key, value); err != nil {
+			return err
+		}
+	}
+
+	key = b.w.storeKey(key)
+	b.recs = append(b.recs, heldRecord{
+		hash:      b.w.hasher(key),
+		key:       bytes.Clone(key),
+		hdr:       hdr,
+		value:     bytes.Clone(value),
+		tombstone: tombstone,
+	})
+	return nil
+}
+
+// Len returns the number of records staged.
+func (b *Batch) Len() int {
+	return len(b.recs)
+}
+
+// Commit adds the staged records to the database, in the order they
+// were staged. If they would make the database too large, Commit
+// returns ErrTooMuchData and adds none of them. If writing fails once
+// some of them have been added, the writer fails as well: the database
+// can't be finalized with half the batch, and should be aborted.
+func (b *Batch) Commit() error {
+	if b.done {
+		return ErrBatchDone
+	}
+	b.done = true
+
+	recs := b.recs
+	b.recs = nil
+
+	cdb := b.w
+	if cdb.failed != nil {
+		return cdb.failed
+	}
+	if err := cdb.fits(recs); err != nil {
+		return err
+	}
+
+	for i := range recs {
+		r := &recs[i]
+
+		var err error
+		if r.tombstone {
+			err = cdb.deleteHashed(r.hash, r.key)
+		} else {
+			err = cdb.putHashed(r.hash, r.key, r.hdr, r.value)
+		}
+		if err != nil && i > 0 {
+			cdb.failed = fmt.Errorf("cdb: batch failed after %d of %d records: %w", i, len(recs), err)
+			return cdb.failed
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard drops the staged records.
+func (b *Batch) Discard() {
+	b.done = true
+	b.recs = nil
+}
+
+// fits returns ErrTooMuchData unless recs can be added to the database
+// without exceeding its size limit. Like putRecord, it counts the
+// trailer, and an alignment filler for every record.
+func (cdb *Writer) fits(recs []heldRecord) error {
+	n := cdb.bufferedOffset + cdb.estimatedFooterSize + 16
+	for _, r := range recs {
+		n += 8 + int64(len(r.key)+len(r.hdr)+len(r.value)) + 16 + 8
+		if a := int64(cdb.trailer.alignment); a != 0 {
+			n += a + 8
+		}
+		if cdb.trailer.crcs != nil {
+			n += 8
+		}
+	}
+	if n > math.MaxUint32 {
+		return ErrTooMuchData
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package bench_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cdb"
+	"cdb/bench"
+)
+
+var (
+	records  = flag.Int("records", 100000, "number of records in the dataset")
+	keyLen   = flag.Int("keylen", 16, "key length")
+	valueLen = flag.Int("vallen", 64, "value length")
+	keys     = flag.String("keys", "random", "key distribution: random, sequential or paths")
+	skew     = flag.Float64("skew", 0, "Zipf exponent for picking keys to look up; 0 for uniform")
+	seed     = flag.Uint64("seed", 1, "dataset seed")
+)
+
+// the database layouts to measure
+var variants = []struct {
+	name string
+	opts []cdb.Option
+}{
+	{"default", nil},
+	{"bloom", []cdb.Option{cdb.WithBloom(10)}},
+	{"mph", []cdb.Option{cdb.WithMPH()}},
+	{"front", []cdb.Option{cdb.WithFrontCoding()}},
+}
+
+var dir string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	var err error
+	dir, err = os.MkdirTemp("", "cdb-bench")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't make a temp dir: %s\n", err)
+		os.Exit(1)
+	}
+
+	rc := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(rc)
+}
+
+func dataset(tb testing.TB) *bench.Dataset {
+	kd, err := bench.ParseKeyDist(*keys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &bench.Dataset{
+		Records:  *records,
+		KeyLen:   *keyLen,
+		ValueLen: *valueLen,
+		Keys:     kd,
+		Seed:     *seed,
+	}
+}
+
+// database returns the path of the dataset built as variant name,
+// building it on first use.
+func database(b *testing.B, d *bench.Dataset, name string, opts []cdb.Option) string {
+	fn := filepath.Join(dir, name+".cdb")
+	if _, err := os.Stat(fn); err == nil {
+		return fn
+	}
+
+	b.StopTimer()
+	defer b.StartTimer()
+	if _, err := d.Build(fn, opts...); err != nil {
+		b.Fatalf("Can't build %s: %s", fn, err)
+	}
+	return fn
+}
+
+func BenchmarkGet(b *testing.B) {
+	d := dataset(b)
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			db, err := cdb.Open(database(b, d, v.name, v.opts))
+			if err != nil {
+				b.Fatalf("Can't open db: %s", err)
+			}
+			defer db.Close()
+
+			p := d.NewPicker(*skew)
+			ks := make([][]byte, 4096)
+			for i := range ks {
+				ks[i] = d.Key(p.Next())
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				v, err := db.Get(ks[i%len(ks)])
+				if err != nil || v == nil {
+					b.Fatalf("Can't get key %x: %v", ks[i%len(ks)], err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetParallel measures lookups from GOMAXPROCS goroutines
+// sharing one database, the way a server uses it.
+func BenchmarkGetParallel(b *testing.B) {
+	d := dataset(b)
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			db, err := cdb.Open(database(b, d, v.name, v.opts))
+			if err != nil {
+				b.Fatalf("Can't open db: %s", err)
+			}
+			defer db.Close()
+
+			p := d.NewPicker(*skew)
+			ks := make([][]byte, 4096)
+			for i := range ks {
+				ks[i] = d.Key(p.Next())
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					v, err := db.Get(ks[i%len(ks)])
+					if err != nil || v == nil {
+						b.Errorf("Can't get key %x: %v", ks[i%len(ks)], err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetMiss(b *testing.B) {
+	d := dataset(b)
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			db, err := cdb.Open(database(b, d, v.name, v.opts))
+			if err != nil {
+				b.Fatalf("Can't open db: %s", err)
+			}
+			defer db.Close()
+
+			// keys past the end of the dataset are absent
+			miss := *d
+			miss.Records = 2 * d.Records
+			ks := make([][]byte, 4096)
+			for i := range ks {
+				ks[i] = miss.Key(d.Records + i%d.Records)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := db.Get(ks[i%len(ks)])
+				if err != nil {
+					b.Fatalf("Can't get key %x: %s", ks[i%len(ks)], err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	d := dataset(b)
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			fn := database(b, d, v.name, v.opts)
+			db, err := cdb.Open(fn)
+			if err != nil {
+				b.Fatalf("Can't open db: %s", err)
+			}
+			defer db.Close()
+
+			if st, err := os.Stat(fn); err == nil {
+				b.SetBytes(st.Size())
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var n int
+				iter := db.Iter()
+				for iter.Next() {
+					n++
+				}
+				if err := iter.Err(); err != nil || n != d.Records {
+					b.Fatalf("Scan read %d of %d records: %v", n, d.Records, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	d := dataset(b)
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			fn := filepath.Join(b.TempDir(), "build.cdb")
+			for i := 0; i < b.N; i++ {
+				n, err := d.Build(fn, v.opts...)
+				if err != nil {
+					b.Fatalf("Can't build %s: %s", fn, err)
+				}
+				b.SetBytes(n)
+			}
+		})
+	}
+}
+
+// BenchmarkOpen measures opening a database, which reads the whole
+// file to verify its checksum unless skipped. The file is usually in
+// the page cache; drop it (e.g. with vmtouch -e) for true cold opens.
+func BenchmarkOpen(b *testing.B) {
+	d := dataset(b)
+	fn := database(b, d, "default", nil)
+	st, err := os.Stat(fn)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, verify := range []bool{true, false} {
+		var opts []cdb.Option
+		if !verify {
+			opts = append(opts, cdb.WithSkipVerify())
+		}
+
+		b.Run(fmt.Sprintf("verify=%v", verify), func(b *testing.B) {
+			b.SetBytes(st.Size())
+			for i := 0; i < b.N; i++ {
+				db, err := cdb.Open(fn, opts...)
+				if err != nil {
+					b.Fatalf("Can't open db: %s", err)
+				}
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestDataset(t *testing.T) {
+	for _, kd := range []bench.KeyDist{bench.KeysRandom, bench.KeysSequential, bench.KeysPaths} {
+		d := &bench.Dataset{Records: 1000, KeyLen: 16, ValueLen: 8, Keys: kd, Seed: 7}
+		fn := filepath.Join(t.TempDir(), kd.String()+".cdb")
+		if _, err := d.Build(fn, cdb.WithValidateOnClose()); err != nil {
+			t.Fatalf("%s: can't build: %s", kd, err)
+		}
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("%s: can't open: %s", kd, err)
+		}
+
+		// keys are distinct and regenerated identically
+		p := d.NewPicker(1.2)
+		for i := 0; i < 100; i++ {
+			j := p.Next()
+			v, err := db.Get(d.Key(j))
+			if err != nil || string(v) != string(d.Value(j)) {
+				t.Fatalf("%s: record %d: saw %q, %v", kd, j, v, err)
+			}
+		}
+		if n := db.Len(); n != d.Records {
+			t.Fatalf("%s: expected %d records, saw %d", kd, d.Records, n)
+		}
+		db.Close()
+	}
+}
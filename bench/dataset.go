@@ -0,0 +1,162 @@
+// Package bench generates reproducible datasets for measuring the cdb
+// reader and writer. A Dataset describes its records rather than
+// holding them: record i is computed from the seed, so the same config
+// builds the same database every time and benchmarks can pick keys at
+// random without keeping them in memory.
+//
+// The benchmarks live in this package's tests; the dataset is set with
+// flags, e.g.
+//
+//	go test ./bench -run '^$' -bench . -count 10 -records 1000000 -keys paths > new.txt
+//
+// Compare two runs with benchstat to see the effect of a change.
+package bench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+
+	"cdb"
+)
+
+// KeyDist describes the shape of the generated keys.
+type KeyDist int
+
+const (
+	// KeysRandom: random bytes; keys share nothing and hash well
+	KeysRandom KeyDist = iota
+
+	// KeysSequential: zero padded decimal numbers in increasing order
+	KeysSequential
+
+	// KeysPaths: URL like paths, with long shared prefixes
+	KeysPaths
+)
+
+var keyDists = []string{"random", "sequential", "paths"}
+
+func (k KeyDist) String() string {
+	if int(k) < len(keyDists) {
+		return keyDists[k]
+	}
+	return fmt.Sprintf("KeyDist(%d)", int(k))
+}
+
+// ParseKeyDist returns the KeyDist named s.
+func ParseKeyDist(s string) (KeyDist, error) {
+	for i, n := range keyDists {
+		if n == s {
+			return KeyDist(i), nil
+		}
+	}
+	return 0, fmt.Errorf("bench: unknown key distribution %q", s)
+}
+
+// Dataset describes a set of records.
+type Dataset struct {
+	// number of records
+	Records int
+
+	// key length for KeysRandom, and the minimum length for the others
+	KeyLen int
+
+	// value length
+	ValueLen int
+
+	Keys KeyDist
+	Seed uint64
+}
+
+// rng returns a generator for record i
+func (d *Dataset) rng(i int, stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(d.Seed^stream, uint64(i)))
+}
+
+// Key returns the key of record i.
+func (d *Dataset) Key(i int) []byte {
+	switch d.Keys {
+	case KeysSequential:
+		return fmt.Appendf(nil, "%0*d", d.KeyLen, i)
+
+	case KeysPaths:
+		r := d.rng(i/64, 1)
+		k := fmt.Appendf(nil, "https://host%d.example.com/%x/%x/", r.IntN(16), r.Uint32(), r.Uint32()&0xfff)
+		k = fmt.Appendf(k, "item-%d", i)
+		for len(k) < d.KeyLen {
+			k = append(k, '_')
+		}
+		return k
+
+	default:
+		k := make([]byte, max(d.KeyLen, 8))
+		r := d.rng(i, 2)
+		for j := 0; j < len(k); j += 8 {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], r.Uint64())
+			copy(k[j:], b[:])
+		}
+
+		// keep keys distinct whatever the generator does
+		binary.LittleEndian.PutUint32(k, uint32(i))
+		return k
+	}
+}
+
+// Value returns the value of record i.
+func (d *Dataset) Value(i int) []byte {
+	v := make([]byte, d.ValueLen)
+	r := d.rng(i, 3)
+	for j := range v {
+		v[j] = byte('a' + r.IntN(26))
+	}
+	return v
+}
+
+// Build writes the dataset to a new database at path, and returns the
+// number of bytes of keys and values written.
+func (d *Dataset) Build(path string, opts ...cdb.Option) (int64, error) {
+	wr, err := cdb.Create(path, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for i := 0; i < d.Records; i++ {
+		k, v := d.Key(i), d.Value(i)
+		if err := wr.Put(k, v); err != nil {
+			wr.Close()
+			return 0, err
+		}
+		n += int64(len(k) + len(v))
+	}
+	return n, wr.Close()
+}
+
+// Picker picks records at random for lookups.
+type Picker struct {
+	r    *rand.Rand
+	n    int
+	zipf *rand.Zipf
+}
+
+// NewPicker returns a Picker over the records of d. If skew is greater
+// than 1, records are picked with a Zipf distribution of that exponent,
+// so that a few are picked far more often than the rest; otherwise
+// every record is equally likely.
+func (d *Dataset) NewPicker(skew float64) *Picker {
+	r := rand.New(rand.NewPCG(d.Seed, 4))
+	p := &Picker{r: r, n: d.Records}
+	if skew > 1 && d.Records > 1 {
+		p.zipf = rand.NewZipf(r, skew, 1, uint64(d.Records-1))
+	}
+	return p
+}
+
+// Next returns the index of the next record.
+func (p *Picker) Next() int {
+	if p.zipf != nil {
+		return int(p.zipf.Uint64())
+	}
+	return p.r.IntN(p.n)
+}
@@ -0,0 +1,229 @@
+package cdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+)
+
+// WithBlobs makes the writer store values longer than threshold bytes
+// in a companion blob file instead of the database, which holds a 16
+// byte pointer in their place. This keeps the database small (and under
+// the 4GB limit) when some values are large, and the records and hash
+// tables compact and cache friendly.
+//
+// Create writes the blob file next to the database, as path+".blob";
+// NewWriter needs WithBlobWriter. The blob file is checksummed along
+// with the database. Open finds the blob file by the same name;
+// NewWithSize needs WithBlobReader. Reads resolve the pointers
+// transparently. Databases with blobs can't be read by older versions
+// of this package or other cdb tools.
+func WithBlobs(threshold int) Option {
+	return func(o *options) {
+		o.blobThreshold = threshold
+	}
+}
+
+// WithBlobWriter gives a writer created WithBlobs the blob file to
+// write to. If it is an io.ReaderAt (as *os.File is), it is also used
+// by Freeze and WithValidateOnClose. Writer.Close doesn't close it, but
+// closing the database returned by Freeze does.
+func WithBlobWriter(w io.Writer) Option {
+	return func(o *options) {
+		o.blobWriter = w
+	}
+}
+
+// WithBlobReader gives the reader the blob file of a database created
+// WithBlobs. Closing the database closes r if it is an io.Closer.
+func WithBlobReader(r io.ReaderAt) Option {
+	return func(o *options) {
+		o.blobReader = r
+	}
+}
+
+// blob pointers are the offset and length of the value in the blob file
+const blobPtrSize = 16
+
+// blobFile is where a Writer puts blobs
+type blobFile struct {
+	w   io.Writer
+	buf *bufio.Writer
+	sum hash.Hash
+	off int64
+
+	// set if the writer created the file and must close it
+	owned *os.File
+}
+
+func newBlobFile(w io.Writer, alg Checksum) (*blobFile, error) {
+	hh, err := newChecksum(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = w
+	if hh != nil {
+		out = io.MultiWriter(w, hh)
+	}
+
+	return &blobFile{w: w, sum: hh, buf: bufio.NewWriterSize(out, 65536)}, nil
+}
+
+// write appends value to the blob file and returns a pointer to it
+func (b *blobFile) write(value []byte) ([]byte, error) {
+	_, err := b.buf.Write(value)
+	if err != nil {
+		return nil, fmt.Errorf("cdb: write blob at offset %d: %w", b.off, err)
+	}
+
+	return b.advance(int64(len(value))), nil
+}
+
+// copy appends the n bytes read from r to the blob file and returns a
+// pointer to them. If r returns fewer, the blob file is unusable.
+func (b *blobFile) copy(r io.Reader, n int64) ([]byte, error) {
+	c, err := io.CopyN(b.buf, r, n)
+	if c != n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("cdb: write blob at offset %d: %w", b.off, err)
+	}
+	return b.advance(n), nil
+}
+
+// advance returns a pointer to the n bytes at the end of the blob file
+// and moves past them
+func (b *blobFile) advance(n int64) []byte {
+	ptr := make([]byte, blobPtrSize)
+	binary.LittleEndian.PutUint64(ptr[0:8], uint64(b.off))
+	binary.LittleEndian.PutUint64(ptr[8:16], uint64(n))
+	b.off += n
+	return ptr
+}
+
+// finish flushes the blob file and returns its size and checksum
+func (b *blobFile) finish() (int64, []byte, error) {
+	if err := b.buf.Flush(); err != nil {
+		return 0, nil, fmt.Errorf("cdb: write blob at offset %d: %w", b.off, err)
+	}
+
+	sum := make([]byte, checksumSize)
+	if b.sum != nil {
+		copy(sum, b.sum.Sum(nil))
+	}
+	return b.off, sum, nil
+}
+
+// reader returns the blob file for reading, or nil
+func (b *blobFile) reader() io.ReaderAt {
+	if b == nil {
+		return nil
+	}
+	ra, _ := b.w.(io.ReaderAt)
+	return ra
+}
+
+// blobInfo is the trailer section describing the blob file
+type blobInfo struct {
+	threshold uint32
+	size      int64
+	sum       []byte
+
+	// offsets of the records whose values are blob pointers, in
+	// increasing order
+	recs []uint32
+}
+
+// marshal encodes the threshold, size, checksum and record offsets
+func (bi *blobInfo) marshal() []byte {
+	buf := make([]byte, 12+checksumSize+4*len(bi.recs))
+	binary.LittleEndian.PutUint32(buf[0:4], bi.threshold)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(bi.size))
+	copy(buf[12:12+checksumSize], bi.sum)
+	for i, off := range bi.recs {
+		binary.LittleEndian.PutUint32(buf[12+checksumSize+4*i:], off)
+	}
+	return buf
+}
+
+func unmarshalBlobs(buf []byte) (*blobInfo, error) {
+	const hdr = 12 + checksumSize
+	if len(buf) < hdr || (len(buf)-hdr)%4 != 0 {
+		return nil, fmt.Errorf("malformed blob section")
+	}
+
+	bi := &blobInfo{
+		threshold: binary.LittleEndian.Uint32(buf[0:4]),
+		size:      int64(binary.LittleEndian.Uint64(buf[4:12])),
+		sum:       buf[12:hdr],
+		recs:      make([]uint32, (len(buf)-hdr)/4),
+	}
+	if bi.size < 0 {
+		return nil, fmt.Errorf("malformed blob section")
+	}
+	for i := range bi.recs {
+		bi.recs[i] = binary.LittleEndian.Uint32(buf[hdr+4*i:])
+	}
+	return bi, nil
+}
+
+// verifyBlobs checks the blob file r against its checksum
+func verifyBlobs(r io.ReaderAt, bi *blobInfo, alg Checksum) error {
+	hh, err := newChecksum(alg)
+	if err != nil || hh == nil {
+		return err
+	}
+
+	err = copyRange(hh, r, 0, bi.size)
+	if err != nil {
+		return fmt.Errorf("i/o error during blob checksum calculation: %s", err)
+	}
+
+	sum := make([]byte, checksumSize)
+	copy(sum, hh.Sum(nil))
+	if !bytes.Equal(sum, bi.sum) {
+		return fmt.Errorf("blob checksum failed. DB possibly corrupt!")
+	}
+	return nil
+}
+
+// isBlob returns true if the value of the record at off is a blob
+// pointer
+func (cdb *CDB) isBlob(off uint32) bool {
+	if cdb.trailer.blobs == nil {
+		return false
+	}
+	return hasOffset(cdb.trailer.blobs.recs, off)
+}
+
+// readBlob returns the value that ptr, the value of the record at off,
+// points to.
+func (cdb *CDB) readBlob(off uint32, ptr []byte) ([]byte, error) {
+	if cdb.blobs == nil {
+		return nil, fmt.Errorf("cdb: record at %d: no blob file", off)
+	}
+	if len(ptr) != blobPtrSize {
+		return nil, corruptAt(ErrBadRecord, int64(off), "malformed blob pointer").want(blobPtrSize, int64(len(ptr)))
+	}
+
+	boff := binary.LittleEndian.Uint64(ptr[0:8])
+	blen := binary.LittleEndian.Uint64(ptr[8:16])
+	size := uint64(cdb.trailer.blobs.size)
+	if boff > size || blen > size-boff || blen > math.MaxInt {
+		return nil, corruptAt(ErrBadRecord, int64(off), "blob extends outside the blob file").want(int64(size), int64(boff+blen))
+	}
+
+	v := make([]byte, blen)
+	_, err := cdb.blobs.ReadAt(v, int64(boff))
+	if err != nil {
+		return nil, fmt.Errorf("cdb: blob of record at %d: %w", off, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,122 @@
+package cdb_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"cdb"
+)
+
+func TestBlobs(t *testing.T) {
+	big := strings.Repeat("x", 1000)
+	recs := []kw{
+		{"small", "value"},
+		{"big", big},
+		{"big2", big + "y"},
+		{"edge", strings.Repeat("e", 64)},
+	}
+
+	wr, err := cdb.Create("./test/blob.cdb", cdb.WithBlobs(64), cdb.WithExpiry(), cdb.WithValidateOnClose())
+	if err != nil {
+		t.Fatalf("Can't create blob.cdb: %s", err)
+	}
+
+	for _, r := range recs {
+		err = wr.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close blob.cdb: %s", err)
+	}
+
+	// the large values went to the blob file
+	st, err := os.Stat("./test/blob.cdb.blob")
+	if err != nil || st.Size() != 2001 {
+		t.Fatalf("Expected a blob file of 2001 bytes: %v, %v", st, err)
+	}
+
+	db, err := cdb.Open("./test/blob.cdb")
+	if err != nil {
+		t.Fatalf("Can't open blob.cdb: %s", err)
+	}
+	defer db.Close()
+
+	if db.Features()&cdb.FeatureBlobs == 0 {
+		t.Fatalf("Expected the blobs feature, saw %s", db.Features())
+	}
+
+	dst := make([]byte, 2048)
+	for _, r := range recs {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Value mismatch for key %s: %.10q, %v", r.key, v, err)
+		}
+
+		n, ok, err := db.GetInto([]byte(r.key), dst)
+		if err != nil || !ok || string(dst[:n]) != r.val {
+			t.Fatalf("GetInto mismatch for key %s: %.10q, %v", r.key, dst[:n], err)
+		}
+	}
+
+	var n int
+	iter := db.Iter()
+	for iter.Next() {
+		if string(iter.Value()) != recs[n].val {
+			t.Fatalf("Iterator value mismatch for key %s", iter.Key())
+		}
+		n++
+	}
+	if iter.Err() != nil || n != len(recs) {
+		t.Fatalf("Iterated %d records: %v", n, iter.Err())
+	}
+
+	// a damaged blob file fails verification
+	buf, err := os.ReadFile("./test/blob.cdb.blob")
+	if err != nil {
+		t.Fatalf("Can't read blob file: %s", err)
+	}
+	buf[500] = 'z'
+
+	raw, err := os.ReadFile("./test/blob.cdb")
+	if err != nil {
+		t.Fatalf("Can't read blob.cdb: %s", err)
+	}
+
+	_, err = cdb.NewWithSize(bytes.NewReader(raw), int64(len(raw)), cdb.WithBlobReader(bytes.NewReader(buf)))
+	if err == nil {
+		t.Fatalf("Opened a database with a damaged blob file")
+	}
+
+	_, err = cdb.NewWithSize(bytes.NewReader(raw), int64(len(raw)))
+	if err == nil {
+		t.Fatalf("Opened a database without its blob file")
+	}
+
+	// a rewrite keeps the values in a blob file
+	err = cdb.Rewrite("./test/blob.cdb", "./test/blob2.cdb", func(w *cdb.Writer, it *cdb.Iterator) error {
+		if it == nil {
+			return nil
+		}
+		return w.Put(it.Key(), it.Value())
+	})
+	if err != nil {
+		t.Fatalf("Can't rewrite blob.cdb: %s", err)
+	}
+
+	db2, err := cdb.Open("./test/blob2.cdb")
+	if err != nil {
+		t.Fatalf("Can't open blob2.cdb: %s", err)
+	}
+	defer db2.Close()
+
+	v, err := db2.Get([]byte("big"))
+	if err != nil || string(v) != big || db2.Features()&cdb.FeatureBlobs == 0 {
+		t.Fatalf("Rewrite lost the blobs: %.10q, %v, %s", v, err, db2.Features())
+	}
+}
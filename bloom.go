@@ -0,0 +1,111 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// WithBloom makes the writer build a Bloom filter over all keys with
+// bitsPerKey bits per key (10 gives a false positive rate of about 1%)
+// and store it in the trailer. The reader loads the filter at open and
+// answers most lookups of absent keys from memory, without reading the
+// hash tables.
+func WithBloom(bitsPerKey int) Option {
+	return func(o *options) {
+		o.bloomBits = bitsPerKey
+	}
+}
+
+// bloom is a Bloom filter over the 32-bit key hashes. The k bit
+// positions are derived from the key hash by double hashing.
+type bloom struct {
+	k    uint32
+	m    uint64
+	bits []uint64
+}
+
+func newBloom(n, bitsPerKey int) *bloom {
+	m := uint64(n) * uint64(bitsPerKey)
+	if m < 64 {
+		m = 64
+	}
+
+	k := uint32(math.Round(float64(bitsPerKey) * math.Ln2))
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+
+	return &bloom{
+		k:    k,
+		m:    m,
+		bits: make([]uint64, (m+63)/64),
+	}
+}
+
+// bloomHashes returns the two hashes used to derive the bit positions
+func bloomHashes(hash uint32) (uint64, uint64) {
+	// spread the 32-bit key hash over 64 bits (splitmix64 finalizer)
+	h := uint64(hash)
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h & 0xffffffff, (h >> 32) | 1
+}
+
+func (b *bloom) add(hash uint32) {
+	h1, h2 := bloomHashes(hash)
+	for i := uint32(0); i < b.k; i++ {
+		bit := (h1 + uint64(i)*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// has returns false if no key with this hash was added
+func (b *bloom) has(hash uint32) bool {
+	h1, h2 := bloomHashes(hash)
+	for i := uint32(0); i < b.k; i++ {
+		bit := (h1 + uint64(i)*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// marshal encodes the filter as k (uint32), m (uint64) and the bitmap
+func (b *bloom) marshal() []byte {
+	buf := make([]byte, 12+8*len(b.bits))
+	binary.LittleEndian.PutUint32(buf[0:4], b.k)
+	binary.LittleEndian.PutUint64(buf[4:12], b.m)
+	for i, w := range b.bits {
+		binary.LittleEndian.PutUint64(buf[12+8*i:], w)
+	}
+	return buf
+}
+
+func unmarshalBloom(buf []byte) (*bloom, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("malformed bloom filter section")
+	}
+
+	b := &bloom{
+		k: binary.LittleEndian.Uint32(buf[0:4]),
+		m: binary.LittleEndian.Uint64(buf[4:12]),
+	}
+	buf = buf[12:]
+
+	if b.k == 0 || b.k > 64 || b.m == 0 || b.m > 8*uint64(len(buf)) || uint64(len(buf)) != 8*((b.m+63)/64) {
+		return nil, fmt.Errorf("malformed bloom filter section")
+	}
+
+	b.bits = make([]uint64, len(buf)/8)
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+	return b, nil
+}
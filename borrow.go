@@ -0,0 +1,60 @@
+package cdb
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Borrower looks up values into a buffer it reuses, instead of
+// allocating a new one for every value as Get does. A value it returns
+// is only valid until the next call on the same Borrower, which
+// overwrites it; copy the value to retain it. Use it in tight loops
+// that look at each value briefly, e.g. to decode or sum it, where
+// copying every value doubles the memory traffic and garbage.
+//
+// A Borrower is not safe for concurrent use; give every goroutine its
+// own. Its buffer grows to the longest value looked up and is freed
+// with the Borrower.
+type Borrower struct {
+	cdb *CDB
+	buf []byte
+}
+
+// Borrowing returns a Borrower that looks up keys in the database.
+func (cdb *CDB) Borrowing() *Borrower {
+	return &Borrower{cdb: cdb}
+}
+
+// Get is like CDB.Get, but the value is only valid until the next call
+// on b.
+func (b *Borrower) Get(key []byte) ([]byte, error) {
+	value, _, err := b.Lookup(key)
+	return value, err
+}
+
+// Lookup is like CDB.Lookup, but the value is only valid until the next
+// call on b.
+func (b *Borrower) Lookup(key []byte) ([]byte, bool, error) {
+	if b.cdb.hook == nil {
+		return b.lookup(key)
+	}
+
+	start := time.Now()
+	value, ok, err := b.lookup(key)
+	b.cdb.hook(key, ok, len(value), time.Since(start))
+	return value, ok, err
+}
+
+// lookup is Lookup without the access hook
+func (b *Borrower) lookup(key []byte) ([]byte, bool, error) {
+	n, ok, err := b.cdb.getInto(key, b.buf)
+	if errors.Is(err, io.ErrShortBuffer) {
+		b.buf = make([]byte, max(n, 2*cap(b.buf)))
+		n, ok, err = b.cdb.getInto(key, b.buf)
+	}
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return b.buf[:n:n], true, nil
+}
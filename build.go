@@ -0,0 +1,196 @@
+package cdb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// BuildFrom reads records in the given format from r and writes them to
+// a new database at path. The input is streamed; only the hash table
+// entries are held in memory. On error, the partially written database
+// is removed.
+func BuildFrom(r io.Reader, format DumpFormat, path string, opts ...Option) error {
+	wr, err := Create(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = buildFrom(wr, r, format)
+	if err == nil {
+		err = wr.Close()
+	}
+
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+	return nil
+}
+
+func buildFrom(wr *Writer, r io.Reader, format DumpFormat) error {
+	switch format {
+	case DumpCDBMake:
+		return readCDBMake(wr, bufio.NewReaderSize(r, 65536))
+
+	case DumpJSON, DumpJSONBase64:
+		return readJSON(wr, r, format == DumpJSONBase64)
+
+	case DumpCSV:
+		return readCSV(wr, r)
+	}
+	return fmt.Errorf("cdb: unknown dump format %d", int(format))
+}
+
+// readCDBMake parses djb's cdbmake format:
+//
+//	+klen,dlen:key->data\n
+//
+// terminated by an empty line.
+func readCDBMake(wr *Writer, r *bufio.Reader) error {
+	for n := 1; ; n++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("cdbmake: record %d: missing end of input marker", n)
+			}
+			return err
+		}
+
+		switch c {
+		case '\n':
+			return nil
+		case '+':
+		default:
+			return fmt.Errorf("cdbmake: record %d: expected '+', saw %q", n, c)
+		}
+
+		klen, err := readNumber(r, ',')
+		if err != nil {
+			return fmt.Errorf("cdbmake: record %d: key length: %w", n, err)
+		}
+
+		vlen, err := readNumber(r, ':')
+		if err != nil {
+			return fmt.Errorf("cdbmake: record %d: data length: %w", n, err)
+		}
+
+		if uint64(klen)+uint64(vlen) > math.MaxUint32 {
+			return fmt.Errorf("cdbmake: record %d: %d+%d bytes is too large", n, klen, vlen)
+		}
+
+		// the key grows as it is read, so that a bogus length can't
+		// make us allocate more than the input has
+		key, err := io.ReadAll(io.LimitReader(r, int64(klen)))
+		if err == nil && len(key) != int(klen) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("cdbmake: record %d: key: %w", n, err)
+		}
+
+		if err = expect(r, "->"); err != nil {
+			return fmt.Errorf("cdbmake: record %d: %w", n, err)
+		}
+
+		// values are streamed; they can be larger than memory
+		if err = wr.PutReader(key, vlen, r); err != nil {
+			return fmt.Errorf("cdbmake: record %d: data: %w", n, err)
+		}
+
+		if err = expect(r, "\n"); err != nil {
+			return fmt.Errorf("cdbmake: record %d: %w", n, err)
+		}
+	}
+}
+
+// readNumber reads a decimal number terminated by 'end'
+func readNumber(r *bufio.Reader, end byte) (uint32, error) {
+	var v uint64
+	var digits int
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if c == end && digits > 0 {
+			return uint32(v), nil
+		}
+
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("unexpected %q", c)
+		}
+
+		v = v*10 + uint64(c-'0')
+		if v > 0xffffffff {
+			return 0, errors.New("number too large")
+		}
+		digits++
+	}
+}
+
+func expect(r *bufio.Reader, s string) error {
+	for i := 0; i < len(s); i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != s[i] {
+			return fmt.Errorf("expected %q, saw %q", s[i], c)
+		}
+	}
+	return nil
+}
+
+func readJSON(wr *Writer, r io.Reader, b64 bool) error {
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var jr jsonRecord
+		err := dec.Decode(&jr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("json: record %d: %w", n, err)
+		}
+
+		key, val := []byte(jr.K), []byte(jr.V)
+		if b64 {
+			if key, err = base64.StdEncoding.DecodeString(jr.K); err != nil {
+				return fmt.Errorf("json: record %d: key: %w", n, err)
+			}
+			if val, err = base64.StdEncoding.DecodeString(jr.V); err != nil {
+				return fmt.Errorf("json: record %d: value: %w", n, err)
+			}
+		}
+
+		if err = wr.Put(key, val); err != nil {
+			return err
+		}
+	}
+}
+
+func readCSV(wr *Writer, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.ReuseRecord = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("csv: %w", err)
+		}
+
+		if err = wr.Put([]byte(rec[0]), []byte(rec[1])); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cdb
+
+import (
+	"iter"
+	"time"
+)
+
+// Progress describes how far BuildFromSeq has got.
+type Progress struct {
+	// records and bytes of keys and values added so far
+	Records int64
+	Bytes   int64
+
+	// time since the build started
+	Elapsed time.Duration
+
+	// the number of records expected, as given to WithProgress, or 0
+	Total int64
+
+	// true for the last report, made once the database is written
+	Done bool
+}
+
+// ETA returns the estimated time until all the expected records are
+// added, from the rate they have been added at so far. It returns false
+// if the number of records expected is unknown, or none were added yet.
+func (p *Progress) ETA() (time.Duration, bool) {
+	if p.Total <= 0 || p.Records == 0 {
+		return 0, false
+	}
+	if p.Records >= p.Total {
+		return 0, true
+	}
+
+	left := float64(p.Total-p.Records) / float64(p.Records)
+	return time.Duration(left * float64(p.Elapsed)), true
+}
+
+// progressConf configures the progress reports of BuildFromSeq
+type progressConf struct {
+	total int64
+	every time.Duration
+	fn    func(*Progress)
+}
+
+// WithProgress makes BuildFromSeq call fn about every interval with
+// how many records it added, and once more when it is done. total is
+// the number of records expected, from which Progress.ETA estimates
+// the time left; pass 0 if it isn't known.
+func WithProgress(total int64, every time.Duration, fn func(*Progress)) Option {
+	return func(o *options) {
+		o.progress = &progressConf{total: total, every: every, fn: fn}
+	}
+}
+
+// records added between looks at the clock
+const progressStride = 256
+
+// BuildFromSeq writes the records yielded by seq, a range-over-func
+// iterator of keys and values, to a new database at path. The records
+// are streamed in the order seq yields them; seq may reuse its slices
+// once the next record is requested. See WithProgress to report
+// progress. If the build fails, seq is stopped and the partially
+// written database removed.
+func BuildFromSeq(path string, seq iter.Seq2[[]byte, []byte], opts ...Option) error {
+	wr, err := Create(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	pc := makeOptions(opts).progress
+	var p Progress
+	if pc != nil {
+		p.Total = pc.total
+	}
+
+	start := time.Now()
+	last := start
+	for k, v := range seq {
+		if err = wr.Put(k, v); err != nil {
+			break
+		}
+
+		p.Records++
+		p.Bytes += int64(len(k) + len(v))
+		if pc != nil && p.Records%progressStride == 0 {
+			if now := time.Now(); now.Sub(last) >= pc.every {
+				last = now
+				p.Elapsed = now.Sub(start)
+				pc.fn(&p)
+			}
+		}
+	}
+
+	if err == nil {
+		err = wr.Close()
+	}
+
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+
+	if pc != nil {
+		p.Elapsed = time.Since(start)
+		p.Done = true
+		pc.fn(&p)
+	}
+	return nil
+}
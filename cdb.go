@@ -7,27 +7,80 @@ package cdb
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"os"
-
-	"github.com/opencoff/go-utils"
+	"sort"
+	"sync/atomic"
+	"time"
 )
 
-const indexSize = 256 * 8
+// size of the index with the default number of tables
+const indexSize = defaultTables * 8
 
-type index [256]table
+type index []table
 
 // CDB represents an open CDB database. It can only be used for reads; to
 // create a database, use Writer.
+//
+// A CDB is safe for concurrent use by any number of goroutines: nothing
+// it holds changes after it is opened, except the probe counts (see
+// WithProbeStats), which are updated atomically. Close waits for the
+// lookups in progress, and lookups after it fail with ErrClosed. See
+// Clone for readers with their own probe counts.
 type CDB struct {
 	reader io.ReaderAt
 	hasher func(b []byte) uint32
 	index  index
+
+	// trailer of the file; for files without one, only hash is set
+	trailer trailer
+
+	// size of the file up to the checksum; the index must not point
+	// past it
+	size int64
+
+	// probe counts; see WithProbeStats
+	stats *probeStats
+
+	// the blob file, if the database has one; see WithBlobs
+	blobs io.ReaderAt
+
+	// normalizes keys; see WithKeyTransform
+	keyFn func([]byte) []byte
+
+	// called after lookups; see WithAccessHook
+	hook AccessHook
+
+	// transforms the values found by lookups; see WithValueTransform
+	valueFn func(key, raw []byte) ([]byte, error)
+
+	// confirms records found by key fingerprint; see
+	// WithFingerprintCheck
+	keyFPCheck func(key, value []byte) bool
+
+	// shares the file of another CDB; see Clone
+	clone bool
+
+	// the files of a database frozen from CreateAnonymous; see LinkAt
+	anon *anonymous
+
+	// background checksum passes; see WithReverify
+	reverify *reverifyConf
+
+	// check the record CRCs on lookups; see WithVerifyEveryRead
+	verifyReads bool
+
+	// the error that stopped the last All or Match; see IterErr
+	iterErr atomic.Value
+
+	// waits for reads in progress on Close
+	closer *closeGate
 }
 
 type table struct {
@@ -35,74 +88,315 @@ type table struct {
 	length uint32
 }
 
-// Open opens an existing CDB database at the given path.
-func Open(path string) (*CDB, error) {
+// Open opens an existing CDB database at the given path. The hash
+// function is picked from the file trailer; files without a trailer use
+// the function given by WithHash, or the default. The blob file of a
+// database built WithBlobs is opened from path+".blob". See WithMmap to
+// map the file into memory instead of reading it, and WithDirectIO to
+// read it around the page cache.
+func Open(path string, opts ...Option) (*CDB, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = verifyChecksum(f, path)
+	st, err := f.Stat()
 	if err != nil {
-		return nil, err
+		f.Close()
+		return nil, fmt.Errorf("can't stat %s: %s", path, err)
 	}
 
-	return New(f, nil)
+	o := makeOptions(opts)
+	var r io.ReaderAt = f
+	switch {
+	case o.direct:
+		r, err = openDirect(f, st.Size())
+	case o.mmap && st.Size() > 0:
+		r, err = mapFile(f, st.Size(), o)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	opts, bf := withBlobFile(path, opts)
+	if o.logger != nil {
+		opts = append(opts[:len(opts):len(opts)], WithLogger(o.logger.With("path", path)))
+	}
+
+	cdb, err := NewWithSize(r, st.Size(), opts...)
+	if err != nil {
+		r.(io.Closer).Close()
+		if bf != nil {
+			bf.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// not needed after all
+	if bf != nil && cdb.blobs == nil {
+		bf.Close()
+	}
+	return cdb, nil
 }
 
-// Verify the DB integrity
-// The last 32 bytes of the DB are the SHA256 checksum of the bytes
-// preceding it. It was appended by the writer module.
-// XXX This is a non-standard extension to CDB;
-func verifyChecksum(f *os.File, path string) error {
-	st, err := f.Stat()
+// withBlobFile opens the blob file of the database at path, if there
+// is one, and returns opts with it added; the caller closes it if the
+// database doesn't use it.
+func withBlobFile(path string, opts []Option) ([]Option, *os.File) {
+	bf, _ := os.Open(path + ".blob")
+	if bf != nil {
+		opts = append(opts[:len(opts):len(opts)], WithBlobReader(bf))
+	}
+	return opts, bf
+}
+
+// NewWithSize opens a CDB database stored in the first size bytes of r;
+// use it for databases held in memory, in an archive or on a remote
+// store. Unlike New, it verifies the checksum (unless WithSkipVerify is
+// given) and reads the trailer. Closing the database closes r if it is
+// an io.Closer.
+func NewWithSize(r io.ReaderAt, size int64, opts ...Option) (*CDB, error) {
+	o := makeOptions(opts)
+
+	if size < (minIndexSize + checksumSize) {
+		return nil, fmt.Errorf("cdb too small")
+	}
+
+	if !o.skipVerify && !o.checksumVerified {
+		start := time.Now()
+		err := verifyChecksum(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if o.logger != nil {
+			o.logger.Debug("cdb: verified checksum", "bytes", size, "duration", time.Since(start))
+		}
+	}
+
+	cdb := &CDB{reader: r, size: size - checksumSize}
+	err := cdb.init(cdb.size, o)
 	if err != nil {
-		return fmt.Errorf("can't stat %s: %s", path, err)
+		return nil, err
 	}
 
-	sz := st.Size()
-	if sz < (2048 + sha256.Size) {
-		return fmt.Errorf("cdb file %s too small", path)
+	if o.logger != nil {
+		o.logger.Debug("cdb: opened", "bytes", size, "records", cdb.trailer.count, "hash", cdb.trailer.hash, "features", cdb.trailer.features())
 	}
 
-	datasz := sz - sha256.Size
+	cdb.gate()
+	return cdb, nil
+}
 
-	// Skip to just before the checksum
-	_, err = f.Seek(datasz, os.SEEK_SET)
+// init reads the trailer ending at 'end' and the index, and sets up
+// the hash function.
+func (cdb *CDB) init(end int64, o *options) error {
+	t, err := readTrailer(cdb.reader, minIndexSize, end)
 	if err != nil {
-		return fmt.Errorf("can't seek %s: %s", path, err)
+		return err
+	}
+
+	id := o.hash
+	if t != nil {
+		if id != 0 && id != t.hash {
+			return fmt.Errorf("cdb: database uses hash %s, not %s", t.hash, id)
+		}
+		id = t.hash
+	}
+
+	if id == HashCustom {
+		if t != nil {
+			return fmt.Errorf("cdb: database uses a custom hash; open it with New()")
+		}
+		id = HashFasthash
+	}
+
+	if t != nil && id == HashSiphash {
+		var fp uint64
+		if o.sipKey != nil {
+			fp = sipFingerprint(*o.sipKey)
+		}
+
+		if fp != t.sipFP {
+			if o.sipKey == nil {
+				return fmt.Errorf("cdb: database uses a keyed siphash; open it WithSipHash()")
+			}
+			return fmt.Errorf("cdb: siphash key doesn't match database")
+		}
 	}
 
-	var eck [sha256.Size]byte
+	if t != nil && id == HashFasthash {
+		if err := o.checkHashSeed(t); err != nil {
+			return err
+		}
+	}
 
-	n, err := f.Read(eck[:])
+	cdb.hasher, err = o.hasher(id)
 	if err != nil {
-		return fmt.Errorf("can't read checksum of %s: %s", path, err)
+		return err
 	}
 
-	if n != sha256.Size {
-		return fmt.Errorf("i/o error while reading checksum of %s: only read %d bytes", n)
+	cdb.trailer.count = -1
+	if t != nil {
+		cdb.trailer = *t
 	}
+	cdb.trailer.hash = id
 
-	// Verify checksum now
-	hh := sha256.New()
-	err = utils.MmapReader(f, 0, datasz, hh)
+	if err := cdb.initKeyTransform(t, o); err != nil {
+		return err
+	}
+
+	if bi := cdb.trailer.blobs; bi != nil {
+		if o.blobReader == nil {
+			return fmt.Errorf("cdb: database stores values in a blob file; open it WithBlobReader()")
+		}
+
+		if !o.skipVerify {
+			err = verifyBlobs(o.blobReader, bi, cdb.trailer.checksum)
+			if err != nil {
+				return err
+			}
+		}
+		cdb.blobs = o.blobReader
+	}
+
+	err = cdb.readIndex()
 	if err != nil {
-		return fmt.Errorf("i/o error during checksum calculation: %s", err)
+		return err
 	}
 
-	ck := hh.Sum(nil)
+	if o.probeStats {
+		cdb.stats = newProbeStats(len(cdb.index))
+	}
+	cdb.hook = o.accessHook
+	if o.logger != nil {
+		cdb.hook = slowLookupHook(o.logger, o.slowLookup, o.accessHook)
+	}
+	cdb.valueFn = o.valueFn
+	cdb.keyFPCheck = o.keyFPCheck
+	cdb.reverify = o.reverify
+	if o.verifyReads {
+		if cdb.trailer.crcs == nil {
+			return ErrNoRecordCRC
+		}
+		cdb.verifyReads = true
+	}
+	return nil
+}
+
+// Verify the DB integrity
+// The last 32 bytes of the DB are the checksum of the bytes preceding
+// it, SHA256 unless the trailer names another algorithm. It was
+// appended by the writer module. Newer files hash the index after the
+// rest of the data; see trailerVersion.
+// XXX This is a non-standard extension to CDB;
+func verifyChecksum(r io.ReaderAt, sz int64) error {
+	_, _, err := checkFile(r, sz)
+	return err
+}
+
+// errChecksum is returned by checkFile for a checksum mismatch
+var errChecksum = errors.New("checksum failed. DB possibly corrupt!")
 
-	if 1 != subtle.ConstantTimeCompare(eck[:], ck) {
-		return fmt.Errorf("checksum failed. DB possibly corrupt!")
+// checkFile verifies the checksum of the sz bytes of r, and returns it
+// along with the trailer. The checksum is nil for ChecksumNone.
+func checkFile(r io.ReaderAt, sz int64) ([]byte, *trailer, error) {
+	if sz < (minIndexSize + checksumSize) {
+		return nil, nil, fmt.Errorf("cdb too small")
 	}
 
-	// rewind to start of file
-	_, err = f.Seek(0, os.SEEK_SET)
+	datasz := sz - checksumSize
+
+	var eck [checksumSize]byte
+
+	n, err := r.ReadAt(eck[:], datasz)
 	if err != nil {
-		return fmt.Errorf("can't seek %s: %s", path, err)
+		return nil, nil, fmt.Errorf("can't read checksum: %w", err)
+	}
+
+	if n != checksumSize {
+		return nil, nil, fmt.Errorf("i/o error while reading checksum: only read %d bytes", n)
+	}
+
+	t, err := readTrailer(r, minIndexSize, datasz)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	alg := ChecksumSHA256
+	if t != nil {
+		alg = t.checksum
+		if err := t.checkCoverage(datasz); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	hh, err := newChecksum(alg)
+	if err != nil || hh == nil {
+		return nil, t, err
+	}
+
+	// Verify checksum now
+	if err := hashFile(hh, r, t, datasz); err != nil {
+		return nil, nil, fmt.Errorf("i/o error during checksum calculation: %w", err)
+	}
+
+	var ck [checksumSize]byte
+	copy(ck[:], hh.Sum(nil))
+
+	if 1 != subtle.ConstantTimeCompare(eck[:], ck[:]) {
+		return nil, nil, errChecksum
+	}
+
+	return ck[:], t, nil
+}
+
+// hashFile writes the datasz bytes of r to hh in the order the
+// checksum covers them; t is the trailer, or nil if there is none.
+func hashFile(hh io.Writer, r io.ReaderAt, t *trailer, datasz int64) error {
+	if t == nil || t.version < 2 {
+		return copyRange(hh, r, 0, datasz)
+	}
+
+	if err := hashData(hh, r, t, datasz); err != nil {
+		return err
+	}
+	return copyRange(hh, r, 0, t.indexSize())
+}
+
+// hashData writes the data between the index and the checksum to hh.
+// The signature is written after the checksum is computed, so it is
+// hashed as zeros.
+func hashData(hh io.Writer, r io.ReaderAt, t *trailer, datasz int64) error {
+	start := t.indexSize()
+	if t.sig != nil {
+		err := copyRange(hh, r, start, t.sigOff-start)
+		if err != nil {
+			return err
+		}
+
+		hh.Write(make([]byte, len(t.sig)))
+		start = t.sigOff + int64(len(t.sig))
 	}
+	return copyRange(hh, r, start, datasz-start)
+}
 
+// checkCoverage verifies that the checksum range recorded by a version
+// 3 trailer is the one the reader is about to hash: the index, and
+// everything from it to the checksum at datasz.
+func (t *trailer) checkCoverage(datasz int64) error {
+	if t.version < 3 {
+		return nil
+	}
+	if t.cover == nil {
+		return corruptAt(ErrBadTrailer, datasz, "no checksum coverage section")
+	}
+	if t.cover.index != t.indexSize() {
+		return corruptAt(ErrBadTrailer, datasz, "checksum doesn't cover the index").want(t.indexSize(), t.cover.index)
+	}
+	if t.cover.end != datasz {
+		return corruptAt(ErrBadTrailer, datasz, "checksum doesn't cover the file").want(datasz, t.cover.end)
+	}
 	return nil
 }
 
@@ -112,6 +406,9 @@ func verifyChecksum(f *os.File, path string) error {
 // If hasher is nil, it will default to the CDB hash function. If a database
 // was created with a particular hash function, that same hash function must be
 // passed to New, or the database will return incorrect results.
+//
+// New doesn't know the size of the underlying data and hence ignores
+// the trailer and checksum.
 func New(reader io.ReaderAt, hasher hash.Hash32) (*CDB, error) {
 	var hf func(b []byte) uint32 = Hash32
 	if hasher != nil {
@@ -122,73 +419,410 @@ func New(reader io.ReaderAt, hasher hash.Hash32) (*CDB, error) {
 		}
 	}
 
-	cdb := &CDB{reader: reader, hasher: hf}
+	cdb := &CDB{reader: reader, hasher: hf, size: readerSize(reader)}
+	cdb.trailer.count = -1
 	err := cdb.readIndex()
 	if err != nil {
 		return nil, err
 	}
 
+	cdb.gate()
 	return cdb, nil
 }
 
 // Get returns the value for a given key, or nil if it can't be found.
+// Keys deleted by a tombstone are not found. Use Lookup to tell empty
+// values apart from missing keys without relying on nil.
 func (cdb *CDB) Get(key []byte) ([]byte, error) {
+	value, _, err := cdb.Lookup(key)
+	return value, err
+}
+
+// Lookup returns the value for a given key and whether it was found.
+// Unlike Get, the result doesn't depend on the value being non-nil;
+// empty values are returned as found.
+func (cdb *CDB) Lookup(key []byte) ([]byte, bool, error) {
+	value, m, err := cdb.lookupMatch(key)
+	return value, m == matchLive, err
+}
+
+// lookup is Lookup without the access hook, key fingerprint check and
+// value transform
+func (cdb *CDB) lookup(key []byte) ([]byte, match, error) {
+	return cdb.lookupStored(cdb.storeKey(key))
+}
+
+// lookupStored is lookup for a key as stored in the database, e.g. one
+// returned by an iterator
+func (cdb *CDB) lookupStored(key []byte) ([]byte, match, error) {
+	if err := cdb.enter(); err != nil {
+		return nil, noMatch, err
+	}
+	defer cdb.leave()
+
+	off, value, err := cdb.searchStored(key)
+	if value == nil {
+		return nil, noMatch, err
+	}
+	if cdb.isTombstone(off) {
+		return nil, matchDeleted, nil
+	}
+
+	value, ok, err := cdb.unwrap(off, value)
+	switch {
+	case err != nil:
+		return nil, noMatch, err
+	case !ok:
+		return nil, matchExpired, nil
+	}
+	return value, matchLive, nil
+}
+
+// GetInto looks up key and copies its value into dst, avoiding the
+// allocation made by Get. It returns the length of the value and
+// whether the key was found. If dst is too small to hold the value,
+// GetInto returns the length needed and io.ErrShortBuffer.
+//
+// dst is also used as scratch space to compare keys; its contents are
+// undefined unless the value was copied into it.
+func (cdb *CDB) GetInto(key, dst []byte) (int, bool, error) {
+	if cdb.hook == nil {
+		return cdb.getInto(key, dst)
+	}
+
+	start := time.Now()
+	n, ok, err := cdb.getInto(key, dst)
+	cdb.hook(key, ok, n, time.Since(start))
+	return n, ok, err
+}
+
+// getInto is GetInto without the access hook
+func (cdb *CDB) getInto(key, dst []byte) (int, bool, error) {
+	if cdb.wholeValues() {
+		return cdb.getIntoCopy(key, dst)
+	}
+
+	if err := cdb.enter(); err != nil {
+		return 0, false, err
+	}
+	defer cdb.leave()
+
+	offset, keyLength, valueLength, err := cdb.locate(key, dst)
+	if err != nil || offset == 0 || cdb.isTombstone(offset) {
+		return 0, false, err
+	}
+
+	if cdb.isBlob(offset) {
+		return cdb.blobInto(offset, keyLength, valueLength, dst)
+	}
+	return cdb.valueInto(offset+8+keyLength, valueLength, dst)
+}
+
+// locate returns the offset and the key and value lengths of the first
+// record for key, comparing keys in place; the offset is 0 if the key
+// can't be found. The record may be a tombstone. scratch is used to
+// compare keys as in GetInto. Front coded keys can't be located. The
+// caller must hold the file; see enter.
+func (cdb *CDB) locate(key, scratch []byte) (uint32, uint32, uint32, error) {
+	key = cdb.storeKey(key)
+	p := cdb.probe(key)
+	if cdb.stats != nil {
+		defer cdb.stats.add(&p)
+	}
+
+	for {
+		offset, ok, err := cdb.next(&p)
+		if err != nil || !ok {
+			return 0, 0, 0, err
+		}
+
+		keyLength, valueLength, err := readTuple(cdb.file(), offset)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		err = checkRecord(offset, keyLength, valueLength, cdb.index[0].offset)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		if int(keyLength) != len(key) {
+			p.collisions++
+			continue
+		}
+
+		eq, err := cdb.keyAt(offset+8, key, scratch)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		if !eq {
+			p.collisions++
+			continue
+		}
+		return offset, keyLength, valueLength, nil
+	}
+}
+
+// getIntoCopy is GetInto for front coded keys, which can't be compared
+// in place, and for values that must be checked or transformed.
+func (cdb *CDB) getIntoCopy(key, dst []byte) (int, bool, error) {
+	value, ok, err := cdb.get(key)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	if len(value) > len(dst) {
+		return len(value), true, io.ErrShortBuffer
+	}
+	return copy(dst, value), true, nil
+}
+
+// keyAt returns true if the key at off is equal to key. It reads the
+// key into dst if it fits, and into a scratch buffer otherwise.
+func (cdb *CDB) keyAt(off uint32, key, dst []byte) (bool, error) {
+	if len(dst) < len(key) {
+		if len(key) > scratchSize {
+			dst = make([]byte, len(key))
+		} else {
+			sp := getScratch()
+			defer putScratch(sp)
+			dst = *sp
+		}
+	}
+
+	kbuf := dst[:len(key)]
+	_, err := cdb.file().ReadAt(kbuf, int64(off))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(kbuf, key), nil
+}
+
+// valueInto copies the value of vlen bytes at off into dst; it skips
+// the expiry time if there is one.
+func (cdb *CDB) valueInto(off, vlen uint32, dst []byte) (int, bool, error) {
+	if cdb.trailer.expiry {
+		if vlen < expirySize {
+			return 0, false, corruptAt(ErrBadRecord, int64(off), "value too short for expiry time").want(expirySize, int64(vlen))
+		}
+
+		lo, hi, err := readTuple(cdb.file(), off)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if expired(int64(hi)<<32|int64(lo), time.Now()) {
+			return 0, false, nil
+		}
+
+		off += expirySize
+		vlen -= expirySize
+	}
+
+	if int(vlen) > len(dst) {
+		return int(vlen), true, io.ErrShortBuffer
+	}
+
+	_, err := cdb.file().ReadAt(dst[:vlen], int64(off))
+	if err != nil {
+		return 0, false, err
+	}
+	return int(vlen), true, nil
+}
+
+// blobInto is valueInto for the record at off, whose value is a blob
+// pointer.
+func (cdb *CDB) blobInto(off, klen, vlen uint32, dst []byte) (int, bool, error) {
+	raw := make([]byte, vlen)
+	_, err := cdb.file().ReadAt(raw, int64(off+8+klen))
+	if err != nil {
+		return 0, false, err
+	}
+
+	v, ok, err := cdb.unwrap(off, raw)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	if len(v) > len(dst) {
+		return len(v), true, io.ErrShortBuffer
+	}
+	return copy(dst, v), true, nil
+}
+
+// isTombstone returns true if the record at off marks a deleted key
+func (cdb *CDB) isTombstone(off uint32) bool {
+	return hasOffset(cdb.trailer.tombstones, off)
+}
+
+// hasOffset returns true if the sorted offsets offs include off
+func hasOffset(offs []uint32, off uint32) bool {
+	i := sort.Search(len(offs), func(i int) bool {
+		return offs[i] >= off
+	})
+	return i < len(offs) && offs[i] == off
+}
+
+// find returns the offset and value of the first record for key. The
+// value is nil if the key can't be found. The record may be a tombstone.
+func (cdb *CDB) find(key []byte) (uint32, []byte, error) {
+	return cdb.findStored(cdb.storeKey(key))
+}
+
+// findStored is find for a key as stored in the database
+func (cdb *CDB) findStored(key []byte) (uint32, []byte, error) {
+	if err := cdb.enter(); err != nil {
+		return 0, nil, err
+	}
+	defer cdb.leave()
+	return cdb.searchStored(key)
+}
+
+// searchStored is findStored for callers that hold the file; see enter
+func (cdb *CDB) searchStored(key []byte) (uint32, []byte, error) {
+	p := cdb.probe(key)
+	if cdb.stats != nil {
+		defer cdb.stats.add(&p)
+	}
+
+	for {
+		offset, ok, err := cdb.next(&p)
+		if err != nil || !ok {
+			return 0, nil, err
+		}
+
+		value, err := cdb.getValueAt(offset, key)
+		if err != nil {
+			return 0, nil, err
+		} else if value != nil {
+			return offset, value, nil
+		}
+		p.collisions++
+	}
+}
+
+// prober walks the slots of a hash table looking for a hash
+type prober struct {
+	hash  uint32
+	table table
+	start uint32
+	slot  uint32
+	done  bool
+
+	// slots read and records that didn't match; see WithProbeStats
+	slots      int
+	collisions int
+}
+
+// probe starts a probe for key
+func (cdb *CDB) probe(key []byte) prober {
 	hash := cdb.hasher(key)
+	p := prober{
+		hash:  hash,
+		table: cdb.index[tableFor(hash, len(cdb.index))],
+	}
 
-	table := cdb.index[hash&0xff]
-	if table.length == 0 {
-		return nil, nil
+	// With an MPH, the only candidate is a one slot "table"
+	if x := cdb.trailer.mph; x != nil {
+		s, ok := x.slot(mphHash(key))
+		p.table = table{offset: x.off + 8*s, length: 1}
+		p.done = !ok
+	}
+
+	if p.done || p.table.length == 0 {
+		p.done = true
+		return p
+	}
+
+	// The Bloom filter answers most misses without any I/O
+	if bf := cdb.trailer.bloom; bf != nil && !bf.has(hash) {
+		p.done = true
+		return p
 	}
 
 	// Probe the given hash table, starting at the given slot.
-	startingSlot := (hash >> 8) % table.length
-	slot := startingSlot
+	p.start = probeStart(hash, len(cdb.index), p.table.length)
+	p.slot = p.start
+	return p
+}
 
-	for {
-		slotOffset := table.offset + (8 * slot)
-		slotHash, offset, err := readTuple(cdb.reader, slotOffset)
+// next returns the offset of the next record whose hash matches the
+// probe; it returns false when there are no more candidates. The caller
+// must hold the file; see enter.
+func (cdb *CDB) next(p *prober) (uint32, bool, error) {
+	for !p.done {
+		slotOffset := p.table.offset + (8 * p.slot)
+		slotHash, offset, err := readTuple(cdb.file(), slotOffset)
 		if err != nil {
-			return nil, err
+			return 0, false, err
 		}
+		p.slots++
 
-		// An empty slot means the key doesn't exist.
-		if slotHash == 0 {
+		// An empty slot means the key doesn't exist. Empty slots
+		// have no record offset; their hash is 0 too, but so may be
+		// the hash of a key.
+		if offset == 0 {
+			p.done = true
 			break
-		} else if slotHash == hash {
-			value, err := cdb.getValueAt(offset, key)
-			if err != nil {
-				return nil, err
-			} else if value != nil {
-				return value, nil
-			}
 		}
 
-		slot = (slot + 1) % table.length
-		if slot == startingSlot {
-			break
+		p.slot = (p.slot + 1) % p.table.length
+		if p.slot == p.start {
+			p.done = true
+		}
+
+		if slotHash == p.hash {
+			return offset, true, nil
 		}
 	}
+	return 0, false, nil
+}
+
+// Len returns the number of records in the database, not counting
+// tombstones. Records that have expired are counted until the database
+// is rebuilt. For databases without a trailer, it is computed from the
+// sizes of the hash tables.
+func (cdb *CDB) Len() int {
+	if cdb.trailer.count >= 0 {
+		return int(cdb.trailer.count) - len(cdb.trailer.tombstones)
+	}
 
-	return nil, nil
+	var n int
+	for _, t := range cdb.index {
+		n += int(t.length / 2)
+	}
+	return n
 }
 
-// Close closes the database to further reads.
-func (cdb *CDB) Close() error {
-	if closer, ok := cdb.reader.(io.Closer); ok {
-		return closer.Close()
-	} else {
-		return nil
+// Clone returns a reader of the same database that shares the file,
+// index and trailer of cdb, but counts its own probes (see
+// WithProbeStats); give one to each goroutine to keep them from
+// contending on the counters. Closing a clone does nothing: the file is
+// closed when cdb is, after which the clones fail too.
+func (cdb *CDB) Clone() *CDB {
+	c := *cdb
+	c.clone = true
+	c.iterErr = atomic.Value{}
+	if cdb.stats != nil {
+		c.stats = newProbeStats(len(cdb.index))
 	}
+	return &c
 }
 
 func (cdb *CDB) readIndex() error {
-	buf := make([]byte, indexSize)
+	if cdb.trailer.compact {
+		return cdb.readCompactIndex()
+	}
+
+	buf := make([]byte, cdb.trailer.indexSize())
 	_, err := cdb.reader.ReadAt(buf, 0)
 	if err != nil {
 		return err
 	}
 
-	for i := 0; i < 256; i++ {
+	cdb.index = make(index, len(buf)/8)
+	for i := range cdb.index {
 		off := i * 8
 		cdb.index[i] = table{
 			offset: binary.LittleEndian.Uint32(buf[off : off+4]),
@@ -196,30 +830,144 @@ func (cdb *CDB) readIndex() error {
 		}
 	}
 
+	return cdb.checkIndex()
+}
+
+// checkIndex makes sure that the data section and the hash tables lie
+// within the file, so that lookups never read past it.
+func (cdb *CDB) checkIndex() error {
+	end := cdb.size
+	start := cdb.dataStart()
+	if data := int64(cdb.index[0].offset); data < int64(start) || data > end {
+		return corruptAt(ErrBadIndex, 0, "data section ends outside the file").want(end, data)
+	}
+
+	for i, t := range cdb.index {
+		tend := int64(t.offset) + 8*int64(t.length)
+		if t.offset < start || tend > end {
+			return corruptAt(ErrBadIndex, int64(8*i), "table %d extends outside the file", i).want(end, tend)
+		}
+	}
+
+	if x := cdb.trailer.mph; x != nil {
+		tend := int64(x.off) + 8*int64(x.m)
+		if x.off < start || tend > end {
+			return corruptAt(ErrBadIndex, int64(x.off), "mph table extends outside the file").want(end, tend)
+		}
+	}
 	return nil
 }
 
+// checkRecord returns an error unless the record at off, with a key and
+// value of klen and vlen bytes, lies between the smallest possible index
+// and end.
+func checkRecord(off, klen, vlen, end uint32) error {
+	rend := int64(off) + 8 + int64(klen) + int64(vlen)
+	if off < minIndexSize || rend > int64(end) {
+		return corruptAt(ErrBadRecord, int64(off), "record extends outside the data section").want(int64(end), rend)
+	}
+	return nil
+}
+
+// readerSize returns the size of r if it can tell, and the largest
+// possible database size otherwise.
+func readerSize(r io.ReaderAt) int64 {
+	switch rd := r.(type) {
+	case interface{ Size() int64 }:
+		return rd.Size()
+
+	case *os.File:
+		if st, err := rd.Stat(); err == nil {
+			return st.Size()
+		}
+	}
+	return math.MaxUint32
+}
+
 func (cdb *CDB) getValueAt(offset uint32, expectedKey []byte) ([]byte, error) {
-	keyLength, valueLength, err := readTuple(cdb.reader, offset)
+	keyLength, valueLength, err := readTuple(cdb.file(), offset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkRecord(offset, keyLength, valueLength, cdb.index[0].offset)
 	if err != nil {
 		return nil, err
 	}
 
+	if cdb.trailer.front {
+		return cdb.frontValueAt(offset, keyLength, valueLength, expectedKey)
+	}
+
 	// We can compare key lengths before reading the key at all.
 	if int(keyLength) != len(expectedKey) {
 		return nil, nil
 	}
 
-	buf := make([]byte, keyLength+valueLength)
-	_, err = cdb.reader.ReadAt(buf, int64(offset+8))
+	// Small records are read into a pooled buffer, so that probes that
+	// hit a different key don't allocate; only the value is copied out.
+	sz := keyLength + valueLength
+	if sz > scratchSize {
+		buf := make([]byte, sz)
+		_, err = cdb.file().ReadAt(buf, int64(offset+8))
+		if err != nil {
+			return nil, err
+		}
+
+		// If they keys don't match, this isn't it.
+		if !bytes.Equal(buf[:keyLength], expectedKey) {
+			return nil, nil
+		}
+
+		if cdb.verifyReads {
+			if err := cdb.checkCRC(offset, keyLength, valueLength, buf); err != nil {
+				return nil, err
+			}
+		}
+		return buf[keyLength:], nil
+	}
+
+	sp := getScratch()
+	defer putScratch(sp)
+
+	buf := (*sp)[:sz]
+	_, err = cdb.file().ReadAt(buf, int64(offset+8))
 	if err != nil {
 		return nil, err
 	}
 
-	// If they keys don't match, this isn't it.
-	if bytes.Compare(buf[:keyLength], expectedKey) != 0 {
+	if !bytes.Equal(buf[:keyLength], expectedKey) {
 		return nil, nil
 	}
 
+	if cdb.verifyReads {
+		if err := cdb.checkCRC(offset, keyLength, valueLength, buf); err != nil {
+			return nil, err
+		}
+	}
+
+	value := make([]byte, valueLength)
+	copy(value, buf[keyLength:])
+	return value, nil
+}
+
+// frontValueAt is getValueAt for front coded keys
+func (cdb *CDB) frontValueAt(offset, keyLength, valueLength uint32, expectedKey []byte) ([]byte, error) {
+	buf := make([]byte, keyLength+valueLength)
+	_, err := cdb.file().ReadAt(buf, int64(offset+8))
+	if err != nil {
+		return nil, err
+	}
+
+	eq, err := cdb.frontMatch(offset, buf[:keyLength], expectedKey)
+	if err != nil || !eq {
+		return nil, err
+	}
+
+	if cdb.verifyReads {
+		if err := cdb.checkCRC(offset, keyLength, valueLength, buf); err != nil {
+			return nil, err
+		}
+	}
 	return buf[keyLength:], nil
 }
@@ -1,7 +1,24 @@
 package cdb_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	//"github.com/colinmarc/cdb"
 	"cdb"
@@ -56,7 +73,7 @@ func TestGet(t *testing.T) {
 	db.Close()
 }
 
-func makeDB(t *testing.T) {
+func makeDB(t testing.TB) {
 	db, err := cdb.Create("./test/test.cdb")
 	if err != nil {
 		t.Fatalf("Can't create test.cdb: %s", err)
@@ -74,3 +91,2167 @@ func makeDB(t *testing.T) {
 		t.Fatalf("Can't close test.db: %s", err)
 	}
 }
+
+func TestHashFromTrailer(t *testing.T) {
+	ids := []cdb.HashID{cdb.HashClassic, cdb.HashFasthash, cdb.HashSiphash, cdb.HashXXHash}
+	for _, id := range ids {
+		wr, err := cdb.Create("./test/hash.cdb", cdb.WithHash(id))
+		if err != nil {
+			t.Fatalf("Can't create hash.cdb: %s", err)
+		}
+
+		for _, r := range testRecords {
+			err = wr.Put([]byte(r.key), []byte(r.val))
+			if err != nil {
+				t.Fatalf("Can't put key %s: %s", r.key, err)
+			}
+		}
+
+		err = wr.Close()
+		if err != nil {
+			t.Fatalf("Can't close hash.cdb: %s", err)
+		}
+
+		// No option: the hash must be picked up from the trailer
+		db, err := cdb.Open("./test/hash.cdb")
+		if err != nil {
+			t.Fatalf("%s: Can't open hash.cdb: %s", id, err)
+		}
+
+		for _, r := range testRecords {
+			v, err := db.Get([]byte(r.key))
+			if err != nil {
+				t.Fatalf("%s: Can't find key %s: %s", id, r.key, err)
+			}
+
+			if r.val != string(v) {
+				t.Fatalf("%s: Value mismatch for key %s (exp %s, saw %s)", id, r.key, r.val, string(v))
+			}
+		}
+		db.Close()
+	}
+}
+
+func TestSipHashKey(t *testing.T) {
+	key := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	wr, err := cdb.Create("./test/sip.cdb", cdb.WithSipHash(key))
+	if err != nil {
+		t.Fatalf("Can't create sip.cdb: %s", err)
+	}
+
+	for _, r := range testRecords {
+		err = wr.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close sip.cdb: %s", err)
+	}
+
+	_, err = cdb.Open("./test/sip.cdb")
+	if err == nil {
+		t.Fatalf("Opened keyed siphash db without a key")
+	}
+
+	bad := key
+	bad[0] = 0xff
+	_, err = cdb.Open("./test/sip.cdb", cdb.WithSipHash(bad))
+	if err == nil {
+		t.Fatalf("Opened keyed siphash db with the wrong key")
+	}
+
+	db, err := cdb.Open("./test/sip.cdb", cdb.WithSipHash(key))
+	if err != nil {
+		t.Fatalf("Can't open sip.cdb: %s", err)
+	}
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't find key %s: %s", r.key, err)
+		}
+
+		if r.val != string(v) {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, string(v))
+		}
+	}
+	db.Close()
+}
+
+// makeDBAt creates a database at path with the given records
+func makeDBAt(t testing.TB, path string, recs []kw, opts ...cdb.Option) {
+	db, err := cdb.Create(path, opts...)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", path, err)
+	}
+
+	for _, r := range recs {
+		err = db.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("Can't close %s: %s", path, err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	wr, err := cdb.Create("./test/meta.cdb")
+	if err != nil {
+		t.Fatalf("Can't create meta.cdb: %s", err)
+	}
+
+	meta := map[string][]byte{
+		"built":   []byte("2024-01-01T00:00:00Z"),
+		"dataset": []byte("v42"),
+		"empty":   []byte{},
+	}
+	wr.SetMetadata(meta)
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close meta.cdb: %s", err)
+	}
+
+	db, err := cdb.Open("./test/meta.cdb")
+	if err != nil {
+		t.Fatalf("Can't open meta.cdb: %s", err)
+	}
+	defer db.Close()
+
+	m := db.Metadata()
+	if len(m) != len(meta) {
+		t.Fatalf("Metadata mismatch: exp %d entries, saw %d", len(meta), len(m))
+	}
+
+	for k, v := range meta {
+		if string(m[k]) != string(v) {
+			t.Fatalf("Metadata mismatch for %s: exp %q, saw %q", k, v, m[k])
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	makeDelta(t, "./test/delta.cdb")
+
+	db, err := cdb.Open("./test/delta.cdb")
+	if err != nil {
+		t.Fatalf("Can't open delta.cdb: %s", err)
+	}
+	defer db.Close()
+
+	if f := db.Features(); f != cdb.FeatureTombstones {
+		t.Fatalf("Feature mismatch: exp %s, saw %s", cdb.FeatureTombstones, f)
+	}
+
+	s := (cdb.FeatureExpiry | 1<<40).String()
+	if s != "expiry, feature-40" {
+		t.Fatalf("Feature names mismatch: %s", s)
+	}
+}
+
+func TestTables(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 1000; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), fmt.Sprintf("val-%d", i)})
+	}
+
+	sizes := map[int]int64{}
+	for _, n := range []int{64, 256, 4096} {
+		fn := fmt.Sprintf("./test/tables-%d.cdb", n)
+		makeDBAt(t, fn, recs, cdb.WithTables(n), cdb.WithValidateOnClose())
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+
+		for _, r := range recs {
+			v, err := db.Get([]byte(r.key))
+			if err != nil || string(v) != r.val {
+				t.Fatalf("%d tables: can't find key %s: %q, %v", n, r.key, v, err)
+			}
+		}
+
+		// 256 tables is the classic layout
+		if f := db.Features(); (f&cdb.FeatureTables != 0) != (n != 256) {
+			t.Fatalf("%d tables: unexpected features %s", n, f)
+		}
+		db.Close()
+
+		st, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("Can't stat %s: %s", fn, err)
+		}
+		sizes[n] = st.Size()
+	}
+
+	// 1536 bytes less index, a little more trailer
+	if sizes[256]-sizes[64] < 1500 {
+		t.Fatalf("Expected a smaller index with 64 tables: %v", sizes)
+	}
+
+	for _, n := range []int{32, 100, 1 << 17} {
+		_, err := cdb.NewWriter(&memFile{}, nil, cdb.WithTables(n))
+		if err == nil {
+			t.Fatalf("Created a database with %d tables", n)
+		}
+	}
+}
+
+func TestNewWithSize(t *testing.T) {
+	makeDB(t)
+
+	buf, err := os.ReadFile("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't read test.cdb: %s", err)
+	}
+
+	db, err := cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("Can't open in-memory db: %s", err)
+	}
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't find key %s: %s", r.key, err)
+		}
+
+		if r.val != string(v) {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, string(v))
+		}
+	}
+
+	// corrupt a record; the checksum must catch it
+	buf[2048+8] ^= 0xff
+	_, err = cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)))
+	if err == nil {
+		t.Fatalf("Opened corrupt in-memory db")
+	}
+}
+
+func TestChecksumV1(t *testing.T) {
+	makeDB(t)
+
+	buf, err := os.ReadFile("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't read test.cdb: %s", err)
+	}
+
+	// turn it into a version 1 file, checksummed in file order
+	datasz := len(buf) - sha256.Size
+	binary.LittleEndian.PutUint32(buf[datasz-12:], 1)
+	ck := sha256.Sum256(buf[:datasz])
+	copy(buf[datasz:], ck[:])
+
+	db, err := cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("Can't open version 1 db: %s", err)
+	}
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || r.val != string(v) {
+			t.Fatalf("Can't find key %s: %q, %v", r.key, v, err)
+		}
+	}
+}
+
+func TestChecksumCoverage(t *testing.T) {
+	makeDB(t)
+
+	buf, err := os.ReadFile("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't read test.cdb: %s", err)
+	}
+
+	// point the coverage section at the wrong end and fix up the
+	// checksum; the file hashes fine, but the recorded range is wrong
+	sec := []byte{17, 0, 0, 0, 16, 0, 0, 0}
+	i := bytes.LastIndex(buf, sec)
+	if i < 0 {
+		t.Fatalf("No coverage section in test.cdb")
+	}
+	end := buf[i+len(sec)+8:]
+	binary.LittleEndian.PutUint64(end, binary.LittleEndian.Uint64(end)-1)
+
+	datasz := len(buf) - sha256.Size
+	h := sha256.New()
+	h.Write(buf[2048:datasz])
+	h.Write(buf[:2048])
+	copy(buf[datasz:], h.Sum(nil))
+
+	_, err = cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)))
+	if !errors.Is(err, cdb.ErrBadTrailer) {
+		t.Fatalf("Opened db with bad checksum coverage: %v", err)
+	}
+
+	// without the fix up, it is a plain checksum mismatch
+	binary.LittleEndian.PutUint64(end, binary.LittleEndian.Uint64(end)+1)
+	if _, err := cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf))); err == nil {
+		t.Fatalf("Opened db with bad checksum")
+	}
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	algs := []cdb.Checksum{cdb.ChecksumSHA256, cdb.ChecksumNone, cdb.ChecksumXXH3, cdb.ChecksumBLAKE3, cdb.ChecksumCRC64}
+	for _, alg := range algs {
+		makeDBAt(t, "./test/checksum.cdb", testRecords, cdb.WithChecksum(alg))
+
+		buf, err := os.ReadFile("./test/checksum.cdb")
+		if err != nil {
+			t.Fatalf("Can't read checksum.cdb: %s", err)
+		}
+
+		db, err := cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			t.Fatalf("%s: can't open db: %s", alg, err)
+		}
+
+		v, err := db.Get([]byte(testRecords[0].key))
+		if err != nil || string(v) != testRecords[0].val {
+			t.Fatalf("%s: can't find key %s: %q, %v", alg, testRecords[0].key, v, err)
+		}
+		if (db.Features()&cdb.FeatureChecksum != 0) != (alg != cdb.ChecksumSHA256) {
+			t.Fatalf("%s: features %s", alg, db.Features())
+		}
+
+		buf[2048+8] ^= 0xff
+		_, err = cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)))
+		if alg == cdb.ChecksumNone && err != nil {
+			t.Fatalf("%s: can't open db: %s", alg, err)
+		} else if alg != cdb.ChecksumNone && err == nil {
+			t.Fatalf("%s: opened corrupt db", alg)
+		}
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	buf := make([]byte, 64)
+	for _, r := range testRecords {
+		n, ok, err := db.GetInto([]byte(r.key), buf)
+		if err != nil || !ok {
+			t.Fatalf("Can't find key %s: %v", r.key, err)
+		}
+
+		if r.val != string(buf[:n]) {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, string(buf[:n]))
+		}
+	}
+
+	n, ok, err := db.GetInto([]byte("hello"), buf[:2])
+	if err != io.ErrShortBuffer || !ok || n != 5 {
+		t.Fatalf("Short buffer: exp (5, true, ErrShortBuffer), saw (%d, %v, %v)", n, ok, err)
+	}
+
+	for _, r := range invKeys {
+		_, ok, err := db.GetInto([]byte(r), buf)
+		if err != nil || ok {
+			t.Fatalf("Found unexpected key %s: %v", r, err)
+		}
+	}
+}
+
+func TestGetIntoAllocs(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	key := []byte("hello")
+	buf := make([]byte, 64)
+	n := testing.AllocsPerRun(100, func() {
+		db.GetInto(key, buf)
+	})
+	if n != 0 {
+		t.Fatalf("GetInto allocates %v times per call", n)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	wr, err := cdb.Create("./test/getall.cdb")
+	if err != nil {
+		t.Fatalf("Can't create getall.cdb: %s", err)
+	}
+
+	recs := []kw{
+		{"multi", "one"},
+		{"other", "x"},
+		{"multi", "two"},
+		{"multi", ""},
+		{"multi", "three"},
+	}
+	for _, r := range recs {
+		if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Can't put %s: %s", r.key, err)
+		}
+	}
+	if err := wr.Delete([]byte("gone")); err != nil {
+		t.Fatalf("Can't delete: %s", err)
+	}
+	if err := wr.Put([]byte("gone"), []byte("ghost")); err != nil {
+		t.Fatalf("Can't put gone: %s", err)
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze getall.cdb: %s", err)
+	}
+	defer db.Close()
+
+	exp := map[string][]string{
+		"multi":   {"one", "two", "", "three"},
+		"other":   {"x"},
+		"gone":    nil,
+		"missing": nil,
+	}
+	for k, want := range exp {
+		vs, err := db.GetAll([]byte(k))
+		if err != nil {
+			t.Fatalf("GetAll %s: %s", k, err)
+		}
+
+		var saw []string
+		for _, v := range vs {
+			saw = append(saw, string(v))
+		}
+		if !reflect.DeepEqual(saw, want) {
+			t.Fatalf("GetAll %s: exp %q, saw %q", k, want, saw)
+		}
+	}
+}
+
+func TestLookupEmptyValue(t *testing.T) {
+	makeDBAt(t, "./test/empty.cdb", []kw{{"empty", ""}, {"full", "x"}})
+
+	db, err := cdb.Open("./test/empty.cdb")
+	if err != nil {
+		t.Fatalf("Can't open empty.cdb: %s", err)
+	}
+	defer db.Close()
+
+	v, ok, err := db.Lookup([]byte("empty"))
+	if err != nil || !ok || len(v) != 0 {
+		t.Fatalf("Lookup of empty value: exp ([], true, nil), saw (%q, %v, %v)", v, ok, err)
+	}
+
+	v, ok, err = db.Lookup([]byte("missing"))
+	if err != nil || ok || v != nil {
+		t.Fatalf("Lookup of missing key: exp (nil, false, nil), saw (%q, %v, %v)", v, ok, err)
+	}
+}
+
+func TestLen(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	if db.Len() != len(testRecords) {
+		t.Fatalf("Len mismatch: exp %d, saw %d", len(testRecords), db.Len())
+	}
+
+	// without the trailer, Len falls back to the table sizes
+	f, err := os.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+
+	old, err := cdb.New(f, nil)
+	if err != nil {
+		t.Fatalf("Can't read test.cdb: %s", err)
+	}
+	defer old.Close()
+
+	if old.Len() != len(testRecords) {
+		t.Fatalf("Len mismatch without trailer: exp %d, saw %d", len(testRecords), old.Len())
+	}
+}
+
+func TestBloom(t *testing.T) {
+	wr, err := cdb.Create("./test/bloom.cdb", cdb.WithBloom(10))
+	if err != nil {
+		t.Fatalf("Can't create bloom.cdb: %s", err)
+	}
+
+	const N = 1000
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		err = wr.Put([]byte(k), []byte(k))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", k, err)
+		}
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close bloom.cdb: %s", err)
+	}
+
+	db, err := cdb.Open("./test/bloom.cdb")
+	if err != nil {
+		t.Fatalf("Can't open bloom.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		v, err := db.Get([]byte(k))
+		if err != nil || string(v) != k {
+			t.Fatalf("Can't find key %s: %q, %v", k, v, err)
+		}
+
+		k = fmt.Sprintf("nokey-%d", i)
+		v, err = db.Get([]byte(k))
+		if err != nil || v != nil {
+			t.Fatalf("Found unexpected key %s: %q, %v", k, v, err)
+		}
+	}
+}
+
+func TestZeroHash(t *testing.T) {
+	// every key has hash 0, which is also the hash of an empty slot
+	id := cdb.HashUser + 25
+	err := cdb.RegisterHash(id, "zero", func([]byte) uint32 { return 0 })
+	if err != nil {
+		t.Fatalf("Can't register hash: %s", err)
+	}
+
+	var recs []kw
+	for i := 0; i < 10; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)})
+	}
+	makeDBAt(t, "./test/zero.cdb", recs, cdb.WithHash(id), cdb.WithValidateOnClose())
+
+	db, err := cdb.Open("./test/zero.cdb")
+	if err != nil {
+		t.Fatalf("Can't open zero.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, r := range recs {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Can't find key %s: %q, %v", r.key, v, err)
+		}
+
+		var dst [16]byte
+		n, ok, err := db.GetInto([]byte(r.key), dst[:])
+		if err != nil || !ok || string(dst[:n]) != r.val {
+			t.Fatalf("GetInto %s: %q, %v, %v", r.key, dst[:n], ok, err)
+		}
+	}
+
+	if v, ok, err := db.Lookup([]byte("not there")); ok || err != nil {
+		t.Fatalf("Found missing key: %q, %v", v, err)
+	}
+
+	if err := db.Validate(); err != nil {
+		t.Fatalf("Validate failed: %s", err)
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	wr, err := cdb.Create("./test/seed.cdb", cdb.WithHashSeed(42))
+	if err != nil {
+		t.Fatalf("Can't create seed.cdb: %s", err)
+	}
+
+	hf := cdb.SeededHash32(42)
+	for _, r := range testRecords {
+		if err := wr.PutHashed(hf([]byte(r.key)), []byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("PutHashed %s failed: %s", r.key, err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	// the seed is picked from the trailer
+	db, err := cdb.Open("./test/seed.cdb")
+	if err != nil {
+		t.Fatalf("Can't open seed.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Can't find key %s: %q, %v", r.key, v, err)
+		}
+	}
+	if db.Features()&cdb.FeatureHashSeed == 0 {
+		t.Fatalf("Hash seed feature not set: %s", db.Features())
+	}
+
+	if db, err := cdb.Open("./test/seed.cdb", cdb.WithHashSeed(43)); err == nil {
+		db.Close()
+		t.Fatalf("Opened seed.cdb with the wrong seed")
+	}
+
+	makeDB(t)
+	if db, err := cdb.Open("./test/test.cdb", cdb.WithHashSeed(42)); err == nil {
+		db.Close()
+		t.Fatalf("Opened test.cdb with a seed")
+	}
+
+	if cdb.SeededHash32(cdb.DefaultSeed)([]byte("hello")) != cdb.Hash32([]byte("hello")) {
+		t.Fatalf("SeededHash32(DefaultSeed) differs from Hash32")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	path := func(i int) string {
+		return fmt.Sprintf("./test/bucket-%d.cdb", i)
+	}
+
+	sw, err := cdb.ShardedWriter(3, path)
+	if err != nil {
+		t.Fatalf("ShardedWriter failed: %s", err)
+	}
+	for _, r := range testRecords {
+		if err := sw.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Put %s failed: %s", r.key, err)
+		}
+	}
+
+	sr, err := sw.Freeze()
+	if err != nil {
+		t.Fatalf("Freeze failed: %s", err)
+	}
+	defer sr.Close()
+
+	shards := sr.Shards()
+	for _, r := range testRecords {
+		b := cdb.Bucket([]byte(r.key), len(shards))
+		if v, ok, err := shards[b].Lookup([]byte(r.key)); !ok || err != nil || string(v) != r.val {
+			t.Fatalf("Key %s isn't in shard %d: %v, %v", r.key, b, ok, err)
+		}
+	}
+}
+
+func TestProbeStats(t *testing.T) {
+	// every key lands in table 7, with the same hash
+	id := cdb.HashUser + 24
+	err := cdb.RegisterHash(id, "constant", func([]byte) uint32 { return 0x1007 })
+	if err != nil {
+		t.Fatalf("Can't register hash: %s", err)
+	}
+
+	makeDBAt(t, "./test/probe.cdb", testRecords, cdb.WithHash(id))
+
+	db, err := cdb.Open("./test/probe.cdb", cdb.WithProbeStats())
+	if err != nil {
+		t.Fatalf("Can't open probe.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Can't find key %s: %q, %v", r.key, v, err)
+		}
+	}
+
+	// 3 records in a table of 6 slots; the lookups read 1, 2 and 3
+	// slots, and collide on every record before the right one
+	s := db.ProbeStats()
+	if s.Lookups != 3 || s.Slots != 6 || s.Collisions != 3 || s.Max != 3 {
+		t.Fatalf("Unexpected probe stats: %+v", s)
+	}
+	if s.Probes[1] != 1 || s.Probes[2] != 1 || s.Probes[3] != 1 {
+		t.Fatalf("Unexpected probe histogram: %v", s.Probes)
+	}
+	if hot := s.Hot(5); len(hot) != 1 || hot[0] != 7 || s.Tables[7].Slots != 6 {
+		t.Fatalf("Expected table 7 to be hot, saw %v", hot)
+	}
+
+	if !strings.Contains(s.String(), "3 lookups, 2.00 slots/lookup") {
+		t.Fatalf("Unexpected report:\n%s", s)
+	}
+
+	plain, err := cdb.Open("./test/probe.cdb")
+	if err != nil {
+		t.Fatalf("Can't open probe.cdb: %s", err)
+	}
+	defer plain.Close()
+
+	if s := plain.ProbeStats(); s != nil {
+		t.Fatalf("Expected no probe stats, saw %+v", s)
+	}
+}
+
+func TestAccessHook(t *testing.T) {
+	makeDBAt(t, "./test/access.cdb", testRecords)
+
+	type access struct {
+		key   string
+		found bool
+		bytes int
+	}
+
+	var mu sync.Mutex
+	var seen []access
+	hook := func(key []byte, found bool, bytes int, dur time.Duration) {
+		if dur < 0 {
+			t.Errorf("%s: negative duration %s", key, dur)
+		}
+		mu.Lock()
+		seen = append(seen, access{string(key), found, bytes})
+		mu.Unlock()
+	}
+
+	db, err := cdb.Open("./test/access.cdb", cdb.WithAccessHook(hook))
+	if err != nil {
+		t.Fatalf("Can't open access.cdb: %s", err)
+	}
+	defer db.Close()
+
+	db.Get([]byte("hello"))
+	db.Lookup([]byte("foo"))
+	db.GetInto([]byte("abc"), make([]byte, 16))
+	if err := db.Warm([][]byte{[]byte("123")}); err != nil {
+		t.Fatalf("Can't warm: %s", err)
+	}
+
+	exp := []access{
+		{"hello", true, 5},
+		{"foo", false, 0},
+		{"abc", true, 3},
+	}
+	if !reflect.DeepEqual(seen, exp) {
+		t.Fatalf("Unexpected accesses: exp %v, saw %v", exp, seen)
+	}
+}
+
+// TestLogger checks what the writer and the reader log.
+func TestMarshaler(t *testing.T) {
+	fn := "./test/marshal.cdb"
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	when := time.Date(2024, 2, 29, 12, 30, 0, 0, time.UTC)
+	if err := wr.PutMarshaler([]byte("when"), when); err != nil {
+		t.Fatalf("PutMarshaler failed: %s", err)
+	}
+	if err := wr.Put([]byte("junk"), []byte("not a time")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	var got time.Time
+	if ok, err := db.GetUnmarshaler([]byte("when"), &got); !ok || err != nil {
+		t.Fatalf("GetUnmarshaler failed: %v, %v", ok, err)
+	}
+	if !got.Equal(when) {
+		t.Fatalf("GetUnmarshaler: exp %s, saw %s", when, got)
+	}
+
+	if ok, err := db.GetUnmarshaler([]byte("missing"), &got); ok || err != nil {
+		t.Fatalf("GetUnmarshaler of a missing key: saw %v, %v", ok, err)
+	}
+	if ok, err := db.GetUnmarshaler([]byte("junk"), &got); !ok || err == nil {
+		t.Fatalf("GetUnmarshaler of a bad value: exp an error, saw %v, %v", ok, err)
+	}
+}
+
+func TestBorrowing(t *testing.T) {
+	fn := "./test/borrow.cdb"
+	makeDBAt(t, fn, testRecords)
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	b := db.Borrowing()
+	for range 2 {
+		for _, r := range testRecords {
+			v, ok, err := b.Lookup([]byte(r.key))
+			if !ok || err != nil {
+				t.Fatalf("Lookup %q failed: %v, %v", r.key, ok, err)
+			}
+			if string(v) != r.val {
+				t.Fatalf("Lookup %q: exp %q, saw %q", r.key, r.val, v)
+			}
+		}
+	}
+
+	v, err := b.Get([]byte("missing"))
+	if v != nil || err != nil {
+		t.Fatalf("Get of a missing key: saw %q, %v", v, err)
+	}
+
+	// the value is overwritten by the next call
+	first, _ := b.Get([]byte(testRecords[0].key))
+	b.Get([]byte(testRecords[1].key))
+	if testRecords[0].val != testRecords[1].val && string(first) == testRecords[0].val {
+		t.Fatalf("Get: the buffer wasn't reused")
+	}
+}
+
+func TestReverify(t *testing.T) {
+	fn := "./test/reverify.cdb"
+	reports := make(chan error, 1)
+	report := func(err error) {
+		reports <- err
+	}
+
+	for _, damage := range []string{"flip", "truncate"} {
+		makeDBAt(t, fn, testRecords)
+		db, err := cdb.Open(fn, cdb.WithReverify(time.Millisecond, 1<<30, report))
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		db.Reverify(ctx)
+
+		// a few clean passes
+		time.Sleep(20 * time.Millisecond)
+		select {
+		case err := <-reports:
+			t.Fatalf("%s: Reverify reported a sound database: %s", damage, err)
+		default:
+		}
+
+		f, err := os.OpenFile(fn, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		st, _ := f.Stat()
+		if damage == "flip" {
+			// the first key
+			_, err = f.WriteAt([]byte{0xff}, 2048+8)
+		} else {
+			err = f.Truncate(st.Size() - 1)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: can't damage %s: %s", damage, fn, err)
+		}
+
+		select {
+		case err := <-reports:
+			if !errors.Is(err, cdb.ErrChanged) {
+				t.Fatalf("%s: exp ErrChanged, saw %s", damage, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Reverify didn't notice", damage)
+		}
+		cancel()
+		db.Close()
+	}
+}
+
+func TestFreezeLayout(t *testing.T) {
+	fn := "./test/freeze.cdb"
+	reports := make(chan error, 1)
+	report := func(err error) {
+		reports <- err
+	}
+
+	wr, err := cdb.Create(fn, cdb.WithReverify(time.Millisecond, 1<<30, report))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	for _, r := range testRecords {
+		if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Can't put %s: %s", r.key, err)
+		}
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	odb, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer odb.Close()
+
+	fl, err := db.Layout()
+	if err != nil {
+		t.Fatalf("Layout of the frozen db failed: %s", err)
+	}
+	ol, err := odb.Layout()
+	if err != nil {
+		t.Fatalf("Layout failed: %s", err)
+	}
+	if fl.Version != ol.Version || fl.TrailerStart != ol.TrailerStart || fl.Size != ol.Size || fl.Version == 0 {
+		t.Fatalf("frozen layout %+v differs from %+v", fl, ol)
+	}
+
+	// paced to take at least 50ms if the whole file is read
+	rate := (ol.Size + 32) * 20
+	start := time.Now()
+	if err := db.WarmAll(rate); err != nil {
+		t.Fatalf("WarmAll failed: %s", err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("WarmAll read too little; took %s", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Reverify(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-reports:
+		t.Fatalf("Reverify reported a sound frozen database: %s", err)
+	default:
+	}
+
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	_, err = f.WriteAt([]byte{0xff}, 2048+8)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Can't damage %s: %s", fn, err)
+	}
+
+	select {
+	case err := <-reports:
+		if !errors.Is(err, cdb.ErrChanged) {
+			t.Fatalf("exp ErrChanged, saw %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Reverify didn't notice")
+	}
+}
+
+func TestPack(t *testing.T) {
+	a, b, fn := "./test/pack-a.cdb", "./test/pack-b.cdb", "./test/test.cdbpack"
+	makeDBAt(t, a, testRecords)
+	big := strings.Repeat("x", 100)
+	makeDBAt(t, b, []kw{{"big", big}, {"small", "y"}}, cdb.WithBlobs(64))
+
+	pw, err := cdb.CreatePack(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := pw.AddFile("a", a); err != nil {
+		t.Fatalf("AddFile a failed: %s", err)
+	}
+	if err := pw.AddFile("b", b); err != nil {
+		t.Fatalf("AddFile b failed: %s", err)
+	}
+	if err := pw.AddFile("a", b); err == nil {
+		t.Fatalf("AddFile of a duplicate name succeeded")
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithMmap()}} {
+		p, err := cdb.OpenPack(fn, opts...)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		if names := p.Names(); !slices.Equal(names, []string{"a", "b"}) {
+			t.Fatalf("Names: exp [a b], saw %q", names)
+		}
+
+		db, err := p.DB("a")
+		if err != nil {
+			t.Fatalf("Can't open a: %s", err)
+		}
+		for _, r := range testRecords {
+			if v, err := db.Get([]byte(r.key)); err != nil || string(v) != r.val {
+				t.Fatalf("Get %q: exp %q, saw %q, %v", r.key, r.val, v, err)
+			}
+		}
+		db.Close()
+
+		db, err = p.DB("b")
+		if err != nil {
+			t.Fatalf("Can't open b: %s", err)
+		}
+		if v, err := db.Get([]byte("big")); err != nil || string(v) != big {
+			t.Fatalf("Get big: saw %q, %v", v, err)
+		}
+		db.Close()
+
+		if _, err := p.DB("c"); !errors.Is(err, cdb.ErrNoMember) {
+			t.Fatalf("DB of a missing name: exp ErrNoMember, saw %v", err)
+		}
+		p.Close()
+	}
+
+	// a damaged directory is refused
+	buf, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+	buf[len(buf)-30] ^= 1
+	if err := os.WriteFile(fn, buf, 0644); err != nil {
+		t.Fatalf("Can't write %s: %s", fn, err)
+	}
+	if _, err := cdb.OpenPack(fn); !errors.Is(err, cdb.ErrCorrupt) {
+		t.Fatalf("OpenPack of a damaged pack: exp ErrCorrupt, saw %v", err)
+	}
+
+	// so is a file that isn't a database, and the pack removed
+	pw, err = cdb.CreatePack(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := pw.Add("junk", strings.NewReader(strings.Repeat("junk", 1024)), 4096); err == nil {
+		t.Fatalf("Add of a non-database succeeded")
+	}
+	if err := pw.Close(); err == nil {
+		t.Fatalf("Close after a failed Add succeeded")
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("failed pack left behind: %v", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: cdb.LevelTrace}))
+
+	fn := "./test/logger.cdb"
+	makeDBAt(t, fn, testRecords, cdb.WithLogger(log))
+	for _, msg := range []string{"cdb: wrote hash tables", "cdb: wrote trailer", "cdb: wrote index", "cdb: finalized", "path=" + fn} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Fatalf("writer didn't log %q:\n%s", msg, buf.String())
+		}
+	}
+
+	buf.Reset()
+	var hooked int
+	db, err := cdb.Open(fn, cdb.WithLogger(log), cdb.WithSlowLookup(time.Nanosecond), cdb.WithAccessHook(func([]byte, bool, int, time.Duration) {
+		hooked++
+	}))
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Get([]byte("hello")); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	for _, msg := range []string{"cdb: verified checksum", "cdb: opened", "cdb: slow lookup", `key="\"hello\""`} {
+		if !strings.Contains(buf.String(), msg) {
+			t.Fatalf("reader didn't log %q:\n%s", msg, buf.String())
+		}
+	}
+	if hooked != 1 {
+		t.Fatalf("access hook called %d times", hooked)
+	}
+}
+
+func TestValueTransform(t *testing.T) {
+	makeDBAt(t, "./test/xform.cdb", []kw{
+		{"a", "enc:one"},
+		{"b", "two"},
+		{"dup", "enc:first"},
+		{"dup", "enc:second"},
+	})
+
+	errRaw := errors.New("not encoded")
+	xform := func(key, raw []byte) ([]byte, error) {
+		v, ok := bytes.CutPrefix(raw, []byte("enc:"))
+		if !ok {
+			return nil, errRaw
+		}
+		return append([]byte(string(key)+"="), v...), nil
+	}
+
+	db, err := cdb.Open("./test/xform.cdb", cdb.WithValueTransform(xform))
+	if err != nil {
+		t.Fatalf("Can't open xform.cdb: %s", err)
+	}
+	defer db.Close()
+
+	if v, err := db.Get([]byte("a")); err != nil || string(v) != "a=one" {
+		t.Fatalf("Get a: saw %q, %v", v, err)
+	}
+	if v, ok, err := db.Lookup([]byte("b")); !errors.Is(err, errRaw) || ok || v != nil {
+		t.Fatalf("Lookup b: saw %q, %v, %v", v, ok, err)
+	}
+	if v, ok, err := db.Lookup([]byte("c")); err != nil || ok || v != nil {
+		t.Fatalf("Lookup c: saw %q, %v, %v", v, ok, err)
+	}
+
+	buf := make([]byte, 3)
+	if n, ok, err := db.GetInto([]byte("a"), buf); err != io.ErrShortBuffer || !ok || n != 5 {
+		t.Fatalf("GetInto a: saw %d, %v, %v", n, ok, err)
+	}
+	buf = make([]byte, 16)
+	if n, ok, err := db.GetInto([]byte("a"), buf); err != nil || !ok || string(buf[:n]) != "a=one" {
+		t.Fatalf("GetInto a: saw %q, %v, %v", buf[:n], ok, err)
+	}
+
+	vals, err := db.GetAll([]byte("dup"))
+	exp := [][]byte{[]byte("dup=first"), []byte("dup=second")}
+	if err != nil || !reflect.DeepEqual(vals, exp) {
+		t.Fatalf("GetAll dup: saw %q, %v", vals, err)
+	}
+
+	// iterators see the raw values
+	iter := db.Iter()
+	if !iter.Next() || string(iter.Value()) != "enc:one" {
+		t.Fatalf("Iter: saw %q", iter.Value())
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	var big []byte
+	for i := 0; i < 200; i++ {
+		big = append(big, byte(i))
+	}
+	recs := []kw{
+		{"small", "0123456789"},
+		{"big", string(big)},
+		{"empty", ""},
+	}
+
+	variants := []struct {
+		name string
+		opts []cdb.Option
+	}{
+		{"plain", nil},
+		{"expiry", []cdb.Option{cdb.WithExpiry()}},
+		{"blobs", []cdb.Option{cdb.WithBlobs(64), cdb.WithExpiry()}},
+		{"front", []cdb.Option{cdb.WithFrontCoding()}},
+	}
+
+	ranges := []struct {
+		off, n int
+	}{
+		{0, 4}, {3, 5}, {8, 10}, {10, 1}, {64, 64}, {150, 100}, {300, 1}, {0, 0},
+	}
+
+	for _, v := range variants {
+		fn := "./test/range-" + v.name + ".cdb"
+		makeDBAt(t, fn, recs, v.opts...)
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("%s: can't open: %s", v.name, err)
+		}
+
+		for _, r := range recs {
+			for _, rg := range ranges {
+				exp := []byte(r.val)
+				exp = exp[min(rg.off, len(exp)):min(rg.off+rg.n, len(exp))]
+
+				got, err := db.GetRange([]byte(r.key), rg.off, rg.n)
+				if err != nil || got == nil || !bytes.Equal(got, exp) {
+					t.Fatalf("%s: %s[%d+%d]: exp %q, saw %q, %v", v.name, r.key, rg.off, rg.n, exp, got, err)
+				}
+			}
+		}
+
+		if got, err := db.GetRange([]byte("missing"), 0, 4); got != nil || err != nil {
+			t.Fatalf("%s: missing key: saw %q, %v", v.name, got, err)
+		}
+		if _, err := db.GetRange([]byte("small"), -1, 4); err == nil {
+			t.Fatalf("%s: negative offset accepted", v.name)
+		}
+		db.Close()
+	}
+}
+
+func TestOpenStrict(t *testing.T) {
+	variants := []struct {
+		name string
+		opts []cdb.Option
+	}{
+		{"default", nil},
+		{"mph", []cdb.Option{cdb.WithMPH()}},
+		{"tables", []cdb.Option{cdb.WithTables(64)}},
+		{"blobs", []cdb.Option{cdb.WithBlobs(4)}},
+	}
+
+	for _, v := range variants {
+		fn := "./test/strict-" + v.name + ".cdb"
+		makeDBAt(t, fn, testRecords, v.opts...)
+
+		db, err := cdb.OpenStrict(fn)
+		if err != nil {
+			t.Fatalf("%s: OpenStrict failed: %s", v.name, err)
+		}
+		db.Close()
+	}
+
+	fn := "./test/strict-default.cdb"
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	for _, r := range testRecords {
+		wr.Put([]byte(r.key), []byte(r.val))
+	}
+	wr.Delete([]byte("gone"))
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	good, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+
+	// the first table after one with slots
+	var i int
+	for i = 1; binary.LittleEndian.Uint32(good[8*(i-1)+4:]) == 0; i++ {
+	}
+
+	corrupt := []struct {
+		name string
+		fn   func(b []byte)
+	}{
+		{"gap", func(b []byte) {
+			off := binary.LittleEndian.Uint32(b[8*i:])
+			binary.LittleEndian.PutUint32(b[8*i:], off+8)
+		}},
+		{"overlap", func(b []byte) {
+			off := binary.LittleEndian.Uint32(b[8*i:])
+			binary.LittleEndian.PutUint32(b[8*i:], off-8)
+		}},
+		{"slots", func(b []byte) {
+			n := binary.LittleEndian.Uint32(b[8*255+4:])
+			binary.LittleEndian.PutUint32(b[8*255+4:], n+1)
+		}},
+	}
+
+	for _, c := range corrupt {
+		bad := slices.Clone(good)
+		c.fn(bad)
+
+		fn := "./test/strict-" + c.name + ".cdb"
+		if err := os.WriteFile(fn, bad, 0644); err != nil {
+			t.Fatalf("Can't write %s: %s", fn, err)
+		}
+
+		_, err := cdb.OpenStrict(fn, cdb.WithSkipVerify())
+		if !errors.Is(err, cdb.ErrBadIndex) {
+			t.Fatalf("%s: OpenStrict: %v", c.name, err)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithMPH()}, {cdb.WithBloom(10)}} {
+		fn := "./test/layout.cdb"
+		makeDBAt(t, fn, testRecords, opts...)
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+
+		l, err := db.Layout()
+		if err != nil {
+			t.Fatalf("Layout failed: %s", err)
+		}
+		if l.Records != int64(len(testRecords)) || l.DataStart != 2048 || len(l.Tables) != 256 {
+			t.Fatalf("Unexpected layout: %+v", l)
+		}
+
+		var used uint32
+		for _, tl := range l.Tables {
+			used += tl.Used
+		}
+		if l.MPH != nil {
+			used += l.MPH.Used
+		}
+		if used != uint32(len(testRecords)) {
+			t.Fatalf("%d slots used, exp %d", used, len(testRecords))
+		}
+
+		for _, r := range testRecords {
+			p := db.Probe([]byte(r.key))
+			if !p.Maybe || (l.MPH == nil && l.Tables[p.Table].Used == 0) {
+				t.Fatalf("Probe %s: %+v", r.key, p)
+			}
+
+			off, ok, err := db.RecordOffset([]byte(r.key))
+			if err != nil || !ok {
+				t.Fatalf("RecordOffset %s: %v, %v", r.key, ok, err)
+			}
+			raw, err := db.RawRecord(off)
+			if err != nil {
+				t.Fatalf("RawRecord %d: %s", off, err)
+			}
+			if exp := r.key + r.val; string(raw.Bytes[8:]) != exp || raw.KeyLen != uint32(len(r.key)) {
+				t.Fatalf("RawRecord %d: exp %q, saw %q", off, exp, raw.Bytes[8:])
+			}
+		}
+		db.Close()
+	}
+}
+
+func TestMPH(t *testing.T) {
+	wr, err := cdb.Create("./test/mph.cdb", cdb.WithMPH(), cdb.WithValidateOnClose())
+	if err != nil {
+		t.Fatalf("Can't create mph.cdb: %s", err)
+	}
+
+	const N = 5000
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		err = wr.Put([]byte(k), []byte(k))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", k, err)
+		}
+	}
+
+	// a duplicate: only the first record is found by Get
+	err = wr.Put([]byte("key-7"), []byte("dup"))
+	if err != nil {
+		t.Fatalf("Can't put duplicate key: %s", err)
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close mph.cdb: %s", err)
+	}
+
+	db, err := cdb.Open("./test/mph.cdb")
+	if err != nil {
+		t.Fatalf("Can't open mph.cdb: %s", err)
+	}
+	defer db.Close()
+
+	if db.Features()&cdb.FeatureMPH == 0 {
+		t.Fatalf("Expected the mph feature, got %s", db.Features())
+	}
+
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		v, err := db.Get([]byte(k))
+		if err != nil || string(v) != k {
+			t.Fatalf("Can't find key %s: %q, %v", k, v, err)
+		}
+
+		k = fmt.Sprintf("nokey-%d", i)
+		v, err = db.Get([]byte(k))
+		if err != nil || v != nil {
+			t.Fatalf("Found unexpected key %s: %q, %v", k, v, err)
+		}
+	}
+
+	// the parallel scan splits the data using the mph slot table
+	var n atomic.Int64
+	err = db.RangeParallel(4, func(k, v []byte) bool {
+		n.Add(1)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Can't range over mph.cdb: %s", err)
+	}
+	// the duplicate is shadowed by the first key-7
+	if n.Load() != N {
+		t.Fatalf("Expected %d records, got %d", N, n.Load())
+	}
+}
+
+// TestMPHWriteOnly builds an MPH database on an output that can't be
+// read back, where the keys of duplicate records are compared in memory
+func TestMPHWriteOnly(t *testing.T) {
+	fn := "./test/mph-wo.cdb"
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	defer f.Close()
+
+	out := struct{ io.WriteSeeker }{f}
+	wr, err := cdb.NewWriter(out, nil, cdb.WithMPH())
+	if err != nil {
+		t.Fatalf("Can't make writer: %s", err)
+	}
+	for _, r := range append(testRecords, kw{"abc", "dup"}) {
+		if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Get %s: exp %s, saw %q, %v", r.key, r.val, v, err)
+		}
+	}
+}
+
+func TestFrontCoding(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("https://example.com/static/assets/images/%04d/%d.png", i/10, i)
+		recs = append(recs, kw{k, fmt.Sprintf("%d", i)})
+	}
+	recs = append(recs, kw{"short", "x"}, kw{"", "empty"})
+
+	makeDBAt(t, "./test/plain.cdb", recs)
+	makeDBAt(t, "./test/front.cdb", recs, cdb.WithFrontCoding(), cdb.WithValidateOnClose())
+
+	plain, err := os.Stat("./test/plain.cdb")
+	if err != nil {
+		t.Fatalf("Can't stat plain.cdb: %s", err)
+	}
+	front, err := os.Stat("./test/front.cdb")
+	if err != nil {
+		t.Fatalf("Can't stat front.cdb: %s", err)
+	}
+	if front.Size() >= plain.Size()*3/4 {
+		t.Fatalf("Front coding didn't shrink the database: %d vs %d bytes", front.Size(), plain.Size())
+	}
+
+	db, err := cdb.Open("./test/front.cdb")
+	if err != nil {
+		t.Fatalf("Can't open front.cdb: %s", err)
+	}
+	defer db.Close()
+
+	buf := make([]byte, 64)
+	for _, r := range recs {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Can't find key %s: %q, %v", r.key, v, err)
+		}
+
+		n, ok, err := db.GetInto([]byte(r.key), buf)
+		if err != nil || !ok || string(buf[:n]) != r.val {
+			t.Fatalf("GetInto %s: %q, %v, %v", r.key, buf[:n], ok, err)
+		}
+	}
+
+	v, err := db.Get([]byte("https://example.com/static/assets/images/0000/99.png"))
+	if err != nil || v != nil {
+		t.Fatalf("Found unexpected key: %q, %v", v, err)
+	}
+
+	var i int
+	iter := db.Iter()
+	for iter.Next() {
+		if string(iter.Key()) != recs[i].key || string(iter.Value()) != recs[i].val {
+			t.Fatalf("Iterator: expected %s, got %s", recs[i].key, iter.Key())
+		}
+		i++
+	}
+	if i != len(recs) {
+		t.Fatalf("Iterator: expected %d records, got %d", len(recs), i)
+	}
+
+	i = 0
+	err = db.Range(func(k, v []byte) bool {
+		if string(k) != recs[i].key {
+			t.Fatalf("Range: expected %s, got %s", recs[i].key, k)
+		}
+		i++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Can't range over front.cdb: %s", err)
+	}
+}
+
+func TestPutHashed(t *testing.T) {
+	wr, err := cdb.Create("./test/hashed.cdb")
+	if err != nil {
+		t.Fatalf("Can't create hashed.cdb: %s", err)
+	}
+
+	// pre-bucket the records as an upstream sharder would
+	var buckets [256][]cdb.HashedRecord
+	for i := 0; i < 2000; i++ {
+		k := []byte(fmt.Sprintf("key-%d", i))
+		r := cdb.HashedRecord{Hash: cdb.Hash32(k), Key: k, Value: k}
+		buckets[r.Bucket()] = append(buckets[r.Bucket()], r)
+	}
+
+	for _, b := range buckets {
+		err = wr.PutBucket(b)
+		if err != nil {
+			t.Fatalf("Can't put bucket: %s", err)
+		}
+	}
+
+	err = wr.PutHashed(cdb.Hash32([]byte("single")), []byte("single"), []byte("one"))
+	if err != nil {
+		t.Fatalf("Can't put hashed key: %s", err)
+	}
+
+	mixed := []cdb.HashedRecord{buckets[0][0], buckets[1][0]}
+	if err = wr.PutBucket(mixed); err == nil {
+		t.Fatalf("PutBucket accepted records from different buckets")
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze hashed.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		v, err := db.Get([]byte(k))
+		if err != nil || string(v) != k {
+			t.Fatalf("Can't find key %s: %q, %v", k, v, err)
+		}
+	}
+
+	v, err := db.Get([]byte("single"))
+	if err != nil || string(v) != "one" {
+		t.Fatalf("Can't find key single: %q, %v", v, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Can't generate key: %s", err)
+	}
+
+	wr, err := cdb.Create("./test/signed.cdb", cdb.WithValidateOnClose())
+	if err != nil {
+		t.Fatalf("Can't create signed.cdb: %s", err)
+	}
+
+	err = wr.Sign(priv)
+	if err != nil {
+		t.Fatalf("Can't sign signed.cdb: %s", err)
+	}
+
+	for _, r := range testRecords {
+		err = wr.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close signed.cdb: %s", err)
+	}
+
+	db, err := cdb.OpenVerified("./test/signed.cdb", pub)
+	if err != nil {
+		t.Fatalf("Can't open signed.cdb: %s", err)
+	}
+
+	v, err := db.Get([]byte(testRecords[0].key))
+	if err != nil || string(v) != testRecords[0].val {
+		t.Fatalf("Can't find key %s: %q, %v", testRecords[0].key, v, err)
+	}
+	db.Close()
+
+	// the signature doesn't get in the way of plain readers
+	db, err = cdb.Open("./test/signed.cdb")
+	if err != nil {
+		t.Fatalf("Can't open signed.cdb: %s", err)
+	}
+	db.Close()
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	_, err = cdb.OpenVerified("./test/signed.cdb", other)
+	if !errors.Is(err, cdb.ErrBadSignature) {
+		t.Fatalf("Expected ErrBadSignature for the wrong key, got %v", err)
+	}
+
+	makeDB(t)
+	_, err = cdb.OpenVerified("./test/test.cdb", pub)
+	if !errors.Is(err, cdb.ErrBadSignature) {
+		t.Fatalf("Expected ErrBadSignature for an unsigned db, got %v", err)
+	}
+
+	wr, err = cdb.Create("./test/signed.cdb", cdb.WithChecksum(cdb.ChecksumCRC64))
+	if err != nil {
+		t.Fatalf("Can't create signed.cdb: %s", err)
+	}
+	defer wr.Close()
+
+	if err = wr.Sign(priv); err == nil {
+		t.Fatalf("Signed a database with a crc64 checksum")
+	}
+}
+
+func TestSignedBlobs(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Can't generate key: %s", err)
+	}
+
+	fn := "./test/signed-blob.cdb"
+	big := strings.Repeat("line-value", 20)
+	wr, err := cdb.Create(fn, cdb.WithBlobs(64))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := wr.Sign(priv); err != nil {
+		t.Fatalf("Can't sign %s: %s", fn, err)
+	}
+	if err := wr.Put([]byte("big"), []byte(big)); err != nil {
+		t.Fatalf("Can't put big: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	// the blob file is opened along with the database
+	db, err := cdb.OpenVerified(fn, pub)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	v, err := db.Get([]byte("big"))
+	if err != nil || string(v) != big {
+		t.Fatalf("Get big: %.10q, %v", v, err)
+	}
+	db.Close()
+
+	f, err := os.OpenFile(fn+".blob", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Can't open %s.blob: %s", fn, err)
+	}
+	_, err = f.WriteAt([]byte("EVIL"), 0)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Can't damage %s.blob: %s", fn, err)
+	}
+
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithSkipVerify()}} {
+		if db, err := cdb.OpenVerified(fn, pub, opts...); err == nil {
+			v, _ := db.Get([]byte("big"))
+			db.Close()
+			t.Fatalf("OpenVerified accepted a damaged blob file; read %.10q", v)
+		}
+	}
+}
+func TestWithPrefix(t *testing.T) {
+	fn := "./test/prefix.cdb"
+	recs := []kw{
+		{"user/alice", "1"},
+		{"group/admin", "2"},
+		{"user/bob", "3"},
+		{"user/bob/mail", "4"},
+	}
+	makeDBAt(t, fn, recs)
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	users := db.WithPrefix([]byte("user/"))
+	if v, err := users.Get([]byte("alice")); err != nil || string(v) != "1" {
+		t.Fatalf("Get alice: exp 1, saw %q, %v", v, err)
+	}
+	if _, ok, err := users.Lookup([]byte("admin")); ok || err != nil {
+		t.Fatalf("Lookup admin: saw %v, %v", ok, err)
+	}
+
+	var keys []string
+	it := users.Iter()
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Iter failed: %s", err)
+	}
+	if exp := []string{"alice", "bob", "bob/mail"}; !slices.Equal(keys, exp) {
+		t.Fatalf("Iter: exp %q, saw %q", exp, keys)
+	}
+
+	bob := users.WithPrefix([]byte("bob/"))
+	if string(bob.Prefix()) != "user/bob/" {
+		t.Fatalf("nested prefix: saw %q", bob.Prefix())
+	}
+	if v, err := bob.Get([]byte("mail")); err != nil || string(v) != "4" {
+		t.Fatalf("Get mail: exp 4, saw %q, %v", v, err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	wr, err := cdb.Create("./test/ns.cdb")
+	if err != nil {
+		t.Fatalf("Can't create ns.cdb: %s", err)
+	}
+
+	a, b := wr.Namespace("tenant-a"), wr.Namespace("tenant-b")
+	for _, r := range testRecords {
+		if err := a.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+	if err := b.Put([]byte("hello"), []byte("b")); err != nil {
+		t.Fatalf("Can't put key hello: %s", err)
+	}
+	if err := b.Delete([]byte("abc")); err != nil {
+		t.Fatalf("Can't delete key abc: %s", err)
+	}
+	if err := wr.Put([]byte("hello"), []byte("plain")); err != nil {
+		t.Fatalf("Can't put key hello: %s", err)
+	}
+	if err := wr.Namespace("").Put([]byte("x"), nil); err == nil {
+		t.Fatalf("Put to unnamed namespace succeeded")
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close ns.cdb: %s", err)
+	}
+
+	db, err := cdb.Open("./test/ns.cdb")
+	if err != nil {
+		t.Fatalf("Can't open ns.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for ns, exp := range map[string]string{"tenant-a": "world", "tenant-b": "b"} {
+		v, err := db.Namespace(ns).Get([]byte("hello"))
+		if err != nil || string(v) != exp {
+			t.Fatalf("%s: Get hello: exp %s, saw %s, %v", ns, exp, v, err)
+		}
+	}
+	if v, _ := db.Get([]byte("hello")); string(v) != "plain" {
+		t.Fatalf("Get hello: exp plain, saw %s", v)
+	}
+	if _, ok, _ := db.Namespace("tenant-c").Lookup([]byte("hello")); ok {
+		t.Fatalf("Found key in unknown namespace")
+	}
+
+	dir := db.Namespaces()
+	exp := []cdb.NamespaceStats{
+		{Name: "tenant-a", Records: 3, Bytes: 22},
+		{Name: "tenant-b", Records: 1, Deleted: 1, Bytes: 9},
+	}
+	if !reflect.DeepEqual(dir, exp) {
+		t.Fatalf("Namespace directory mismatch:\nexp %+v\nsaw %+v", exp, dir)
+	}
+
+	var i int
+	iter := db.Namespace("tenant-a").Iter()
+	for ; iter.Next(); i++ {
+		r := testRecords[i]
+		if string(iter.Key()) != r.key || string(iter.Value()) != r.val {
+			t.Fatalf("Record %d: exp %s=%s, saw %s=%s", i, r.key, r.val, iter.Key(), iter.Value())
+		}
+	}
+	if err := iter.Err(); err != nil || i != len(testRecords) {
+		t.Fatalf("Iterated %d of %d records: %v", i, len(testRecords), err)
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	const n = 2000
+	url := func(i int) string {
+		return fmt.Sprintf("https://example.com/some/rather/long/path/to/resource/%d?query=%d", i, i*7)
+	}
+
+	build := func(fn string, opts ...cdb.Option) int64 {
+		wr, err := cdb.Create(fn, opts...)
+		if err != nil {
+			t.Fatalf("Can't create %s: %s", fn, err)
+		}
+		for i := 0; i < n; i++ {
+			if err := wr.Put([]byte(url(i)), []byte(fmt.Sprint(i))); err != nil {
+				t.Fatalf("Can't put %d: %s", i, err)
+			}
+		}
+		if err := wr.Delete([]byte("gone")); err != nil {
+			t.Fatalf("Can't delete: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Can't close %s: %s", fn, err)
+		}
+
+		st, err := os.Stat(fn)
+		if err != nil {
+			t.Fatalf("Can't stat %s: %s", fn, err)
+		}
+		return st.Size()
+	}
+
+	plain := build("./test/fp-plain.cdb")
+	for _, bits := range []int{64, 128} {
+		fn := fmt.Sprintf("./test/fp-%d.cdb", bits)
+		if size := build(fn, cdb.WithKeyFingerprint(bits), cdb.WithValidateOnClose()); size >= plain/2 {
+			t.Fatalf("%d bit fingerprints: %d bytes, plain %d bytes", bits, size, plain)
+		}
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		if db.Features()&cdb.FeatureKeyFingerprint == 0 {
+			t.Fatalf("%s: features %s", fn, db.Features())
+		}
+
+		for i := 0; i < n; i++ {
+			v, err := db.Get([]byte(url(i)))
+			if err != nil || string(v) != fmt.Sprint(i) {
+				t.Fatalf("%s: Get %d: saw %q, %v", fn, i, v, err)
+			}
+		}
+		for _, k := range []string{url(n), "gone", "x"} {
+			if _, ok, err := db.Lookup([]byte(k)); ok || err != nil {
+				t.Fatalf("%s: Lookup %s: %v, %v", fn, k, ok, err)
+			}
+		}
+
+		iter := db.Iter()
+		if !iter.Next() || !bytes.Equal(iter.Key(), cdb.KeyFingerprint([]byte(url(0)), bits)) {
+			t.Fatalf("%s: first key %x is not the fingerprint", fn, iter.Key())
+		}
+
+		// copies keep the fingerprints
+		cp := fmt.Sprintf("./test/fp-%d-copy.cdb", bits)
+		err = cdb.Rewrite(fn, cp, func(w *cdb.Writer, it *cdb.Iterator) error {
+			if it == nil {
+				return w.Put(cdb.KeyFingerprint([]byte("new"), bits), []byte("added"))
+			}
+			return w.Put(it.Key(), it.Value())
+		})
+		if err != nil {
+			t.Fatalf("%s: Rewrite failed: %s", fn, err)
+		}
+		if err := cdb.Merge(cp+".merged", []*cdb.CDB{db}); err != nil {
+			t.Fatalf("%s: Merge failed: %s", fn, err)
+		}
+		db.Close()
+
+		for _, path := range []string{cp, cp + ".merged"} {
+			db, err := cdb.Open(path)
+			if err != nil {
+				t.Fatalf("Can't open %s: %s", path, err)
+			}
+			if v, err := db.Get([]byte(url(5))); err != nil || string(v) != "5" {
+				t.Fatalf("%s: Get: saw %q, %v", path, v, err)
+			}
+			db.Close()
+		}
+
+		db, err = cdb.Open(cp)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", cp, err)
+		}
+		if v, err := db.Get([]byte("new")); err != nil || string(v) != "added" {
+			t.Fatalf("%s: Get new: saw %q, %v", cp, v, err)
+		}
+		db.Close()
+	}
+
+	// a check that rejects everything but even values
+	even := func(key, value []byte) bool {
+		return value[len(value)-1]%2 == 0
+	}
+	db, err := cdb.Open("./test/fp-64.cdb", cdb.WithFingerprintCheck(even))
+	if err != nil {
+		t.Fatalf("Can't open fp-64.cdb: %s", err)
+	}
+	defer db.Close()
+
+	if v, err := db.Get([]byte(url(4))); err != nil || string(v) != "4" {
+		t.Fatalf("Get 4: saw %q, %v", v, err)
+	}
+	if v, ok, err := db.Lookup([]byte(url(5))); ok || err != nil {
+		t.Fatalf("Lookup 5: saw %q, %v, %v", v, ok, err)
+	}
+	if n, ok, err := db.GetInto([]byte(url(5)), make([]byte, 8)); ok || err != nil {
+		t.Fatalf("GetInto 5: saw %d, %v, %v", n, ok, err)
+	}
+
+	if _, err := cdb.Create("./test/fp-bad.cdb", cdb.WithKeyFingerprint(32)); err == nil {
+		t.Fatalf("32 bit fingerprints accepted")
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	lower := cdb.WithKeyTransform("lower", bytes.ToLower)
+	makeDBAt(t, "./test/keyfn.cdb", []kw{{"Hello", "world"}, {"ABC", "def"}}, lower)
+
+	db, err := cdb.Open("./test/keyfn.cdb", lower)
+	if err != nil {
+		t.Fatalf("Can't open keyfn.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, k := range []string{"hello", "HELLO", "Hello"} {
+		v, err := db.Get([]byte(k))
+		if err != nil || string(v) != "world" {
+			t.Fatalf("Get %s: exp world, saw %s, %v", k, v, err)
+		}
+	}
+
+	var dst [16]byte
+	n, ok, err := db.GetInto([]byte("abc"), dst[:])
+	if err != nil || !ok || string(dst[:n]) != "def" {
+		t.Fatalf("GetInto abc: exp def, saw %s, %v, %v", dst[:n], ok, err)
+	}
+
+	if db.Features()&cdb.FeatureKeyTransform == 0 {
+		t.Fatalf("Key transform feature not set: %s", db.Features())
+	}
+
+	// readers must normalize the same way
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithKeyTransform("upper", bytes.ToUpper)}} {
+		if db, err := cdb.Open("./test/keyfn.cdb", opts...); err == nil {
+			db.Close()
+			t.Fatalf("Open with mismatched key transform succeeded")
+		}
+	}
+
+	makeDB(t)
+	if db, err := cdb.Open("./test/test.cdb", lower); err == nil {
+		db.Close()
+		t.Fatalf("Open of plain database with key transform succeeded")
+	}
+}
+
+func TestMmap(t *testing.T) {
+	makeDB(t)
+
+	for _, opt := range []cdb.Option{cdb.WithMmap(), cdb.WithMadvise(cdb.AdviceRandom), cdb.WithMadvise(cdb.AdviceWillNeed)} {
+		db, err := cdb.Open("./test/test.cdb", opt)
+		if err != nil {
+			t.Fatalf("Can't open test.cdb: %s", err)
+		}
+
+		if err := db.Prefault(); err != nil {
+			t.Fatalf("Prefault failed: %s", err)
+		}
+
+		for _, r := range testRecords {
+			v, err := db.Get([]byte(r.key))
+			if err != nil || string(v) != r.val {
+				t.Fatalf("Get %s: exp %s, saw %s, %v", r.key, r.val, v, err)
+			}
+		}
+
+		if v, err := db.Get([]byte("not there")); err != nil || v != nil {
+			t.Fatalf("Get of missing key: saw %s, %v", v, err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	keys := [][]byte{[]byte("hello"), []byte("not there")}
+	if err := db.Warm(keys); err != nil {
+		t.Fatalf("Warm failed: %s", err)
+	}
+
+	if err := db.WarmAll(0); err != nil {
+		t.Fatalf("WarmAll failed: %s", err)
+	}
+
+	st, err := os.Stat("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't stat test.cdb: %s", err)
+	}
+
+	// at 10x the file size per second, this takes about 100ms
+	start := time.Now()
+	if err := db.WarmAll(st.Size() * 10); err != nil {
+		t.Fatalf("WarmAll failed: %s", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("WarmAll ignored the rate limit: %s", d)
+	}
+}
+
+func TestDirectIO(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 2000; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), strings.Repeat(fmt.Sprintf("%d", i), 100+i%400)})
+	}
+	makeDBAt(t, "./test/direct.cdb", recs)
+
+	db, err := cdb.Open("./test/direct.cdb", cdb.WithDirectIO())
+	if err != nil {
+		t.Fatalf("Can't open direct.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, r := range recs {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Get %s: exp %d bytes, saw %d, %v", r.key, len(r.val), len(v), err)
+		}
+	}
+
+	var i int
+	err = db.Range(func(k, v []byte) bool {
+		if string(k) != recs[i].key || string(v) != recs[i].val {
+			t.Fatalf("Record %d mismatch: saw %s", i, k)
+		}
+		i++
+		return true
+	})
+	if err != nil || i != len(recs) {
+		t.Fatalf("Range saw %d records, %v", i, err)
+	}
+}
+
+// TestConcurrentReaders hammers one database from many goroutines and
+// closes it under them; run it with -race.
+func TestConcurrentReaders(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 1000; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)})
+	}
+	makeDBAt(t, "./test/concurrent.cdb", recs)
+
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithMmap()}, {cdb.WithProbeStats()}} {
+		db, err := cdb.Open("./test/concurrent.cdb", opts...)
+		if err != nil {
+			t.Fatalf("Can't open concurrent.cdb: %s", err)
+		}
+
+		var wg sync.WaitGroup
+		var gets atomic.Int64
+		errs := make(chan error, 1)
+		for g := 0; g < 1000; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+
+				rd := db
+				if g%2 == 0 {
+					rd = db.Clone()
+					defer rd.Close()
+				}
+
+				for i := 0; i < 100; i++ {
+					r := recs[(g*31+i)%len(recs)]
+					v, err := rd.Get([]byte(r.key))
+					if errors.Is(err, cdb.ErrClosed) {
+						// closed under us
+						return
+					}
+					if err != nil || string(v) != r.val {
+						select {
+						case errs <- fmt.Errorf("Get %s: exp %s, saw %s, %v", r.key, r.val, v, err):
+						default:
+						}
+						return
+					}
+					gets.Add(1)
+				}
+			}(g)
+		}
+
+		// close once the readers are well under way
+		for gets.Load() < 10000 && len(errs) == 0 {
+			runtime.Gosched()
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+		wg.Wait()
+
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		default:
+		}
+
+		if _, err := db.Get([]byte(recs[0].key)); !errors.Is(err, cdb.ErrClosed) {
+			t.Fatalf("Get after Close: exp ErrClosed, saw %v", err)
+		}
+		if err := db.Close(); !errors.Is(err, cdb.ErrClosed) {
+			t.Fatalf("Second Close: exp ErrClosed, saw %v", err)
+		}
+	}
+}
+
+// BenchmarkParallelLookup looks up keys from all the CPUs at once, to
+// show what the reads pay for being safe to Close under them.
+func BenchmarkParallelLookup(b *testing.B) {
+	var recs []kw
+	for i := 0; i < 1000; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)})
+	}
+	makeDBAt(b, "./test/parallel.cdb", recs)
+
+	for _, bc := range []struct {
+		name string
+		opts []cdb.Option
+	}{
+		{"file", nil},
+		{"mmap", []cdb.Option{cdb.WithMmap()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db, err := cdb.Open("./test/parallel.cdb", bc.opts...)
+			if err != nil {
+				b.Fatalf("Can't open parallel.cdb: %s", err)
+			}
+			defer db.Close()
+
+			b.RunParallel(func(pb *testing.PB) {
+				buf := make([]byte, 64)
+				var i int
+				for pb.Next() {
+					r := recs[i%len(recs)]
+					if _, ok, err := db.GetInto([]byte(r.key), buf); !ok || err != nil {
+						b.Fatalf("GetInto %s: %v, %v", r.key, ok, err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
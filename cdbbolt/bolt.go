@@ -0,0 +1,124 @@
+// Package cdbbolt copies cdb databases to and from bbolt buckets, so
+// that a dataset can move between a mutable store and an immutable one
+// as it stabilizes. It lives in its own package to keep bbolt out of
+// programs that only use cdb.
+package cdbbolt
+
+import (
+	"bytes"
+	"fmt"
+
+	"cdb"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// records per transaction when loading a bucket; bbolt holds the dirty
+// pages of a transaction in memory
+const batchSize = 50000
+
+// Putter is where FromBolt writes records; *cdb.Writer,
+// *cdb.ShardWriter and *cdb.NamespaceWriter implement it.
+type Putter interface {
+	Put(key, value []byte) error
+}
+
+// ToBolt copies the records of db to a new bucket of the bbolt database
+// at path, which is created if it doesn't exist. The bucket must not
+// exist yet. As with Get, the first record of a key wins, and deleted
+// and expired keys are left out. The records are committed in batches;
+// on error, the bucket is left partially filled.
+func ToBolt(db *cdb.CDB, path, bucket string) error {
+	bdb, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("cdbbolt: %s: bucket %q: %w", path, bucket, err)
+	}
+
+	// a key whose first record is dead is missing, even if a later
+	// record is live; only Lookup can tell
+	check := db.Features()&(cdb.FeatureTombstones|cdb.FeatureExpiry) != 0
+
+	var tx *bolt.Tx
+	var b *bolt.Bucket
+	var n int
+	var perr error
+	err = db.Range(func(key, value []byte) bool {
+		if check {
+			if _, ok, err := db.Lookup(key); err != nil || !ok {
+				perr = err
+				return err == nil
+			}
+		}
+
+		if tx == nil {
+			if tx, perr = bdb.Begin(true); perr != nil {
+				return false
+			}
+			b = tx.Bucket([]byte(bucket))
+		}
+
+		if k, _ := b.Cursor().Seek(key); bytes.Equal(k, key) {
+			return true
+		}
+
+		// bbolt keeps the slices until the transaction commits
+		if perr = b.Put(clone(key), clone(value)); perr != nil {
+			perr = fmt.Errorf("cdbbolt: %s: key %q: %w", path, key, perr)
+			return false
+		}
+
+		if n++; n%batchSize == 0 {
+			perr, tx = tx.Commit(), nil
+		}
+		return perr == nil
+	})
+	if err == nil {
+		err = perr
+	}
+
+	if tx != nil {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}
+	return err
+}
+
+// FromBolt writes the key/value pairs of the bucket of the bbolt
+// database at path to out, in key order. Nested buckets are skipped.
+func FromBolt(path, bucket string, out Putter) error {
+	bdb, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	return bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("cdbbolt: %s: no bucket %q", path, bucket)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil && b.Bucket(k) != nil {
+				return nil
+			}
+			return out.Put(k, v)
+		})
+	})
+}
+
+func clone(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
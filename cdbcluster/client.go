@@ -0,0 +1,309 @@
+package cdbcluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cdb"
+)
+
+// ErrNoServers is returned by lookups on a client without servers.
+var ErrNoServers = errors.New("cdbcluster: no servers")
+
+// Options configures a Client. The zero value picks the defaults.
+type Options struct {
+	// points per server on the ring; see NewRing
+	Vnodes int
+
+	// number of other servers to try when a server fails; default 2,
+	// negative for none
+	Retries int
+
+	// deadline of a request, including connecting; default 1s
+	Timeout time.Duration
+
+	// interval between health checks; default 5s, negative to disable.
+	// Servers that fail a request or a check are tried last until
+	// they pass a check.
+	HealthInterval time.Duration
+
+	// idle connections kept per server; default 4
+	MaxIdle int
+
+	// longest value or error message read from a server; default
+	// DefaultMaxValueSize. A longer response fails the request
+	// before its buffer is allocated.
+	MaxValueSize int
+}
+
+func (o *Options) defaults() {
+	if o.Retries == 0 {
+		o.Retries = 2
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+	if o.HealthInterval == 0 {
+		o.HealthInterval = 5 * time.Second
+	}
+	if o.MaxIdle <= 0 {
+		o.MaxIdle = 4
+	}
+	if o.MaxValueSize <= 0 {
+		o.MaxValueSize = DefaultMaxValueSize
+	}
+}
+
+// Client looks up keys in a cluster of servers.
+type Client struct {
+	ring  *Ring
+	opts  Options
+	nodes map[string]*node
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+var _ cdb.Reader = &Client{}
+
+type node struct {
+	addr    string
+	healthy atomic.Bool
+	idle    chan *conn
+}
+
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// NewClient returns a client for the servers at addrs. A nil opts uses
+// the defaults.
+func NewClient(addrs []string, opts *Options) *Client {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	o.defaults()
+
+	c := &Client{
+		ring:  NewRing(addrs, o.Vnodes),
+		opts:  o,
+		nodes: make(map[string]*node, len(addrs)),
+		stop:  make(chan struct{}),
+	}
+	for _, a := range addrs {
+		n := &node{addr: a, idle: make(chan *conn, o.MaxIdle)}
+		n.healthy.Store(true)
+		c.nodes[a] = n
+	}
+
+	if o.HealthInterval > 0 {
+		c.wg.Add(1)
+		go c.checkHealth()
+	}
+	return c
+}
+
+// Ring returns the ring the client places keys with.
+func (c *Client) Ring() *Ring {
+	return c.ring
+}
+
+// Get returns the value for key, or nil if it can't be found.
+func (c *Client) Get(key []byte) ([]byte, error) {
+	v, _, err := c.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found. It
+// asks the owner of key first; if the server can't be reached, it
+// retries on the next servers of the ring, healthy ones first.
+func (c *Client) Lookup(key []byte) ([]byte, bool, error) {
+	if len(key) > MaxKeySize {
+		return nil, false, fmt.Errorf("cdbcluster: key of %d bytes exceeds %d", len(key), MaxKeySize)
+	}
+
+	tries := c.order(key)
+	if len(tries) == 0 {
+		return nil, false, ErrNoServers
+	}
+	if n := 1 + max(c.opts.Retries, 0); len(tries) > n {
+		tries = tries[:n]
+	}
+
+	var errs []error
+	for _, n := range tries {
+		v, ok, err := c.get(n, key)
+		var se *ServerError
+		if err == nil || errors.As(err, &se) {
+			return v, ok, err
+		}
+
+		n.healthy.Store(false)
+		errs = append(errs, err)
+	}
+	return nil, false, fmt.Errorf("cdbcluster: lookup failed on %d servers: %w", len(tries), errors.Join(errs...))
+}
+
+// order returns the servers to try for key: the ring order, with the
+// unhealthy servers moved to the end
+func (c *Client) order(key []byte) []*node {
+	var good, bad []*node
+	for _, a := range c.ring.Owners(key) {
+		if n := c.nodes[a]; n.healthy.Load() {
+			good = append(good, n)
+		} else {
+			bad = append(bad, n)
+		}
+	}
+	return append(good, bad...)
+}
+
+// get looks key up on n
+func (c *Client) get(n *node, key []byte) ([]byte, bool, error) {
+	cn, err := c.conn(n)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cn.c.SetDeadline(time.Now().Add(c.opts.Timeout))
+	cn.w.WriteByte(opGet)
+	writeBytes(cn.w, key)
+	if err := cn.w.Flush(); err != nil {
+		cn.c.Close()
+		return nil, false, err
+	}
+
+	st, err := cn.r.ReadByte()
+	if err != nil {
+		cn.c.Close()
+		return nil, false, err
+	}
+
+	var v []byte
+	limit := uint64(c.opts.MaxValueSize)
+	switch st {
+	case statusOK:
+		v, err = readBytes(cn.r, limit)
+	case statusNotFound:
+	case statusError:
+		var msg []byte
+		if msg, err = readBytes(cn.r, limit); err == nil {
+			err = &ServerError{Addr: n.addr, Msg: string(msg)}
+			c.release(n, cn)
+			return nil, false, err
+		}
+	default:
+		err = fmt.Errorf("%w: status %d", errProtocol, st)
+	}
+	if err != nil {
+		cn.c.Close()
+		return nil, false, err
+	}
+
+	c.release(n, cn)
+	return v, st == statusOK, nil
+}
+
+// ping checks that n answers
+func (c *Client) ping(n *node) error {
+	cn, err := c.conn(n)
+	if err != nil {
+		return err
+	}
+
+	cn.c.SetDeadline(time.Now().Add(c.opts.Timeout))
+	cn.w.WriteByte(opPing)
+	if err := cn.w.Flush(); err != nil {
+		cn.c.Close()
+		return err
+	}
+
+	st, err := cn.r.ReadByte()
+	if err == nil && st != statusOK {
+		err = fmt.Errorf("%w: status %d", errProtocol, st)
+	}
+	if err != nil {
+		cn.c.Close()
+		return err
+	}
+
+	c.release(n, cn)
+	return nil
+}
+
+// conn returns an idle connection to n, or a new one
+func (c *Client) conn(n *node) (*conn, error) {
+	select {
+	case cn := <-n.idle:
+		return cn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", n.addr, c.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// release returns cn to the idle connections of n
+func (c *Client) release(n *node, cn *conn) {
+	select {
+	case <-c.stop:
+		cn.c.Close()
+		return
+	default:
+	}
+
+	select {
+	case n.idle <- cn:
+	default:
+		cn.c.Close()
+	}
+}
+
+// checkHealth pings all the servers every HealthInterval
+func (c *Client) checkHealth() {
+	defer c.wg.Done()
+
+	t := time.NewTicker(c.opts.HealthInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-t.C:
+		}
+
+		for _, n := range c.nodes {
+			n.healthy.Store(c.ping(n) == nil)
+		}
+	}
+}
+
+// Close stops the health checks and closes the idle connections.
+func (c *Client) Close() error {
+	close(c.stop)
+	c.wg.Wait()
+
+	for _, n := range c.nodes {
+		for {
+			select {
+			case cn := <-n.idle:
+				cn.c.Close()
+				continue
+			default:
+			}
+			break
+		}
+	}
+	return nil
+}
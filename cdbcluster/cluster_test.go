@@ -0,0 +1,208 @@
+package cdbcluster_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cdb"
+	"cdb/cdbcluster"
+)
+
+func TestCluster(t *testing.T) {
+	const nsrv = 3
+	const nkeys = 1000
+
+	lns := make([]net.Listener, nsrv)
+	addrs := make([]string, nsrv)
+	for i := range lns {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Can't listen: %s", err)
+		}
+		lns[i] = ln
+		addrs[i] = ln.Addr().String()
+	}
+
+	// every server holds the keys it owns and those of the server
+	// before it, so one server can fail without losing keys
+	ring := cdbcluster.NewRing(addrs, 0)
+	wrs := make(map[string]*cdb.Writer)
+	fns := make(map[string]string)
+	for i, a := range addrs {
+		fn := filepath.Join(t.TempDir(), fmt.Sprintf("%d.cdb", i))
+		wr, err := cdb.Create(fn)
+		if err != nil {
+			t.Fatalf("Can't create %s: %s", fn, err)
+		}
+		wrs[a], fns[a] = wr, fn
+	}
+
+	for i := 0; i < nkeys; i++ {
+		k := []byte(fmt.Sprintf("key-%d", i))
+		for _, a := range ring.Owners(k)[:2] {
+			if err := wrs[a].Put(k, []byte(fmt.Sprintf("val-%d", i))); err != nil {
+				t.Fatalf("Can't put %s: %s", k, err)
+			}
+		}
+	}
+
+	srvs := make(map[string]*cdbcluster.Server)
+	for i, a := range addrs {
+		if err := wrs[a].Close(); err != nil {
+			t.Fatalf("Can't close %s: %s", fns[a], err)
+		}
+		db, err := cdb.Open(fns[a])
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fns[a], err)
+		}
+		defer db.Close()
+
+		srv := cdbcluster.NewServer(db)
+		srvs[a] = srv
+		go srv.Serve(lns[i])
+		defer srv.Close()
+	}
+
+	c := cdbcluster.NewClient(addrs, &cdbcluster.Options{HealthInterval: 50 * time.Millisecond})
+	defer c.Close()
+
+	check := func(what string) {
+		for i := 0; i < nkeys; i++ {
+			k := []byte(fmt.Sprintf("key-%d", i))
+			v, ok, err := c.Lookup(k)
+			if err != nil {
+				t.Fatalf("%s: lookup %s: %s", what, k, err)
+			}
+			if want := fmt.Sprintf("val-%d", i); !ok || string(v) != want {
+				t.Fatalf("%s: lookup %s: exp %q, saw %q (found %v)", what, k, want, v, ok)
+			}
+		}
+
+		v, ok, err := c.Lookup([]byte("missing"))
+		if err != nil || ok || v != nil {
+			t.Fatalf("%s: lookup of missing key: saw %q, %v, %v", what, v, ok, err)
+		}
+	}
+	check("all up")
+
+	// the first server fails; its keys are served by the next one
+	if err := srvs[addrs[0]].Close(); err != nil {
+		t.Fatalf("Can't close server: %s", err)
+	}
+	check("one down")
+
+	// wait for a health check to notice, and keep serving
+	time.Sleep(200 * time.Millisecond)
+	check("after health check")
+
+	// with two servers down, the last one answers for all keys, and
+	// doesn't have those it doesn't hold a copy of
+	srvs[addrs[1]].Close()
+	for i := 0; i < nkeys; i++ {
+		k := []byte(fmt.Sprintf("key-%d", i))
+		_, ok, err := c.Lookup(k)
+		if err != nil {
+			t.Fatalf("two down: lookup %s: %s", k, err)
+		}
+
+		owners := ring.Owners(k)
+		if held := owners[0] == addrs[2] || owners[1] == addrs[2]; ok != held {
+			t.Fatalf("two down: lookup %s: exp found %v, saw %v", k, held, ok)
+		}
+	}
+}
+
+// TestMaxValueSize talks to a server that announces a value longer than
+// the client accepts; the lookup must fail rather than allocate it.
+func TestMaxValueSize(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Can't listen: %s", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+
+		// read the request: op, key length, key
+		r := bufio.NewReader(nc)
+		r.ReadByte()
+		n, _ := binary.ReadUvarint(r)
+		r.Discard(int(n))
+
+		var b [1 + binary.MaxVarintLen64]byte
+		m := binary.PutUvarint(b[1:], 1<<30)
+		nc.Write(b[:1+m])
+	}()
+
+	c := cdbcluster.NewClient([]string{ln.Addr().String()}, &cdbcluster.Options{
+		Retries:        -1,
+		HealthInterval: -1,
+		MaxValueSize:   1024,
+	})
+	defer c.Close()
+
+	_, _, err = c.Lookup([]byte("key"))
+	if err == nil || !strings.Contains(err.Error(), "exceeds 1024") {
+		t.Fatalf("Oversized value: exp a protocol error, saw %v", err)
+	}
+}
+
+func TestRing(t *testing.T) {
+	addrs := []string{"a:1", "b:1", "c:1", "d:1"}
+	r := cdbcluster.NewRing(addrs, 0)
+
+	// the ring doesn't depend on the order of the addresses
+	r2 := cdbcluster.NewRing([]string{"d:1", "c:1", "b:1", "a:1"}, 0)
+
+	// adding a server only moves keys to it
+	r3 := cdbcluster.NewRing(append(addrs, "e:1"), 0)
+
+	const n = 10000
+	var moved int
+	count := make(map[string]int)
+	for i := 0; i < n; i++ {
+		k := []byte(fmt.Sprintf("key-%d", i))
+		o := r.Owner(k)
+		count[o]++
+
+		if o2 := r2.Owner(k); o2 != o {
+			t.Fatalf("%s: owners differ: %s, %s", k, o, o2)
+		}
+
+		owners := r.Owners(k)
+		if len(owners) != len(addrs) || owners[0] != o {
+			t.Fatalf("%s: bad owners %v", k, owners)
+		}
+
+		if o3 := r3.Owner(k); o3 != o {
+			if o3 != "e:1" {
+				t.Fatalf("%s: moved from %s to %s", k, o, o3)
+			}
+			moved++
+		}
+	}
+
+	for _, a := range addrs {
+		if c := count[a]; c < n/8 || c > n/2 {
+			t.Fatalf("%s: owns %d of %d keys", a, c, n)
+		}
+	}
+	if moved < n/10 || moved > n/3 {
+		t.Fatalf("moved %d of %d keys to the new server", moved, n)
+	}
+
+	if o := cdbcluster.NewRing(nil, 0).Owner([]byte("x")); o != "" {
+		t.Fatalf("empty ring: owner %q", o)
+	}
+}
@@ -0,0 +1,86 @@
+// Package cdbcluster serves cdb databases over TCP and spreads lookups
+// over a cluster of such servers with consistent hashing, turning a set
+// of static shards into a lookup tier.
+//
+// A Server answers lookups from one cdb.Reader: a database, a stack or
+// the shards of a cdb.ShardReader. A Client picks the server for a key
+// from a Ring of server addresses, retries on another one when a server
+// fails, and checks the health of the servers in the background. Build
+// the data for each server with the same Ring (see Ring.Owners) so the
+// servers hold the keys the clients send them.
+//
+// The protocol is a simple binary request/response exchange over a
+// persistent connection:
+//
+//	request:  'G' uvarint(len(key)) key     look up key
+//	          'P'                           ping
+//	response: 0 uvarint(len(value)) value   found (ping: just 0)
+//	          1                             not found
+//	          2 uvarint(len(msg)) msg       error
+package cdbcluster
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// request ops
+const (
+	opGet  byte = 'G'
+	opPing byte = 'P'
+)
+
+// response status
+const (
+	statusOK       byte = 0
+	statusNotFound byte = 1
+	statusError    byte = 2
+)
+
+// MaxKeySize is the longest key a server accepts.
+const MaxKeySize = 1 << 20
+
+// DefaultMaxValueSize is the longest value or error message a client
+// reads by default; see Options.MaxValueSize.
+const DefaultMaxValueSize = 64 << 20
+
+// ServerError is an error reported by a server, e.g. a failed read of
+// its database. The client doesn't retry it on another server.
+type ServerError struct {
+	Addr string
+	Msg  string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("cdbcluster: %s: %s", e.Addr, e.Msg)
+}
+
+var errProtocol = errors.New("cdbcluster: protocol error")
+
+// readBytes reads a uvarint length and that many bytes, up to max
+func readBytes(r *bufio.Reader, max uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, fmt.Errorf("%w: length %d exceeds %d", errProtocol, n, max)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeBytes writes b with a uvarint length prefix
+func writeBytes(w *bufio.Writer, b []byte) error {
+	var n [binary.MaxVarintLen64]byte
+	w.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))])
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,82 @@
+package cdbcluster
+
+import (
+	"sort"
+	"strconv"
+
+	"cdb"
+)
+
+// DefaultVnodes is the number of points each server gets on a Ring
+// unless NewRing is told otherwise.
+const DefaultVnodes = 128
+
+// Ring maps keys to servers by consistent hashing: every server owns
+// the keys that hash to the arcs before its points, so adding or
+// removing a server only moves the keys of its arcs.
+type Ring struct {
+	addrs  []string
+	points []point
+}
+
+type point struct {
+	hash uint64
+	node int
+}
+
+// NewRing returns a ring of the servers at addrs with vnodes points
+// each; vnodes <= 0 uses DefaultVnodes. The ring depends only on the
+// set of addresses, not their order.
+func NewRing(addrs []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVnodes
+	}
+
+	r := &Ring{addrs: append([]string(nil), addrs...)}
+	sort.Strings(r.addrs)
+	for i, a := range r.addrs {
+		for v := 0; v < vnodes; v++ {
+			h := cdb.Hash64([]byte(a + "#" + strconv.Itoa(v)))
+			r.points = append(r.points, point{h, i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// Owner returns the server that owns key, or "" for an empty ring.
+func (r *Ring) Owner(key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.addrs[r.points[r.start(key)].node]
+}
+
+// Owners returns all the servers in the order a client tries them for
+// key: the owner first, then the next distinct servers on the ring.
+func (r *Ring) Owners(key []byte) []string {
+	var owners []string
+	seen := make([]bool, len(r.addrs))
+	for i, n := r.start(key), 0; n < len(r.points) && len(owners) < len(r.addrs); i, n = (i+1)%len(r.points), n+1 {
+		if p := r.points[i]; !seen[p.node] {
+			seen[p.node] = true
+			owners = append(owners, r.addrs[p.node])
+		}
+	}
+	return owners
+}
+
+// start returns the index of the first point at or after the hash of
+// key
+func (r *Ring) start(key []byte) int {
+	h := cdb.Hash64(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
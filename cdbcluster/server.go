@@ -0,0 +1,181 @@
+package cdbcluster
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"cdb"
+)
+
+// Server answers lookups from a cdb.Reader over TCP.
+type Server struct {
+	db cdb.Reader
+
+	// IdleTimeout closes connections that send no request for this
+	// long; zero means never.
+	IdleTimeout time.Duration
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer returns a server for db. It doesn't close db.
+func NewServer(db cdb.Reader) *Server {
+	return &Server{db: db, conns: make(map[net.Conn]struct{})}
+}
+
+// ListenAndServe listens on the TCP address addr and serves lookups;
+// see Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves lookups on them until the
+// server is closed, when it returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		if !s.track(c) {
+			c.Close()
+			return nil
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(c)
+			s.serve(c)
+		}()
+	}
+}
+
+func (s *Server) track(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	c.Close()
+}
+
+// serve answers the requests on c until it is closed or fails
+func (s *Server) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		if s.IdleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+
+		op, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch op {
+		case opPing:
+			w.WriteByte(statusOK)
+
+		case opGet:
+			key, err := readBytes(r, MaxKeySize)
+			if err != nil {
+				return
+			}
+			s.get(w, key)
+
+		default:
+			w.WriteByte(statusError)
+			writeBytes(w, []byte("unknown request"))
+			w.Flush()
+			return
+		}
+
+		// answer pipelined requests in one write
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) get(w *bufio.Writer, key []byte) {
+	v, ok, err := s.db.Lookup(key)
+	switch {
+	case err != nil:
+		w.WriteByte(statusError)
+		writeBytes(w, []byte(err.Error()))
+	case !ok:
+		w.WriteByte(statusNotFound)
+	default:
+		w.WriteByte(statusOK)
+		writeBytes(w, v)
+	}
+}
+
+// Close stops the server: it closes the listener and all connections,
+// and waits for the requests in progress.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
+var _ io.Closer = &Server{}
@@ -0,0 +1,408 @@
+// Package cdbdns builds and reads DNS databases in the format of
+// tinydns, the DNS server of djbdns and the classic user of cdb.
+//
+// A Builder compiles lines in the tinydns-data format into a data.cdb
+// that tinydns can serve, and DB looks names up in such a database the
+// way tinydns does: every record of a name is stored under the name
+// (so names have several records each), and "*.fqdn" records answer
+// for names below fqdn that have no records of their own.
+//
+// Each record is stored under the name in DNS wire format, in lower
+// case, with a value of the record type (2 bytes, big endian), a byte
+// that is '=' for plain records and '*' for wildcards, the TTL (4
+// bytes), a timestamp (8 bytes) and the record data in wire format.
+// Timestamps and client locations are not supported: the builder
+// rejects lines that use them, and DB ignores location-specific
+// records.
+package cdbdns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cdb"
+)
+
+// record flags
+const (
+	flagPlain    byte = '='
+	flagWildcard byte = '*'
+)
+
+// SOA timers of '.' lines, as tinydns-data sets them
+const (
+	soaRefresh = 16384
+	soaRetry   = 2048
+	soaExpire  = 1048576
+	soaMinimum = 2560
+)
+
+// Builder adds DNS records to a cdb database.
+type Builder struct {
+	w *cdb.Writer
+
+	// serial of the SOA records of lines that don't give one
+	Serial uint32
+}
+
+// NewBuilder returns a builder that writes records to w, with the
+// current time as the default SOA serial.
+func NewBuilder(w *cdb.Writer) *Builder {
+	return &Builder{w: w, Serial: uint32(time.Now().Unix())}
+}
+
+// Build compiles the tinydns-data lines read from data into a new
+// database at path. If the build fails, the partially written database
+// is removed.
+func Build(path string, data io.Reader, opts ...cdb.Option) error {
+	wr, err := cdb.Create(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = NewBuilder(wr).AddLines(data)
+	if err == nil {
+		err = wr.Close()
+	} else {
+		wr.Close()
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// AddLines adds the records of the tinydns-data lines read from r.
+func (b *Builder) AddLines(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if err := b.AddLine(sc.Text()); err != nil {
+			return fmt.Errorf("cdbdns: line %d: %w", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Add adds rr; a name of the form "*.fqdn" makes it a wildcard record.
+func (b *Builder) Add(rr RR) error {
+	key, err := encodeName(rr.Name)
+	if err != nil {
+		return err
+	}
+
+	flag := flagPlain
+	if strings.HasPrefix(rr.Name, "*.") {
+		flag = flagWildcard
+	}
+
+	v := make([]byte, 15, 15+len(rr.Data))
+	binary.BigEndian.PutUint16(v, rr.Type)
+	v[2] = flag
+	binary.BigEndian.PutUint32(v[3:], rr.TTL)
+	v = append(v, rr.Data...)
+	return b.w.Put(key, v)
+}
+
+// AddLine adds the records of one line in the tinydns-data format,
+// e.g. "+www.example.com:192.0.2.1:300". All the line types of
+// tinydns-data are supported except '%' (locations), plus '3' and '6'
+// for AAAA records, whose address is written as 32 hex digits. Empty
+// lines and lines starting with '#' or '-' are ignored.
+func (b *Builder) AddLine(line string) error {
+	line = strings.TrimRight(line, " \t\r\n")
+	if line == "" {
+		return nil
+	}
+
+	f := strings.Split(line[1:], ":")
+	for i := range f {
+		s, err := unescape(f[i])
+		if err != nil {
+			return err
+		}
+		f[i] = s
+	}
+
+	// field returns field i, or "" if the line is shorter
+	field := func(i int) string {
+		if i < len(f) {
+			return f[i]
+		}
+		return ""
+	}
+
+	// ttl parses field i, and checks that the timestamp and location
+	// fields after it are empty
+	ttl := func(i int, def uint32) (uint32, error) {
+		for _, s := range f[min(i+1, len(f)):] {
+			if s != "" {
+				return 0, fmt.Errorf("timestamps and locations are not supported")
+			}
+		}
+		if s := field(i); s != "" {
+			n, err := strconv.ParseUint(s, 10, 32)
+			return uint32(n), err
+		}
+		return def, nil
+	}
+
+	fqdn := field(0)
+	switch line[0] {
+	case '#', '-':
+		return nil
+
+	case '.', '&':
+		t, err := ttl(3, 259200)
+		if err != nil {
+			return err
+		}
+
+		x := field(2)
+		if !strings.Contains(x, ".") {
+			x += ".ns." + fqdn
+		}
+
+		if line[0] == '.' {
+			soaTTL := uint32(2560)
+			if t == 0 {
+				soaTTL = 0
+			}
+			err = b.soa(fqdn, x, "hostmaster."+fqdn, soaTTL, b.Serial, soaRefresh, soaRetry, soaExpire, soaMinimum)
+			if err != nil {
+				return err
+			}
+		}
+		if err := b.name(fqdn, TypeNS, t, nil, x); err != nil {
+			return err
+		}
+		return b.addr(x, field(1), t)
+
+	case '=', '+':
+		t, err := ttl(2, 86400)
+		if err != nil {
+			return err
+		}
+		if err := b.addr(fqdn, field(1), t); err != nil {
+			return err
+		}
+		if line[0] == '+' {
+			return nil
+		}
+
+		rev, err := reverse4(field(1))
+		if err != nil {
+			return err
+		}
+		return b.name(rev, TypePTR, t, nil, fqdn)
+
+	case '@':
+		t, err := ttl(4, 86400)
+		if err != nil {
+			return err
+		}
+
+		x := field(2)
+		if !strings.Contains(x, ".") {
+			x += ".mx." + fqdn
+		}
+
+		var dist uint64
+		if s := field(3); s != "" {
+			if dist, err = strconv.ParseUint(s, 10, 16); err != nil {
+				return err
+			}
+		}
+
+		if err := b.name(fqdn, TypeMX, t, binary.BigEndian.AppendUint16(nil, uint16(dist)), x); err != nil {
+			return err
+		}
+		return b.addr(x, field(1), t)
+
+	case '\'':
+		t, err := ttl(2, 86400)
+		if err != nil {
+			return err
+		}
+
+		// tinydns-data splits text into strings of 127 bytes
+		var data []byte
+		for s := field(1); ; s = s[min(len(s), 127):] {
+			n := min(len(s), 127)
+			data = append(data, byte(n))
+			data = append(data, s[:n]...)
+			if len(s) <= 127 {
+				break
+			}
+		}
+		return b.Add(RR{Name: fqdn, Type: TypeTXT, TTL: t, Data: data})
+
+	case '^', 'C':
+		t, err := ttl(2, 86400)
+		if err != nil {
+			return err
+		}
+
+		typ := TypePTR
+		if line[0] == 'C' {
+			typ = TypeCNAME
+		}
+		return b.name(fqdn, typ, t, nil, field(1))
+
+	case 'Z':
+		t, err := ttl(8, 2560)
+		if err != nil {
+			return err
+		}
+
+		timers := []uint32{b.Serial, soaRefresh, soaRetry, soaExpire, soaMinimum}
+		for i := range timers {
+			if s := field(3 + i); s != "" {
+				n, err := strconv.ParseUint(s, 10, 32)
+				if err != nil {
+					return err
+				}
+				timers[i] = uint32(n)
+			}
+		}
+		return b.soa(fqdn, field(1), field(2), t, timers[0], timers[1], timers[2], timers[3], timers[4])
+
+	case ':':
+		t, err := ttl(3, 86400)
+		if err != nil {
+			return err
+		}
+
+		typ, err := strconv.ParseUint(field(1), 10, 16)
+		if err != nil {
+			return err
+		}
+		if typ == 0 || uint16(typ) == TypeANY || uint16(typ) == TypeSOA || uint16(typ) == TypeNS {
+			return fmt.Errorf("type %d can't be given as a generic record", typ)
+		}
+		return b.Add(RR{Name: fqdn, Type: uint16(typ), TTL: t, Data: []byte(field(2))})
+
+	case '3', '6':
+		t, err := ttl(2, 86400)
+		if err != nil {
+			return err
+		}
+
+		ip, err := hex.DecodeString(field(1))
+		if err != nil || len(ip) != 16 {
+			return fmt.Errorf("bad IPv6 address %q", field(1))
+		}
+		if err := b.Add(RR{Name: fqdn, Type: TypeAAAA, TTL: t, Data: ip}); err != nil {
+			return err
+		}
+		if line[0] == '3' {
+			return nil
+		}
+		return b.name(reverse6(ip), TypePTR, t, nil, fqdn)
+
+	case '%':
+		return fmt.Errorf("locations are not supported")
+	}
+	return fmt.Errorf("unknown leading character %q", line[0])
+}
+
+// name adds a record whose data is prefix followed by target in wire
+// format
+func (b *Builder) name(owner string, typ uint16, ttl uint32, prefix []byte, target string) error {
+	n, err := encodeName(target)
+	if err != nil {
+		return err
+	}
+	return b.Add(RR{Name: owner, Type: typ, TTL: ttl, Data: append(prefix, n...)})
+}
+
+// addr adds an A record for owner, if ip isn't empty
+func (b *Builder) addr(owner, ip string, ttl uint32) error {
+	if ip == "" {
+		return nil
+	}
+
+	a := net.ParseIP(ip).To4()
+	if a == nil {
+		return fmt.Errorf("bad IPv4 address %q", ip)
+	}
+	return b.Add(RR{Name: owner, Type: TypeA, TTL: ttl, Data: a})
+}
+
+func (b *Builder) soa(owner, mname, rname string, ttl uint32, timers ...uint32) error {
+	m, err := encodeName(mname)
+	if err != nil {
+		return err
+	}
+	r, err := encodeName(rname)
+	if err != nil {
+		return err
+	}
+
+	data := append(m, r...)
+	for _, t := range timers {
+		data = binary.BigEndian.AppendUint32(data, t)
+	}
+	return b.Add(RR{Name: owner, Type: TypeSOA, TTL: ttl, Data: data})
+}
+
+// reverse4 returns the in-addr.arpa name of the IPv4 address ip
+func reverse4(ip string) (string, error) {
+	a := net.ParseIP(ip).To4()
+	if a == nil {
+		return "", fmt.Errorf("bad IPv4 address %q", ip)
+	}
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", a[3], a[2], a[1], a[0]), nil
+}
+
+// reverse6 returns the ip6.arpa name of the IPv6 address ip
+func reverse6(ip []byte) string {
+	const digits = "0123456789abcdef"
+
+	var sb strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		sb.WriteByte(digits[ip[i]&15])
+		sb.WriteByte('.')
+		sb.WriteByte(digits[ip[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa")
+	return sb.String()
+}
+
+// unescape replaces the octal escapes \nnn of tinydns-data fields with
+// the bytes they stand for
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+
+		if i+4 > len(s) {
+			return "", fmt.Errorf("bad escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("bad escape in %q", s)
+		}
+		b = append(b, byte(n))
+		i += 3
+	}
+	return string(b), nil
+}
@@ -0,0 +1,112 @@
+package cdbdns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"cdb"
+)
+
+// DB looks up DNS records in a tinydns data.cdb.
+type DB struct {
+	db *cdb.CDB
+}
+
+// Open opens the database at path.
+func Open(path string, opts ...cdb.Option) (*DB, error) {
+	db, err := cdb.Open(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db}, nil
+}
+
+// New returns a DB reading db.
+func New(db *cdb.CDB) *DB {
+	return &DB{db}
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Lookup returns the records of type qtype (TypeANY for all of them)
+// of name, and whether the name exists. If name has a CNAME record and
+// qtype is neither TypeCNAME nor TypeANY, the CNAME is returned
+// instead. If name has no records at all, the records of the wildcard
+// of its closest ancestor that has one are returned, renamed to name.
+func (d *DB) Lookup(name string, qtype uint16) ([]RR, bool, error) {
+	key, err := encodeName(name)
+	if err != nil {
+		return nil, false, err
+	}
+	name = strings.ToLower(strings.Trim(name, "."))
+
+	rrs, err := d.records(key, name, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// look for a wildcard: *.parent for every parent of the name
+	for k := key; len(rrs) == 0 && k[0] != 0; {
+		k = k[1+k[0]:]
+		wild := append([]byte{1, '*'}, k...)
+		if rrs, err = d.records(wild, name, true); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(rrs) == 0 {
+		return nil, false, nil
+	}
+
+	var cname, match []RR
+	for _, rr := range rrs {
+		if rr.Type == TypeCNAME {
+			cname = append(cname, rr)
+		}
+		if qtype == TypeANY || rr.Type == qtype {
+			match = append(match, rr)
+		}
+	}
+	if len(cname) > 0 && qtype != TypeCNAME && qtype != TypeANY {
+		return cname, true, nil
+	}
+	return match, true, nil
+}
+
+// records returns the records stored under key, named name; wild
+// selects the wildcard records
+func (d *DB) records(key []byte, name string, wild bool) ([]RR, error) {
+	values, err := d.db.GetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrs []RR
+	for _, v := range values {
+		if len(v) < 15 {
+			return nil, fmt.Errorf("cdbdns: %s: record of %d bytes", name, len(v))
+		}
+
+		// records restricted to a location are skipped
+		switch v[2] {
+		case flagPlain:
+			if wild {
+				continue
+			}
+		case flagWildcard:
+		default:
+			continue
+		}
+
+		rrs = append(rrs, RR{
+			Name: name,
+			Type: binary.BigEndian.Uint16(v),
+			TTL:  binary.BigEndian.Uint32(v[3:]),
+			Data: v[15:],
+		})
+	}
+	return rrs, nil
+}
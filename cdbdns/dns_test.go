@@ -0,0 +1,131 @@
+package cdbdns_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cdb"
+	"cdb/cdbdns"
+)
+
+const testData = `# example.com
+.example.com:192.0.2.53:a:3600
+=www.example.com:192.0.2.1:300
++www.example.com:192.0.2.2:300
+@example.com:192.0.2.25:mx1:10
+'example.com:v=spf1 mx -all
+'colon.example.com:a\072b
+Cftp.example.com:www.example.com
++*.dyn.example.com:192.0.2.99
+'*.dyn.example.com:wild
++fixed.dyn.example.com:192.0.2.100
+3six.example.com:20010db8000000000000000000000001
+:gen.example.com:99:\001\002:60
+-www.example.com:192.0.2.3
+`
+
+func TestDNS(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "data.cdb")
+	if err := cdbdns.Build(fn, strings.NewReader(testData)); err != nil {
+		t.Fatalf("Build failed: %s", err)
+	}
+
+	db, err := cdbdns.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		exists bool
+		exp    []string
+	}{
+		{"WWW.Example.com.", cdbdns.TypeA, true, []string{
+			"www.example.com 300 A 192.0.2.1",
+			"www.example.com 300 A 192.0.2.2",
+		}},
+		{"1.2.0.192.in-addr.arpa", cdbdns.TypePTR, true, []string{
+			"1.2.0.192.in-addr.arpa 300 PTR www.example.com.",
+		}},
+		{"example.com", cdbdns.TypeNS, true, []string{
+			"example.com 3600 NS a.ns.example.com.",
+		}},
+		{"example.com", cdbdns.TypeMX, true, []string{
+			"example.com 86400 MX 10 mx1.mx.example.com.",
+		}},
+		{"example.com", cdbdns.TypeTXT, true, []string{
+			`example.com 86400 TXT "v=spf1 mx -all"`,
+		}},
+		{"example.com", cdbdns.TypeAAAA, true, nil},
+		{"a.ns.example.com", cdbdns.TypeA, true, []string{
+			"a.ns.example.com 3600 A 192.0.2.53",
+		}},
+		{"mx1.mx.example.com", cdbdns.TypeA, true, []string{
+			"mx1.mx.example.com 86400 A 192.0.2.25",
+		}},
+		{"colon.example.com", cdbdns.TypeTXT, true, []string{
+			`colon.example.com 86400 TXT "a:b"`,
+		}},
+		{"ftp.example.com", cdbdns.TypeA, true, []string{
+			"ftp.example.com 86400 CNAME www.example.com.",
+		}},
+		{"host.dyn.example.com", cdbdns.TypeA, true, []string{
+			"host.dyn.example.com 86400 A 192.0.2.99",
+		}},
+		{"a.b.dyn.example.com", cdbdns.TypeANY, true, []string{
+			"a.b.dyn.example.com 86400 A 192.0.2.99",
+			`a.b.dyn.example.com 86400 TXT "wild"`,
+		}},
+		{"fixed.dyn.example.com", cdbdns.TypeTXT, true, nil},
+		{"six.example.com", cdbdns.TypeAAAA, true, []string{
+			"six.example.com 86400 AAAA 2001:db8::1",
+		}},
+		{"gen.example.com", 99, true, []string{
+			`gen.example.com 60 TYPE99 \# 2 0102`,
+		}},
+		{"nope.example.com", cdbdns.TypeA, false, nil},
+	}
+	for _, tc := range tests {
+		rrs, exists, err := db.Lookup(tc.name, tc.qtype)
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %s", tc.name, err)
+		}
+
+		var saw []string
+		for i := range rrs {
+			saw = append(saw, rrs[i].String())
+		}
+		if exists != tc.exists || strings.Join(saw, "\n") != strings.Join(tc.exp, "\n") {
+			t.Fatalf("%s/%d: exp %v %q, saw %v %q", tc.name, tc.qtype, tc.exists, tc.exp, exists, saw)
+		}
+	}
+
+	rrs, _, err := db.Lookup("example.com", cdbdns.TypeSOA)
+	if err != nil || len(rrs) != 1 || !strings.HasPrefix(rrs[0].String(), "example.com 2560 SOA a.ns.example.com. hostmaster.example.com. ") {
+		t.Fatalf("SOA: saw %v, %v", rrs, err)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir := t.TempDir()
+	bad := []string{
+		"+www.example.com:300.1.1.1",
+		"+www.example.com:192.0.2.1:300:4000000000000000",
+		"%in:192.168",
+		"!unknown",
+		"'bad.example.com:a\\7",
+	}
+	for i, line := range bad {
+		fn := filepath.Join(dir, "bad.cdb")
+		err := cdbdns.Build(fn, strings.NewReader("+ok.example.com:192.0.2.1\n"+line+"\n"))
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Fatalf("%d: %q: exp an error on line 2, saw %v", i, line, err)
+		}
+		if _, err := cdb.Open(fn); err == nil {
+			t.Fatalf("%d: failed build left %s behind", i, fn)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package cdbdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Record types understood by the data file parser and RR.String.
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypeSOA   uint16 = 6
+	TypePTR   uint16 = 12
+	TypeMX    uint16 = 15
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	TypeANY   uint16 = 255
+)
+
+var typeNames = map[uint16]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypeSOA:   "SOA",
+	TypePTR:   "PTR",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeANY:   "ANY",
+}
+
+// RR is a resource record.
+type RR struct {
+	// owner name, in lower case, without the trailing dot; for
+	// records found through a wildcard, the name looked up
+	Name string
+
+	Type uint16
+	TTL  uint32
+
+	// the record data in wire format, with names uncompressed
+	Data []byte
+}
+
+// String returns the record in the format of a zone file, e.g.
+// "mail.example.com 86400 MX 10 mx.example.com.".
+func (rr *RR) String() string {
+	t, ok := typeNames[rr.Type]
+	if !ok {
+		t = "TYPE" + strconv.Itoa(int(rr.Type))
+	}
+
+	data, err := rr.data()
+	if err != nil {
+		data = fmt.Sprintf("\\# %d %x", len(rr.Data), rr.Data)
+	}
+	return fmt.Sprintf("%s %d %s %s", rr.Name, rr.TTL, t, data)
+}
+
+var errShort = errors.New("record data too short")
+
+// data returns the data of rr in presentation format
+func (rr *RR) data() (string, error) {
+	d := rr.Data
+	switch rr.Type {
+	case TypeA:
+		if len(d) != 4 {
+			return "", errShort
+		}
+		return net.IP(d).String(), nil
+
+	case TypeAAAA:
+		if len(d) != 16 {
+			return "", errShort
+		}
+		return net.IP(d).String(), nil
+
+	case TypeNS, TypeCNAME, TypePTR:
+		name, _, err := decodeName(d)
+		return name + ".", err
+
+	case TypeMX:
+		if len(d) < 2 {
+			return "", errShort
+		}
+		name, _, err := decodeName(d[2:])
+		return fmt.Sprintf("%d %s.", binary.BigEndian.Uint16(d), name), err
+
+	case TypeTXT:
+		var s []string
+		for len(d) > 0 {
+			n := int(d[0])
+			if len(d) < 1+n {
+				return "", errShort
+			}
+			s = append(s, strconv.Quote(string(d[1:1+n])))
+			d = d[1+n:]
+		}
+		return strings.Join(s, " "), nil
+
+	case TypeSOA:
+		mname, n, err := decodeName(d)
+		if err != nil {
+			return "", err
+		}
+		rname, m, err := decodeName(d[n:])
+		if err != nil {
+			return "", err
+		}
+		d = d[n+m:]
+		if len(d) != 20 {
+			return "", errShort
+		}
+		return fmt.Sprintf("%s. %s. %d %d %d %d %d", mname, rname,
+			binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:]),
+			binary.BigEndian.Uint32(d[8:]), binary.BigEndian.Uint32(d[12:]),
+			binary.BigEndian.Uint32(d[16:])), nil
+	}
+	return "", errors.New("unknown type")
+}
+
+// encodeName returns name in DNS wire format, in lower case
+func encodeName(name string) ([]byte, error) {
+	name = strings.Trim(name, ".")
+	if len(name) > 253 {
+		return nil, fmt.Errorf("name %q too long", name)
+	}
+
+	var b []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("name %q: bad label length", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, strings.ToLower(label)...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// decodeName decodes the wire format name at the start of b, and
+// returns it without the trailing dot, and its length in b
+func decodeName(b []byte) (string, int, error) {
+	var labels []string
+	for i := 0; i < len(b); {
+		n := int(b[i])
+		if n == 0 {
+			return strings.Join(labels, "."), i + 1, nil
+		}
+		if n > 63 || i+1+n > len(b) {
+			break
+		}
+		labels = append(labels, string(b[i+1:i+1+n]))
+		i += 1 + n
+	}
+	return "", 0, errors.New("bad name")
+}
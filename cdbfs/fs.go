@@ -0,0 +1,288 @@
+// Package cdbfs serves the records of a cdb database as a read-only
+// fs.FS: keys are file paths and values are file contents. A database
+// built from a directory tree with Pack is a single-file asset bundle
+// that can back http.FileServer, template.ParseFS and the like.
+//
+// Keys must be valid fs.FS paths (slash separated, unrooted, see
+// fs.ValidPath) to be visible; other records are ignored. Directories
+// are implied by the paths of the files in them.
+//
+// Modification times and permissions are optional; they are read from
+// the database metadata (see cdb.Writer.SetMetadata). MetaModTime holds
+// the time of all files and MetaModTime+":"+path that of a single file,
+// both formatted as RFC 3339; MetaMode+":"+path holds the permission
+// bits of a file in octal. Files are read-only (0444) by default. Pack
+// records both.
+package cdbfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cdb"
+)
+
+// Metadata keys for modification times and permissions
+const (
+	MetaModTime = "cdbfs.mtime"
+	MetaMode    = "cdbfs.mode"
+)
+
+// FS is a read-only filesystem over a database.
+type FS struct {
+	db    *cdb.CDB
+	mtime time.Time
+	meta  map[string][]byte
+
+	// the directory tree, built on first use
+	once sync.Once
+	dirs map[string][]string
+	err  error
+}
+
+var (
+	_ fs.FS         = &FS{}
+	_ fs.ReadFileFS = &FS{}
+	_ fs.ReadDirFS  = &FS{}
+	_ fs.StatFS     = &FS{}
+)
+
+// New returns a filesystem serving the records of db. Closing db
+// invalidates it.
+func New(db *cdb.CDB) *FS {
+	f := &FS{db: db, meta: db.Metadata()}
+	f.mtime = parseTime(f.meta[MetaModTime])
+	return f
+}
+
+// Open opens the named file or directory.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name != "." {
+		v, ok, err := f.db.Lookup([]byte(name))
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		if ok {
+			return &file{Reader: bytes.NewReader(v), info: f.fileInfo(name, len(v))}, nil
+		}
+	}
+
+	ents, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &dir{info: f.dirInfo(name), ents: ents}, nil
+}
+
+// ReadFile returns the contents of the named file.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	v, ok, err := f.db.Lookup([]byte(name))
+	switch {
+	case err != nil:
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	case !ok:
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+	return v, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	ents, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return ents, nil
+}
+
+// Stat returns a FileInfo describing the named file or directory.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	fd, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return fd.Stat()
+}
+
+// readDir returns the entries of directory name
+func (f *FS) readDir(name string) ([]fs.DirEntry, error) {
+	f.once.Do(f.scan)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	names, ok := f.dirs[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	ents := make([]fs.DirEntry, 0, len(names))
+	for _, n := range names {
+		p := path.Join(name, n)
+		if _, isDir := f.dirs[p]; isDir {
+			ents = append(ents, fs.FileInfoToDirEntry(f.dirInfo(p)))
+			continue
+		}
+
+		v, _, err := f.db.Lookup([]byte(p))
+		if err != nil {
+			return nil, err
+		}
+		ents = append(ents, fs.FileInfoToDirEntry(f.fileInfo(p, len(v))))
+	}
+	return ents, nil
+}
+
+// scan builds the directory tree from the keys of the database
+func (f *FS) scan() {
+	seen := make(map[string]map[string]bool)
+	seen["."] = map[string]bool{}
+
+	iter := f.db.Iter()
+	for iter.Next() {
+		name := string(iter.Key())
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+
+		for name != "." {
+			d, base := path.Split(name)
+			d = strings.TrimSuffix(d, "/")
+			if d == "" {
+				d = "."
+			}
+
+			ents, ok := seen[d]
+			if !ok {
+				ents = map[string]bool{}
+				seen[d] = ents
+			}
+			if ents[base] {
+				break
+			}
+			ents[base] = true
+			name = d
+		}
+	}
+	if f.err = iter.Err(); f.err != nil {
+		return
+	}
+
+	f.dirs = make(map[string][]string, len(seen))
+	for d, ents := range seen {
+		names := make([]string, 0, len(ents))
+		for n := range ents {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		f.dirs[d] = names
+	}
+}
+
+func (f *FS) fileInfo(name string, size int) *info {
+	mtime := f.mtime
+	if t := parseTime(f.meta[MetaModTime+":"+name]); !t.IsZero() {
+		mtime = t
+	}
+	mode := fs.FileMode(0444)
+	if m, err := strconv.ParseUint(string(f.meta[MetaMode+":"+name]), 8, 32); err == nil {
+		mode = fs.FileMode(m) & fs.ModePerm
+	}
+	return &info{name: path.Base(name), size: int64(size), mode: mode, mtime: mtime}
+}
+
+func (f *FS) dirInfo(name string) *info {
+	return &info{name: path.Base(name), mode: fs.ModeDir | 0555, mtime: f.mtime}
+}
+
+func parseTime(b []byte) time.Time {
+	t, _ := time.Parse(time.RFC3339, string(b))
+	return t
+}
+
+// file is an open file; its contents are in memory
+type file struct {
+	*bytes.Reader
+	info *info
+}
+
+func (fd *file) Stat() (fs.FileInfo, error) {
+	return fd.info, nil
+}
+
+func (fd *file) Close() error {
+	return nil
+}
+
+// dir is an open directory
+type dir struct {
+	info *info
+	ents []fs.DirEntry
+}
+
+var _ fs.ReadDirFile = &dir{}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		ents := d.ents
+		d.ents = nil
+		return ents, nil
+	}
+
+	if len(d.ents) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.ents))
+	ents := d.ents[:n]
+	d.ents = d.ents[n:]
+	return ents, nil
+}
+
+// info is the fs.FileInfo of files and directories
+type info struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (i *info) Name() string       { return i.name }
+func (i *info) Size() int64        { return i.size }
+func (i *info) Mode() fs.FileMode  { return i.mode }
+func (i *info) ModTime() time.Time { return i.mtime }
+func (i *info) IsDir() bool        { return i.mode.IsDir() }
+func (i *info) Sys() any           { return nil }
@@ -0,0 +1,79 @@
+package cdbfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cdb"
+	"cdb/cdbfs"
+)
+
+func TestFS(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := fstest.MapFS{
+		"index.html":         {Data: []byte("<h1>hi</h1>"), ModTime: mtime},
+		"css/site.css":       {Data: []byte("body {}"), ModTime: mtime, Mode: 0640},
+		"js/lib/app.js":      {Data: []byte("alert(1)"), ModTime: mtime},
+		"templates/a.tmpl":   {Data: []byte("{{.}}"), ModTime: mtime},
+		"templates/empty.md": {Data: []byte{}, ModTime: mtime},
+	}
+
+	fn := filepath.Join(t.TempDir(), "assets.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := cdbfs.Pack(wr, src); err != nil {
+		t.Fatalf("Pack failed: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	fsys := cdbfs.New(db)
+	err = fstest.TestFS(fsys, "index.html", "css/site.css", "js/lib/app.js", "templates/a.tmpl", "templates/empty.md")
+	if err != nil {
+		t.Fatalf("TestFS: %s", err)
+	}
+
+	st, err := fs.Stat(fsys, "css/site.css")
+	if err != nil {
+		t.Fatalf("Stat failed: %s", err)
+	}
+	if !st.ModTime().Equal(mtime) {
+		t.Fatalf("ModTime mismatch: exp %s, saw %s", mtime, st.ModTime())
+	}
+	if st.Mode() != 0640 {
+		t.Fatalf("Mode mismatch: exp %s, saw %s", fs.FileMode(0640), st.Mode())
+	}
+
+	if _, err := fsys.Open("nope.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Open of missing file: exp ErrNotExist, saw %v", err)
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/css/site.css")
+	if err != nil {
+		t.Fatalf("GET failed: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "body {}" {
+		t.Fatalf("GET /css/site.css: %s %q", resp.Status, body)
+	}
+}
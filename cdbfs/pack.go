@@ -0,0 +1,63 @@
+package cdbfs
+
+import (
+	"io/fs"
+	"strconv"
+	"time"
+
+	"cdb"
+)
+
+// Pack writes the regular files of fsys to w, keyed by their paths,
+// and records their modification times and permissions in the metadata
+// of w, replacing any metadata set earlier. The caller closes w.
+func Pack(w *cdb.Writer, fsys fs.FS) error {
+	return PackFilter(w, fsys, nil)
+}
+
+// PackFilter is like Pack, but only packs the files for which keep
+// returns true. keep is also called for directories; returning false
+// skips the directory and everything under it. A nil keep keeps all.
+func PackFilter(w *cdb.Writer, fsys fs.FS, keep func(name string, d fs.DirEntry) bool) error {
+	meta := make(map[string][]byte)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if keep != nil && name != "." && !keep(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		if err := w.Put([]byte(name), b); err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if t := fi.ModTime(); !t.IsZero() {
+			meta[MetaModTime+":"+name] = []byte(t.UTC().Format(time.RFC3339))
+		}
+		meta[MetaMode+":"+name] = []byte(strconv.FormatUint(uint64(fi.Mode().Perm()), 8))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.SetMetadata(meta)
+	return nil
+}
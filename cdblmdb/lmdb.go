@@ -0,0 +1,129 @@
+// Package cdblmdb copies cdb databases to and from LMDB environments,
+// so that a dataset can move between a mutable store and an immutable
+// one as it stabilizes. It lives in its own package to keep LMDB, which
+// needs cgo, out of programs that only use cdb.
+package cdblmdb
+
+import (
+	"fmt"
+
+	"cdb"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+// map size of the environments written; it only reserves address
+// space, and cdb databases are limited to 4GB
+const mapSize = 64 << 30
+
+// Putter is where FromLMDB writes records; *cdb.Writer,
+// *cdb.ShardWriter and *cdb.NamespaceWriter implement it.
+type Putter interface {
+	Put(key, value []byte) error
+}
+
+// open opens the environment in the file at path
+func open(path string, size int64, flags uint) (*lmdb.Env, error) {
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.SetMaxDBs(256)
+	if err == nil && size > 0 {
+		err = env.SetMapSize(size)
+	}
+	if err == nil {
+		err = env.Open(path, lmdb.NoSubdir|flags, 0600)
+	}
+	if err != nil {
+		env.Close()
+		return nil, fmt.Errorf("cdblmdb: %s: %w", path, err)
+	}
+	return env, nil
+}
+
+// ToLMDB copies the records of db to the named database of the LMDB
+// environment in the file at path, which is created if it doesn't
+// exist; name "" is the main database. As with Get, the first record of a key
+// wins, and deleted and expired keys are left out; keys already in the
+// database are kept. LMDB limits keys to 511 bytes. The records are
+// written in one transaction; on error, nothing is added.
+func ToLMDB(db *cdb.CDB, path, name string) error {
+	env, err := open(path, mapSize, 0)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	// a key whose first record is dead is missing, even if a later
+	// record is live; only Lookup can tell
+	check := db.Features()&(cdb.FeatureTombstones|cdb.FeatureExpiry) != 0
+
+	return env.Update(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI(name, lmdb.Create)
+		if err != nil {
+			return fmt.Errorf("cdblmdb: %s: database %q: %w", path, name, err)
+		}
+
+		var perr error
+		err = db.Range(func(key, value []byte) bool {
+			if check {
+				if _, ok, err := db.Lookup(key); err != nil || !ok {
+					perr = err
+					return err == nil
+				}
+			}
+
+			err := txn.Put(dbi, key, value, lmdb.NoOverwrite)
+			if err != nil && !lmdb.IsErrno(err, lmdb.KeyExist) {
+				perr = fmt.Errorf("cdblmdb: %s: key %q: %w", path, key, err)
+				return false
+			}
+			return true
+		})
+		if err == nil {
+			err = perr
+		}
+		return err
+	})
+}
+
+// FromLMDB writes the key/value pairs of the named database of the
+// LMDB environment in the file at path to out, in key order; name ""
+// is the main database.
+func FromLMDB(path, name string, out Putter) error {
+	env, err := open(path, 0, lmdb.Readonly)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	return env.View(func(txn *lmdb.Txn) error {
+		txn.RawRead = true
+
+		dbi, err := txn.OpenDBI(name, 0)
+		if err != nil {
+			return fmt.Errorf("cdblmdb: %s: database %q: %w", path, name, err)
+		}
+
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		for {
+			k, v, err := cur.Get(nil, nil, lmdb.Next)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := out.Put(k, v); err != nil {
+				return err
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package cdblmdb_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cdb"
+	"cdb/cdblmdb"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "src.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	recs := [][2]string{
+		{"hello", "world"},
+		{"abc", "def"},
+		{"dup", "first"},
+		{"empty", ""},
+		{"dup", "second"},
+	}
+	for _, r := range recs {
+		if err := wr.Put([]byte(r[0]), []byte(r[1])); err != nil {
+			t.Fatalf("Can't put %s: %s", r[0], err)
+		}
+	}
+
+	// deleted first, so the later record isn't seen by Get
+	if err := wr.Delete([]byte("gone")); err != nil {
+		t.Fatalf("Can't delete: %s", err)
+	}
+	if err := wr.Put([]byte("gone"), []byte("ghost")); err != nil {
+		t.Fatalf("Can't put gone: %s", err)
+	}
+
+	src, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", fn, err)
+	}
+	defer src.Close()
+
+	envfn := filepath.Join(dir, "export.mdb")
+	if err := cdblmdb.ToLMDB(src, envfn, "recs"); err != nil {
+		t.Fatalf("ToLMDB failed: %s", err)
+	}
+
+	// loading again keeps the records already there
+	if err := cdblmdb.ToLMDB(src, envfn, "recs"); err != nil {
+		t.Fatalf("ToLMDB into an existing database failed: %s", err)
+	}
+
+	out := filepath.Join(dir, "dst.cdb")
+	wr, err = cdb.Create(out)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", out, err)
+	}
+	if err := cdblmdb.FromLMDB(envfn, "recs", wr); err != nil {
+		t.Fatalf("FromLMDB failed: %s", err)
+	}
+	dst, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", out, err)
+	}
+	defer dst.Close()
+
+	exp, err := src.ToMap()
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+	m, err := dst.ToMap()
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", out, err)
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("Round trip: exp %q, saw %q", exp, m)
+	}
+	if dst.Len() != len(exp) {
+		t.Fatalf("Round trip: exp %d records, saw %d", len(exp), dst.Len())
+	}
+
+	if err := cdblmdb.FromLMDB(envfn, "missing", wr); err == nil {
+		t.Fatalf("FromLMDB read a missing database")
+	}
+}
@@ -0,0 +1,133 @@
+// Package cdbmail looks up mail addresses in cdb tables the way mail
+// servers look up their alias, user and virtual domain maps: an address
+// that has no entry of its own falls back to entries for the local part
+// alone and for the whole domain.
+//
+// The keys of a table are addresses ("user@example.com"), local parts
+// ("user") and domains ("@example.com"), all in lower case. For the
+// address User+Ext@Example.COM with '+' as the extension separator, a
+// Resolver tries, in order:
+//
+//	user+ext@example.com
+//	user@example.com
+//	user+ext
+//	user
+//	@example.com
+//
+// and returns the first that is found.
+package cdbmail
+
+import (
+	"strings"
+
+	"cdb"
+)
+
+// Resolver looks up addresses in a table.
+type Resolver struct {
+	db cdb.Reader
+
+	// Extension separates the extension from the user name in local
+	// parts, e.g. '+' as with Postfix and Sendmail, or '-' as with
+	// qmail; zero disables extensions.
+	Extension byte
+}
+
+// NewResolver returns a resolver that looks addresses up in db, without
+// extensions.
+func NewResolver(db cdb.Reader) *Resolver {
+	return &Resolver{db: db}
+}
+
+// Lookup returns the value for addr and the key it was found under,
+// trying the keys returned by Keys in order. The key is "" if none of
+// them was found.
+func (r *Resolver) Lookup(addr string) ([]byte, string, error) {
+	// the keys are all built from the one lower case address
+	buf := make([]byte, 0, 2*len(addr)+1)
+	for _, k := range r.keys(addr, buf) {
+		v, ok, err := r.db.Lookup(k)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			return v, string(k), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// Get is Lookup without the key; it returns nil if addr isn't found.
+func (r *Resolver) Get(addr string) ([]byte, error) {
+	v, _, err := r.Lookup(addr)
+	return v, err
+}
+
+// Keys returns the keys Lookup tries for addr, in order.
+func (r *Resolver) Keys(addr string) []string {
+	var keys []string
+	for _, k := range r.keys(addr, nil) {
+		keys = append(keys, string(k))
+	}
+	return keys
+}
+
+// keys returns the keys to try for addr, built in buf
+func (r *Resolver) keys(addr string, buf []byte) [][]byte {
+	local, domain := addr, ""
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		local, domain = addr[:i], addr[i:]
+	}
+
+	// base is local without the extension
+	base := local
+	if r.Extension != 0 {
+		if i := strings.IndexByte(local, r.Extension); i > 0 {
+			base = local[:i]
+		}
+	}
+
+	// local@domain, with base a prefix of it
+	buf = appendLower(buf, local)
+	buf = appendLower(buf, domain)
+	full := buf[:len(local)+len(domain)]
+	at := buf[len(local):len(full)]
+
+	// base@domain, after full
+	var short []byte
+	if base != local && domain != "" {
+		buf = appendLower(buf, base)
+		buf = append(buf, at...)
+		short = buf[len(full):]
+	}
+
+	keys := make([][]byte, 0, 5)
+	if local != "" && domain != "" {
+		keys = append(keys, full)
+		if short != nil {
+			keys = append(keys, short)
+		}
+	}
+	if local != "" {
+		keys = append(keys, full[:len(local)])
+		if base != local {
+			keys = append(keys, full[:len(base)])
+		}
+	}
+	if len(at) > 1 {
+		keys = append(keys, at)
+	}
+	return keys
+}
+
+// appendLower appends s to b in lower case; addresses are ASCII
+func appendLower(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package cdbmail_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cdb"
+	"cdb/cdbmail"
+)
+
+func TestResolver(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "aliases.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	table := map[string]string{
+		"joe@example.com":       "joe",
+		"joe+lists@example.com": "joe-lists",
+		"postmaster":            "root",
+		"@example.com":          "catchall",
+		"sales+eu":              "eu-team",
+	}
+	for k, v := range table {
+		if err := wr.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Can't put %s: %s", k, err)
+		}
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	r := cdbmail.NewResolver(db)
+	r.Extension = '+'
+
+	tests := []struct {
+		addr, key, val string
+	}{
+		{"Joe@Example.COM", "joe@example.com", "joe"},
+		{"joe+lists@example.com", "joe+lists@example.com", "joe-lists"},
+		{"joe+other@example.com", "joe@example.com", "joe"},
+		{"PostMaster@example.org", "postmaster", "root"},
+		{"postmaster@example.com", "postmaster", "root"},
+		{"sales+eu@example.org", "sales+eu", "eu-team"},
+		{"nobody@example.com", "@example.com", "catchall"},
+		{"nobody@example.org", "", ""},
+		{"postmaster", "postmaster", "root"},
+	}
+	for _, tc := range tests {
+		v, key, err := r.Lookup(tc.addr)
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %s", tc.addr, err)
+		}
+		if key != tc.key || string(v) != tc.val {
+			t.Fatalf("%s: exp %q under %q, saw %q under %q", tc.addr, tc.val, tc.key, v, key)
+		}
+	}
+
+	exp := []string{"user+ext@example.com", "user@example.com", "user+ext", "user", "@example.com"}
+	if keys := r.Keys("User+Ext@Example.COM"); !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("Keys: exp %q, saw %q", exp, keys)
+	}
+	if keys := r.Keys("@Example.com"); !reflect.DeepEqual(keys, []string{"@example.com"}) {
+		t.Fatalf("Keys of a domain: saw %q", keys)
+	}
+
+	r.Extension = 0
+	if keys := r.Keys("a+b@c"); !reflect.DeepEqual(keys, []string{"a+b@c", "a+b", "@c"}) {
+		t.Fatalf("Keys without extensions: saw %q", keys)
+	}
+}
@@ -0,0 +1,131 @@
+// Package cdbproto stores protobuf messages as cdb values. Its Writer
+// and Reader reuse their marshaling buffers, sized from the message or
+// the value before they are filled, so that storing and loading a
+// message costs no more allocations than protobuf itself makes. It lives
+// in its own package to keep protobuf out of programs that only use cdb.
+package cdbproto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"cdb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// buffers longer than this aren't kept for reuse, so that one large
+// message doesn't pin its buffer for the life of the Writer or Reader
+const maxPooled = 1 << 20
+
+// Putter is where a Writer writes records; *cdb.Writer,
+// *cdb.ShardWriter, *cdb.NamespaceWriter and *cdb.Batch implement it.
+// Put must not retain value after it returns.
+type Putter interface {
+	Put(key, value []byte) error
+}
+
+// Writer marshals protobuf messages into the values of a database.
+// It is not safe for concurrent use.
+type Writer struct {
+	w    Putter
+	opts proto.MarshalOptions
+	buf  []byte
+}
+
+// NewWriter returns a Writer that marshals messages with opts and
+// writes them to w.
+func NewWriter(w Putter, opts proto.MarshalOptions) *Writer {
+	return &Writer{w: w, opts: opts}
+}
+
+// Put marshals m and adds it to the database as the value of key.
+func (w *Writer) Put(key []byte, m proto.Message) error {
+	// Size caches the size of m and its submessages, which
+	// MarshalAppend then reuses instead of walking m again
+	opts := w.opts
+	n := opts.Size(m)
+	opts.UseCachedSize = true
+
+	buf, err := opts.MarshalAppend(slices.Grow(w.buf[:0], n), m)
+	if err != nil {
+		return fmt.Errorf("cdbproto: marshal value of %q: %w", key, err)
+	}
+	if cap(buf) <= maxPooled {
+		w.buf = buf
+	}
+	return w.w.Put(key, buf)
+}
+
+// Reader unmarshals protobuf messages from the values of a database.
+// It is safe for concurrent use.
+type Reader struct {
+	db   *cdb.CDB
+	opts proto.UnmarshalOptions
+	bufs sync.Pool
+}
+
+// NewReader returns a Reader that unmarshals the values of db with
+// opts.
+func NewReader(db *cdb.CDB, opts proto.UnmarshalOptions) *Reader {
+	return &Reader{db: db, opts: opts}
+}
+
+// Get looks up key and unmarshals its value into m, which is left alone
+// if the key can't be found. It returns whether the key was found. The
+// value is read into a reused buffer; if it is too small, the buffer is
+// grown to the length of the value and the lookup repeated.
+func (r *Reader) Get(key []byte, m proto.Message) (bool, error) {
+	var buf []byte
+	if p, ok := r.bufs.Get().(*[]byte); ok {
+		buf = *p
+	}
+
+	n, ok, err := r.db.GetInto(key, buf[:cap(buf)])
+	if errors.Is(err, io.ErrShortBuffer) {
+		buf = make([]byte, n)
+		n, ok, err = r.db.GetInto(key, buf)
+	}
+	defer r.put(buf)
+
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := r.opts.Unmarshal(buf[:n], m); err != nil {
+		return true, fmt.Errorf("cdbproto: unmarshal value of %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// put returns buf to the pool
+func (r *Reader) put(buf []byte) {
+	if cap(buf) > 0 && cap(buf) <= maxPooled {
+		r.bufs.Put(&buf)
+	}
+}
+
+// Get looks up key in db and unmarshals its value into m; it is
+// Reader.Get for callers that don't keep a Reader.
+func Get(db *cdb.CDB, key []byte, m proto.Message) (bool, error) {
+	value, ok, err := db.Lookup(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := proto.Unmarshal(value, m); err != nil {
+		return true, fmt.Errorf("cdbproto: unmarshal value of %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// Put marshals m and writes it to w as the value of key; it is
+// Writer.Put for callers that don't keep a Writer.
+func Put(w Putter, key []byte, m proto.Message) error {
+	value, err := proto.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("cdbproto: marshal value of %q: %w", key, err)
+	}
+	return w.Put(key, value)
+}
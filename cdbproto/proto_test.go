@@ -0,0 +1,73 @@
+package cdbproto_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cdb"
+	"cdb/cdbproto"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRoundTrip(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "proto.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	vals := map[string]string{
+		"short": "hi",
+		"long":  strings.Repeat("x", 100),
+		"empty": "",
+	}
+
+	pw := cdbproto.NewWriter(wr, proto.MarshalOptions{Deterministic: true})
+	for k, v := range vals {
+		if err := pw.Put([]byte(k), wrapperspb.String(v)); err != nil {
+			t.Fatalf("Put %s failed: %s", k, err)
+		}
+	}
+	if err := cdbproto.Put(wr, []byte("plain"), wrapperspb.String("p")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := wr.Put([]byte("junk"), []byte{0xff, 0xff}); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	pr := cdbproto.NewReader(db, proto.UnmarshalOptions{})
+	vals["plain"] = "p"
+
+	// twice, so that the second round reuses the buffers
+	for range 2 {
+		for k, v := range vals {
+			var m wrapperspb.StringValue
+			if ok, err := pr.Get([]byte(k), &m); !ok || err != nil {
+				t.Fatalf("Get %s failed: %v, %v", k, ok, err)
+			}
+			if m.GetValue() != v {
+				t.Fatalf("Get %s: exp %q, saw %q", k, v, m.GetValue())
+			}
+		}
+	}
+
+	var m wrapperspb.StringValue
+	if ok, err := cdbproto.Get(db, []byte("long"), &m); !ok || err != nil || m.GetValue() != vals["long"] {
+		t.Fatalf("Get long: saw %v, %v, %q", ok, err, m.GetValue())
+	}
+	if ok, err := pr.Get([]byte("missing"), &m); ok || err != nil {
+		t.Fatalf("Get of a missing key: saw %v, %v", ok, err)
+	}
+	if ok, err := pr.Get([]byte("junk"), &m); !ok || err == nil {
+		t.Fatalf("Get of a bad value: exp an error, saw %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,217 @@
+// Package cdbsql is a read-only database/sql driver for cdb databases,
+// so that existing tools can read them for debugging and reporting.
+//
+// The driver is registered as "cdb"; the data source name is the path
+// of the database:
+//
+//	db, err := sql.Open("cdb", "/var/db/users.cdb")
+//	row := db.QueryRow("SELECT value FROM cdb WHERE key = ?", "alice")
+//
+// A database is a table with two BLOB columns, key and value. Only
+// queries of the form
+//
+//	SELECT cols FROM table [WHERE key = ? | WHERE key = 'literal'] [LIMIT n]
+//
+// are supported, where cols is *, COUNT(*) or a list of key and value;
+// the table name is ignored and COUNT(*) can't have WHERE. With WHERE,
+// the query returns the value Get returns for the key, if any. Without
+// it, the query scans the database and returns every record in file
+// order, including all the values of duplicate keys. Tombstones and
+// expired records are skipped.
+package cdbsql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+
+	"cdb"
+)
+
+// ErrReadOnly is returned for attempts to modify the database.
+var ErrReadOnly = errors.New("cdbsql: database is read-only")
+
+func init() {
+	sql.Register("cdb", &Driver{})
+}
+
+// Driver is the database/sql driver for cdb databases.
+type Driver struct{}
+
+var _ driver.Driver = &Driver{}
+
+// Open opens the database at path name.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	db, err := cdb.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{db: db}, nil
+}
+
+type conn struct {
+	db *cdb.CDB
+}
+
+func (c *conn) Prepare(sql string) (driver.Stmt, error) {
+	q, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{db: c.db, q: q}, nil
+}
+
+func (c *conn) Close() error {
+	return c.db.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, ErrReadOnly
+}
+
+type stmt struct {
+	db *cdb.CDB
+	q  *query
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	if s.q.param {
+		return 1
+	}
+	return 0
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	q := s.q
+	switch {
+	case q.cols[0] == "count":
+		return s.count()
+
+	case !q.where:
+		return &scanRows{cols: q.cols, iter: s.db.Iter(), left: q.limit}, nil
+	}
+
+	key := []byte(q.key)
+	if q.param {
+		switch a := args[0].(type) {
+		case []byte:
+			key = a
+		case string:
+			key = []byte(a)
+		default:
+			return nil, fmt.Errorf("cdbsql: key must be a string or []byte, not %T", args[0])
+		}
+	}
+
+	v, ok, err := s.db.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]driver.Value
+	if ok && q.limit != 0 {
+		rows = append(rows, project(q.cols, key, v))
+	}
+	return &sliceRows{cols: q.cols, rows: rows}, nil
+}
+
+// count counts the records a scan would return
+func (s *stmt) count() (driver.Rows, error) {
+	if s.q.where {
+		return nil, fmt.Errorf("cdbsql: COUNT(*) doesn't support WHERE")
+	}
+
+	var n int64
+	iter := s.db.Iter()
+	for iter.Next() {
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	var rows [][]driver.Value
+	if s.q.limit != 0 {
+		rows = append(rows, []driver.Value{n})
+	}
+	return &sliceRows{cols: []string{"count"}, rows: rows}, nil
+}
+
+// project returns the columns cols of a record
+func project(cols []string, key, value []byte) []driver.Value {
+	row := make([]driver.Value, len(cols))
+	for i, c := range cols {
+		if c == "key" {
+			row[i] = key
+		} else {
+			row[i] = value
+		}
+	}
+	return row
+}
+
+// scanRows returns the records of a database scan
+type scanRows struct {
+	cols []string
+	iter *cdb.Iterator
+
+	// rows left before the limit; negative for no limit
+	left int
+}
+
+func (r *scanRows) Columns() []string {
+	return r.cols
+}
+
+func (r *scanRows) Close() error {
+	return nil
+}
+
+func (r *scanRows) Next(dest []driver.Value) error {
+	if r.left == 0 || !r.iter.Next() {
+		if err := r.iter.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	if r.left > 0 {
+		r.left--
+	}
+	copy(dest, project(r.cols, r.iter.Key(), r.iter.Value()))
+	return nil
+}
+
+// sliceRows returns rows computed in advance
+type sliceRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *sliceRows) Columns() []string {
+	return r.cols
+}
+
+func (r *sliceRows) Close() error {
+	return nil
+}
+
+func (r *sliceRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
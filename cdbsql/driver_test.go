@@ -0,0 +1,96 @@
+package cdbsql_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"cdb"
+	"cdb/cdbsql"
+)
+
+func TestDriver(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "sql.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	recs := []struct{ k, v string }{{"hello", "world"}, {"abc", "def"}, {"it's", "quoted"}}
+	for _, r := range recs {
+		if err := wr.Put([]byte(r.k), []byte(r.v)); err != nil {
+			t.Fatalf("Can't put key %s: %s", r.k, err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	db, err := sql.Open("cdb", fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	var v string
+	err = db.QueryRow("SELECT value FROM cdb WHERE key = ?", "hello").Scan(&v)
+	if err != nil || v != "world" {
+		t.Fatalf("Lookup hello: exp world, saw %q, %v", v, err)
+	}
+
+	err = db.QueryRow("select value from cdb where key = 'it''s'").Scan(&v)
+	if err != nil || v != "quoted" {
+		t.Fatalf("Lookup literal: exp quoted, saw %q, %v", v, err)
+	}
+
+	err = db.QueryRow("SELECT value FROM cdb WHERE key = ?", "nope").Scan(&v)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Lookup of missing key: exp ErrNoRows, saw %v", err)
+	}
+
+	rows, err := db.Query("SELECT * FROM cdb")
+	if err != nil {
+		t.Fatalf("Scan failed: %s", err)
+	}
+
+	var i int
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			t.Fatalf("Scan row %d: %s", i, err)
+		}
+		if k != recs[i].k || v != recs[i].v {
+			t.Fatalf("Row %d: exp %s=%s, saw %s=%s", i, recs[i].k, recs[i].v, k, v)
+		}
+		i++
+	}
+	if err := rows.Err(); err != nil || i != len(recs) {
+		t.Fatalf("Scan: exp %d rows, saw %d, %v", len(recs), i, err)
+	}
+	rows.Close()
+
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM cdb").Scan(&n)
+	if err != nil || n != len(recs) {
+		t.Fatalf("Count: exp %d, saw %d, %v", len(recs), n, err)
+	}
+
+	rows, err = db.Query("SELECT key FROM cdb LIMIT 1")
+	if err != nil {
+		t.Fatalf("Limit failed: %s", err)
+	}
+	for i = 0; rows.Next(); i++ {
+	}
+	rows.Close()
+	if i != 1 {
+		t.Fatalf("Limit 1: saw %d rows", i)
+	}
+
+	if _, err := db.Exec("DELETE FROM cdb"); err == nil {
+		t.Fatalf("DELETE succeeded")
+	}
+	if _, err := db.Begin(); !errors.Is(err, cdbsql.ErrReadOnly) {
+		t.Fatalf("Begin: exp ErrReadOnly, saw %v", err)
+	}
+}
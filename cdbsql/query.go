@@ -0,0 +1,206 @@
+package cdbsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// query is a parsed SELECT statement
+type query struct {
+	// the columns selected, in order: "key", "value" or "count"
+	cols []string
+
+	// set if the query has WHERE key = ...; a literal key is in key,
+	// otherwise it is the first argument
+	where bool
+	param bool
+	key   string
+
+	// maximum number of rows; -1 for no limit
+	limit int
+}
+
+// parse parses the SQL dialect the driver supports:
+//
+//	SELECT cols FROM table [WHERE key = ? | WHERE key = 'literal'] [LIMIT n]
+//
+// where cols is *, COUNT(*) or a list of key and value. Keywords and
+// column names are case insensitive; the table name is ignored.
+func parse(sql string) (*query, error) {
+	toks, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	q := &query{limit: -1}
+
+	if err := p.keyword("select"); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.peek() == "*":
+		p.next()
+		q.cols = []string{"key", "value"}
+
+	case strings.EqualFold(p.peek(), "count"):
+		p.next()
+		for _, t := range []string{"(", "*", ")"} {
+			if err := p.expect(t); err != nil {
+				return nil, err
+			}
+		}
+		q.cols = []string{"count"}
+
+	default:
+		for {
+			col := strings.ToLower(p.next())
+			if col != "key" && col != "value" {
+				return nil, fmt.Errorf("cdbsql: unknown column %q", col)
+			}
+			q.cols = append(q.cols, col)
+
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err := p.keyword("from"); err != nil {
+		return nil, err
+	}
+	if t := p.next(); !isIdent(t) {
+		return nil, fmt.Errorf("cdbsql: expected table name, saw %q", t)
+	}
+
+	if strings.EqualFold(p.peek(), "where") {
+		p.next()
+		if col := p.next(); !strings.EqualFold(col, "key") {
+			return nil, fmt.Errorf("cdbsql: only WHERE key = ... is supported")
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+
+		q.where = true
+		switch t := p.next(); {
+		case t == "?":
+			q.param = true
+		case len(t) >= 2 && t[0] == '\'':
+			q.key = strings.ReplaceAll(t[1:len(t)-1], "''", "'")
+		default:
+			return nil, fmt.Errorf("cdbsql: expected ? or a string, saw %q", t)
+		}
+	}
+
+	if strings.EqualFold(p.peek(), "limit") {
+		p.next()
+		t := p.next()
+		n, err := strconv.Atoi(t)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("cdbsql: bad limit %q", t)
+		}
+		q.limit = n
+	}
+
+	if p.peek() == ";" {
+		p.next()
+	}
+	if t := p.peek(); t != "" {
+		return nil, fmt.Errorf("cdbsql: unexpected %q", t)
+	}
+	return q, nil
+}
+
+type parser struct {
+	toks []string
+}
+
+// peek returns the next token, or "" at the end
+func (p *parser) peek() string {
+	if len(p.toks) == 0 {
+		return ""
+	}
+	return p.toks[0]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	if len(p.toks) > 0 {
+		p.toks = p.toks[1:]
+	}
+	return t
+}
+
+func (p *parser) expect(want string) error {
+	if t := p.next(); t != want {
+		return fmt.Errorf("cdbsql: expected %q, saw %q", want, t)
+	}
+	return nil
+}
+
+func (p *parser) keyword(kw string) error {
+	if t := p.next(); !strings.EqualFold(t, kw) {
+		return fmt.Errorf("cdbsql: expected %s, saw %q", strings.ToUpper(kw), t)
+	}
+	return nil
+}
+
+func isIdent(t string) bool {
+	if t == "" {
+		return false
+	}
+	for _, c := range t {
+		if !(c == '_' || c == '.' || unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits sql into words, punctuation and quoted strings, which
+// keep their quotes.
+func tokenize(sql string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case strings.IndexByte("*,=();?", c) >= 0:
+			toks = append(toks, sql[i:i+1])
+			i++
+
+		case c == '\'':
+			j := i + 1
+			for {
+				k := strings.IndexByte(sql[j:], '\'')
+				if k < 0 {
+					return nil, fmt.Errorf("cdbsql: unterminated string")
+				}
+				j += k + 1
+				if j < len(sql) && sql[j] == '\'' {
+					j++
+					continue
+				}
+				break
+			}
+			toks = append(toks, sql[i:j])
+			i = j
+
+		default:
+			j := i
+			for j < len(sql) && strings.IndexByte(" \t\n\r*,=();?'", sql[j]) < 0 {
+				j++
+			}
+			toks = append(toks, sql[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
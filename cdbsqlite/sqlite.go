@@ -0,0 +1,156 @@
+// Package cdbsqlite copies cdb databases to and from SQLite, so that
+// their contents can be explored with SQL and the results frozen back
+// into a cdb. It lives in its own package to keep the SQLite driver
+// (modernc.org/sqlite, which needs no cgo) out of programs that only
+// use cdb.
+package cdbsqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"cdb"
+
+	_ "modernc.org/sqlite"
+)
+
+// name the SQLite driver registers as
+const driverName = "sqlite"
+
+// Putter is where FromSQLite writes records; *cdb.Writer,
+// *cdb.ShardWriter and *cdb.NamespaceWriter implement it.
+type Putter interface {
+	Put(key, value []byte) error
+}
+
+// ToSQLite copies the records of db to a new table in the SQLite
+// database at path, which is created if it doesn't exist. The table has
+// two BLOB columns, key (the primary key) and value, and must not exist
+// yet. As with Get, the first record of a key wins, and deleted and
+// expired keys are left out. The records are inserted in one
+// transaction; on error, nothing is added.
+func ToSQLite(db *cdb.CDB, path, table string) error {
+	sdb, err := sql.Open(driverName, path)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	tx, err := sdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t := quote(table)
+	if _, err := tx.Exec("CREATE TABLE " + t + " (key BLOB PRIMARY KEY, value BLOB NOT NULL)"); err != nil {
+		return fmt.Errorf("cdbsqlite: %s: %w", path, err)
+	}
+
+	ins, err := tx.Prepare("INSERT OR IGNORE INTO " + t + " (key, value) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("cdbsqlite: %s: %w", path, err)
+	}
+	defer ins.Close()
+
+	// a key whose first record is dead is missing, even if a later
+	// record is live; only Lookup can tell
+	check := db.Features()&(cdb.FeatureTombstones|cdb.FeatureExpiry) != 0
+
+	var ierr error
+	err = db.Range(func(key, value []byte) bool {
+		if check {
+			if _, ok, err := db.Lookup(key); err != nil || !ok {
+				ierr = err
+				return err == nil
+			}
+		}
+
+		if _, err := ins.Exec(key, nonNil(value)); err != nil {
+			ierr = fmt.Errorf("cdbsqlite: %s: key %q: %w", path, key, err)
+			return false
+		}
+		return true
+	})
+	if err == nil {
+		err = ierr
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FromSQLite runs query on the SQLite database at path and writes the
+// columns keyCol and valCol of every row it returns to out as a record,
+// e.g.
+//
+//	cdbsqlite.FromSQLite("users.db", "SELECT id, json FROM users WHERE active", "id", "json", wr)
+//
+// Columns of any type are converted to bytes as database/sql converts
+// them to []byte: text and blobs as they are, numbers in decimal. A
+// NULL key is an error; a NULL value is written as an empty value.
+func FromSQLite(path, query, keyCol, valCol string, out Putter) error {
+	sdb, err := sql.Open(driverName, path)
+	if err != nil {
+		return err
+	}
+	defer sdb.Close()
+
+	rows, err := sdb.Query(query)
+	if err != nil {
+		return fmt.Errorf("cdbsqlite: %s: %w", path, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	// scan the key and value columns, and discard the rest
+	var key, value []byte
+	dest := make([]any, len(cols))
+	ki, vi := -1, -1
+	for i, c := range cols {
+		switch c {
+		case keyCol:
+			dest[i], ki = &key, i
+		case valCol:
+			dest[i], vi = &value, i
+		default:
+			dest[i] = new(sql.RawBytes)
+		}
+	}
+	if ki < 0 || vi < 0 {
+		return fmt.Errorf("cdbsqlite: query must return columns %q and %q, not %s", keyCol, valCol, strings.Join(cols, ", "))
+	}
+
+	for n := 1; rows.Next(); n++ {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("cdbsqlite: row %d: %w", n, err)
+		}
+		if key == nil {
+			return fmt.Errorf("cdbsqlite: row %d: NULL key", n)
+		}
+		if err := out.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// quote returns name as an SQL identifier
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// nonNil returns b, or an empty slice for nil, which the driver would
+// store as NULL
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
@@ -0,0 +1,88 @@
+package cdbsqlite_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cdb"
+	"cdb/cdbsqlite"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "src.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	recs := [][2]string{
+		{"hello", "world"},
+		{"abc", "def"},
+		{"dup", "first"},
+		{"empty", ""},
+		{"dup", "second"},
+	}
+	for _, r := range recs {
+		if err := wr.Put([]byte(r[0]), []byte(r[1])); err != nil {
+			t.Fatalf("Can't put %s: %s", r[0], err)
+		}
+	}
+
+	// deleted first, so the later record isn't seen by Get
+	if err := wr.Delete([]byte("gone")); err != nil {
+		t.Fatalf("Can't delete: %s", err)
+	}
+	if err := wr.Put([]byte("gone"), []byte("ghost")); err != nil {
+		t.Fatalf("Can't put gone: %s", err)
+	}
+
+	src, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", fn, err)
+	}
+	defer src.Close()
+
+	sqlfn := filepath.Join(dir, "export.db")
+	if err := cdbsqlite.ToSQLite(src, sqlfn, "recs"); err != nil {
+		t.Fatalf("ToSQLite failed: %s", err)
+	}
+	if err := cdbsqlite.ToSQLite(src, sqlfn, "recs"); err == nil {
+		t.Fatalf("ToSQLite overwrote an existing table")
+	}
+
+	out := filepath.Join(dir, "dst.cdb")
+	wr, err = cdb.Create(out)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", out, err)
+	}
+	if err := cdbsqlite.FromSQLite(sqlfn, "SELECT value, key FROM recs", "key", "value", wr); err != nil {
+		t.Fatalf("FromSQLite failed: %s", err)
+	}
+	dst, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", out, err)
+	}
+	defer dst.Close()
+
+	exp, err := src.ToMap()
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+	m, err := dst.ToMap()
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", out, err)
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("Round trip: exp %q, saw %q", exp, m)
+	}
+	if dst.Len() != len(exp) {
+		t.Fatalf("Round trip: exp %d records, saw %d", len(exp), dst.Len())
+	}
+
+	err = cdbsqlite.FromSQLite(sqlfn, "SELECT key FROM recs", "key", "value", wr)
+	if err == nil {
+		t.Fatalf("FromSQLite accepted a query without the value column")
+	}
+}
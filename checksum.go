@@ -0,0 +1,111 @@
+package cdb
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc64"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// Checksum identifies the algorithm used for the checksum at the end of
+// the file. It is recorded in the trailer, and Open verifies the file
+// with the same algorithm.
+//
+// The checksum detects corruption, not tampering: anyone who can modify
+// the file can also recompute the checksum or change the algorithm.
+type Checksum uint32
+
+const (
+	// ChecksumSHA256 is the default.
+	ChecksumSHA256 Checksum = iota
+
+	// ChecksumNone disables the checksum; Open has nothing to verify.
+	ChecksumNone
+
+	// ChecksumXXH3 is the 128-bit xxh3 hash; it is many times faster
+	// than SHA256.
+	ChecksumXXH3
+
+	// ChecksumBLAKE3 is a cryptographic hash that is faster than
+	// SHA256 on most machines.
+	ChecksumBLAKE3
+
+	// ChecksumCRC64 is CRC-64 with the ECMA polynomial.
+	ChecksumCRC64
+)
+
+// The checksum occupies a fixed size slot at the end of the file;
+// shorter checksums are padded with zeros.
+const checksumSize = sha256.Size
+
+var checksumNames = []string{
+	"sha256",
+	"none",
+	"xxh3-128",
+	"blake3",
+	"crc64",
+}
+
+// String returns the name of the algorithm.
+func (c Checksum) String() string {
+	if int(c) < len(checksumNames) {
+		return checksumNames[c]
+	}
+	return fmt.Sprintf("checksum-%d", uint32(c))
+}
+
+// WithChecksum selects the checksum algorithm of a new database. SHA256
+// of a multi-GB file is slow to compute and to verify on every Open;
+// the faster algorithms are just as good at catching corruption.
+func WithChecksum(c Checksum) Option {
+	return func(o *options) {
+		o.checksum = c
+	}
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// newChecksum returns a hash.Hash computing c, or nil for ChecksumNone
+func newChecksum(c Checksum) (hash.Hash, error) {
+	switch c {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumNone:
+		return nil, nil
+	case ChecksumXXH3:
+		return xxh3128{xxh3.New()}, nil
+	case ChecksumBLAKE3:
+		return blake3.New(), nil
+	case ChecksumCRC64:
+		return crc64.New(crc64Table), nil
+	}
+	return nil, fmt.Errorf("cdb: unknown checksum algorithm %s", c)
+}
+
+// countingHash is a hash.Hash that counts the bytes written to it
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return c.Hash.Write(b)
+}
+
+// xxh3128 makes the 128-bit sum the result of Sum
+type xxh3128 struct {
+	*xxh3.Hasher
+}
+
+func (x xxh3128) Size() int {
+	return 16
+}
+
+func (x xxh3128) Sum(b []byte) []byte {
+	s := x.Sum128().Bytes()
+	return append(b, s[:]...)
+}
@@ -0,0 +1,150 @@
+package cdb
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by reads from a database after it is closed.
+var ErrClosed = errors.New("cdb: database is closed")
+
+// closeGate lets Close wait for the reads in progress, and fails the
+// reads that come after it with ErrClosed, so that a database can be
+// closed (e.g. when it is replaced by a newer version) while other
+// goroutines are still looking up keys in it.
+//
+// Readers take a reference for as long as they read: a lookup takes one
+// for all its reads (see CDB.enter) and reads the file directly, other
+// reads go through a gatedReader and take one each. References are an
+// atomic count, so concurrent readers don't contend on a lock.
+type closeGate struct {
+	// references held, plus gateClosed once Close is called
+	refs atomic.Int64
+
+	// closed when the last reference is dropped after Close
+	drained chan struct{}
+	once    sync.Once
+}
+
+// gateClosed is added to the reference count by Close
+const gateClosed = 1 << 62
+
+func newCloseGate() *closeGate {
+	return &closeGate{drained: make(chan struct{})}
+}
+
+// enter takes a reference, or returns ErrClosed
+func (g *closeGate) enter() error {
+	if g.refs.Add(1)&gateClosed != 0 {
+		g.leave()
+		return ErrClosed
+	}
+	return nil
+}
+
+// leave drops a reference taken by enter
+func (g *closeGate) leave() {
+	if g.refs.Add(-1) == gateClosed {
+		g.once.Do(func() {
+			close(g.drained)
+		})
+	}
+}
+
+// shut closes the gate and waits for the references held to be dropped.
+// It returns false if the gate was already closed.
+func (g *closeGate) shut() bool {
+	n := g.refs.Or(gateClosed)
+	if n&gateClosed != 0 {
+		return false
+	}
+	if n != 0 {
+		<-g.drained
+	}
+	return true
+}
+
+// gatedReader is an io.ReaderAt whose reads pass through a closeGate
+type gatedReader struct {
+	g *closeGate
+	r io.ReaderAt
+}
+
+func (g *gatedReader) ReadAt(p []byte, off int64) (int, error) {
+	if err := g.g.enter(); err != nil {
+		return 0, err
+	}
+	defer g.g.leave()
+	return g.r.ReadAt(p, off)
+}
+
+// ungated returns the reader under r
+func ungated(r io.ReaderAt) io.ReaderAt {
+	if g, ok := r.(*gatedReader); ok {
+		return g.r
+	}
+	return r
+}
+
+// gate routes the reads of the file and blob file of cdb through a
+// closeGate
+func (cdb *CDB) gate() {
+	g := newCloseGate()
+	cdb.closer = g
+	cdb.reader = &gatedReader{g: g, r: cdb.reader}
+	if cdb.blobs != nil {
+		cdb.blobs = &gatedReader{g: g, r: cdb.blobs}
+	}
+}
+
+// enter takes a reference to the file for the reads of one lookup,
+// which can then read it with file(); it returns ErrClosed once the
+// database is closed. Every successful enter must be paired with leave.
+func (cdb *CDB) enter() error {
+	if g := cdb.closer; g != nil {
+		return g.enter()
+	}
+	return nil
+}
+
+// leave drops the reference taken by enter
+func (cdb *CDB) leave() {
+	if g := cdb.closer; g != nil {
+		g.leave()
+	}
+}
+
+// file returns the reader of the database without the close gate; it
+// must only be read between enter and leave.
+func (cdb *CDB) file() io.ReaderAt {
+	return ungated(cdb.reader)
+}
+
+// Close closes the database to further reads. It waits for the reads in
+// progress to finish; later ones fail with ErrClosed, as does closing
+// the database again.
+func (cdb *CDB) Close() error {
+	if cdb.clone {
+		return nil
+	}
+
+	if g := cdb.closer; g != nil && !g.shut() {
+		return ErrClosed
+	}
+
+	var err error
+	if closer, ok := ungated(cdb.blobs).(io.Closer); ok {
+		err = closer.Close()
+	}
+
+	if closer, ok := ungated(cdb.reader).(io.Closer); ok {
+		err = errors.Join(closer.Close(), err)
+	}
+
+	if cdb.anon != nil {
+		err = errors.Join(err, cdb.anon.discard())
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"cdb"
+)
+
+func init() {
+	commands = append(commands, command{
+		name:  "diff",
+		usage: "diff [-q] OLD NEW   show keys added (+), removed (-) and changed (~)",
+		run:   diffCmd,
+	})
+}
+
+func diffCmd(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only print a summary")
+	fs.Parse(args)
+
+	args = fs.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("need exactly two databases")
+	}
+
+	a, err := cdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	b, err := cdb.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	var n [3]int
+	out := bufio.NewWriter(os.Stdout)
+	err = cdb.DiffFunc(a, b, func(kind cdb.DiffKind, key, _, _ []byte) error {
+		n[kind]++
+		if *quiet {
+			return nil
+		}
+
+		var c byte
+		switch kind {
+		case cdb.DiffAdded:
+			c = '+'
+		case cdb.DiffRemoved:
+			c = '-'
+		case cdb.DiffChanged:
+			c = '~'
+		}
+		_, err := fmt.Fprintf(out, "%c %s\n", c, strconv.Quote(string(key)))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%d added, %d removed, %d changed\n",
+		n[cdb.DiffAdded], n[cdb.DiffRemoved], n[cdb.DiffChanged])
+	out.Flush()
+
+	// like diff(1): exit status 1 when the inputs differ
+	if n[0]+n[1]+n[2] > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"cdb"
+)
+
+func init() {
+	commands = append(commands, command{
+		name:  "inspect",
+		usage: "inspect [-t] [--offset N | --key K] DB   show the layout of DB, and dump a record",
+		run:   inspectCmd,
+	})
+}
+
+func inspectCmd(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	tables := fs.Bool("t", false, "show every hash table, not just a summary")
+	offset := fs.Int64("offset", -1, "dump the record at this offset")
+	key := fs.String("key", "", "dump the record of this key")
+	fs.Parse(args)
+
+	args = fs.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("need exactly one database")
+	}
+	if *offset >= 0 && *key != "" {
+		return fmt.Errorf("--offset and --key are exclusive")
+	}
+
+	db, err := cdb.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	l, err := db.Layout()
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	showLayout(out, args[0], l, *tables)
+	showMetadata(out, db.Metadata())
+
+	switch {
+	case *key != "":
+		return showKey(out, db, []byte(*key))
+	case *offset >= 0:
+		return showRecord(out, db, uint32(*offset))
+	}
+	return nil
+}
+
+func showLayout(out *bufio.Writer, name string, l *cdb.Layout, all bool) {
+	fmt.Fprintf(out, "file:       %s\n", name)
+	if l.Version == 0 {
+		fmt.Fprintf(out, "trailer:    none\n")
+	} else {
+		fmt.Fprintf(out, "trailer:    version %d at %d, %d bytes\n", l.Version, l.TrailerStart, l.Size-l.TrailerStart)
+	}
+	fmt.Fprintf(out, "hash:       %s\n", l.Hash)
+	fmt.Fprintf(out, "checksum:   %s\n", l.Checksum)
+	fmt.Fprintf(out, "features:   %s\n", l.Features)
+	if l.Records >= 0 {
+		fmt.Fprintf(out, "records:    %d (%d tombstones)\n", l.Records, l.Tombstones)
+	}
+	if l.BloomBits > 0 {
+		fmt.Fprintf(out, "bloom:      %d bits\n", l.BloomBits)
+	}
+	if l.Alignment > 0 {
+		fmt.Fprintf(out, "alignment:  %d bytes (%d fillers)\n", l.Alignment, l.Fillers)
+	}
+	fmt.Fprintf(out, "data:       [%d, %d) %d bytes\n", l.DataStart, l.DataEnd, l.DataEnd-l.DataStart)
+
+	var slots, used, probe uint64
+	var empty int
+	for _, t := range l.Tables {
+		slots += uint64(t.Slots)
+		used += uint64(t.Used)
+		probe = max(probe, uint64(t.MaxProbe))
+		if t.Slots == 0 {
+			empty++
+		}
+	}
+	fmt.Fprintf(out, "tables:     %d (%d empty), %d slots, %d used (%s), longest probe %d\n",
+		len(l.Tables), empty, slots, used, percent(used, slots), probe)
+	if x := l.MPH; x != nil {
+		fmt.Fprintf(out, "mph:        at %d, %d slots, %d used (%s)\n", x.Offset, x.Slots, x.Used, percent(uint64(x.Used), uint64(x.Slots)))
+	}
+
+	if all {
+		fmt.Fprintf(out, "\n%6s %10s %8s %8s %7s %6s\n", "table", "offset", "slots", "used", "fill", "probe")
+		for i, t := range l.Tables {
+			fmt.Fprintf(out, "%6d %10d %8d %8d %7s %6d\n", i, t.Offset, t.Slots, t.Used, percent(uint64(t.Used), uint64(t.Slots)), t.MaxProbe)
+		}
+	}
+}
+
+func showMetadata(out *bufio.Writer, meta map[string][]byte) {
+	if len(meta) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(out, "metadata:\n")
+	for _, k := range keys {
+		fmt.Fprintf(out, "  %s = %s\n", strconv.Quote(k), strconv.Quote(string(meta[k])))
+	}
+}
+
+func showKey(out *bufio.Writer, db *cdb.CDB, key []byte) error {
+	p := db.Probe(key)
+	fmt.Fprintf(out, "\nkey:        %s\n", strconv.Quote(string(key)))
+	if string(p.Stored) != string(key) {
+		fmt.Fprintf(out, "stored as:  %s\n", strconv.Quote(string(p.Stored)))
+	}
+	fmt.Fprintf(out, "hash:       %#08x\n", p.Hash)
+	if p.Table < 0 {
+		fmt.Fprintf(out, "probe:      mph slot %d\n", p.Slot)
+	} else {
+		fmt.Fprintf(out, "probe:      table %d, slot %d\n", p.Table, p.Slot)
+	}
+	if !p.Maybe {
+		fmt.Fprintf(out, "not found:  ruled out without reading the tables\n")
+		return nil
+	}
+
+	off, ok, err := db.RecordOffset(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Fprintf(out, "not found\n")
+		return nil
+	}
+	return showRecord(out, db, off)
+}
+
+func showRecord(out *bufio.Writer, db *cdb.CDB, off uint32) error {
+	r, err := db.RawRecord(off)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nrecord at %d: key %d bytes, value %d bytes", r.Offset, r.KeyLen, r.ValueLen)
+	if r.Tombstone {
+		fmt.Fprintf(out, ", tombstone")
+	}
+	if r.Blob {
+		fmt.Fprintf(out, ", value in the blob file")
+	}
+	if r.Filler {
+		fmt.Fprintf(out, ", filler")
+	}
+	fmt.Fprintf(out, "\n")
+
+	d := hex.Dumper(out)
+	d.Write(r.Bytes)
+	return d.Close()
+}
+
+func percent(n, d uint64) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(d))
+}
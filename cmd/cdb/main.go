@@ -0,0 +1,55 @@
+// cdb is a command line tool to work with cdb databases.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+var Z = filepath.Base(os.Args[0])
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(os.Args[2:]); err != nil {
+				die("%s: %s", name, err)
+			}
+			return
+		}
+	}
+
+	warn("unknown command %s", name)
+	usage()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s CMD [args...]\n\nCommands:\n", Z)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+	}
+	os.Exit(1)
+}
+
+func warn(f string, v ...interface{}) {
+	s := fmt.Sprintf(f, v...)
+	fmt.Fprintf(os.Stderr, "%s: %s\n", Z, s)
+}
+
+func die(f string, v ...interface{}) {
+	warn(f, v...)
+	os.Exit(1)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cdb"
+	"cdb/cdbfs"
+)
+
+func init() {
+	commands = append(commands, command{
+		name:  "pack",
+		usage: "pack [-i GLOB] [-x GLOB] DIR OUT   pack the files under DIR into the database OUT",
+		run:   packCmd,
+	}, command{
+		name:  "unpack",
+		usage: "unpack DB DIR                      extract the files packed in DB under DIR",
+		run:   unpackCmd,
+	})
+}
+
+// globs is a repeatable flag of glob patterns
+type globs []string
+
+func (g *globs) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globs) Set(s string) error {
+	if _, err := path.Match(s, ""); err != nil {
+		return fmt.Errorf("bad pattern %q: %w", s, err)
+	}
+	*g = append(*g, s)
+	return nil
+}
+
+// match returns true if any pattern matches the path name or its last
+// element
+func (g globs) match(name string) bool {
+	for _, p := range g {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func packCmd(args []string) error {
+	var incl, excl globs
+
+	flags := flag.NewFlagSet("pack", flag.ExitOnError)
+	flags.Var(&incl, "i", "only pack files matching `GLOB` (repeatable)")
+	flags.Var(&excl, "x", "skip files and directories matching `GLOB` (repeatable)")
+	flags.Parse(args)
+
+	args = flags.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("need a directory and an output database")
+	}
+	dir, out := args[0], args[1]
+
+	wr, err := cdb.Create(out)
+	if err != nil {
+		return err
+	}
+
+	err = cdbfs.PackFilter(wr, os.DirFS(dir), func(name string, d fs.DirEntry) bool {
+		if excl.match(name) {
+			return false
+		}
+		return d.IsDir() || len(incl) == 0 || incl.match(name)
+	})
+	if err == nil {
+		err = wr.Close()
+	} else {
+		wr.Close()
+	}
+
+	if err != nil {
+		os.Remove(out)
+		return err
+	}
+	return nil
+}
+
+func unpackCmd(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("need a database and an output directory")
+	}
+	src, dir := args[0], args[1]
+
+	db, err := cdb.Open(src)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fsys := cdbfs.New(db)
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(dst, b, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		// WriteFile doesn't change the mode of existing files
+		if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if t := fi.ModTime(); !t.IsZero() {
+			return os.Chtimes(dst, t, t)
+		}
+		return nil
+	})
+}
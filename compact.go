@@ -0,0 +1,125 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// compactHeadSize is the size of the head of a database with a compact
+// index: the offset of the hash tables, then the header flags.
+const compactHeadSize = 8
+
+// compactFlag is the header flag of a compact index
+const compactFlag = 1
+
+// WithCompactIndex makes the writer replace the dense index at the
+// head of the file, 8 bytes per hash table, with an 8 byte header; the
+// lengths of the non-empty tables are kept in the trailer instead, 8
+// bytes each. It shrinks databases of a few records by about 2KiB.
+//
+// The header holds the offset of the hash tables and a flag that says
+// the index is compact, which must agree with the trailer. Classic cdb
+// readers can't read such databases. It can't be used with WithMPH.
+func WithCompactIndex() Option {
+	return func(o *options) {
+		o.compactIndex = true
+	}
+}
+
+// tableLen is the length of a non-empty table of a compact index
+type tableLen struct {
+	table  uint32
+	length uint32
+}
+
+// compactIndex returns the lengths of the non-empty tables of idx
+func compactIndex(idx index) []tableLen {
+	var tl []tableLen
+	for i, t := range idx {
+		if t.length > 0 {
+			tl = append(tl, tableLen{table: uint32(i), length: t.length})
+		}
+	}
+	return tl
+}
+
+// compactHead returns the header of a compact index whose tables start
+// at off.
+func compactHead(off uint32) []byte {
+	b := make([]byte, compactHeadSize)
+	binary.LittleEndian.PutUint32(b[0:4], off)
+	binary.LittleEndian.PutUint32(b[4:8], compactFlag)
+	return b
+}
+
+// readCompactIndex reads the header of a compact index and expands it,
+// with the table lengths in the trailer, into the dense index. The
+// tables are laid out one after another, so each starts where the one
+// before it ends.
+func (cdb *CDB) readCompactIndex() error {
+	var head [compactHeadSize]byte
+	if _, err := cdb.reader.ReadAt(head[:], 0); err != nil {
+		return err
+	}
+
+	if flags := binary.LittleEndian.Uint32(head[4:]); flags != compactFlag {
+		return corruptAt(ErrBadIndex, 4, "header flags %#x don't describe a compact index", flags)
+	}
+
+	n := defaultTables
+	if cdb.trailer.tables != 0 {
+		n = int(cdb.trailer.tables)
+	}
+
+	off := int64(binary.LittleEndian.Uint32(head[:4]))
+	tl := cdb.trailer.lengths
+	cdb.index = make(index, n)
+	for i := range cdb.index {
+		var l uint32
+		if len(tl) > 0 && tl[0].table == uint32(i) {
+			l, tl = tl[0].length, tl[1:]
+		}
+
+		end := off + 8*int64(l)
+		if end > cdb.size {
+			return corruptAt(ErrBadIndex, 0, "table %d extends outside the file", i).want(cdb.size, end)
+		}
+		cdb.index[i] = table{offset: uint32(off), length: l}
+		off = end
+	}
+
+	if len(tl) > 0 {
+		return corruptAt(ErrBadTrailer, cdb.trailer.start, "compact index has a length for table %d of %d", tl[0].table, n)
+	}
+	return cdb.checkIndex()
+}
+
+// marshalLengths returns the payload of the compact index section
+func marshalLengths(tl []tableLen) []byte {
+	b := make([]byte, 8*len(tl))
+	for i, t := range tl {
+		binary.LittleEndian.PutUint32(b[8*i:], t.table)
+		binary.LittleEndian.PutUint32(b[8*i+4:], t.length)
+	}
+	return b
+}
+
+func unmarshalLengths(t *trailer, b []byte) error {
+	if len(b)%8 != 0 {
+		return fmt.Errorf("malformed compact index section")
+	}
+
+	t.compact = true
+	t.lengths = make([]tableLen, len(b)/8)
+	for i := range t.lengths {
+		tl := tableLen{
+			table:  binary.LittleEndian.Uint32(b[8*i:]),
+			length: binary.LittleEndian.Uint32(b[8*i+4:]),
+		}
+		if i > 0 && tl.table <= t.lengths[i-1].table {
+			return fmt.Errorf("compact index tables out of order")
+		}
+		t.lengths[i] = tl
+	}
+	return nil
+}
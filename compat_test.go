@@ -0,0 +1,397 @@
+package cdb_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cdb"
+)
+
+//go:generate go run ./testdata/gen
+
+var update = flag.Bool("update", false, "rewrite the golden databases in testdata/golden")
+
+// classicHash is djb's cdb hash as a hash.Hash32, for reading databases
+// built by cdbmake with New
+type classicHash struct {
+	h uint32
+}
+
+func (c *classicHash) Write(b []byte) (int, error) {
+	for _, x := range b {
+		c.h = ((c.h << 5) + c.h) ^ uint32(x)
+	}
+	return len(b), nil
+}
+
+func (c *classicHash) Sum(b []byte) []byte {
+	return append(b, byte(c.h>>24), byte(c.h>>16), byte(c.h>>8), byte(c.h))
+}
+
+func (c *classicHash) Reset()         { c.h = 5381 }
+func (c *classicHash) Size() int      { return 4 }
+func (c *classicHash) BlockSize() int { return 1 }
+func (c *classicHash) Sum32() uint32  { return c.h }
+
+// readCDBMake parses the records of a cdbmake input file
+func readCDBMake(t *testing.T, fn string) []kw {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+
+	var recs []kw
+	r := bufio.NewReader(bytes.NewReader(b))
+	for {
+		var klen, vlen int
+		_, err := fmt.Fscanf(r, "+%d,%d:", &klen, &vlen)
+		if err != nil {
+			break
+		}
+
+		buf := make([]byte, klen+2+vlen+1)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("%s: truncated record: %s", fn, err)
+		}
+		recs = append(recs, kw{string(buf[:klen]), string(buf[klen+2 : klen+2+vlen])})
+	}
+	return recs
+}
+
+func classicCorpus(t *testing.T) []string {
+	names, err := filepath.Glob("testdata/classic/*.cdbmake")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("No classic corpus in testdata/classic: %v", err)
+	}
+	for i, n := range names {
+		names[i] = strings.TrimSuffix(n, ".cdbmake")
+	}
+	return names
+}
+
+// firstRecords returns the first record of every key in recs, in order:
+// the records an iterator yields.
+func firstRecords(recs []kw) []kw {
+	seen := make(map[string]bool)
+	var first []kw
+	for _, r := range recs {
+		if !seen[r.key] {
+			seen[r.key] = true
+			first = append(first, r)
+		}
+	}
+	return first
+}
+
+// TestClassicRead reads databases built by cdbmake.
+func TestClassicRead(t *testing.T) {
+	for _, base := range classicCorpus(t) {
+		recs := readCDBMake(t, base+".cdbmake")
+
+		f, err := os.Open(base + ".cdb")
+		if err != nil {
+			t.Fatalf("Can't open %s.cdb: %s", base, err)
+		}
+		defer f.Close()
+
+		db, err := cdb.New(f, &classicHash{})
+		if err != nil {
+			t.Fatalf("%s: New failed: %s", base, err)
+		}
+
+		first := make(map[string]string)
+		for _, r := range recs {
+			if _, ok := first[r.key]; !ok {
+				first[r.key] = r.val
+			}
+		}
+		for k, v := range first {
+			got, ok, err := db.Lookup([]byte(k))
+			if err != nil || !ok || string(got) != v {
+				t.Fatalf("%s: Lookup %q: exp %q, saw %q, %v, %v", base, k, v, got, ok, err)
+			}
+		}
+
+		if _, ok, _ := db.Lookup([]byte("not there")); ok {
+			t.Fatalf("%s: found missing key", base)
+		}
+
+		// later duplicates are shadowed
+		live := firstRecords(recs)
+		var i int
+		iter := db.Iter()
+		for ; iter.Next(); i++ {
+			if i >= len(live) {
+				t.Fatalf("%s: iterator returned too many records", base)
+			}
+			if string(iter.Key()) != live[i].key || string(iter.Value()) != live[i].val {
+				t.Fatalf("%s: record %d: exp %q=%q, saw %q=%q", base, i, live[i].key, live[i].val, iter.Key(), iter.Value())
+			}
+		}
+		if err := iter.Err(); err != nil || i != len(live) {
+			t.Fatalf("%s: iterated %d of %d records: %v", base, i, len(live), err)
+		}
+	}
+}
+
+// TestClassicWrite checks that the writer lays out the records and hash
+// tables exactly as cdbmake does; only the trailer follows.
+func TestClassicWrite(t *testing.T) {
+	for _, base := range classicCorpus(t) {
+		in, err := os.Open(base + ".cdbmake")
+		if err != nil {
+			t.Fatalf("Can't open %s.cdbmake: %s", base, err)
+		}
+
+		fn := filepath.Join("test", filepath.Base(base)+".cdb")
+		err = cdb.BuildFrom(in, cdb.DumpCDBMake, fn, cdb.WithHash(cdb.HashClassic))
+		in.Close()
+		if err != nil {
+			t.Fatalf("%s: build failed: %s", base, err)
+		}
+
+		exp, err := os.ReadFile(base + ".cdb")
+		if err != nil {
+			t.Fatalf("Can't read %s.cdb: %s", base, err)
+		}
+		got, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", fn, err)
+		}
+
+		if len(got) < len(exp) || !bytes.Equal(got[:len(exp)], exp) {
+			t.Fatalf("%s: output differs from cdbmake", base)
+		}
+	}
+}
+
+// TestConvert converts the classic corpus to the checksummed flavor and
+// back.
+func TestConvert(t *testing.T) {
+	for _, base := range classicCorpus(t) {
+		recs := readCDBMake(t, base+".cdbmake")
+
+		if f, err := cdb.DetectFlavor(base + ".cdb"); err != nil || f != cdb.FlavorClassic {
+			t.Fatalf("%s: detected %s, %v", base, f, err)
+		}
+
+		fn := filepath.Join("test", "convert-"+filepath.Base(base)+".cdb")
+		if err := cdb.Convert(base+".cdb", fn, cdb.FlavorChecksummed); err != nil {
+			t.Fatalf("%s: convert failed: %s", base, err)
+		}
+		if f, err := cdb.DetectFlavor(fn); err != nil || f != cdb.FlavorChecksummed {
+			t.Fatalf("%s: detected %s, %v", fn, f, err)
+		}
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("%s: open failed: %s", fn, err)
+		}
+
+		// the duplicates are kept, but shadowed; the round trip below
+		// checks they are all there
+		live := firstRecords(recs)
+		var i int
+		iter := db.Iter()
+		for ; iter.Next(); i++ {
+			if i >= len(live) || string(iter.Key()) != live[i].key || string(iter.Value()) != live[i].val {
+				t.Fatalf("%s: record %d differs", fn, i)
+			}
+		}
+		db.Close()
+		if i != len(live) {
+			t.Fatalf("%s: %d of %d records", fn, i, len(live))
+		}
+
+		back := filepath.Join("test", "convert-"+filepath.Base(base)+"-classic.cdb")
+		if err := cdb.Convert(fn, back, cdb.FlavorClassic); err != nil {
+			t.Fatalf("%s: convert back failed: %s", base, err)
+		}
+
+		exp, err := os.ReadFile(base + ".cdb")
+		if err != nil {
+			t.Fatalf("Can't read %s.cdb: %s", base, err)
+		}
+		got, err := os.ReadFile(back)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", back, err)
+		}
+		if !bytes.Equal(got, exp) {
+			t.Fatalf("%s: round trip differs from cdbmake", base)
+		}
+	}
+
+	fn := "./test/convert-front.cdb"
+	makeDBAt(t, fn, []kw{{"a", "b"}}, cdb.WithFrontCoding())
+	if err := cdb.Convert(fn, "./test/convert-front-classic.cdb", cdb.FlavorClassic, cdb.WithFrontCoding()); !errors.Is(err, cdb.ErrNotClassic) {
+		t.Fatalf("front coded classic database: %v", err)
+	}
+	if _, err := os.Stat("./test/convert-front-classic.cdb"); err == nil {
+		t.Fatalf("failed conversion left its output behind")
+	}
+}
+
+// TestRehash adds the trailer and checksum to the classic corpus, and
+// repairs the checksum of a database that was patched or truncated.
+func TestRehash(t *testing.T) {
+	for _, base := range classicCorpus(t) {
+		recs := readCDBMake(t, base+".cdbmake")
+
+		exp, err := os.ReadFile(base + ".cdb")
+		if err != nil {
+			t.Fatalf("Can't read %s.cdb: %s", base, err)
+		}
+
+		fn := filepath.Join("test", "rehash-"+filepath.Base(base)+".cdb")
+		if err := os.WriteFile(fn, exp, 0644); err != nil {
+			t.Fatalf("Can't write %s: %s", fn, err)
+		}
+		if err := cdb.Rehash(fn); err != nil {
+			t.Fatalf("%s: rehash failed: %s", base, err)
+		}
+
+		got, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", fn, err)
+		}
+		if !bytes.Equal(got[:len(exp)], exp) {
+			t.Fatalf("%s: rehash modified the database", base)
+		}
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("%s: open failed: %s", fn, err)
+		}
+		for _, r := range recs {
+			if _, err := db.Get([]byte(r.key)); err != nil {
+				t.Fatalf("%s: Get %q: %s", fn, r.key, err)
+			}
+		}
+		db.Close()
+	}
+
+	fn := "./test/rehash.cdb"
+	makeDBAt(t, fn, testRecords)
+	exp, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+
+	// a patched value, a zeroed checksum and a truncated one
+	patched := bytes.Replace(exp, []byte("world"), []byte("WORLD"), 1)
+	zeroed := append(bytes.Clone(exp[:len(exp)-32]), make([]byte, 32)...)
+	for i, b := range [][]byte{patched, zeroed, exp[:len(exp)-32]} {
+		if err := os.WriteFile(fn, b, 0644); err != nil {
+			t.Fatalf("Can't write %s: %s", fn, err)
+		}
+		if _, err := cdb.Open(fn); err == nil {
+			t.Fatalf("%d: opened a database with a bad checksum", i)
+		}
+		if err := cdb.Rehash(fn); err != nil {
+			t.Fatalf("%d: rehash failed: %s", i, err)
+		}
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("%d: open failed: %s", i, err)
+		}
+		v, err := db.Get([]byte("hello"))
+		db.Close()
+		want := "world"
+		if i == 0 {
+			want = "WORLD"
+		}
+		if err != nil || string(v) != want {
+			t.Fatalf("%d: Get: saw %q, %v", i, v, err)
+		}
+		if i > 0 {
+			got, _ := os.ReadFile(fn)
+			if !bytes.Equal(got, exp) {
+				t.Fatalf("%d: rehash didn't restore the checksum", i)
+			}
+		}
+	}
+
+	// a trailer cut short can't be rebuilt
+	if err := os.WriteFile(fn, exp[:len(exp)-40], 0644); err != nil {
+		t.Fatalf("Can't write %s: %s", fn, err)
+	}
+	if err := cdb.Rehash(fn); err == nil {
+		t.Fatalf("rehashed a database with a damaged trailer")
+	}
+}
+
+// TestGolden checks that the writer output is deterministic and
+// doesn't change between releases. Run with -update to rewrite the
+// golden files after an intended format change.
+func TestGolden(t *testing.T) {
+	var sipKey [16]byte
+	for i := range sipKey {
+		sipKey[i] = byte(i)
+	}
+
+	variants := []struct {
+		name string
+		opts []cdb.Option
+	}{
+		{"default", nil},
+		{"siphash", []cdb.Option{cdb.WithSipHash(sipKey)}},
+		{"bloom", []cdb.Option{cdb.WithBloom(10)}},
+		{"mph", []cdb.Option{cdb.WithMPH()}},
+		{"front", []cdb.Option{cdb.WithFrontCoding()}},
+		{"tables", []cdb.Option{cdb.WithTables(64)}},
+	}
+
+	for _, v := range variants {
+		var out [2][]byte
+		for i := range out {
+			in, err := os.Open("testdata/classic/many.cdbmake")
+			if err != nil {
+				t.Fatalf("Can't open many.cdbmake: %s", err)
+			}
+
+			fn := filepath.Join("test", "golden-"+v.name+".cdb")
+			err = cdb.BuildFrom(in, cdb.DumpCDBMake, fn, v.opts...)
+			in.Close()
+			if err != nil {
+				t.Fatalf("%s: build failed: %s", v.name, err)
+			}
+
+			out[i], err = os.ReadFile(fn)
+			if err != nil {
+				t.Fatalf("Can't read %s: %s", fn, err)
+			}
+		}
+
+		if !bytes.Equal(out[0], out[1]) {
+			t.Fatalf("%s: output differs between builds", v.name)
+		}
+
+		golden := filepath.Join("testdata", "golden", v.name+".cdb")
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+				t.Fatalf("Can't create golden dir: %s", err)
+			}
+			if err := os.WriteFile(golden, out[0], 0644); err != nil {
+				t.Fatalf("Can't write %s: %s", golden, err)
+			}
+			continue
+		}
+
+		exp, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", golden, err)
+		}
+		if !bytes.Equal(out[0], exp) {
+			t.Fatalf("%s: output differs from %s", v.name, golden)
+		}
+	}
+}
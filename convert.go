@@ -0,0 +1,215 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Flavor is an on-disk cdb layout.
+type Flavor int
+
+const (
+	// FlavorClassic is djb's cdb layout, also read and written by
+	// tinycdb and github.com/colinmarc/cdb: 256 hash tables using the
+	// classic hash, and nothing after them.
+	FlavorClassic Flavor = iota
+
+	// FlavorChecksummed is the layout written by this package: a
+	// trailer describing the database and a checksum follow the hash
+	// tables.
+	FlavorChecksummed
+)
+
+func (f Flavor) String() string {
+	switch f {
+	case FlavorClassic:
+		return "classic"
+	case FlavorChecksummed:
+		return "checksummed"
+	}
+	return fmt.Sprintf("flavor-%d", int(f))
+}
+
+// ErrNotClassic is returned by Convert when the database it built can't
+// be written in the classic layout: it uses features (see Features) or
+// a hash function that classic readers don't know.
+var ErrNotClassic = errors.New("cdb: database can't be written in the classic layout")
+
+// DetectFlavor returns the layout of the database at path. A file is
+// taken to be classic if its hash tables end exactly at the end of the
+// file; anything else is expected to be checksummed, which is only
+// verified when it is opened.
+func DetectFlavor(path string) (Flavor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("can't stat %s: %s", path, err)
+	}
+
+	end, err := classicEnd(f, st.Size())
+	if err == nil && end == st.Size() {
+		return FlavorClassic, nil
+	}
+	return FlavorChecksummed, nil
+}
+
+// classicEnd returns the end of the hash tables of a database with the
+// classic index of 256 tables
+func classicEnd(r io.ReaderAt, size int64) (int64, error) {
+	buf := make([]byte, 8*defaultTables)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return 0, err
+	}
+
+	end := int64(len(buf))
+	for i := 0; i < len(buf); i += 8 {
+		off := int64(binary.LittleEndian.Uint32(buf[i:]))
+		n := int64(binary.LittleEndian.Uint32(buf[i+4:]))
+		if off < int64(len(buf)) || off+8*n > size {
+			return 0, ErrBadIndex
+		}
+		end = max(end, off+8*n)
+	}
+	return end, nil
+}
+
+// openClassic opens the classic database at path
+func openClassic(path string) (*CDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := New(f, nil)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	db.hasher = classicHash
+	return db, nil
+}
+
+// Convert writes the records of the database at src, of either
+// flavor, to a new database at dst in the flavor to. Records are copied
+// in order, duplicate keys included; tombstones, expired records and
+// the records of deleted keys are not.
+//
+// A checksummed database is built with the settings of a checksummed
+// src, or the defaults for a classic one; opts apply to both opening
+// src and creating dst. A classic database is built with the classic
+// hash, and must not use any feature other than a bloom filter, which
+// is dropped along with the trailer; expiry times are dropped too. On
+// error, the partially written database is removed.
+func Convert(src, dst string, to Flavor, opts ...Option) error {
+	from, err := DetectFlavor(src)
+	if err != nil {
+		return err
+	}
+
+	var db *CDB
+	if from == FlavorClassic {
+		db, err = openClassic(src)
+	} else {
+		db, err = Open(src, opts...)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var wopts []Option
+	switch to {
+	case FlavorClassic:
+		wopts = []Option{WithHash(HashClassic)}
+	case FlavorChecksummed:
+		if from == FlavorChecksummed {
+			wopts = db.inheritOptions()
+		}
+	default:
+		return fmt.Errorf("cdb: unknown flavor %s", to)
+	}
+	wopts = append(wopts, opts...)
+
+	wr, err := Create(dst, wopts...)
+	if err != nil {
+		return err
+	}
+
+	err = convert(wr, db)
+	if err == nil {
+		err = wr.Close()
+	}
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+
+	if to == FlavorClassic {
+		err = stripTrailer(dst)
+	}
+
+	if err != nil {
+		os.Remove(dst)
+		os.Remove(dst + ".blob")
+		return err
+	}
+	return nil
+}
+
+func convert(wr *Writer, db *CDB) error {
+	now := time.Now()
+	iter := db.rawIter()
+	for iter.Next() {
+		if iter.deleted || expired(iter.expires, now) {
+			continue
+		}
+
+		// duplicates are kept, unless the key was deleted
+		first, _, err := db.findStored(iter.key)
+		if err != nil {
+			return err
+		}
+		if db.isTombstone(first) {
+			continue
+		}
+
+		if wr.trailer.expiry {
+			err = wr.PutTTL(iter.Key(), iter.Value(), iter.Expires())
+		} else {
+			err = wr.Put(iter.Key(), iter.Value())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// stripTrailer truncates the database at path, built with the classic
+// hash, to its hash tables
+func stripTrailer(path string) error {
+	db, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if db.trailer.hash != HashClassic || db.Features() != 0 {
+		return ErrNotClassic
+	}
+
+	end, err := classicEnd(db.reader, db.size)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, end)
+}
@@ -0,0 +1,76 @@
+package cdb
+
+import (
+	"bytes"
+	"slices"
+)
+
+// WithDeterministic makes the writer's output depend only on the set
+// of records added, not the order they were added in: identical input
+// yields a byte-identical file, which content-addressed storage and
+// build caches can recognize as unchanged. This matters when records
+// come from concurrent producers or unordered sources such as maps.
+//
+// The writer never records timestamps or other state of its own, and
+// places the records in the hash tables in the order they are written;
+// with WithDeterministic, it holds all the records in memory and writes
+// them sorted by key when the database is finalized. Records with the
+// same key keep the order they were added in, so that Get still returns
+// the first. Errors such as ErrTooMuchData are reported by Close or
+// Freeze rather than by Put.
+func WithDeterministic() Option {
+	return func(o *options) {
+		o.deterministic = true
+	}
+}
+
+// heldRecord is a record held by a deterministic writer
+type heldRecord struct {
+	hash       uint32
+	key        []byte
+	hdr, value []byte
+	tombstone  bool
+}
+
+// hold copies a record to be written by writeHeld
+func (cdb *Writer) hold(hash uint32, key, hdr, value []byte, tombstone bool) {
+	cdb.held = append(cdb.held, heldRecord{
+		hash:      hash,
+		key:       bytes.Clone(key),
+		hdr:       bytes.Clone(hdr),
+		value:     bytes.Clone(value),
+		tombstone: tombstone,
+	})
+}
+
+// writeHeld writes the held records in key order
+func (cdb *Writer) writeHeld() error {
+	if !cdb.deterministic {
+		return nil
+	}
+	cdb.deterministic = false
+
+	held := cdb.held
+	cdb.held = nil
+	slices.SortStableFunc(held, func(a, b heldRecord) int {
+		return bytes.Compare(a.key, b.key)
+	})
+
+	for i := range held {
+		r := &held[i]
+
+		var err error
+		if r.tombstone {
+			err = cdb.deleteHashed(r.hash, r.key)
+		} else {
+			err = cdb.putRecord(r.hash, r.key, r.hdr, r.value, nil, int64(len(r.value)))
+		}
+		if err != nil {
+			return err
+		}
+
+		// let the GC have the record once written
+		held[i] = heldRecord{}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DiffKind describes how a key differs between two databases.
+type DiffKind int
+
+const (
+	// DiffAdded: the key is only in the second database
+	DiffAdded DiffKind = iota
+
+	// DiffRemoved: the key is only in the first database
+	DiffRemoved
+
+	// DiffChanged: the key is in both databases with different values
+	DiffChanged
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("diffkind-%d", int(k))
+}
+
+// DiffReport summarizes the differences between two databases.
+type DiffReport struct {
+	Added   [][]byte
+	Removed [][]byte
+	Changed [][]byte
+}
+
+// Equal returns true if the databases had no differences.
+func (r *DiffReport) Equal() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0
+}
+
+// Diff compares databases a and b and returns the keys added, removed
+// and changed going from a to b. The keys are accumulated in memory; use
+// DiffFunc to process very large differences.
+func Diff(a, b *CDB) (*DiffReport, error) {
+	r := &DiffReport{}
+	err := DiffFunc(a, b, func(kind DiffKind, key, _, _ []byte) error {
+		switch kind {
+		case DiffAdded:
+			r.Added = append(r.Added, key)
+		case DiffRemoved:
+			r.Removed = append(r.Removed, key)
+		case DiffChanged:
+			r.Changed = append(r.Changed, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DiffFunc streams the differences between databases a and b to fn. For
+// added keys, old is nil; for removed keys, new is nil. Each database is
+// scanned once sequentially and probed once per key in the other, so
+// memory use is independent of the database sizes. If fn returns an
+// error, DiffFunc stops and returns it.
+//
+// Keys that occur more than once in a database are compared by their
+// first value (the one Get returns); later duplicates are ignored.
+func DiffFunc(a, b *CDB, fn func(kind DiffKind, key, old, new []byte) error) error {
+	// pass 1: everything in a is either removed or (maybe) changed
+	iter := a.Iter()
+	for iter.Next() {
+		key, val := iter.Key(), iter.Value()
+		nv, _, err := b.lookupStored(key)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case nv == nil:
+			err = fn(DiffRemoved, key, val, nil)
+		case !bytes.Equal(val, nv):
+			err = fn(DiffChanged, key, val, nv)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	// pass 2: keys in b not in a were added
+	iter = b.Iter()
+	for iter.Next() {
+		key, val := iter.Key(), iter.Value()
+		ov, _, err := a.lookupStored(key)
+		if err != nil {
+			return err
+		}
+
+		if ov == nil {
+			if err = fn(DiffAdded, key, nil, val); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,119 @@
+package cdb_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"cdb"
+)
+
+func TestDiff(t *testing.T) {
+	makeDBAt(t, "./test/a.cdb", testRecords)
+	makeDBAt(t, "./test/b.cdb", []kw{
+		{"hello", "world"},
+		{"abc", "xyz"},
+		{"new", "key"},
+	})
+
+	a, err := cdb.Open("./test/a.cdb")
+	if err != nil {
+		t.Fatalf("Can't open a.cdb: %s", err)
+	}
+	defer a.Close()
+
+	b, err := cdb.Open("./test/b.cdb")
+	if err != nil {
+		t.Fatalf("Can't open b.cdb: %s", err)
+	}
+	defer b.Close()
+
+	r, err := cdb.Diff(a, b)
+	if err != nil {
+		t.Fatalf("Diff failed: %s", err)
+	}
+
+	if len(r.Added) != 1 || string(r.Added[0]) != "new" {
+		t.Fatalf("Added mismatch: %q", r.Added)
+	}
+	if len(r.Removed) != 1 || string(r.Removed[0]) != "123" {
+		t.Fatalf("Removed mismatch: %q", r.Removed)
+	}
+	if len(r.Changed) != 1 || string(r.Changed[0]) != "abc" {
+		t.Fatalf("Changed mismatch: %q", r.Changed)
+	}
+
+	r, err = cdb.Diff(a, a)
+	if err != nil {
+		t.Fatalf("Diff failed: %s", err)
+	}
+	if !r.Equal() {
+		t.Fatalf("Database differs from itself: %+v", r)
+	}
+}
+
+func TestExportApply(t *testing.T) {
+	makeDBAt(t, "./test/old.cdb", testRecords)
+	makeDBAt(t, "./test/new.cdb", []kw{
+		{"hello", "world"},
+		{"abc", "xyz"},
+		{"new", "key"},
+	})
+
+	old, err := cdb.Open("./test/old.cdb")
+	if err != nil {
+		t.Fatalf("Can't open old.cdb: %s", err)
+	}
+	defer old.Close()
+
+	cur, err := cdb.Open("./test/new.cdb")
+	if err != nil {
+		t.Fatalf("Can't open new.cdb: %s", err)
+	}
+	defer cur.Close()
+
+	var buf bytes.Buffer
+	err = cur.Export(&buf, old)
+	if err != nil {
+		t.Fatalf("Export failed: %s", err)
+	}
+	delta := buf.Bytes()
+
+	err = cdb.Apply(bytes.NewReader(delta), old, "./test/applied.cdb")
+	if err != nil {
+		t.Fatalf("Apply failed: %s", err)
+	}
+
+	db, err := cdb.Open("./test/applied.cdb")
+	if err != nil {
+		t.Fatalf("Can't open applied.cdb: %s", err)
+	}
+	defer db.Close()
+
+	r, err := cdb.Diff(db, cur)
+	if err != nil {
+		t.Fatalf("Diff failed: %s", err)
+	}
+	if !r.Equal() {
+		t.Fatalf("Applied database differs: %+v", r)
+	}
+
+	// the delta only applies to the database it was made against
+	err = cdb.Apply(bytes.NewReader(delta), cur, "./test/applied.cdb")
+	if !errors.Is(err, cdb.ErrDeltaBase) {
+		t.Fatalf("Apply to wrong base: exp ErrDeltaBase, saw %v", err)
+	}
+
+	// damaged and truncated streams are rejected
+	bad := bytes.Clone(delta)
+	bad[len(bad)-40] ^= 1
+	err = cdb.Apply(bytes.NewReader(bad), old, "./test/applied.cdb")
+	if err == nil {
+		t.Fatalf("Apply of damaged stream succeeded")
+	}
+
+	err = cdb.Apply(bytes.NewReader(delta[:len(delta)-1]), old, "./test/applied.cdb")
+	if err == nil {
+		t.Fatalf("Apply of truncated stream succeeded")
+	}
+}
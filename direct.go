@@ -0,0 +1,18 @@
+package cdb
+
+// WithDirectIO makes Open read the database with O_DIRECT, bypassing
+// the page cache, for one-off scans of cold data that shouldn't evict
+// the working set of other databases served from the same machine.
+// Reads are made in aligned blocks of 256KB; the last block read is
+// kept in memory, so sequential scans (Range, Iter, Keys) read every
+// block once, but each random lookup costs a disk read. It overrides
+// WithMmap.
+//
+// It is a no-op on platforms without O_DIRECT and on filesystems that
+// don't support it (e.g. tmpfs), which are read through the page cache
+// as usual.
+func WithDirectIO() Option {
+	return func(o *options) {
+		o.direct = true
+	}
+}
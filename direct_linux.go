@@ -0,0 +1,111 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// alignment of the offsets, sizes and buffers of O_DIRECT reads
+	directAlign = 4096
+
+	// size of the reads made through the O_DIRECT descriptor
+	directBlock = 256 * 1024
+)
+
+// directFile reads a file opened with O_DIRECT. It reads aligned blocks
+// into an aligned buffer and serves ReadAt from the last one read.
+type directFile struct {
+	f    *os.File
+	size int64
+
+	mu  sync.Mutex
+	buf []byte
+	off int64
+	n   int
+}
+
+// openDirect reopens f with O_DIRECT. It returns f unchanged if the
+// filesystem doesn't support O_DIRECT; otherwise f is closed.
+func openDirect(f *os.File, size int64) (io.ReaderAt, error) {
+	df, err := os.OpenFile(f.Name(), os.O_RDONLY|syscall.O_DIRECT, 0)
+	if errors.Is(err, syscall.EINVAL) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &directFile{f: df, size: size, buf: alignedBuf(directBlock), off: -1}, nil
+}
+
+// alignedBuf returns a buffer of n bytes aligned to directAlign
+func alignedBuf(n int) []byte {
+	b := make([]byte, n+directAlign)
+	a := int(uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1))
+	if a != 0 {
+		a = directAlign - a
+	}
+	return b[a : a+n : a+n]
+}
+
+func (d *directFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("cdb: negative offset %d", off)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var done int
+	for done < len(p) && off < d.size {
+		if off < d.off || off >= d.off+int64(d.n) {
+			if err := d.fill(off); err != nil {
+				return done, err
+			}
+		}
+
+		n := copy(p[done:], d.buf[off-d.off:d.n])
+		done += n
+		off += int64(n)
+	}
+
+	if done < len(p) {
+		return done, io.EOF
+	}
+	return done, nil
+}
+
+// fill reads the block holding off
+func (d *directFile) fill(off int64) error {
+	start := off &^ (directAlign - 1)
+	n, err := d.f.ReadAt(d.buf, start)
+	if err != nil && !(err == io.EOF && start+int64(n) > off) {
+		d.off, d.n = -1, 0
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	d.off, d.n = start, n
+	return nil
+}
+
+func (d *directFile) Size() int64 {
+	return d.size
+}
+
+func (d *directFile) Stat() (os.FileInfo, error) {
+	return d.f.Stat()
+}
+
+func (d *directFile) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cdb
+
+import (
+	"io"
+	"os"
+)
+
+// openDirect returns f unchanged; there is no O_DIRECT here.
+func openDirect(f *os.File, size int64) (io.ReaderAt, error) {
+	return f, nil
+}
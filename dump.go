@@ -0,0 +1,127 @@
+package cdb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DumpFormat selects the text representation used by DumpTo.
+type DumpFormat int
+
+const (
+	// DumpCDBMake is djb's cdbmake input format: one "+klen,dlen:key->data"
+	// line per record, terminated by an empty line.
+	DumpCDBMake DumpFormat = iota
+
+	// DumpJSON writes one {"k":...,"v":...} object per line with keys
+	// and values as JSON strings; every key and value must be valid UTF-8.
+	DumpJSON
+
+	// DumpJSONBase64 is like DumpJSON but keys and values are base64
+	// encoded; use it for binary data.
+	DumpJSONBase64
+
+	// DumpCSV writes one "key,value" row per record.
+	DumpCSV
+)
+
+func (f DumpFormat) String() string {
+	switch f {
+	case DumpCDBMake:
+		return "cdbmake"
+	case DumpJSON:
+		return "json"
+	case DumpJSONBase64:
+		return "json-base64"
+	case DumpCSV:
+		return "csv"
+	}
+	return fmt.Sprintf("dumpformat-%d", int(f))
+}
+
+// jsonRecord is the per line object of the JSON formats
+type jsonRecord struct {
+	K string `json:"k"`
+	V string `json:"v"`
+}
+
+// DumpTo writes every record of the database that Iter yields to w in
+// the given format, in the order they were written to the database.
+func (cdb *CDB) DumpTo(w io.Writer, format DumpFormat) error {
+	bw := bufio.NewWriterSize(w, 65536)
+
+	var put func(k, v []byte) error
+	var cw *csv.Writer
+
+	switch format {
+	case DumpCDBMake:
+		put = func(k, v []byte) error {
+			bw.WriteByte('+')
+			bw.WriteString(strconv.Itoa(len(k)))
+			bw.WriteByte(',')
+			bw.WriteString(strconv.Itoa(len(v)))
+			bw.WriteByte(':')
+			bw.Write(k)
+			bw.WriteString("->")
+			bw.Write(v)
+			return bw.WriteByte('\n')
+		}
+
+	case DumpJSON, DumpJSONBase64:
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		put = func(k, v []byte) error {
+			var r jsonRecord
+			if format == DumpJSON {
+				if !utf8.Valid(k) || !utf8.Valid(v) {
+					return fmt.Errorf("cdb: key %q: not valid UTF-8; use DumpJSONBase64", k)
+				}
+				r = jsonRecord{string(k), string(v)}
+			} else {
+				r = jsonRecord{
+					base64.StdEncoding.EncodeToString(k),
+					base64.StdEncoding.EncodeToString(v),
+				}
+			}
+			return enc.Encode(&r)
+		}
+
+	case DumpCSV:
+		cw = csv.NewWriter(bw)
+		put = func(k, v []byte) error {
+			return cw.Write([]string{string(k), string(v)})
+		}
+
+	default:
+		return fmt.Errorf("cdb: unknown dump format %d", int(format))
+	}
+
+	iter := cdb.Iter()
+	for iter.Next() {
+		if err := put(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	switch format {
+	case DumpCDBMake:
+		bw.WriteByte('\n')
+	case DumpCSV:
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
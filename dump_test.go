@@ -0,0 +1,190 @@
+package cdb_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"cdb"
+)
+
+func TestDumpCDBMake(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	var b bytes.Buffer
+	err = db.DumpTo(&b, cdb.DumpCDBMake)
+	if err != nil {
+		t.Fatalf("Can't dump test.cdb: %s", err)
+	}
+
+	exp := "+5,5:hello->world\n+3,3:abc->def\n+3,3:123->345\n\n"
+	if b.String() != exp {
+		t.Fatalf("Dump mismatch:\nexp %q\nsaw %q", exp, b.String())
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	formats := []cdb.DumpFormat{cdb.DumpCDBMake, cdb.DumpJSON, cdb.DumpJSONBase64, cdb.DumpCSV}
+	for _, f := range formats {
+		var b bytes.Buffer
+		err = db.DumpTo(&b, f)
+		if err != nil {
+			t.Fatalf("%s: Can't dump test.cdb: %s", f, err)
+		}
+
+		err = cdb.BuildFrom(&b, f, "./test/build.cdb")
+		if err != nil {
+			t.Fatalf("%s: Can't build from dump: %s", f, err)
+		}
+
+		nb, err := cdb.Open("./test/build.cdb")
+		if err != nil {
+			t.Fatalf("%s: Can't open build.cdb: %s", f, err)
+		}
+
+		for _, r := range testRecords {
+			v, err := nb.Get([]byte(r.key))
+			if err != nil {
+				t.Fatalf("%s: Can't find key %s: %s", f, r.key, err)
+			}
+
+			if r.val != string(v) {
+				t.Fatalf("%s: Value mismatch for key %s (exp %s, saw %s)", f, r.key, r.val, string(v))
+			}
+		}
+		nb.Close()
+	}
+}
+
+func TestBuildFromBadCDBMake(t *testing.T) {
+	fn := "./test/build-bad.cdb"
+	for _, in := range []string{
+		"+4000000000,1:k->v\n\n",
+		"+1,4294967295:k->v\n\n",
+		"+3,5:abc->def\n\n",
+		"+3,3:abc-def\n\n",
+	} {
+		err := cdb.BuildFrom(strings.NewReader(in), cdb.DumpCDBMake, fn)
+		if err == nil {
+			t.Fatalf("%q: BuildFrom didn't fail", in)
+		}
+		if _, err := os.Stat(fn); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%q: %s left behind: %v", in, fn, err)
+		}
+	}
+}
+
+func TestBuildFromChan(t *testing.T) {
+	ch := make(chan cdb.Record, 2)
+	go func() {
+		for _, r := range testRecords {
+			ch <- cdb.Record{Key: []byte(r.key), Value: []byte(r.val)}
+		}
+		close(ch)
+	}()
+
+	err := cdb.BuildFromChan("./test/chan.cdb", ch)
+	if err != nil {
+		t.Fatalf("BuildFromChan failed: %s", err)
+	}
+
+	db, err := cdb.Open("./test/chan.cdb")
+	if err != nil {
+		t.Fatalf("Can't open chan.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Get %s: exp %s, saw %s, %v", r.key, r.val, v, err)
+		}
+	}
+
+	// a producer error aborts the build, and the rest of the records
+	// are drained
+	boom := errors.New("boom")
+	ch = make(chan cdb.Record)
+	go func() {
+		ch <- cdb.Record{Key: []byte("a"), Value: []byte("b")}
+		ch <- cdb.Record{Err: boom}
+		for _, r := range testRecords {
+			ch <- cdb.Record{Key: []byte(r.key), Value: []byte(r.val)}
+		}
+		close(ch)
+	}()
+
+	err = cdb.BuildFromChan("./test/chan-err.cdb", ch)
+	if !errors.Is(err, boom) {
+		t.Fatalf("BuildFromChan: exp %v, saw %v", boom, err)
+	}
+	if _, err := os.Stat("./test/chan-err.cdb"); !os.IsNotExist(err) {
+		t.Fatalf("Failed build left chan-err.cdb behind: %v", err)
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	m := make(map[string][]byte)
+	var sm sync.Map
+	for i := 0; i < 5000; i++ {
+		k, v := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+		m[k] = []byte(v)
+		sm.Store(k, []byte(v))
+	}
+
+	if err := cdb.FromMap("./test/map.cdb", m); err != nil {
+		t.Fatalf("FromMap failed: %s", err)
+	}
+	if err := cdb.FromRanger("./test/syncmap.cdb", &sm); err != nil {
+		t.Fatalf("FromRanger failed: %s", err)
+	}
+
+	// both are written in key order
+	a, err := os.ReadFile("./test/map.cdb")
+	if err != nil {
+		t.Fatalf("Can't read map.cdb: %s", err)
+	}
+	b, err := os.ReadFile("./test/syncmap.cdb")
+	if err != nil {
+		t.Fatalf("Can't read syncmap.cdb: %s", err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatalf("FromMap and FromRanger output differ")
+	}
+
+	db, err := cdb.Open("./test/map.cdb")
+	if err != nil {
+		t.Fatalf("Can't open map.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for k, v := range m {
+		got, err := db.Get([]byte(k))
+		if err != nil || !bytes.Equal(got, v) {
+			t.Fatalf("Get %s: exp %s, saw %s, %v", k, v, got, err)
+		}
+	}
+
+	sm.Store(1, "one")
+	if err := cdb.FromRanger("./test/syncmap-err.cdb", &sm); err == nil {
+		t.Fatalf("FromRanger accepted an int key")
+	}
+}
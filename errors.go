@@ -0,0 +1,156 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WriteStage identifies the part of the database a Writer was writing
+// when it failed.
+type WriteStage int
+
+const (
+	// StageData: writing records (Put, Delete, ...)
+	StageData WriteStage = iota
+
+	// StageTable: writing the hash tables during finalize
+	StageTable
+
+	// StageTrailer: writing the trailer during finalize
+	StageTrailer
+
+	// StageIndex: writing the index at the head of the file
+	StageIndex
+
+	// StageChecksum: computing or appending the checksum
+	StageChecksum
+
+	// StageSignature: writing the signature into the trailer
+	StageSignature
+
+	// StageReserve: allocating or releasing disk space; see
+	// Writer.Reserve
+	StageReserve
+)
+
+func (s WriteStage) String() string {
+	switch s {
+	case StageData:
+		return "data"
+	case StageTable:
+		return "table"
+	case StageTrailer:
+		return "trailer"
+	case StageIndex:
+		return "index"
+	case StageChecksum:
+		return "checksum"
+	case StageSignature:
+		return "signature"
+	case StageReserve:
+		return "reserve"
+	}
+	return fmt.Sprintf("stage-%d", int(s))
+}
+
+// WriteError describes a failure of the underlying file while writing
+// a database; use errors.Is/As on Err to check for conditions such as
+// ENOSPC. Errors in StageData can happen during any Put and mean the
+// records written so far are incomplete; errors in later stages happen
+// in Close or Freeze and mean the file is not a valid database.
+type WriteError struct {
+	Stage WriteStage
+
+	// Offset of the record, table or structure being written. Writes
+	// are buffered, so the failure may have surfaced while writing an
+	// earlier part of the file.
+	Offset int64
+
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("cdb: write %s at offset %d: %s", e.Stage, e.Offset, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+func writeErr(stage WriteStage, off int64, err error) error {
+	return &WriteError{Stage: stage, Offset: off, Err: err}
+}
+
+// ErrCorrupt is wrapped by the errors returned for malformed database
+// files: offsets or lengths that point outside the file, sections that
+// don't parse, and the like. Reading a corrupt (or malicious) file
+// returns a *CorruptError rather than panicking or making huge
+// allocations; use errors.Is to check for ErrCorrupt or one of the
+// more specific kinds below.
+var ErrCorrupt = errors.New("cdb: corrupt database")
+
+// Kinds of corruption; see CorruptError.
+var (
+	// ErrBadIndex: the index at the head of the file, or the location
+	// of a hash table, is invalid
+	ErrBadIndex = errors.New("cdb: bad index")
+
+	// ErrBadSlot: a hash table slot doesn't point to the right record
+	ErrBadSlot = errors.New("cdb: bad hash table slot")
+
+	// ErrBadRecord: a record in the data section is malformed
+	ErrBadRecord = errors.New("cdb: bad record")
+
+	// ErrBadTrailer: the trailer doesn't parse
+	ErrBadTrailer = errors.New("cdb: bad trailer")
+)
+
+// CorruptError describes what is wrong with a malformed database file,
+// and where. errors.Is matches it against ErrCorrupt and its Kind.
+type CorruptError struct {
+	// ErrBadIndex, ErrBadSlot, ErrBadRecord or ErrBadTrailer
+	Kind error
+
+	// Offset in the file of the index entry, slot, record or trailer
+	// section that is bad
+	Offset int64
+
+	// Expected and Actual values, for errors that compare two; they
+	// are equal (zero) otherwise
+	Expected int64
+	Actual   int64
+
+	Msg string
+
+	// underlying error, if any
+	Err error
+}
+
+func (e *CorruptError) Error() string {
+	s := fmt.Sprintf("%s at offset %d: %s", e.Kind, e.Offset, e.Msg)
+	if e.Expected != e.Actual {
+		s += fmt.Sprintf(" (expected %d, saw %d)", e.Expected, e.Actual)
+	}
+	return s
+}
+
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt || target == e.Kind
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// corruptAt returns a CorruptError of the given kind at off; format
+// and args describe the problem and may wrap an error with %w.
+func corruptAt(kind error, off int64, format string, args ...interface{}) *CorruptError {
+	err := fmt.Errorf(format, args...)
+	return &CorruptError{Kind: kind, Offset: off, Msg: err.Error(), Err: errors.Unwrap(err)}
+}
+
+// want records the expected and actual values of e
+func (e *CorruptError) want(expected, actual int64) *CorruptError {
+	e.Expected, e.Actual = expected, actual
+	return e
+}
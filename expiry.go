@@ -0,0 +1,90 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrNoExpiry is returned by PutTTL when the database wasn't created
+// WithExpiry().
+var ErrNoExpiry = errors.New("cdb: database doesn't store expiry times")
+
+// Size of the expiry time stored before each value
+const expirySize = 8
+
+// WithExpiry makes the writer store an expiry time with every value;
+// see Writer.PutTTL. Records written with Put never expire.
+//
+// The expiry time is stored as a prefix of the value (unix seconds);
+// readers that don't understand the trailer will see it as part of the
+// value.
+func WithExpiry() Option {
+	return func(o *options) {
+		o.expiry = true
+	}
+}
+
+// ExpireFilter makes Merge drop records that expired at or before t,
+// instead of copying them with their expiry time.
+func ExpireFilter(t time.Time) Option {
+	return func(o *options) {
+		o.expireBefore = t
+	}
+}
+
+// PutTTL adds a key/value pair that Get stops returning at expiresAt.
+// A zero expiresAt means the record never expires. The database must
+// have been created WithExpiry().
+func (cdb *Writer) PutTTL(key, value []byte, expiresAt time.Time) error {
+	if !cdb.trailer.expiry {
+		return ErrNoExpiry
+	}
+	if err := cdb.checkSchema(key, value); err != nil {
+		return err
+	}
+
+	var hdr [expirySize]byte
+	if !expiresAt.IsZero() {
+		binary.LittleEndian.PutUint64(hdr[:], uint64(expiresAt.Unix()))
+	}
+	return cdb.put(key, hdr[:], value)
+}
+
+// splitExpiry separates the raw value of the record at off into its
+// expiry time (unix seconds, 0 for never) and the value proper, which
+// it reads from the blob file if the record holds a blob pointer.
+func (cdb *CDB) splitExpiry(off uint32, raw []byte) (int64, []byte, error) {
+	var exp int64
+	if cdb.trailer.expiry {
+		if len(raw) < expirySize {
+			return 0, nil, corruptAt(ErrBadRecord, int64(off), "value too short for expiry time").want(expirySize, int64(len(raw)))
+		}
+		exp, raw = int64(binary.LittleEndian.Uint64(raw)), raw[expirySize:]
+	}
+
+	if cdb.isBlob(off) {
+		v, err := cdb.readBlob(off, raw)
+		return exp, v, err
+	}
+	return exp, raw, nil
+}
+
+// unwrap returns the value proper of the raw value of the record at off;
+// it returns false if the record has expired.
+func (cdb *CDB) unwrap(off uint32, raw []byte) ([]byte, bool, error) {
+	exp, v, err := cdb.splitExpiry(off, raw)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if expired(exp, time.Now()) {
+		return nil, false, nil
+	}
+	return v, true, nil
+}
+
+// expired returns true if a record with expiry time exp has expired at now
+func expired(exp int64, now time.Time) bool {
+	return exp != 0 && exp <= now.Unix()
+}
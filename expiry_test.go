@@ -0,0 +1,88 @@
+package cdb_test
+
+import (
+	"testing"
+	"time"
+
+	"cdb"
+)
+
+func TestExpiry(t *testing.T) {
+	wr, err := cdb.Create("./test/ttl.cdb", cdb.WithExpiry())
+	if err != nil {
+		t.Fatalf("Can't create ttl.cdb: %s", err)
+	}
+
+	now := time.Now()
+	recs := []struct {
+		key, val string
+		exp      time.Time
+	}{
+		{"old", "stale", now.Add(-time.Hour)},
+		{"new", "fresh", now.Add(time.Hour)},
+		{"forever", "young", time.Time{}},
+	}
+
+	for _, r := range recs {
+		err = wr.PutTTL([]byte(r.key), []byte(r.val), r.exp)
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Put([]byte("plain"), []byte("value"))
+	if err != nil {
+		t.Fatalf("Can't put key plain: %s", err)
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close ttl.cdb: %s", err)
+	}
+
+	db, err := cdb.Open("./test/ttl.cdb")
+	if err != nil {
+		t.Fatalf("Can't open ttl.cdb: %s", err)
+	}
+	defer db.Close()
+
+	exp := map[string]string{
+		"old":     "",
+		"new":     "fresh",
+		"forever": "young",
+		"plain":   "value",
+	}
+	for k, val := range exp {
+		v, err := db.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("Can't get key %s: %s", k, err)
+		}
+
+		if string(v) != val {
+			t.Fatalf("Value mismatch for key %s (exp %q, saw %q)", k, val, string(v))
+		}
+	}
+
+	err = cdb.Merge("./test/ttl2.cdb", []*cdb.CDB{db}, cdb.ExpireFilter(now))
+	if err != nil {
+		t.Fatalf("Can't merge: %s", err)
+	}
+
+	db2, err := cdb.Open("./test/ttl2.cdb")
+	if err != nil {
+		t.Fatalf("Can't open ttl2.cdb: %s", err)
+	}
+	defer db2.Close()
+
+	var n int
+	iter := db2.Iter()
+	for iter.Next() {
+		if string(iter.Key()) == "new" && iter.Expires().Unix() != now.Add(time.Hour).Unix() {
+			t.Fatalf("Expiry time lost in merge: %s", iter.Expires())
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("Merged db has %d records; exp 3", n)
+	}
+}
@@ -0,0 +1,281 @@
+package cdb
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// number of slots written to the output at a time by an external sort
+const extSortSlots = 4096
+
+// WithExternalSort builds the hash tables with an external sort, for
+// inputs whose hash table entries don't fit in memory even one table
+// at a time. It implies WithSpill(dir): the entries are spooled to a
+// temporary file as records are written. When the writer is
+// finalized, each spilled segment of a table is sorted in place by the
+// slot where its probes start, and the sorted segments are merged
+// straight into the table on disk; only one segment and a small read
+// buffer per segment are held in memory.
+//
+// The tables are laid out differently from those of an in-memory build
+// (entries are placed in order of their probe start rather than in the
+// order they were written), but they are looked up the same way, and
+// duplicate keys are still found in the order they were written.
+func WithExternalSort(dir string) Option {
+	return func(o *options) {
+		o.spill = true
+		o.spillDir = dir
+		o.extSort = true
+	}
+}
+
+// writeSortedTable writes table t with an external sort of its
+// entries, adding them to bf if it is not nil. It returns the table and
+// its longest probe distance.
+func (cdb *Writer) writeSortedTable(t int, bf *bloom) (table, uint32, error) {
+	s := cdb.spill
+	mem := cdb.entries[t]
+	cdb.entries[t] = nil
+
+	count := len(mem)
+	for _, sg := range s.segs[t] {
+		count += sg.n
+	}
+
+	n := len(cdb.entries)
+	l := uint32(count << 1)
+	tab := table{
+		offset: uint32(cdb.bufferedOffset),
+		length: l,
+	}
+	start := func(e entry) uint32 {
+		return probeStart(e.hash, n, l)
+	}
+	order := func(a, b entry) int {
+		return cmp.Compare(start(a), start(b))
+	}
+
+	// Sort every run; stable, so that duplicate keys keep the order
+	// they were written in.
+	var buf []entry
+	for _, sg := range s.segs[t] {
+		var err error
+		buf, err = s.readSegment(sg, buf)
+		if err != nil {
+			return tab, 0, err
+		}
+		slices.SortStableFunc(buf, order)
+		if err := s.writeSegment(sg, buf); err != nil {
+			return tab, 0, err
+		}
+		bf.addEntries(buf)
+	}
+	slices.SortStableFunc(mem, order)
+	bf.addEntries(mem)
+	buf = nil
+
+	// An entry is placed in the first free slot at or after its probe
+	// start. Those that run off the end of the table wrap around to the
+	// first free slots from the start; finding them takes a first pass.
+	var wrapped []entry
+	var next uint32
+	m, err := s.newMerge(t, mem, start)
+	if err != nil {
+		return tab, 0, err
+	}
+	for m.Len() > 0 {
+		e, k, err := m.pop()
+		if err != nil {
+			return tab, 0, err
+		}
+		if next >= l {
+			wrapped = append(wrapped, e)
+		} else {
+			next = max(next, k) + 1
+		}
+	}
+
+	slots := make([]entry, 0, extSortSlots)
+	emit := func(e entry) error {
+		slots = append(slots, e)
+		if len(slots) < extSortSlots {
+			return nil
+		}
+		err := cdb.writeSlots(slots)
+		slots = slots[:0]
+		return err
+	}
+
+	// fill emits a free slot, unless an entry wrapped around into it
+	var probe uint32
+	fill := func() error {
+		if len(wrapped) == 0 {
+			return emit(entry{})
+		}
+		e := wrapped[0]
+		wrapped = wrapped[1:]
+		probe = max(probe, probeDist(start(e), next, l))
+		return emit(e)
+	}
+
+	next = 0
+	m, err = s.newMerge(t, mem, start)
+	if err != nil {
+		return tab, 0, err
+	}
+	for m.Len() > 0 && next < l {
+		e, k, err := m.pop()
+		if err != nil {
+			return tab, 0, err
+		}
+		for ; next < k; next++ {
+			if err := fill(); err != nil {
+				return tab, 0, err
+			}
+		}
+		if err := emit(e); err != nil {
+			return tab, 0, err
+		}
+		probe = max(probe, next-k)
+		next++
+	}
+	for ; next < l; next++ {
+		if err := fill(); err != nil {
+			return tab, 0, err
+		}
+	}
+	return tab, probe, cdb.writeSlots(slots)
+}
+
+// addEntries adds the hashes of ents to the filter, if there is one
+func (bf *bloom) addEntries(ents []entry) {
+	if bf == nil {
+		return
+	}
+	for _, e := range ents {
+		bf.add(e.hash)
+	}
+}
+
+// writeSegment overwrites spilled segment sg with ents
+func (s *spill) writeSegment(sg segment, ents []entry) error {
+	buf := make([]byte, 0, 8*len(ents))
+	for _, e := range ents {
+		buf = binary.LittleEndian.AppendUint32(buf, e.hash)
+		buf = binary.LittleEndian.AppendUint32(buf, e.offset)
+	}
+	if _, err := s.f.WriteAt(buf, sg.off); err != nil {
+		return fmt.Errorf("cdb: spill: %w", err)
+	}
+	return nil
+}
+
+// run is one sorted run of the entries of a table: a spilled segment
+// or the entries still in memory
+type run struct {
+	r    *bufio.Reader
+	left int
+	mem  []entry
+
+	head entry
+	key  uint32
+}
+
+// advance moves to the next entry of the run; it returns false at the
+// end of the run
+func (r *run) advance(start func(entry) uint32) (bool, error) {
+	if r.r == nil {
+		if len(r.mem) == 0 {
+			return false, nil
+		}
+		r.head, r.mem = r.mem[0], r.mem[1:]
+	} else {
+		if r.left == 0 {
+			return false, nil
+		}
+		var b [8]byte
+		if _, err := io.ReadFull(r.r, b[:]); err != nil {
+			return false, fmt.Errorf("cdb: spill: %w", err)
+		}
+		r.head = entry{
+			hash:   binary.LittleEndian.Uint32(b[:4]),
+			offset: binary.LittleEndian.Uint32(b[4:]),
+		}
+		r.left--
+	}
+	r.key = start(r.head)
+	return true, nil
+}
+
+// runMerge merges the sorted runs of a table; runs written earlier come
+// first among entries with the same probe start
+type runMerge struct {
+	runs  []*run
+	idx   []int
+	start func(entry) uint32
+}
+
+// newMerge returns a merge of the spilled segments of table t and its
+// in-memory entries mem, in that order
+func (s *spill) newMerge(t int, mem []entry, start func(entry) uint32) (*runMerge, error) {
+	m := &runMerge{start: start}
+	for _, sg := range s.segs[t] {
+		sr := io.NewSectionReader(s.f, sg.off, int64(8*sg.n))
+		m.runs = append(m.runs, &run{r: bufio.NewReaderSize(sr, 8<<10), left: sg.n})
+	}
+	m.runs = append(m.runs, &run{mem: mem})
+
+	for i, r := range m.runs {
+		ok, err := r.advance(start)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.idx = append(m.idx, i)
+		}
+	}
+	heap.Init(m)
+	return m, nil
+}
+
+// pop returns the next entry and its probe start
+func (m *runMerge) pop() (entry, uint32, error) {
+	r := m.runs[m.idx[0]]
+	e, k := r.head, r.key
+
+	ok, err := r.advance(m.start)
+	if err != nil {
+		return e, k, err
+	}
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+	}
+	return e, k, nil
+}
+
+func (m *runMerge) Len() int { return len(m.idx) }
+
+func (m *runMerge) Less(i, j int) bool {
+	a, b := m.runs[m.idx[i]], m.runs[m.idx[j]]
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	return m.idx[i] < m.idx[j]
+}
+
+func (m *runMerge) Swap(i, j int) { m.idx[i], m.idx[j] = m.idx[j], m.idx[i] }
+
+func (m *runMerge) Push(x any) { m.idx = append(m.idx, x.(int)) }
+
+func (m *runMerge) Pop() any {
+	i := m.idx[len(m.idx)-1]
+	m.idx = m.idx[:len(m.idx)-1]
+	return i
+}
@@ -0,0 +1,156 @@
+package cdb
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Feature is a bit in the feature bitmap recorded in the trailer. Each
+// bit names an extension that changes how records must be interpreted;
+// a reader refuses to open a file that uses features it doesn't know,
+// rather than silently misreading it.
+type Feature uint64
+
+const (
+	// FeatureTombstones: some records are tombstones (see Writer.Delete)
+	FeatureTombstones Feature = 1 << iota
+
+	// FeatureExpiry: every value is prefixed by its expiry time (see
+	// WithExpiry)
+	FeatureExpiry
+
+	// FeatureMPH: the hash tables are replaced by a minimal perfect hash
+	// (see WithMPH)
+	FeatureMPH
+
+	// FeatureFrontCoding: keys are stored front coded (see
+	// WithFrontCoding)
+	FeatureFrontCoding
+
+	// FeatureTables: the index has a number of hash tables other than
+	// 256 (see WithTables)
+	FeatureTables
+
+	// FeatureBlobs: some values are stored in a blob file (see
+	// WithBlobs)
+	FeatureBlobs
+
+	// FeatureKeyTransform: keys are normalized before they are stored
+	// or looked up (see WithKeyTransform)
+	FeatureKeyTransform
+
+	// FeatureHashSeed: the hash function is seeded with a seed other
+	// than the default (see WithHashSeed)
+	FeatureHashSeed
+
+	// FeatureKeyFingerprint: fingerprints of the keys are stored
+	// instead of the keys (see WithKeyFingerprint)
+	FeatureKeyFingerprint
+
+	// FeatureAlignment: the data section has filler records that
+	// align the values (see WithAlignment)
+	FeatureAlignment
+
+	// FeatureCompactIndex: the index is a header, and the lengths of
+	// the hash tables are in the trailer (see WithCompactIndex)
+	FeatureCompactIndex
+
+	// FeatureChecksum: the checksum is computed with an algorithm
+	// other than SHA256, or there is none (see WithChecksum)
+	FeatureChecksum
+)
+
+// supportedFeatures is the set of features understood by this reader
+const supportedFeatures = FeatureTombstones | FeatureExpiry | FeatureMPH | FeatureFrontCoding | FeatureTables | FeatureBlobs | FeatureKeyTransform | FeatureHashSeed | FeatureKeyFingerprint | FeatureAlignment | FeatureCompactIndex | FeatureChecksum
+
+var featureNames = []string{
+	"tombstones",
+	"expiry",
+	"mph",
+	"front-coding",
+	"tables",
+	"blobs",
+	"key-transform",
+	"hash-seed",
+	"key-fingerprint",
+	"alignment",
+	"compact-index",
+	"checksum",
+}
+
+// String returns the names of the features set in f.
+func (f Feature) String() string {
+	if f == 0 {
+		return "none"
+	}
+
+	var names []string
+	for f != 0 {
+		i := bits.TrailingZeros64(uint64(f))
+		if i < len(featureNames) {
+			names = append(names, featureNames[i])
+		} else {
+			names = append(names, fmt.Sprintf("feature-%d", i))
+		}
+		f &^= 1 << i
+	}
+	return strings.Join(names, ", ")
+}
+
+// FeatureError is returned by Open for databases that use features
+// this version of the package doesn't support.
+type FeatureError struct {
+	Unsupported Feature
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("cdb: database uses unsupported features: %s", e.Unsupported)
+}
+
+// Features returns the set of format extensions used by the database.
+func (cdb *CDB) Features() Feature {
+	return cdb.trailer.features()
+}
+
+// features returns the feature bitmap describing the trailer contents
+func (t *trailer) features() Feature {
+	var f Feature
+	if len(t.tombstones) > 0 {
+		f |= FeatureTombstones
+	}
+	if t.expiry {
+		f |= FeatureExpiry
+	}
+	if t.mph != nil {
+		f |= FeatureMPH
+	}
+	if t.front {
+		f |= FeatureFrontCoding
+	}
+	if t.tables != 0 {
+		f |= FeatureTables
+	}
+	if t.blobs != nil {
+		f |= FeatureBlobs
+	}
+	if t.keyTransform != "" {
+		f |= FeatureKeyTransform
+	}
+	if t.hashSeed != nil {
+		f |= FeatureHashSeed
+	}
+	if t.keyFP != 0 {
+		f |= FeatureKeyFingerprint
+	}
+	if t.alignment != 0 {
+		f |= FeatureAlignment
+	}
+	if t.compact {
+		f |= FeatureCompactIndex
+	}
+	if t.checksum != ChecksumSHA256 {
+		f |= FeatureChecksum
+	}
+	return f
+}
@@ -0,0 +1,75 @@
+package cdb
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/opencoff/go-lib/fasthash"
+)
+
+// seed of the hash KeepSample picks keys by; it differs from the seeds
+// of the table hashes, so the kept keys still spread evenly over the
+// tables of the new database
+const sampleSeed = 0xc6a4a7935bd1e995
+
+// Filter makes Merge and Rewrite copy only the records for which keep
+// returns true, so that rebuilds can prune a dataset without a separate
+// pass. Filters given more than once must all keep a record. Merge
+// applies them to the record that wins for its key: a key whose latest
+// record is dropped is dropped from the output, not replaced by an
+// older record. Rewrite applies them before apply sees the record.
+// DropPrefix, DropValueMatching and KeepSample are ready-made filters.
+func Filter(keep func(key, value []byte) bool) Option {
+	return func(o *options) {
+		o.filters = append(o.filters, keep)
+	}
+}
+
+// keep returns true if the filters in o keep the record
+func (o *options) keep(key, value []byte) bool {
+	for _, fn := range o.filters {
+		if !fn(key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// DropPrefix is a filter that drops the keys starting with any of
+// prefixes.
+func DropPrefix(prefixes ...[]byte) func(key, value []byte) bool {
+	return func(key, _ []byte) bool {
+		for _, p := range prefixes {
+			if bytes.HasPrefix(key, p) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DropValueMatching is a filter that drops the records whose value
+// matches re.
+func DropValueMatching(re *regexp.Regexp) func(key, value []byte) bool {
+	return func(_, value []byte) bool {
+		return !re.Match(value)
+	}
+}
+
+// KeepSample is a filter that keeps a fraction p of the keys, e.g. to
+// build a smaller database for testing. The keys are picked by their
+// hash, so the same keys are kept every time, and a sample at a smaller
+// p is a subset of one at a larger p.
+func KeepSample(p float64) func(key, value []byte) bool {
+	if p >= 1 {
+		return func(_, _ []byte) bool { return true }
+	}
+
+	var limit uint64
+	if p > 0 {
+		limit = uint64(p * (1 << 64))
+	}
+	return func(key, _ []byte) bool {
+		return fasthash.Hash64(sampleSeed, key) < limit
+	}
+}
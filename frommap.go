@@ -0,0 +1,117 @@
+package cdb
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Ranger is a map-like collection that can be snapshotted with
+// FromRanger. *sync.Map implements it. The keys and values passed to fn
+// must be strings or byte slices.
+type Ranger interface {
+	Range(fn func(key, value any) bool)
+}
+
+// FromMap writes the contents of m to a new database at path. The
+// records are written in key order, so the same map always yields the
+// same file; the keys are hashed in parallel. If the build fails, the
+// partially written database is removed.
+func FromMap(path string, m map[string][]byte, opts ...Option) error {
+	recs := make([]HashedRecord, 0, len(m))
+	for k, v := range m {
+		recs = append(recs, HashedRecord{Key: []byte(k), Value: v})
+	}
+	return fromRecords(path, recs, opts)
+}
+
+// FromRanger is like FromMap, but reads the records from r, e.g. a
+// *sync.Map. r is read once, before the database is written; concurrent
+// updates to r are seen as sync.Map's Range sees them. It fails if a
+// key or value isn't a string or []byte.
+func FromRanger(path string, r Ranger, opts ...Option) error {
+	var recs []HashedRecord
+	var err error
+	r.Range(func(key, value any) bool {
+		var k, v []byte
+		if k, err = anyBytes(key); err != nil {
+			err = fmt.Errorf("cdb: FromRanger: key %w", err)
+			return false
+		}
+		if v, err = anyBytes(value); err != nil {
+			err = fmt.Errorf("cdb: FromRanger: value of %q %w", k, err)
+			return false
+		}
+		recs = append(recs, HashedRecord{Key: k, Value: v})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fromRecords(path, recs, opts)
+}
+
+func anyBytes(x any) ([]byte, error) {
+	switch x := x.(type) {
+	case string:
+		return []byte(x), nil
+	case []byte:
+		return x, nil
+	}
+	return nil, fmt.Errorf("has type %T, not string or []byte", x)
+}
+
+func fromRecords(path string, recs []HashedRecord, opts []Option) error {
+	sort.Slice(recs, func(i, j int) bool {
+		return string(recs[i].Key) < string(recs[j].Key)
+	})
+
+	wr, err := Create(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	wr.hashAll(recs)
+	for i := range recs {
+		r := &recs[i]
+		if err = wr.PutHashed(r.Hash, r.Key, r.Value); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = wr.Close()
+	}
+
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+	return nil
+}
+
+// hashAll fills in the hashes of the (normalized) keys of recs, using
+// one goroutine per CPU.
+func (cdb *Writer) hashAll(recs []HashedRecord) {
+	n := runtime.GOMAXPROCS(0)
+	chunk := (len(recs) + n - 1) / n
+	if n == 1 || chunk < 1024 {
+		chunk = len(recs)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(recs); i += chunk {
+		part := recs[i:min(i+chunk, len(recs))]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range part {
+				part[j].Key = cdb.normKey(part[j].Key)
+				part[j].Hash = cdb.hasher(cdb.storeKey(part[j].Key))
+			}
+		}()
+	}
+	wg.Wait()
+}
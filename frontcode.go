@@ -0,0 +1,190 @@
+package cdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// WithFrontCoding makes the writer front code keys: a key that shares
+// a prefix with the last fully stored key is written as the length of
+// the shared prefix, the distance back to that key and the remaining
+// suffix. This shrinks databases whose keys are URLs, file paths and
+// the like, especially when they are added in sorted order. Keys are
+// reconstructed transparently by Get and the iterators.
+//
+// A lookup of a front coded key reads the record holding its prefix as
+// well, and GetInto allocates. Databases built this way can't be read
+// by older versions of this package or other cdb tools.
+func WithFrontCoding() Option {
+	return func(o *options) {
+		o.front = true
+	}
+}
+
+// Keys sharing fewer bytes than this with the last full key are stored
+// in full and become the base for the keys after them.
+const frontMinShared = 8
+
+// A front coded key is stored as uvarint(shared) followed by the key
+// itself if shared is 0, or by uvarint(distance) and the suffix
+// otherwise. distance is the offset of the record holding the full key,
+// counted back from the record itself.
+
+// frontCode returns the stored form of key for a record at off
+func (cdb *Writer) frontCode(key []byte, off uint32) []byte {
+	shared := commonPrefix(cdb.frontKey, key)
+	if cdb.frontKey == nil || shared < frontMinShared {
+		enc := make([]byte, 0, 1+len(key))
+		enc = binary.AppendUvarint(enc, 0)
+		return append(enc, key...)
+	}
+
+	enc := make([]byte, 0, 2*binary.MaxVarintLen32+len(key)-shared)
+	enc = binary.AppendUvarint(enc, uint64(shared))
+	enc = binary.AppendUvarint(enc, uint64(off-cdb.frontOff))
+	return append(enc, key[shared:]...)
+}
+
+func commonPrefix(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// frontKey parses the stored form of a front coded key. It returns the
+// length of the shared prefix, the offset of the record holding it
+// and the suffix.
+func frontKey(off uint32, enc []byte) (int, uint32, []byte, error) {
+	shared, n := binary.Uvarint(enc)
+	if n <= 0 || shared > math.MaxInt32 {
+		return 0, 0, nil, corruptAt(ErrBadRecord, int64(off), "malformed front coded key")
+	}
+	if shared == 0 {
+		return 0, off, enc[n:], nil
+	}
+
+	dist, m := binary.Uvarint(enc[n:])
+	if m <= 0 || dist == 0 || dist > uint64(off) {
+		return 0, 0, nil, corruptAt(ErrBadRecord, int64(off), "malformed front coded key")
+	}
+	return int(shared), off - uint32(dist), enc[n+m:], nil
+}
+
+// fullKey returns the key of the record at off, which must be stored
+// in full. The data section ends at end.
+func fullKey(r io.ReaderAt, off, end uint32) ([]byte, error) {
+	klen, _, err := readTuple(r, off)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRecord(off, klen, 0, end); err != nil {
+		return nil, err
+	}
+
+	enc := make([]byte, klen)
+	_, err = r.ReadAt(enc, int64(off)+8)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, _, key, err := frontKey(off, enc)
+	if err != nil {
+		return nil, err
+	}
+	if shared != 0 {
+		return nil, corruptAt(ErrBadRecord, int64(off), "front coded key refers to another front coded key")
+	}
+	return key, nil
+}
+
+// frontMatch returns true if the stored key enc of the record at off
+// decodes to key.
+func (cdb *CDB) frontMatch(off uint32, enc, key []byte) (bool, error) {
+	shared, base, suffix, err := frontKey(off, enc)
+	if err != nil {
+		return false, err
+	}
+
+	// compare what we have before reading the prefix
+	if shared+len(suffix) != len(key) || !bytes.Equal(suffix, key[shared:]) {
+		return false, nil
+	}
+	if shared == 0 {
+		return true, nil
+	}
+
+	bk, err := fullKey(cdb.reader, base, cdb.index[0].offset)
+	if err != nil {
+		return false, err
+	}
+	return len(bk) >= shared && bytes.Equal(bk[:shared], key[:shared]), nil
+}
+
+// frontDecoder reconstructs front coded keys during sequential reads;
+// it remembers the last full key, which is the base of the keys that
+// follow it.
+type frontDecoder struct {
+	off uint32
+	key []byte
+}
+
+// decode returns the key stored as enc in the record at off, in a data
+// section ending at end. The result may alias enc.
+func (d *frontDecoder) decode(r io.ReaderAt, off, end uint32, enc []byte) ([]byte, error) {
+	shared, base, suffix, err := frontKey(off, enc)
+	if err != nil {
+		return nil, err
+	}
+
+	if shared == 0 {
+		d.off = off
+		d.key = append(d.key[:0], suffix...)
+		return suffix, nil
+	}
+
+	if d.key == nil || d.off != base {
+		bk, err := fullKey(r, base, end)
+		if err != nil {
+			return nil, err
+		}
+		d.off, d.key = base, bk
+	}
+
+	if shared > len(d.key) {
+		return nil, corruptAt(ErrBadRecord, int64(off), "front coded prefix longer than its base").want(int64(len(d.key)), int64(shared))
+	}
+
+	key := make([]byte, shared+len(suffix))
+	copy(key, d.key[:shared])
+	copy(key[shared:], suffix)
+	return key, nil
+}
+
+// readKey returns the key of the record at off, in a data section
+// ending at end.
+func readKey(r io.ReaderAt, off, end uint32, front bool) ([]byte, error) {
+	klen, _, err := readTuple(r, off)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkRecord(off, klen, 0, end); err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, klen)
+	_, err = r.ReadAt(key, int64(off)+8)
+	if err != nil || !front {
+		return key, err
+	}
+
+	var d frontDecoder
+	return d.decode(r, off, end, key)
+}
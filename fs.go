@@ -0,0 +1,90 @@
+package cdb
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFS is a filesystem in which databases can be created: an fs.FS
+// with the ability to create files. Use it to build databases on
+// in-memory filesystems, test fakes or custom storage backends.
+type WriteFS interface {
+	fs.FS
+
+	// Create creates or truncates the named file and opens it for
+	// writing. The file should also implement io.ReaderAt so that the
+	// database can be frozen or validated; if it implements io.Closer,
+	// it is closed by Writer.Close.
+	Create(name string) (io.WriteSeeker, error)
+}
+
+// CreateFS creates a database named name in fsys.
+func CreateFS(fsys WriteFS, name string, opts ...Option) (*Writer, error) {
+	f, err := fsys.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f, nil, opts...)
+	if err != nil {
+		if c, ok := f.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, err
+	}
+	return w, nil
+}
+
+// OpenFS opens the database named name in fsys. If the file doesn't
+// implement io.ReaderAt, it is read into memory.
+func OpenFS(fsys fs.FS, name string, opts ...Option) (*CDB, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var r io.ReaderAt
+	if ra, ok := f.(io.ReaderAt); ok {
+		r = ra
+	} else {
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	db, err := NewWithSize(r, st.Size(), opts...)
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return db, nil
+}
+
+// DirFS returns a WriteFS for the tree rooted at dir.
+func DirFS(dir string) WriteFS {
+	return dirFS{FS: os.DirFS(dir), dir: dir}
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func (d dirFS) Create(name string) (io.WriteSeeker, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.OpenFile(filepath.Join(d.dir, filepath.FromSlash(name)), os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0600)
+}
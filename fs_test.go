@@ -0,0 +1,103 @@
+package cdb_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"cdb"
+)
+
+// memFile is an in-memory io.WriteSeeker + io.ReaderAt
+type memFile struct {
+	name string
+	fs   *memFS
+	buf  []byte
+	off  int64
+}
+
+func (m *memFile) Write(p []byte) (int, error) {
+	if end := m.off + int64(len(p)); end > int64(len(m.buf)) {
+		m.buf = append(m.buf, make([]byte, end-int64(len(m.buf)))...)
+	}
+	n := copy(m.buf[m.off:], p)
+	m.off += int64(n)
+	return n, nil
+}
+
+func (m *memFile) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		off += m.off
+	case io.SeekEnd:
+		off += int64(len(m.buf))
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	m.off = off
+	return off, nil
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memFile) Close() error {
+	m.fs.MapFS[m.name] = &fstest.MapFile{Data: m.buf}
+	return nil
+}
+
+type memFS struct {
+	fstest.MapFS
+}
+
+func (m *memFS) Create(name string) (io.WriteSeeker, error) {
+	return &memFile{name: name, fs: m}, nil
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := &memFS{fstest.MapFS{}}
+
+	wr, err := cdb.CreateFS(fsys, "mem.cdb")
+	if err != nil {
+		t.Fatalf("Can't create mem.cdb: %s", err)
+	}
+
+	for _, r := range testRecords {
+		err = wr.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close mem.cdb: %s", err)
+	}
+
+	db, err := cdb.OpenFS(fsys, "mem.cdb")
+	if err != nil {
+		t.Fatalf("Can't open mem.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for _, r := range testRecords {
+		v, err := db.Get([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't find key %s: %s", r.key, err)
+		}
+
+		if r.val != string(v) {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, string(v))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cdb_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"cdb"
+)
+
+// FuzzOpen feeds mangled databases to the reader, which must return
+// errors rather than panic or allocate without bound.
+func FuzzOpen(f *testing.F) {
+	makeDB(f)
+	makeDelta(f, "./test/delta.cdb")
+	makeDBAt(f, "./test/fuzz-mph.cdb", testRecords, cdb.WithMPH(), cdb.WithBloom(10), cdb.WithFrontCoding())
+	for _, fn := range []string{"./test/test.cdb", "./test/delta.cdb", "./test/fuzz-mph.cdb"} {
+		buf, err := os.ReadFile(fn)
+		if err != nil {
+			f.Fatalf("Can't read %s: %s", fn, err)
+		}
+		f.Add(buf)
+	}
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		// the checksum would reject almost every input
+		db, err := cdb.NewWithSize(bytes.NewReader(buf), int64(len(buf)), cdb.WithSkipVerify())
+		if err != nil {
+			return
+		}
+
+		for _, r := range testRecords {
+			db.Get([]byte(r.key))
+			db.GetInto([]byte(r.key), make([]byte, 16))
+		}
+
+		iter := db.Iter()
+		for iter.Next() {
+		}
+
+		db.Range(func(k, v []byte) bool { return true })
+		db.RangeParallel(3, func(k, v []byte) bool { return true })
+		db.Validate()
+	})
+}
+
+func TestCorrupt(t *testing.T) {
+	makeDB(t)
+
+	buf, err := os.ReadFile("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't read test.cdb: %s", err)
+	}
+
+	// a key length pointing far past the end of the data
+	bad := bytes.Clone(buf)
+	copy(bad[2048:], []byte{0xff, 0xff, 0xff, 0x7f})
+
+	db, err := cdb.NewWithSize(bytes.NewReader(bad), int64(len(bad)), cdb.WithSkipVerify())
+	if err != nil {
+		t.Fatalf("Can't open db: %s", err)
+	}
+
+	iter := db.Iter()
+	for iter.Next() {
+	}
+	if !errors.Is(iter.Err(), cdb.ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt from the iterator, saw %v", iter.Err())
+	}
+
+	var ce *cdb.CorruptError
+	if !errors.As(iter.Err(), &ce) || !errors.Is(ce, cdb.ErrBadRecord) || ce.Offset != 2048 {
+		t.Fatalf("Expected a bad record at 2048, saw %v", iter.Err())
+	}
+
+	_, err = db.Get([]byte(testRecords[0].key))
+	if !errors.Is(err, cdb.ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt from Get, saw %v", err)
+	}
+
+	// a hash table past the end of the file
+	bad = bytes.Clone(buf)
+	copy(bad[8:], []byte{0xff, 0xff, 0xff, 0x0f})
+
+	_, err = cdb.NewWithSize(bytes.NewReader(bad), int64(len(bad)), cdb.WithSkipVerify())
+	if !errors.Is(err, cdb.ErrCorrupt) {
+		t.Fatalf("Expected ErrCorrupt opening db, saw %v", err)
+	}
+	if !errors.As(err, &ce) || !errors.Is(ce, cdb.ErrBadIndex) || ce.Offset != 8 {
+		t.Fatalf("Expected a bad index entry at 8, saw %v", err)
+	}
+}
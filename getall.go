@@ -0,0 +1,85 @@
+package cdb
+
+import (
+	"time"
+)
+
+// GetAll returns the values of all the records for key, in the order
+// they were written, as cdb_findnext in the classic C library does; use
+// it for databases that hold several records per key, such as tinydns
+// data. If the first record of key is a tombstone, the key is deleted
+// and GetAll returns nothing; later tombstones and expired records are
+// skipped. Databases built WithMPH hold one record per key.
+func (cdb *CDB) GetAll(key []byte) ([][]byte, error) {
+	var start time.Time
+	if cdb.hook != nil {
+		start = time.Now()
+	}
+
+	values, err := cdb.getAll(key)
+	if cdb.hook != nil {
+		var n int
+		for _, v := range values {
+			n += len(v)
+		}
+		cdb.hook(key, len(values) > 0, n, time.Since(start))
+	}
+	return values, err
+}
+
+func (cdb *CDB) getAll(key []byte) ([][]byte, error) {
+	if err := cdb.enter(); err != nil {
+		return nil, err
+	}
+	defer cdb.leave()
+
+	orig := key
+	key = cdb.storeKey(key)
+	p := cdb.probe(key)
+	if cdb.stats != nil {
+		defer cdb.stats.add(&p)
+	}
+
+	var values [][]byte
+	first := true
+	for {
+		off, ok, err := cdb.next(&p)
+		if err != nil || !ok {
+			return values, err
+		}
+
+		raw, err := cdb.getValueAt(off, key)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			p.collisions++
+			continue
+		}
+
+		if cdb.isTombstone(off) {
+			if first {
+				return nil, nil
+			}
+			continue
+		}
+		first = false
+
+		v, ok, err := cdb.unwrap(off, raw)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !cdb.checkFingerprint(orig, v) {
+			continue
+		}
+		if ok && cdb.valueFn != nil {
+			v, err = cdb.transform(orig, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ok {
+			values = append(values, v)
+		}
+	}
+}
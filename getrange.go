@@ -0,0 +1,108 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// GetRange returns n bytes of the value of key starting at off, or
+// fewer if the value ends first; it returns nil if the key can't be
+// found, and an empty slice if off is at or past the end of the value.
+// Only the requested bytes are read, from the database or its blob
+// file, so that a prefix of a large value costs no more than the prefix.
+//
+// Values whose keys are front coded, or that are checked or transformed
+// (see WithFingerprintCheck and WithValueTransform), are read whole and
+// the range taken from the result.
+func (cdb *CDB) GetRange(key []byte, off, n int) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("cdb: invalid range %d+%d", off, n)
+	}
+
+	if cdb.hook == nil {
+		return cdb.getRange(key, off, n)
+	}
+
+	start := time.Now()
+	v, err := cdb.getRange(key, off, n)
+	cdb.hook(key, v != nil, len(v), time.Since(start))
+	return v, err
+}
+
+func (cdb *CDB) getRange(key []byte, off, n int) ([]byte, error) {
+	if cdb.wholeValues() {
+		v, ok, err := cdb.get(key)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return clipRange(v, off, n), nil
+	}
+
+	if err := cdb.enter(); err != nil {
+		return nil, err
+	}
+	defer cdb.leave()
+
+	rec, klen, vlen, err := cdb.locate(key, nil)
+	if err != nil || rec == 0 || cdb.isTombstone(rec) {
+		return nil, err
+	}
+
+	voff := int64(rec) + 8 + int64(klen)
+	size := int64(vlen)
+	if cdb.trailer.expiry {
+		if size < expirySize {
+			return nil, corruptAt(ErrBadRecord, voff, "value too short for expiry time").want(expirySize, size)
+		}
+
+		var hdr [expirySize]byte
+		if _, err := cdb.file().ReadAt(hdr[:], voff); err != nil {
+			return nil, err
+		}
+		if expired(int64(binary.LittleEndian.Uint64(hdr[:])), time.Now()) {
+			return nil, nil
+		}
+		voff += expirySize
+		size -= expirySize
+	}
+
+	r := cdb.file()
+	if cdb.isBlob(rec) {
+		var ptr [blobPtrSize]byte
+		if size != blobPtrSize {
+			return nil, corruptAt(ErrBadRecord, int64(rec), "malformed blob pointer").want(blobPtrSize, size)
+		}
+		if _, err := cdb.file().ReadAt(ptr[:], voff); err != nil {
+			return nil, err
+		}
+		if cdb.blobs == nil {
+			return nil, fmt.Errorf("cdb: record at %d: no blob file", rec)
+		}
+
+		r = ungated(cdb.blobs)
+		voff = int64(binary.LittleEndian.Uint64(ptr[0:8]))
+		size = int64(binary.LittleEndian.Uint64(ptr[8:16]))
+		if bs := cdb.trailer.blobs.size; voff < 0 || size < 0 || voff > bs || size > bs-voff {
+			return nil, corruptAt(ErrBadRecord, int64(rec), "blob extends outside the blob file").want(bs, voff+size)
+		}
+	}
+
+	if int64(off) >= size {
+		return []byte{}, nil
+	}
+	v := make([]byte, min(int64(n), size-int64(off)))
+	if _, err := r.ReadAt(v, voff+int64(off)); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// clipRange returns the n bytes of v starting at off, or fewer if v
+// ends first
+func clipRange(v []byte, off, n int) []byte {
+	if off >= len(v) {
+		return []byte{}
+	}
+	return v[off : off+min(n, len(v)-off)]
+}
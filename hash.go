@@ -1,15 +1,172 @@
 package cdb
 
 import (
+	"encoding/binary"
+	"fmt"
+	"sync"
 
-	//"github.com/dchest/siphash"
+	"github.com/cespare/xxhash/v2"
+	"github.com/dchest/siphash"
 	"github.com/opencoff/go-lib/fasthash"
 )
 
-//var seed = []byte{0x2d, 0xe9, 0xce, 0x7b, 0x97, 0x7e, 0x79, 0xd9, 0x56, 0xc6, 0x9f, 0x68, 0x0c, 0x8f, 0x66, 0x7b}
+// HashID identifies the hash function used to build a database. It is
+// recorded in the file trailer so that Open can pick the right function
+// without the caller having to remember it.
+type HashID uint32
 
-// This is all that is needed
+const (
+	// HashCustom marks a database built with a caller supplied hash.Hash32;
+	// such a database can only be read via New() with the same hasher.
+	HashCustom HashID = iota
+
+	// HashClassic is djb's original cdb hash.
+	HashClassic
+
+	// HashFasthash is Zi Long Tan's fasthash folded to 32 bits. This is
+	// the default.
+	HashFasthash
+
+	// HashSiphash is SipHash-2-4 folded to 32 bits.
+	HashSiphash
+
+	// HashXXHash is xxhash64 folded to 32 bits.
+	HashXXHash
+)
+
+// HashUser is the first HashID available for user defined hash functions.
+// IDs below this are reserved for this package.
+const HashUser HashID = 128
+
+type hashFunc struct {
+	name string
+	fn   func(b []byte) uint32
+}
+
+var hashes = struct {
+	sync.RWMutex
+	m map[HashID]hashFunc
+}{
+	m: map[HashID]hashFunc{
+		HashClassic:  {"cdb-classic", classicHash},
+		HashFasthash: {"fasthash", Hash32},
+		HashSiphash:  {"siphash", sipHash},
+		HashXXHash:   {"xxhash", xxHash},
+	},
+}
+
+// RegisterHash makes a user defined hash function available under the
+// given id. Databases written with WithHash(id) record the id in their
+// trailer; Open uses this registry to find the function again. The id
+// must be >= HashUser and not already registered.
+func RegisterHash(id HashID, name string, fn func(b []byte) uint32) error {
+	if id < HashUser {
+		return fmt.Errorf("cdb: hash id %d is reserved", id)
+	}
+
+	hashes.Lock()
+	defer hashes.Unlock()
+
+	if h, ok := hashes.m[id]; ok {
+		return fmt.Errorf("cdb: hash id %d already registered to %s", id, h.name)
+	}
+
+	hashes.m[id] = hashFunc{name, fn}
+	return nil
+}
+
+// lookupHash returns the hash function registered under id.
+func lookupHash(id HashID) (func(b []byte) uint32, error) {
+	hashes.RLock()
+	h, ok := hashes.m[id]
+	hashes.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("cdb: unknown hash function id %d", id)
+	}
+	return h.fn, nil
+}
+
+// String returns the registered name of the hash function.
+func (id HashID) String() string {
+	hashes.RLock()
+	h, ok := hashes.m[id]
+	hashes.RUnlock()
+
+	if !ok {
+		return fmt.Sprintf("hash-%d", id)
+	}
+	return h.name
+}
+
+// DefaultSeed is the seed of the default hash function; see
+// WithHashSeed.
+const DefaultSeed uint64 = 0x2de9ce7b97d9569f
+
+// Hash32 is the default hash function: Hash64 folded to 32 bits.
 func Hash32(key []byte) uint32 {
-	h := fasthash.Hash64(0x2de9ce7b97d9569f, key)
+	return fold(Hash64(key))
+}
+
+// Hash64 is the 64-bit fasthash of key with DefaultSeed, of which
+// Hash32 is the folded form. Use it to partition keys upstream with
+// the same hash the database uses.
+func Hash64(key []byte) uint64 {
+	return fasthash.Hash64(DefaultSeed, key)
+}
+
+// SeededHash32 returns the hash function of databases built
+// WithHashSeed(seed); pass its results to PutHashed and PutBucket.
+func SeededHash32(seed uint64) func(key []byte) uint32 {
+	return func(key []byte) uint32 {
+		return fold(fasthash.Hash64(seed, key))
+	}
+}
+
+// Bucket returns the shard, among n, that ShardedWriter and
+// OpenSharded place key in; upstream partitioning that uses it agrees
+// with them by construction. Keys are bucketed as given: with
+// WithKeyTransform, bucket the normalized key.
+func Bucket(key []byte, n int) int {
+	return shardOf(key, n)
+}
+
+// fold reduces a 64-bit hash to 32 bits
+func fold(h uint64) uint32 {
 	return uint32(h - h>>32)
 }
+
+// classicHash is the hash function from the original cdb design
+func classicHash(key []byte) uint32 {
+	var h uint32 = 5381
+	for _, c := range key {
+		h = ((h << 5) + h) ^ uint32(c)
+	}
+	return h
+}
+
+// default siphash key
+var sipSeed = [16]byte{0x2d, 0xe9, 0xce, 0x7b, 0x97, 0x7e, 0x79, 0xd9, 0x56, 0xc6, 0x9f, 0x68, 0x0c, 0x8f, 0x66, 0x7b}
+
+var sipHash = keyedSipHash(sipSeed)
+
+// keyedSipHash returns a siphash function keyed with k
+func keyedSipHash(k [16]byte) func(b []byte) uint32 {
+	k0 := binary.LittleEndian.Uint64(k[:8])
+	k1 := binary.LittleEndian.Uint64(k[8:])
+	return func(key []byte) uint32 {
+		return fold(siphash.Hash(k0, k1, key))
+	}
+}
+
+// sipFingerprint identifies a siphash key without revealing it: it is
+// the siphash of a fixed string under that key.
+func sipFingerprint(k [16]byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(k[:8])
+	k1 := binary.LittleEndian.Uint64(k[8:])
+	return siphash.Hash(k0, k1, []byte("cdb siphash key fingerprint"))
+}
+
+func xxHash(key []byte) uint32 {
+	return fold(xxhash.Sum64(key))
+}
@@ -0,0 +1,70 @@
+package cdb
+
+import (
+	"fmt"
+	"slices"
+)
+
+// PutHashed is like Put, but takes the hash of key instead of computing
+// it; pipelines that already hash keys upstream (e.g. to shard them)
+// can skip hashing them twice. hash must be what the database's hash
+// function (see WithHash) returns for key (after WithKeyTransform and
+// WithKeyFingerprint, if used), or the record will not be found by Get.
+func (cdb *Writer) PutHashed(hash uint32, key, value []byte) error {
+	if err := cdb.checkSchema(key, value); err != nil {
+		return err
+	}
+
+	key = cdb.storeKey(key)
+	if cdb.trailer.expiry {
+		var never [8]byte
+		return cdb.putHashed(hash, key, never[:], value)
+	}
+	return cdb.putHashed(hash, key, nil, value)
+}
+
+// HashedRecord is a key/value pair with the hash of its key, as taken
+// by PutBucket.
+type HashedRecord struct {
+	Hash  uint32
+	Key   []byte
+	Value []byte
+}
+
+// Bucket returns the hash table a key with this hash belongs to in a
+// database with the default 256 tables; all the records passed to one
+// PutBucket call must be in the same table. See WithTables.
+func (r *HashedRecord) Bucket() uint8 {
+	return uint8(r.Hash)
+}
+
+// PutBucket adds a batch of records whose hashes all fall in the same
+// hash table, in order. Builders that bucket their input ahead of time
+// (for example by sorting it on the low byte of the hash) can use it to
+// grow each table once per batch instead of once per record. As with
+// PutHashed, the hashes must match the database's hash function.
+func (cdb *Writer) PutBucket(recs []HashedRecord) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	n := len(cdb.entries)
+	b := tableFor(recs[0].Hash, n)
+	for i := range recs {
+		if t := tableFor(recs[i].Hash, n); t != b {
+			return fmt.Errorf("cdb: PutBucket: record %d is in bucket %d, not %d", i, t, b)
+		}
+	}
+
+	if !cdb.mph {
+		cdb.entries[b] = slices.Grow(cdb.entries[b], len(recs))
+	}
+
+	for i := range recs {
+		r := &recs[i]
+		if err := cdb.PutHashed(r.Hash, r.Key, r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
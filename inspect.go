@@ -0,0 +1,216 @@
+package cdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Layout describes how a database is laid out in its file, for tools
+// that show it (e.g. cdb inspect); see CDB.Layout.
+type Layout struct {
+	// trailer version; 0 if the database has no trailer
+	Version uint32
+
+	Hash     HashID
+	Checksum Checksum
+	Features Feature
+
+	// number of records, including tombstones; -1 if unknown
+	Records    int64
+	Tombstones int
+
+	// alignment of the values and number of filler records; see
+	// WithAlignment
+	Alignment uint32
+	Fillers   int
+
+	// size of the Bloom filter in bits; 0 if there is none
+	BloomBits uint64
+
+	// the data section is [DataStart, DataEnd)
+	DataStart uint32
+	DataEnd   uint32
+
+	// the hash tables, and the MPH slot table of a database built
+	// WithMPH
+	Tables []TableLayout
+	MPH    *TableLayout
+
+	// offset of the trailer, or the end of the database if there is
+	// none; the checksum follows it
+	TrailerStart int64
+	Size         int64
+}
+
+// TableLayout describes a hash table.
+type TableLayout struct {
+	Offset uint32
+	Slots  uint32
+
+	// slots in use
+	Used uint32
+
+	// the longest distance, in slots, from where a probe starts to the
+	// record it finds
+	MaxProbe uint32
+}
+
+// Layout describes the layout of the database. It reads all the hash
+// tables, once, to find how full they are.
+func (cdb *CDB) Layout() (*Layout, error) {
+	t := &cdb.trailer
+	l := &Layout{
+		Version:      t.version,
+		Hash:         t.hash,
+		Checksum:     t.checksum,
+		Features:     t.features(),
+		Records:      t.count,
+		Tombstones:   len(t.tombstones),
+		Alignment:    t.alignment,
+		Fillers:      len(t.padding),
+		DataStart:    cdb.dataStart(),
+		DataEnd:      cdb.index[0].offset,
+		TrailerStart: cdb.size,
+		Size:         cdb.size,
+	}
+	if t.bloom != nil {
+		l.BloomBits = t.bloom.m
+	}
+	if t.version > 0 {
+		l.TrailerStart = t.start
+	}
+
+	br := bufio.NewReaderSize(io.NewSectionReader(cdb.reader, int64(l.DataEnd), int64(cdb.tableBytes())), scanBufSize)
+
+	n := len(cdb.index)
+	l.Tables = make([]TableLayout, n)
+	for i, tab := range cdb.index {
+		tl, err := readTableLayout(br, tab, func(hash uint32) uint32 {
+			return probeStart(hash, n, tab.length)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cdb: table %d: %w", i, err)
+		}
+		l.Tables[i] = tl
+	}
+
+	// MPH slots hold their records directly
+	if x := t.mph; x != nil {
+		tl, err := readTableLayout(br, table{offset: x.off, length: x.m}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cdb: mph table: %w", err)
+		}
+		l.MPH = &tl
+	}
+	return l, nil
+}
+
+// readTableLayout reads the slots of tab from br; start returns the
+// slot where the probe for a hash starts, if probes apply
+func readTableLayout(br *bufio.Reader, tab table, start func(hash uint32) uint32) (TableLayout, error) {
+	tl := TableLayout{Offset: tab.offset, Slots: tab.length}
+
+	var slot [8]byte
+	for s := uint32(0); s < tab.length; s++ {
+		if _, err := io.ReadFull(br, slot[:]); err != nil {
+			return tl, err
+		}
+		if binary.LittleEndian.Uint32(slot[4:]) == 0 {
+			continue
+		}
+
+		tl.Used++
+		if start != nil {
+			d := (s + tab.length - start(binary.LittleEndian.Uint32(slot[:]))) % tab.length
+			tl.MaxProbe = max(tl.MaxProbe, d)
+		}
+	}
+	return tl, nil
+}
+
+// KeyProbe describes where a lookup for a key goes; see CDB.Probe.
+type KeyProbe struct {
+	// the key as stored, after any key transform or fingerprint
+	Stored []byte
+	Hash   uint32
+
+	// the hash table and the slot where the probe starts; for a
+	// database built WithMPH, Table is -1 and Slot is the MPH slot
+	Table int
+	Slot  uint32
+
+	// false if the Bloom filter or the MPH rule the key out, or its
+	// table is empty
+	Maybe bool
+}
+
+// Probe returns where a lookup for key goes, without reading anything.
+func (cdb *CDB) Probe(key []byte) KeyProbe {
+	stored := cdb.storeKey(key)
+	hash := cdb.hasher(stored)
+	kp := KeyProbe{
+		Stored: stored,
+		Hash:   hash,
+		Table:  int(tableFor(hash, len(cdb.index))),
+	}
+
+	if x := cdb.trailer.mph; x != nil {
+		kp.Table = -1
+		kp.Slot, kp.Maybe = x.slot(mphHash(stored))
+		return kp
+	}
+
+	n := cdb.index[kp.Table].length
+	if n > 0 {
+		kp.Slot = probeStart(hash, len(cdb.index), n)
+	}
+	kp.Maybe = n > 0 && (cdb.trailer.bloom == nil || cdb.trailer.bloom.has(hash))
+	return kp
+}
+
+// RawRecord is a record as stored in the data section.
+type RawRecord struct {
+	Offset uint32
+
+	// the whole record: the key and value lengths, the key and the
+	// value, with any expiry time or blob pointer
+	Bytes []byte
+
+	KeyLen   uint32
+	ValueLen uint32
+
+	Tombstone bool
+	Blob      bool
+
+	// the record pads the next value; see WithAlignment
+	Filler bool
+}
+
+// RawRecord returns the record at off, which must be the offset of a
+// record (see RecordOffset); any other offset in the data section
+// returns whatever the bytes there parse as, or an error.
+func (cdb *CDB) RawRecord(off uint32) (*RawRecord, error) {
+	klen, vlen, err := readTuple(cdb.reader, off)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRecord(off, klen, vlen, cdb.index[0].offset); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 8+int(klen)+int(vlen))
+	if _, err := cdb.reader.ReadAt(b, int64(off)); err != nil {
+		return nil, err
+	}
+	return &RawRecord{
+		Offset:    off,
+		Bytes:     b,
+		KeyLen:    klen,
+		ValueLen:  vlen,
+		Tombstone: cdb.isTombstone(off),
+		Blob:      cdb.isBlob(off),
+		Filler:    cdb.isPadding(off),
+	}, nil
+}
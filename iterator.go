@@ -1,29 +1,75 @@
 package cdb
 
+import (
+	"time"
+)
+
 // Iterator represents a sequential iterator over a CDB database.
 type Iterator struct {
 	db     *CDB
+	cur    uint32
 	pos    uint32
 	endPos uint32
 	err    error
 	key    []byte
 	value  []byte
+
+	// if set, tombstones and expired records are returned rather
+	// than skipped
+	raw     bool
+	deleted bool
+	expires int64
+
+	// decodes front coded keys
+	front frontDecoder
 }
 
 // Iter creates an Iterator that can be used to iterate the database.
+// Tombstones, expired records and records shadowed by an earlier record
+// for the same key are skipped, so Iter yields exactly what Get returns.
 func (cdb *CDB) Iter() *Iterator {
 	return &Iterator{
 		db:     cdb,
-		pos:    uint32(indexSize),
+		pos:    cdb.dataStart(),
 		endPos: cdb.index[0].offset,
 	}
 }
 
+// rawIter returns an iterator that includes tombstones, expired and
+// shadowed records
+func (cdb *CDB) rawIter() *Iterator {
+	iter := cdb.Iter()
+	iter.raw = true
+	return iter
+}
+
 // Next reads the next key/value pair and advances the iterator one record.
 // It returns false when the scan stops, either by reaching the end of the
 // database or an error. After Next returns false, the Err method will return
 // any error that occurred while iterating.
 func (iter *Iterator) Next() bool {
+	now := time.Now()
+	for iter.next() {
+		iter.deleted = iter.db.isTombstone(iter.cur)
+		if iter.raw {
+			return true
+		}
+		if iter.deleted || expired(iter.expires, now) {
+			continue
+		}
+		dup, err := iter.shadowed()
+		if err != nil {
+			iter.err = err
+			return false
+		}
+		if !dup {
+			return true
+		}
+	}
+	return false
+}
+
+func (iter *Iterator) next() bool {
 	if iter.pos >= iter.endPos {
 		return false
 	}
@@ -34,6 +80,17 @@ func (iter *Iterator) Next() bool {
 		return false
 	}
 
+	err = checkRecord(iter.pos, keyLength, valueLength, iter.endPos)
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	if iter.db.isPadding(iter.pos) {
+		iter.pos += 8 + keyLength + valueLength
+		return iter.next()
+	}
+
 	buf := make([]byte, keyLength+valueLength)
 	_, err = iter.db.reader.ReadAt(buf, int64(iter.pos+8))
 	if err != nil {
@@ -41,9 +98,26 @@ func (iter *Iterator) Next() bool {
 		return false
 	}
 
+	exp, value, err := iter.db.splitExpiry(iter.pos, buf[keyLength:])
+	if err != nil {
+		iter.err = err
+		return false
+	}
+
+	key := buf[:keyLength]
+	if iter.db.trailer.front {
+		key, err = iter.front.decode(iter.db.reader, iter.pos, iter.endPos, key)
+		if err != nil {
+			iter.err = err
+			return false
+		}
+	}
+
 	// Update iterator state
-	iter.key = buf[:keyLength]
-	iter.value = buf[keyLength:]
+	iter.cur = iter.pos
+	iter.key = key
+	iter.value = value
+	iter.expires = exp
 	iter.pos += 8 + keyLength + valueLength
 
 	return true
@@ -59,7 +133,26 @@ func (iter *Iterator) Value() []byte {
 	return iter.value
 }
 
+// Expires returns the expiry time of the current record, or the zero
+// time if it never expires. See WithExpiry.
+func (iter *Iterator) Expires() time.Time {
+	if iter.expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(iter.expires, 0)
+}
+
 // Err returns the current error.
 func (iter *Iterator) Err() error {
 	return iter.err
 }
+
+// shadowed returns true if the current record's key occurs earlier in
+// the database; Get never returns such a record.
+func (iter *Iterator) shadowed() (bool, error) {
+	off, _, err := iter.db.findStored(iter.key)
+	if err != nil {
+		return false, err
+	}
+	return off != iter.cur, nil
+}
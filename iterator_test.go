@@ -1,8 +1,16 @@
 package cdb_test
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
 	"testing"
-	//	"cdb"
+
+	"cdb"
 )
 
 func TestIterator(t *testing.T) {
@@ -13,3 +21,307 @@ func BenchmarkIterator(b *testing.B) {
 
 func ExampleIterator() {
 }
+
+func TestRange(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	var i int
+	err = db.Range(func(k, v []byte) bool {
+		r := testRecords[i]
+		if r.key != string(k) || r.val != string(v) {
+			t.Fatalf("Record %d mismatch: exp %s=%s, saw %s=%s", i, r.key, r.val, k, v)
+		}
+		i++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Range failed: %s", err)
+	}
+
+	if i != len(testRecords) {
+		t.Fatalf("Range saw %d records; exp %d", i, len(testRecords))
+	}
+
+	// early termination
+	i = 0
+	err = db.Range(func(k, v []byte) bool {
+		i++
+		return false
+	})
+	if err != nil || i != 1 {
+		t.Fatalf("Range didn't stop: %d records, %v", i, err)
+	}
+}
+
+func TestRangeParallel(t *testing.T) {
+	wr, err := cdb.Create("./test/par.cdb")
+	if err != nil {
+		t.Fatalf("Can't create par.cdb: %s", err)
+	}
+
+	const N = 10000
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		err = wr.Put([]byte(k), []byte(strings.Repeat("v", i%50)))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", k, err)
+		}
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze par.cdb: %s", err)
+	}
+	defer db.Close()
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	err = db.RangeParallel(7, func(k, v []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[string(k)] {
+			t.Errorf("Key %s seen twice", k)
+		}
+		seen[string(k)] = true
+		return true
+	})
+	if err != nil {
+		t.Fatalf("RangeParallel failed: %s", err)
+	}
+
+	if len(seen) != N {
+		t.Fatalf("RangeParallel saw %d records; exp %d", len(seen), N)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	wr, err := cdb.Create("./test/tomap.cdb")
+	if err != nil {
+		t.Fatalf("Can't create tomap.cdb: %s", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if i%10 == 0 {
+			if err := wr.Delete([]byte(k)); err != nil {
+				t.Fatalf("Delete %s failed: %s", k, err)
+			}
+		}
+		if err := wr.Put([]byte(k), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Put %s failed: %s", k, err)
+		}
+	}
+	// the first record for a key wins
+	if err := wr.Put([]byte("key-1"), []byte("dup")); err != nil {
+		t.Fatalf("Put key-1 failed: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	db, err := cdb.Open("./test/tomap.cdb")
+	if err != nil {
+		t.Fatalf("Can't open tomap.cdb: %s", err)
+	}
+	defer db.Close()
+
+	m, err := db.ToMap()
+	if err != nil {
+		t.Fatalf("ToMap failed: %s", err)
+	}
+	if len(m) != 90 {
+		t.Fatalf("ToMap: exp 90 keys, saw %d", len(m))
+	}
+	for k, v := range m {
+		got, err := db.Get([]byte(k))
+		if err != nil || string(got) != string(v) {
+			t.Fatalf("%s: map has %s, Get returns %s, %v", k, v, got, err)
+		}
+	}
+	if _, ok := m["key-10"]; ok {
+		t.Fatalf("ToMap returned deleted key")
+	}
+
+	tm, err := cdb.ToTypedMap(db, func(k, v []byte) (string, int, error) {
+		var n int
+		_, err := fmt.Sscanf(string(v), "value-%d", &n)
+		return string(k), n, err
+	})
+	if err == nil {
+		t.Fatalf("ToTypedMap didn't fail on a bad value")
+	}
+
+	tm, err = cdb.ToTypedMap(db, func(k, v []byte) (string, int, error) {
+		return string(k), len(v), nil
+	})
+	if err != nil || len(tm) != 90 || tm["key-1"] != len("value-1") {
+		t.Fatalf("ToTypedMap: %d keys, key-1=%d, %v", len(tm), tm["key-1"], err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithExpiry()}, {cdb.WithFrontCoding()}} {
+		wr, err := cdb.Create("./test/keys.cdb", opts...)
+		if err != nil {
+			t.Fatalf("Can't create keys.cdb: %s", err)
+		}
+
+		for _, r := range testRecords {
+			if err := wr.Put([]byte(r.key), []byte(strings.Repeat(r.val, 1000))); err != nil {
+				t.Fatalf("Put %s failed: %s", r.key, err)
+			}
+		}
+		if err := wr.Delete([]byte("gone")); err != nil {
+			t.Fatalf("Delete failed: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		db, err := cdb.Open("./test/keys.cdb")
+		if err != nil {
+			t.Fatalf("Can't open keys.cdb: %s", err)
+		}
+
+		var keys []string
+		it := db.Keys()
+		for it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Keys failed: %s", err)
+		}
+
+		var sb strings.Builder
+		if err := db.KeysTo(&sb, '\n'); err != nil {
+			t.Fatalf("KeysTo failed: %s", err)
+		}
+		db.Close()
+
+		var exp []string
+		for _, r := range testRecords {
+			exp = append(exp, r.key)
+		}
+		if strings.Join(keys, ",") != strings.Join(exp, ",") {
+			t.Fatalf("Keys: exp %v, saw %v", exp, keys)
+		}
+		if sb.String() != strings.Join(exp, "\n")+"\n" {
+			t.Fatalf("KeysTo: exp %q, saw %q", strings.Join(exp, "\n")+"\n", sb.String())
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithMPH()}} {
+		wr, err := cdb.Create("./test/sample.cdb", opts...)
+		if err != nil {
+			t.Fatalf("Can't create sample.cdb: %s", err)
+		}
+		for i := 0; i < 1000; i++ {
+			k, v := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+			if err := wr.Put([]byte(k), []byte(v)); err != nil {
+				t.Fatalf("Put %s failed: %s", k, err)
+			}
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		db, err := cdb.Open("./test/sample.cdb")
+		if err != nil {
+			t.Fatalf("Can't open sample.cdb: %s", err)
+		}
+
+		rng := rand.New(rand.NewPCG(1, 2))
+		recs, err := db.Sample(50, rng)
+		if err != nil || len(recs) != 50 {
+			t.Fatalf("Sample: %d records, %v", len(recs), err)
+		}
+
+		seen := make(map[string]bool)
+		for _, r := range recs {
+			if seen[string(r.Key)] {
+				t.Fatalf("Sample returned %s twice", r.Key)
+			}
+			seen[string(r.Key)] = true
+
+			v, err := db.Get(r.Key)
+			if err != nil || string(v) != string(r.Value) {
+				t.Fatalf("Sampled %s=%s, Get returns %s, %v", r.Key, r.Value, v, err)
+			}
+		}
+
+		recs, err = db.Sample(5000, rng)
+		if err != nil || len(recs) != 1000 {
+			t.Fatalf("Sample of all: %d records, %v", len(recs), err)
+		}
+		db.Close()
+	}
+}
+
+func TestAll(t *testing.T) {
+	makeDB(t)
+
+	db, err := cdb.Open("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't open test.cdb: %s", err)
+	}
+	defer db.Close()
+
+	var i int
+	for k, v := range db.All() {
+		r := testRecords[i]
+		if r.key != string(k) || r.val != string(v) {
+			t.Fatalf("Record %d mismatch: exp %s=%s, saw %s=%s", i, r.key, r.val, k, v)
+		}
+		i++
+	}
+	if err := db.IterErr(); err != nil || i != len(testRecords) {
+		t.Fatalf("All: saw %d records, %v", i, err)
+	}
+
+	for range db.All() {
+		i++
+		break
+	}
+	if i != len(testRecords)+1 {
+		t.Fatalf("All: break didn't stop the loop")
+	}
+
+	var keys []string
+	for k := range db.Match([]byte("ab")) {
+		keys = append(keys, string(k))
+	}
+	if len(keys) != 1 || keys[0] != "abc" {
+		t.Fatalf("Match: exp [abc], saw %q", keys)
+	}
+
+	// a record whose key length runs past the data section
+	b, err := os.ReadFile("./test/test.cdb")
+	if err != nil {
+		t.Fatalf("Can't read test.cdb: %s", err)
+	}
+	binary.LittleEndian.PutUint32(b[2048+8+len("hello")+len("world"):], 1<<30)
+	if err := os.WriteFile("./test/bad.cdb", b, 0644); err != nil {
+		t.Fatalf("Can't write bad.cdb: %s", err)
+	}
+	bad, err := cdb.Open("./test/bad.cdb", cdb.WithSkipVerify())
+	if err != nil {
+		t.Fatalf("Can't open bad.cdb: %s", err)
+	}
+	defer bad.Close()
+
+	i = 0
+	for range bad.All() {
+		i++
+	}
+	if err := bad.IterErr(); !errors.Is(err, cdb.ErrCorrupt) || i != 1 {
+		t.Fatalf("All over a corrupt record: saw %d records, %v", i, err)
+	}
+}
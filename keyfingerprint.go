@@ -0,0 +1,103 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/opencoff/go-lib/fasthash"
+)
+
+// seeds of the key fingerprint halves
+const (
+	keyFPSeed0 = 0x9ae16a3b2f90404f
+	keyFPSeed1 = 0xc949d7c7509e6557
+)
+
+// WithKeyFingerprint makes the writer store a fingerprint of bits bits
+// (64 or 128) of every key instead of the key itself, which shrinks
+// databases with long keys such as URLs to a fixed 8 or 16 bytes per
+// key. The database records the fingerprint size, and readers
+// fingerprint the keys they look up the same way.
+//
+// The original keys are gone: iterators, Range, dumps and the like
+// return the fingerprints, and a lookup can find the record of a
+// different key with the same fingerprint. The chance of that is about
+// n/2^bits for a missing key in a database of n keys; use 128 bits for
+// large databases, or WithFingerprintCheck to confirm matches against
+// the value. Rewrite, Merge and the other functions that copy databases
+// copy the fingerprints as they are, and take the keys they are given
+// to be fingerprints: keys added by Rewrite's apply must be passed
+// through KeyFingerprint first.
+func WithKeyFingerprint(bits int) Option {
+	return func(o *options) {
+		o.keyFPBits = bits
+	}
+}
+
+// withStoredFingerprints makes the writer take keys that are already
+// fingerprints of size bytes, as copied from a database built
+// WithKeyFingerprint, and store them as they are
+func withStoredFingerprints(size int) Option {
+	return func(o *options) {
+		o.keyFPBits = 8 * size
+		o.keyFPStored = true
+	}
+}
+
+// WithFingerprintCheck makes the reader of a database built
+// WithKeyFingerprint call fn with the key looked up and the value found
+// by Get, Lookup, GetInto, GetAll and GetRange; a record whose value
+// fn rejects is taken to belong to another key with the same
+// fingerprint, and is not returned. fn sees the value before any value
+// transform; it must be safe for concurrent use.
+func WithFingerprintCheck(fn func(key, value []byte) bool) Option {
+	return func(o *options) {
+		o.keyFPCheck = fn
+	}
+}
+
+// KeyFingerprint returns the fingerprint of bits bits (64 or 128) that
+// a database built WithKeyFingerprint(bits) stores for key, after any
+// key transform. Use it to compute the hash for PutHashed.
+func KeyFingerprint(key []byte, bits int) []byte {
+	fp := binary.LittleEndian.AppendUint64(make([]byte, 0, 16), fasthash.Hash64(keyFPSeed0, key))
+	if bits == 128 {
+		fp = binary.LittleEndian.AppendUint64(fp, fasthash.Hash64(keyFPSeed1, key))
+	}
+	return fp
+}
+
+// checkKeyFingerprint validates the key fingerprint options
+func (o *options) checkKeyFingerprint() error {
+	switch o.keyFPBits {
+	case 0, 64, 128:
+		return nil
+	}
+	return fmt.Errorf("cdb: %d bit key fingerprints; want 64 or 128", o.keyFPBits)
+}
+
+// storeKey returns the key the writer stores for key
+func (cdb *Writer) storeKey(key []byte) []byte {
+	switch {
+	case cdb.keyFPStored:
+		return key
+	case cdb.trailer.keyFP != 0:
+		return KeyFingerprint(cdb.normKey(key), 8*int(cdb.trailer.keyFP))
+	}
+	return cdb.normKey(key)
+}
+
+// storeKey returns the key stored for key in the database
+func (cdb *CDB) storeKey(key []byte) []byte {
+	key = cdb.normKey(key)
+	if n := cdb.trailer.keyFP; n != 0 {
+		return KeyFingerprint(key, 8*int(n))
+	}
+	return key
+}
+
+// checkFingerprint returns false if the fingerprint check rejects the
+// value found for key
+func (cdb *CDB) checkFingerprint(key, value []byte) bool {
+	return cdb.keyFPCheck == nil || cdb.keyFPCheck(key, value)
+}
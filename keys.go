@@ -0,0 +1,137 @@
+package cdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// KeyIterator iterates the keys of a database without reading the
+// values.
+type KeyIterator struct {
+	db  *CDB
+	pos uint32
+	end uint32
+	now time.Time
+	key []byte
+	buf []byte
+	err error
+
+	// decodes front coded keys
+	front frontDecoder
+}
+
+// Keys returns an iterator over the keys of the live records of the
+// database, in file order. Only the record headers and keys are read
+// (and the expiry times, for databases built WithExpiry); the values
+// are skipped over, which makes it much faster than Iter for auditing
+// the key space of a database with large values. A key written more
+// than once is returned once, and a deleted key not at all, as by Iter.
+func (cdb *CDB) Keys() *KeyIterator {
+	return &KeyIterator{
+		db:  cdb,
+		pos: cdb.dataStart(),
+		end: cdb.index[0].offset,
+		now: time.Now(),
+	}
+}
+
+// Next advances the iterator to the next key. It returns false when
+// there are no more keys or on error; see Err.
+func (it *KeyIterator) Next() bool {
+	for it.err == nil && it.pos < it.end {
+		off := it.pos
+		klen, vlen, err := readTuple(it.db.reader, off)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		if err := checkRecord(off, klen, vlen, it.end); err != nil {
+			it.err = err
+			return false
+		}
+		it.pos += 8 + klen + vlen
+
+		if it.db.isTombstone(off) || it.db.isPadding(off) {
+			continue
+		}
+
+		// the expiry time is the first 8 bytes of the value
+		n := int(klen)
+		if it.db.trailer.expiry {
+			if vlen < expirySize {
+				it.err = corruptAt(ErrBadRecord, int64(off), "value too short for expiry time").want(expirySize, int64(vlen))
+				return false
+			}
+			n += expirySize
+		}
+
+		if n > cap(it.buf) {
+			it.buf = make([]byte, n)
+		}
+		buf := it.buf[:n]
+		if _, err := it.db.reader.ReadAt(buf, int64(off)+8); err != nil {
+			it.err = err
+			return false
+		}
+
+		if it.db.trailer.expiry {
+			exp := int64(binary.LittleEndian.Uint64(buf[klen:]))
+			if expired(exp, it.now) {
+				continue
+			}
+		}
+
+		key := buf[:klen]
+		if it.db.trailer.front {
+			key, err = it.front.decode(it.db.reader, off, it.end, key)
+			if err != nil {
+				it.err = err
+				return false
+			}
+		}
+
+		// skip records shadowed by an earlier one for the key
+		first, _, err := it.db.findStored(key)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if first != off {
+			continue
+		}
+		it.key = key
+		return true
+	}
+	return false
+}
+
+// Key returns the current key. It is only valid until the next call to
+// Next; copy it to retain it.
+func (it *KeyIterator) Key() []byte {
+	return it.key
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+// KeysTo writes the keys of the live records of the database to w, each
+// followed by sep; see Keys.
+func (cdb *CDB) KeysTo(w io.Writer, sep byte) error {
+	bw := bufio.NewWriterSize(w, 65536)
+	it := cdb.Keys()
+	for it.Next() {
+		bw.Write(it.Key())
+		if err := bw.WriteByte(sep); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,72 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithKeyTransform normalizes keys with fn (e.g. bytes.ToLower or
+// bytes.TrimSpace) before they are stored by the writer and before they
+// are looked up by the reader, so that keys differing only in case,
+// whitespace, Unicode normalization and the like find the same record.
+// Keys returned by iterators are the normalized keys. fn must be
+// idempotent, fn(fn(k)) == fn(k), as normalizations are; it must not
+// modify its argument.
+//
+// The writer records name in the trailer. A reader must be given the
+// same name, and refuses to open the database otherwise: a reader that
+// doesn't normalize its keys, or normalizes them differently, would
+// silently miss records. Older readers refuse such databases too.
+func WithKeyTransform(name string, fn func([]byte) []byte) Option {
+	return func(o *options) {
+		o.keyName = name
+		o.keyFn = fn
+	}
+}
+
+// normKey returns key normalized by the writer's key transform
+func (cdb *Writer) normKey(key []byte) []byte {
+	if cdb.keyFn == nil {
+		return key
+	}
+	return cdb.keyFn(key)
+}
+
+// normKey returns key normalized by the reader's key transform
+func (cdb *CDB) normKey(key []byte) []byte {
+	if cdb.keyFn == nil {
+		return key
+	}
+	return cdb.keyFn(key)
+}
+
+// checkKeyTransform validates the key transform options
+func (o *options) checkKeyTransform() error {
+	if (o.keyFn == nil) != (o.keyName == "") {
+		return errors.New("cdb: WithKeyTransform needs a name and a function")
+	}
+	return nil
+}
+
+// initKeyTransform sets up the key transform of a reader; t is the
+// trailer, or nil if the database has none.
+func (cdb *CDB) initKeyTransform(t *trailer, o *options) error {
+	if err := o.checkKeyTransform(); err != nil {
+		return err
+	}
+
+	// without a trailer, the caller knows best
+	if t != nil && t.keyTransform != o.keyName {
+		switch {
+		case o.keyName == "":
+			return fmt.Errorf("cdb: database keys are transformed with %q; open it WithKeyTransform()", t.keyTransform)
+		case t.keyTransform == "":
+			return fmt.Errorf("cdb: database keys are not transformed, but %q was given", o.keyName)
+		}
+		return fmt.Errorf("cdb: database keys are transformed with %q, not %q", t.keyTransform, o.keyName)
+	}
+
+	cdb.keyFn = o.keyFn
+	cdb.trailer.keyTransform = o.keyName
+	return nil
+}
@@ -0,0 +1,60 @@
+package cdb
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// LevelTrace is the level of the most detailed log records: the stages
+// of finalizing a database. The rest are logged at slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
+// lookups slower than this are logged unless WithSlowLookup says
+// otherwise
+const defaultSlowLookup = 10 * time.Millisecond
+
+// WithLogger makes the reader and the writer log to l: opening a
+// database and verifying its checksum, finalizing one (each stage at
+// LevelTrace), and lookups slower than the WithSlowLookup threshold.
+// Nothing is logged above slog.LevelDebug; errors are returned, not
+// logged.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithSlowLookup sets how long a lookup made with Get, Lookup, GetInto,
+// GetAll or GetRange must take to be logged by a reader WithLogger;
+// the default is 10ms.
+func WithSlowLookup(d time.Duration) Option {
+	return func(o *options) {
+		o.slowLookup = d
+	}
+}
+
+// slowLookupHook returns an access hook that logs the lookups that
+// take slow or longer to l, and then calls next if it is not nil.
+func slowLookupHook(l *slog.Logger, slow time.Duration, next AccessHook) AccessHook {
+	if slow <= 0 {
+		slow = defaultSlowLookup
+	}
+
+	return func(key []byte, found bool, n int, dur time.Duration) {
+		if dur >= slow {
+			l.Debug("cdb: slow lookup", "key", strconv.Quote(string(key)), "found", found, "bytes", n, "duration", dur)
+		}
+		if next != nil {
+			next(key, found, n, dur)
+		}
+	}
+}
+
+// trace logs a stage of finalizing the database at LevelTrace
+func (cdb *Writer) trace(msg string, args ...any) {
+	if cdb.log != nil {
+		cdb.log.Log(context.Background(), LevelTrace, msg, args...)
+	}
+}
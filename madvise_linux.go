@@ -0,0 +1,28 @@
+package cdb
+
+import (
+	"syscall"
+)
+
+var adviceFlags = [...]int{
+	AdviceNormal:     syscall.MADV_NORMAL,
+	AdviceRandom:     syscall.MADV_RANDOM,
+	AdviceSequential: syscall.MADV_SEQUENTIAL,
+	AdviceWillNeed:   syscall.MADV_WILLNEED,
+}
+
+// madvise passes advice for the mapping b to madvise(2)
+func madvise(b []byte, advice Advice) error {
+	if advice == AdviceNormal {
+		return nil
+	}
+	if advice < 0 || int(advice) >= len(adviceFlags) {
+		return syscall.EINVAL
+	}
+	return syscall.Madvise(b, adviceFlags[advice])
+}
+
+// mlock locks the mapping b into memory
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
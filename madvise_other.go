@@ -0,0 +1,18 @@
+//go:build unix && !linux
+
+package cdb
+
+import (
+	"errors"
+)
+
+// madvise is a no-op where madvise(2) isn't available; the kernel's
+// default read-ahead applies.
+func madvise(b []byte, advice Advice) error {
+	return nil
+}
+
+// mlock isn't supported here
+func mlock(b []byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,33 @@
+package cdb
+
+import (
+	"encoding"
+	"fmt"
+)
+
+// PutMarshaler is like Put, but stores the value v marshals itself to.
+// Types that implement encoding.BinaryMarshaler, such as time.Time or
+// types that wrap gob or protobuf encoding, can be stored without
+// marshaling them at every call site.
+func (cdb *Writer) PutMarshaler(key []byte, v encoding.BinaryMarshaler) error {
+	value, err := v.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("cdb: marshal value of %q: %w", key, err)
+	}
+	return cdb.Put(key, value)
+}
+
+// GetUnmarshaler looks up key and unmarshals its value into v, which
+// is left alone if the key can't be found. It returns whether the key
+// was found.
+func (cdb *CDB) GetUnmarshaler(key []byte, v encoding.BinaryUnmarshaler) (bool, error) {
+	value, ok, err := cdb.Lookup(key)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := v.UnmarshalBinary(value); err != nil {
+		return true, fmt.Errorf("cdb: unmarshal value of %q: %w", key, err)
+	}
+	return true, nil
+}
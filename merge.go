@@ -0,0 +1,115 @@
+package cdb
+
+import (
+	"fmt"
+)
+
+// Merge writes a new database at path holding the union of dbs. Later
+// databases take precedence over earlier ones: a key present in more
+// than one database gets the value from the last one, and a tombstone
+// (see Writer.Delete) deletes the key from all the databases before it.
+// Tombstones are not copied to the output.
+//
+// If any of dbs stores expiry times, so does the output; records that
+// expired before the time given by ExpireFilter are dropped, as are
+// those dropped by Filter. Databases built WithKeyFingerprint can only
+// be merged with each other, and the output stores the same
+// fingerprints.
+//
+// Each database is scanned once and every key is probed in the
+// databases after it; nothing but the new hash tables is held in
+// memory. On error, the partially written database is removed.
+func Merge(path string, dbs []*CDB, opts ...Option) error {
+	// don't append to the caller's slice
+	opts = opts[:len(opts):len(opts)]
+
+	for _, db := range dbs {
+		if db.trailer.keyFP != dbs[0].trailer.keyFP {
+			return fmt.Errorf("cdb: can't merge databases with different key fingerprints")
+		}
+	}
+	if len(dbs) > 0 && dbs[0].trailer.keyFP != 0 {
+		opts = append(opts, withStoredFingerprints(int(dbs[0].trailer.keyFP)))
+	}
+
+	for _, db := range dbs {
+		if db.trailer.expiry {
+			opts = append(opts, WithExpiry())
+			break
+		}
+	}
+
+	o := makeOptions(opts)
+	wr, err := Create(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = merge(wr, dbs, o)
+	if err == nil {
+		err = wr.Close()
+	}
+
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+	return nil
+}
+
+func merge(wr *Writer, dbs []*CDB, o *options) error {
+	for i, db := range dbs {
+		iter := db.rawIter()
+		for iter.Next() {
+			if dup, err := iter.shadowed(); err != nil {
+				return err
+			} else if dup || iter.deleted {
+				continue
+			}
+
+			newer, err := hasKey(dbs[i+1:], iter.key)
+			if err != nil {
+				return err
+			}
+			if newer {
+				continue
+			}
+
+			if !o.expireBefore.IsZero() && expired(iter.expires, o.expireBefore) {
+				continue
+			}
+			if !o.keep(iter.key, iter.value) {
+				continue
+			}
+
+			if wr.trailer.expiry {
+				err = wr.PutTTL(iter.key, iter.value, iter.Expires())
+			} else {
+				err = wr.Put(iter.key, iter.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasKey returns true if any of dbs has a record (or tombstone) for key,
+// as stored in the databases
+func hasKey(dbs []*CDB, key []byte) (bool, error) {
+	for _, db := range dbs {
+		_, v, err := db.findStored(key)
+		if err != nil {
+			return false, err
+		}
+		if v != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,26 @@
+package cdb
+
+// SetMetadata stores m in the trailer of the database, replacing any
+// metadata set earlier. Use it for things like the build time, the
+// version of the source data or a schema id. The metadata is copied; it
+// is written out when the database is finalized.
+func (cdb *Writer) SetMetadata(m map[string][]byte) {
+	cdb.trailer.meta = copyMeta(m)
+}
+
+// Metadata returns a copy of the metadata stored in the database by
+// Writer.SetMetadata; it returns nil if there is none.
+func (cdb *CDB) Metadata() map[string][]byte {
+	if len(cdb.trailer.meta) == 0 {
+		return nil
+	}
+	return copyMeta(cdb.trailer.meta)
+}
+
+func copyMeta(m map[string][]byte) map[string][]byte {
+	c := make(map[string][]byte, len(m))
+	for k, v := range m {
+		c[k] = append([]byte(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+package cdb
+
+import (
+	"io"
+)
+
+// Advice is a hint to the kernel about how a memory mapped database
+// will be read; see WithMadvise.
+type Advice int
+
+const (
+	// AdviceNormal leaves read-ahead to the kernel's defaults.
+	AdviceNormal Advice = iota
+
+	// AdviceRandom disables read-ahead: lookups touch a few scattered
+	// pages, and reading around them wastes page cache.
+	AdviceRandom
+
+	// AdviceSequential reads ahead aggressively, for full scans.
+	AdviceSequential
+
+	// AdviceWillNeed starts reading the whole database into the page
+	// cache in the background.
+	AdviceWillNeed
+)
+
+// WithMmap makes Open map the database into memory instead of reading
+// it with pread(2); lookups then cost no system calls once the pages
+// are resident. It is ignored by New and NewWithSize, and on platforms
+// without mmap.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// WithMadvise maps the database like WithMmap and passes advice to
+// madvise(2) for the mapping. It is a no-op where madvise isn't
+// available.
+func WithMadvise(advice Advice) Option {
+	return func(o *options) {
+		o.mmap = true
+		o.advice = advice
+	}
+}
+
+// WithMlock maps the database like WithMmap and locks the mapping into
+// memory with mlock(2), so its pages are never evicted. The whole file
+// is read in when it is opened; Open fails if the lock can't be taken
+// (e.g. because of RLIMIT_MEMLOCK).
+func WithMlock() Option {
+	return func(o *options) {
+		o.mmap = true
+		o.mlock = true
+	}
+}
+
+// Prefault reads the whole database into memory, so that later lookups
+// don't pay for page faults or disk reads; call it at startup, before
+// taking traffic. For memory mapped databases it faults in every page
+// of the mapping; otherwise it reads the file through once to fill the
+// page cache.
+func (cdb *CDB) Prefault() error {
+	if m, ok := ungated(cdb.reader).(*mmapFile); ok {
+		if err := cdb.enter(); err != nil {
+			return err
+		}
+		defer cdb.leave()
+		return m.prefault()
+	}
+	return readRange(io.Discard, cdb.reader, 0, cdb.size)
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package cdb
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile is never created where there is no mmap; WithMmap is
+// ignored and files are read with ReadAt.
+type mmapFile struct {
+	io.ReaderAt
+}
+
+func mapFile(f *os.File, size int64, o *options) (io.ReaderAt, error) {
+	return f, nil
+}
+
+func (m *mmapFile) prefault() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+//go:build unix
+
+package cdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// mmapFile is a read-only memory mapping of a database file. It takes
+// no lock on reads: its owner must not close it while it is read, and
+// does so through a closeGate (see CDB.Close and Pack.Close), so that no
+// read touches the mapping once it is gone.
+type mmapFile struct {
+	b []byte
+	f *os.File
+}
+
+var _ io.ReaderAt = &mmapFile{}
+
+// mapFile maps the first size bytes of f, applying the advice and
+// mlock options in o. Closing the mapping closes f.
+func mapFile(f *os.File, size int64, o *options) (*mmapFile, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, fmt.Errorf("cdb: can't map %d bytes", size)
+	}
+
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("cdb: mmap: %w", err)
+	}
+
+	m := &mmapFile{b: b, f: f}
+	if err := madvise(b, o.advice); err != nil {
+		syscall.Munmap(b)
+		return nil, fmt.Errorf("cdb: madvise: %w", err)
+	}
+
+	if o.mlock {
+		if err := mlock(b); err != nil {
+			syscall.Munmap(b)
+			return nil, fmt.Errorf("cdb: mlock: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *mmapFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("cdb: negative offset %d", off)
+	}
+
+	if m.b == nil {
+		return 0, os.ErrClosed
+	}
+	if off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *mmapFile) Size() int64 {
+	return int64(len(m.b))
+}
+
+// Stat returns the FileInfo of the mapped file
+func (m *mmapFile) Stat() (os.FileInfo, error) {
+	return m.f.Stat()
+}
+
+// prefault touches every page of the mapping
+func (m *mmapFile) prefault() error {
+	if m.b == nil {
+		return ErrClosed
+	}
+	madvise(m.b, AdviceWillNeed)
+
+	pg := os.Getpagesize()
+	var sum byte
+	for i := 0; i < len(m.b); i += pg {
+		sum += m.b[i]
+	}
+	prefaultSink = sum
+	return nil
+}
+
+// keeps the compiler from eliding the loads in prefault
+var prefaultSink byte
+
+func (m *mmapFile) Close() error {
+	if m.b == nil {
+		return os.ErrClosed
+	}
+	err := syscall.Munmap(m.b)
+	m.b = nil
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
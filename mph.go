@@ -0,0 +1,291 @@
+package cdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/opencoff/go-lib/fasthash"
+)
+
+// WithMPH makes the writer replace the 256 hash tables with a minimal
+// perfect hash over all keys, computed when the database is finalized.
+// Every lookup then reads exactly one slot, with no probe chains, and
+// the index takes about 9 bytes per key instead of 16.
+//
+// Finalizing costs more time, and the writer keeps 16 bytes per record
+// in memory, plus the key if the output isn't an io.ReaderAt. Only the
+// first record of a key is reachable, by Get or by iterators; later
+// duplicates only take up space. Databases built this way can't be
+// read by older versions of this package or other cdb tools.
+func WithMPH() Option {
+	return func(o *options) {
+		o.mph = true
+	}
+}
+
+// The MPH index is a CHD style "hash and displace" function. Keys are
+// hashed to 64 bits and split into r buckets; each bucket has a 32-bit
+// seed that maps its keys to distinct slots of a table with one slot per
+// key. A seed with the top bit set holds the slot of a single key bucket
+// directly.
+//
+// The slot table is written where the hash tables would be and has the
+// same 8 byte (hash, offset) slots, so a lookup can reject most absent
+// keys without reading a record. The seeds live in the trailer.
+type mphIndex struct {
+	// number of slots
+	m uint32
+
+	// offset of the slot table
+	off uint32
+
+	seeds []uint32
+}
+
+// average number of keys per bucket
+const mphBucketSize = 4
+
+// seeds with this bit set hold a slot directly
+const mphDirect = 1 << 31
+
+// mphKey is a record as seen by the MPH builder
+type mphKey struct {
+	h      uint64
+	hash   uint32
+	offset uint32
+
+	// the key, kept if the output can't be read back to compare keys
+	// with the same h
+	key []byte
+}
+
+// newMPHKey returns the mphKey of a record
+func (cdb *Writer) newMPHKey(hash uint32, key []byte, off uint32) mphKey {
+	k := mphKey{h: mphHash(key), hash: hash, offset: off}
+	if _, ok := cdb.writer.(io.ReaderAt); !ok {
+		k.key = bytes.Clone(key)
+	}
+	return k
+}
+
+// mphHash is the 64-bit key hash used by the MPH index
+func mphHash(key []byte) uint64 {
+	return fasthash.Hash64(0x51afd7ed558ccd3d, key)
+}
+
+// mphSlot maps a key hash to its slot for a bucket seed
+func mphSlot(h uint64, seed, m uint32) uint32 {
+	h ^= uint64(seed) * 0x9e3779b97f4a7c15
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return uint32(h % uint64(m))
+}
+
+// slot returns the slot for a key hash, or false if no key hashes to
+// its bucket.
+func (x *mphIndex) slot(h uint64) (uint32, bool) {
+	seed := x.seeds[h%uint64(len(x.seeds))]
+	switch {
+	case seed == 0:
+		return 0, false
+	case seed&mphDirect != 0:
+		return seed &^ mphDirect, true
+	default:
+		return mphSlot(h, seed, x.m), true
+	}
+}
+
+// buildMPH computes an MPH index over keys, which must have distinct
+// hashes, and returns it with the slot of every key.
+func buildMPH(keys []mphKey) (*mphIndex, []uint32, error) {
+	m := uint32(len(keys))
+	r := (m + mphBucketSize - 1) / mphBucketSize
+	if r == 0 {
+		r = 1
+	}
+
+	buckets := make([][]int, r)
+	for i, k := range keys {
+		b := k.h % uint64(r)
+		buckets[b] = append(buckets[b], i)
+	}
+
+	// place the largest buckets first, while the table is empty
+	order := make([]int, r)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(buckets[order[i]]) > len(buckets[order[j]])
+	})
+
+	x := &mphIndex{m: m, seeds: make([]uint32, r)}
+	slots := make([]uint32, len(keys))
+	used := make([]bool, m)
+	pos := make([]uint32, 0, 16)
+
+	var free uint32
+	for _, b := range order {
+		bucket := buckets[b]
+		switch len(bucket) {
+		case 0:
+			continue
+
+		case 1:
+			for used[free] {
+				free++
+			}
+			used[free] = true
+			slots[bucket[0]] = free
+			x.seeds[b] = mphDirect | free
+			continue
+		}
+
+		var seed uint32
+	search:
+		for seed = 1; seed < mphDirect; seed++ {
+			pos = pos[:0]
+			for _, i := range bucket {
+				s := mphSlot(keys[i].h, seed, m)
+				if used[s] {
+					continue search
+				}
+				for _, p := range pos {
+					if p == s {
+						continue search
+					}
+				}
+				pos = append(pos, s)
+			}
+			break
+		}
+		if seed == mphDirect {
+			return nil, nil, fmt.Errorf("cdb: can't build a perfect hash for bucket %d", b)
+		}
+
+		x.seeds[b] = seed
+		for j, i := range bucket {
+			used[pos[j]] = true
+			slots[i] = pos[j]
+		}
+	}
+	return x, slots, nil
+}
+
+// uniqueMPHKeys returns the keys for the MPH index: sorted by hash,
+// with only the first record of duplicate keys. The keys of records
+// with the same 64-bit hash are compared, read back from the output or
+// as kept in memory; distinct keys with the same hash are an error.
+func (cdb *Writer) uniqueMPHKeys() ([]mphKey, error) {
+	keys := cdb.mphKeys
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].h != keys[j].h {
+			return keys[i].h < keys[j].h
+		}
+		return keys[i].offset < keys[j].offset
+	})
+
+	ra, _ := cdb.writer.(io.ReaderAt)
+	if ra != nil {
+		if err := cdb.bufferedWriter.Flush(); err != nil {
+			return nil, writeErr(StageData, cdb.bufferedOffset, err)
+		}
+	}
+
+	out := keys[:0]
+	for i, k := range keys {
+		if i > 0 && k.h == keys[i-1].h {
+			prev := out[len(out)-1]
+			same, err := cdb.sameKey(ra, prev, k)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				return nil, fmt.Errorf("cdb: records at %d and %d have colliding keys; build without WithMPH", prev.offset, k.offset)
+			}
+			continue
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// sameKey returns true if the records a and b have the same key. The
+// keys are read from r, or compared in memory if r is nil.
+func (cdb *Writer) sameKey(r io.ReaderAt, a, b mphKey) (bool, error) {
+	if r == nil {
+		return bytes.Equal(a.key, b.key), nil
+	}
+
+	ka, err := readKey(r, a.offset, uint32(cdb.bufferedOffset), cdb.trailer.front)
+	if err != nil {
+		return false, err
+	}
+	kb, err := readKey(r, b.offset, uint32(cdb.bufferedOffset), cdb.trailer.front)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ka, kb), nil
+}
+
+// writeMPH builds the MPH index and writes its slot table at the
+// current offset.
+func (cdb *Writer) writeMPH() error {
+	keys, err := cdb.uniqueMPHKeys()
+	if err != nil {
+		return err
+	}
+
+	x, slots, err := buildMPH(keys)
+	if err != nil {
+		return err
+	}
+	x.off = uint32(cdb.bufferedOffset)
+
+	table := make([]entry, x.m)
+	for i, k := range keys {
+		table[slots[i]] = entry{hash: k.hash, offset: k.offset}
+	}
+
+	if err := cdb.writeSlots(table); err != nil {
+		return err
+	}
+
+	cdb.trailer.mph = x
+	return nil
+}
+
+// marshal encodes the index as m, the slot table offset and the seeds
+func (x *mphIndex) marshal() []byte {
+	buf := make([]byte, 8+4*len(x.seeds))
+	binary.LittleEndian.PutUint32(buf[0:4], x.m)
+	binary.LittleEndian.PutUint32(buf[4:8], x.off)
+	for i, s := range x.seeds {
+		binary.LittleEndian.PutUint32(buf[8+4*i:], s)
+	}
+	return buf
+}
+
+func unmarshalMPH(buf []byte) (*mphIndex, error) {
+	if len(buf) < 12 || len(buf)%4 != 0 {
+		return nil, fmt.Errorf("malformed mph section")
+	}
+
+	x := &mphIndex{
+		m:     binary.LittleEndian.Uint32(buf[0:4]),
+		off:   binary.LittleEndian.Uint32(buf[4:8]),
+		seeds: make([]uint32, (len(buf)-8)/4),
+	}
+	for i := range x.seeds {
+		s := binary.LittleEndian.Uint32(buf[8+4*i:])
+		if (s != 0 && x.m == 0) || (s&mphDirect != 0 && s&^mphDirect >= x.m) {
+			return nil, fmt.Errorf("malformed mph section")
+		}
+		x.seeds[i] = s
+	}
+	return x, nil
+}
@@ -0,0 +1,72 @@
+package cdb
+
+import (
+	"runtime"
+	"sync"
+)
+
+// GetResult is the result of looking up one of the keys given to
+// ShardReader.MultiGet.
+type GetResult struct {
+	Value []byte
+	Found bool
+	Err   error
+}
+
+// WithMultiGetWorkers bounds the number of goroutines a ShardReader
+// uses for each MultiGet; the default is GOMAXPROCS.
+func WithMultiGetWorkers(n int) Option {
+	return func(o *options) {
+		o.multiGetWorkers = n
+	}
+}
+
+// MultiGet looks up keys, and returns their results in the same order.
+// The keys are grouped by shard, and the shards are looked up
+// concurrently by a bounded pool of goroutines (see
+// WithMultiGetWorkers); the keys of a shard are looked up in order,
+// which keeps its reads close together. A failed lookup sets the Err of
+// its key only.
+func (s *ShardReader) MultiGet(keys [][]byte) []GetResult {
+	res := make([]GetResult, len(keys))
+
+	// indices of the keys of each shard
+	groups := make([][]int, len(s.shards))
+	norm := make([][]byte, len(keys))
+	for i, k := range keys {
+		norm[i] = s.shards[0].normKey(k)
+		sh := shardOf(norm[i], len(s.shards))
+		groups[sh] = append(groups[sh], i)
+	}
+
+	work := make(chan int, len(groups))
+	for sh, g := range groups {
+		if len(g) > 0 {
+			work <- sh
+		}
+	}
+	close(work)
+
+	workers := s.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(work))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sh := range work {
+				db := s.shards[sh]
+				for _, i := range groups[sh] {
+					r := &res[i]
+					r.Value, r.Found, r.Err = db.Lookup(norm[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
@@ -0,0 +1,233 @@
+package cdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// NamespaceStats describes the records written to a namespace.
+type NamespaceStats struct {
+	Name string
+
+	// number of records, not counting tombstones
+	Records int64
+
+	// number of tombstones
+	Deleted int64
+
+	// total size of the keys (without the prefix) and values
+	Bytes int64
+}
+
+// NamespaceWriter adds records to a namespace of a database.
+type NamespaceWriter struct {
+	w      *Writer
+	prefix []byte
+	stats  *NamespaceStats
+	err    error
+}
+
+// Namespace returns a writer for the records of namespace name, which
+// must be 1 to 255 bytes long; otherwise its methods return an error.
+// Namespaces partition the keys of one database among tenants, so that
+// many small databases can share a file; read them with CDB.Namespace.
+//
+// A key in namespace "ns" is stored with the prefix len("ns") "ns", and
+// the writer records the name and record counts of every namespace in
+// a directory in the trailer (see CDB.Namespaces). Keys written without
+// a namespace share the key space: a plain key that happens to start
+// with such a prefix is seen in that namespace. The directory is not
+// carried over by Merge, Rewrite and the like, which see namespaced
+// keys as plain keys.
+func (cdb *Writer) Namespace(name string) *NamespaceWriter {
+	prefix, err := nsPrefix(name)
+	if err != nil {
+		return &NamespaceWriter{err: err}
+	}
+
+	if cdb.namespaces == nil {
+		cdb.namespaces = make(map[string]*NamespaceStats)
+	}
+
+	st, ok := cdb.namespaces[name]
+	if !ok {
+		st = &NamespaceStats{Name: name}
+		cdb.namespaces[name] = st
+		cdb.estimatedFooterSize += int64(28 + len(name))
+	}
+	return &NamespaceWriter{w: cdb, prefix: prefix, stats: st}
+}
+
+// Put adds a key/value pair to the namespace.
+func (n *NamespaceWriter) Put(key, value []byte) error {
+	if n.err != nil {
+		return n.err
+	}
+
+	if err := n.w.Put(n.key(key), value); err != nil {
+		return err
+	}
+	n.count(key, value)
+	return nil
+}
+
+// PutTTL adds a key/value pair that expires at expiresAt to the
+// namespace; see Writer.PutTTL.
+func (n *NamespaceWriter) PutTTL(key, value []byte, expiresAt time.Time) error {
+	if n.err != nil {
+		return n.err
+	}
+
+	if err := n.w.PutTTL(n.key(key), value, expiresAt); err != nil {
+		return err
+	}
+	n.count(key, value)
+	return nil
+}
+
+// Delete adds a tombstone for key to the namespace; see Writer.Delete.
+func (n *NamespaceWriter) Delete(key []byte) error {
+	if n.err != nil {
+		return n.err
+	}
+
+	if err := n.w.Delete(n.key(key)); err != nil {
+		return err
+	}
+	n.stats.Deleted++
+	n.stats.Bytes += int64(len(key))
+	return nil
+}
+
+func (n *NamespaceWriter) key(key []byte) []byte {
+	return append(n.prefix[:len(n.prefix):len(n.prefix)], key...)
+}
+
+func (n *NamespaceWriter) count(key, value []byte) {
+	n.stats.Records++
+	n.stats.Bytes += int64(len(key) + len(value))
+}
+
+// nsPrefix returns the prefix of the keys in namespace name
+func nsPrefix(name string) ([]byte, error) {
+	if len(name) == 0 || len(name) > 255 {
+		return nil, fmt.Errorf("cdb: namespace name must be 1 to 255 bytes long, not %d", len(name))
+	}
+	return append([]byte{byte(len(name))}, name...), nil
+}
+
+// nsDirectory returns the namespaces written to, sorted by name
+func (cdb *Writer) nsDirectory() []NamespaceStats {
+	dir := make([]NamespaceStats, 0, len(cdb.namespaces))
+	for _, st := range cdb.namespaces {
+		dir = append(dir, *st)
+	}
+	sort.Slice(dir, func(i, j int) bool {
+		return dir[i].Name < dir[j].Name
+	})
+	return dir
+}
+
+// marshalNamespaces encodes the namespace directory as a sequence of
+// (name length uint32, name, records, deleted, bytes uint64).
+func marshalNamespaces(dir []NamespaceStats) []byte {
+	var b bytes.Buffer
+	var n [8]byte
+	for _, st := range dir {
+		binary.LittleEndian.PutUint32(n[:4], uint32(len(st.Name)))
+		b.Write(n[:4])
+		b.WriteString(st.Name)
+
+		for _, v := range []int64{st.Records, st.Deleted, st.Bytes} {
+			binary.LittleEndian.PutUint64(n[:], uint64(v))
+			b.Write(n[:])
+		}
+	}
+	return b.Bytes()
+}
+
+func unmarshalNamespaces(b []byte) ([]NamespaceStats, error) {
+	var dir []NamespaceStats
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("malformed namespace section")
+		}
+		n := binary.LittleEndian.Uint32(b)
+		b = b[4:]
+		if uint64(n) > uint64(len(b)) || len(b)-int(n) < 24 {
+			return nil, fmt.Errorf("malformed namespace section")
+		}
+
+		st := NamespaceStats{Name: string(b[:n])}
+		b = b[n:]
+		st.Records = int64(binary.LittleEndian.Uint64(b[0:8]))
+		st.Deleted = int64(binary.LittleEndian.Uint64(b[8:16]))
+		st.Bytes = int64(binary.LittleEndian.Uint64(b[16:24]))
+		b = b[24:]
+		dir = append(dir, st)
+	}
+	return dir, nil
+}
+
+// Namespaces returns the namespace directory: the namespaces the
+// database was written with and their record counts, sorted by name.
+// It returns nil for databases without one.
+func (cdb *CDB) Namespaces() []NamespaceStats {
+	return append([]NamespaceStats(nil), cdb.trailer.namespaces...)
+}
+
+// NamespaceReader reads the records of a namespace of a database.
+type NamespaceReader struct {
+	db     *CDB
+	name   string
+	prefix []byte
+	err    error
+}
+
+// Namespace returns a reader for the records of namespace name. It
+// needn't be in the directory; a database without one (e.g. after a
+// Merge) is read the same way.
+func (cdb *CDB) Namespace(name string) *NamespaceReader {
+	prefix, err := nsPrefix(name)
+	return &NamespaceReader{db: cdb, name: name, prefix: prefix, err: err}
+}
+
+// Get returns the value for key in the namespace, or nil if it isn't
+// there.
+func (n *NamespaceReader) Get(key []byte) ([]byte, error) {
+	v, _, err := n.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found.
+func (n *NamespaceReader) Lookup(key []byte) ([]byte, bool, error) {
+	if n.err != nil {
+		return nil, false, n.err
+	}
+	return n.db.Lookup(append(n.prefix[:len(n.prefix):len(n.prefix)], key...))
+}
+
+// Stats returns the directory entry of the namespace, and false if
+// there is none.
+func (n *NamespaceReader) Stats() (NamespaceStats, bool) {
+	for _, st := range n.db.trailer.namespaces {
+		if st.Name == n.name {
+			return st, true
+		}
+	}
+	return NamespaceStats{}, false
+}
+
+// NamespaceIterator iterates the records of a namespace, with the
+// namespace prefix removed from the keys.
+type NamespaceIterator = PrefixIterator
+
+// Iter returns an iterator over the records of the namespace, in file
+// order, with the prefix removed from the keys. It scans the whole
+// database, skipping the records of other namespaces.
+func (n *NamespaceReader) Iter() *NamespaceIterator {
+	return &NamespaceIterator{iter: n.db.Iter(), prefix: n.prefix, err: n.err}
+}
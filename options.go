@@ -0,0 +1,230 @@
+package cdb
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Option configures a Writer or a CDB. Options that have no meaning for
+// one side are ignored by it.
+type Option func(o *options)
+
+type options struct {
+	hash     HashID
+	sipKey   *[16]byte
+	hashSeed *uint64
+
+	// store expiry times with every value
+	expiry bool
+
+	// if non-zero, Merge drops records that expired before this
+	expireBefore time.Time
+
+	// Merge and Rewrite copy only the records these keep
+	filters []func(key, value []byte) bool
+
+	// don't verify the checksum on open
+	skipVerify bool
+
+	// the checksum was verified before opening; see OpenVerified
+	checksumVerified bool
+
+	// validate the database after finalizing it
+	validate bool
+
+	// build a Bloom filter with this many bits per key
+	bloomBits int
+
+	// build a minimal perfect hash instead of hash tables
+	mph bool
+
+	// front code keys
+	front bool
+
+	// values start at a multiple of alignment; see WithAlignment
+	alignment int
+
+	// replace the index with a header; see WithCompactIndex
+	compactIndex bool
+
+	// store the CRC of every record, and check it on lookups; see
+	// WithRecordCRC and WithVerifyEveryRead
+	recordCRC   bool
+	verifyReads bool
+
+	// longest probe chain accepted, and what to do with longer ones;
+	// see WithProbeLimit
+	probeLimit int
+	probeWarn  func(*ProbeWarning)
+
+	// debug logs, and the lookups slow enough to be logged
+	logger     *slog.Logger
+	slowLookup time.Duration
+
+	// checksum algorithm
+	checksum Checksum
+
+	// count the slots read by lookups
+	probeStats bool
+
+	// called after lookups
+	accessHook AccessHook
+
+	// background checksum passes; see WithReverify
+	reverify *reverifyConf
+
+	// progress reports of BuildFromSeq; see WithProgress
+	progress *progressConf
+
+	// transforms the values found by lookups
+	valueFn func(key, raw []byte) ([]byte, error)
+
+	// cache this many fallback results in a ReadThroughReader
+	fallbackCache int
+
+	// goroutines per ShardReader.MultiGet
+	multiGetWorkers int
+
+	// store fingerprints of the keys; keyFPStored if the keys given to
+	// the writer already are
+	keyFPBits   int
+	keyFPStored bool
+	keyFPCheck  func(key, value []byte) bool
+
+	// number of hash tables
+	tables int
+
+	// validates values before they are written
+	schema func(key, value []byte) error
+
+	// store values longer than this in a blob file, written to
+	// blobWriter and read from blobReader
+	blobThreshold int
+	blobWriter    io.Writer
+	blobReader    io.ReaderAt
+
+	// write records in key order regardless of the order they're added
+	deterministic bool
+
+	// normalize keys with keyFn, recorded as keyName
+	keyName string
+	keyFn   func([]byte) []byte
+
+	// map the file on Open, with this advice, and lock it in memory
+	mmap   bool
+	advice Advice
+	mlock  bool
+
+	// read the file with O_DIRECT on Open
+	direct bool
+
+	// size of the writer's output buffer; 0 for the default
+	bufSize int
+
+	// spill hash table entries to a temporary file in spillDir
+	spill    bool
+	spillDir string
+
+	// build the hash tables with an external sort of the spill file
+	extSort bool
+}
+
+func makeOptions(opts []Option) *options {
+	o := &options{}
+	for _, fp := range opts {
+		fp(o)
+	}
+	return o
+}
+
+// WithHash selects the hash function used to build a database. On the
+// reader, it selects the hash for databases that don't record one in
+// their trailer; for databases that do, the ids must agree.
+func WithHash(id HashID) Option {
+	return func(o *options) {
+		o.hash = id
+	}
+}
+
+// WithSipHash selects SipHash-2-4 keyed with key as the hash function.
+// Use this when keys come from untrusted sources: without the key, an
+// attacker can't craft keys that collide and degrade lookups into long
+// probe chains. The writer records a fingerprint of the key (not the
+// key itself) in the trailer; the reader must be given the same key.
+func WithSipHash(key [16]byte) Option {
+	return func(o *options) {
+		o.hash = HashSiphash
+		o.sipKey = &key
+	}
+}
+
+// hasher returns the hash function for id, taking into account any
+// key supplied via WithSipHash.
+func (o *options) hasher(id HashID) (func(b []byte) uint32, error) {
+	if id == HashSiphash && o.sipKey != nil {
+		return keyedSipHash(*o.sipKey), nil
+	}
+	if id == HashFasthash && o.hashSeed != nil {
+		return SeededHash32(*o.hashSeed), nil
+	}
+	return lookupHash(id)
+}
+
+// default size of the writer's output buffer
+const defaultBufferSize = 64 * 1024
+
+// WithBufferSize sets the size of the buffer the writer collects
+// records in before writing them out; the default is 64KB. Larger
+// buffers mean fewer, larger writes, which helps on network and
+// copy-on-write filesystems. Sizes below 4KB are rounded up.
+func WithBufferSize(n int) Option {
+	return func(o *options) {
+		o.bufSize = n
+	}
+}
+
+func (o *options) bufferSize() int {
+	if o.bufSize == 0 {
+		return defaultBufferSize
+	}
+	return max(o.bufSize, 4096)
+}
+
+// WithHashSeed selects the default hash function (fasthash), seeded
+// with seed instead of DefaultSeed. The writer records the seed in the
+// trailer and readers use it, so the option is only needed by callers
+// that compute hashes themselves (see SeededHash32 and PutHashed); a
+// reader given a different seed fails to open the database.
+func WithHashSeed(seed uint64) Option {
+	return func(o *options) {
+		o.hash = HashFasthash
+		o.hashSeed = &seed
+	}
+}
+
+// checkHashSeed reconciles the seed in o with the one in the trailer t,
+// which is used if o has none.
+func (o *options) checkHashSeed(t *trailer) error {
+	switch {
+	case t.hashSeed == nil && o.hashSeed != nil:
+		return fmt.Errorf("cdb: database uses the default hash seed, not %#x", *o.hashSeed)
+	case t.hashSeed == nil:
+		return nil
+	case o.hashSeed != nil && *o.hashSeed != *t.hashSeed:
+		return fmt.Errorf("cdb: database uses hash seed %#x, not %#x", *t.hashSeed, *o.hashSeed)
+	}
+	o.hashSeed = t.hashSeed
+	return nil
+}
+
+// WithSkipVerify makes the reader skip verifying the checksum when the
+// database is opened. Verification reads the entire file; skip it when
+// that is too expensive (e.g. for remote databases) and the integrity of
+// the file is assured by other means.
+func WithSkipVerify() Option {
+	return func(o *options) {
+		o.skipVerify = true
+	}
+}
@@ -0,0 +1,57 @@
+package cdb
+
+import (
+	"errors"
+)
+
+// Reader is the lookup interface shared by CDB and the readers layered
+// on top of it.
+type Reader interface {
+	// Get returns the value for a given key, or nil if it can't be found.
+	Get(key []byte) ([]byte, error)
+
+	// Lookup returns the value for a given key and whether it was found.
+	Lookup(key []byte) ([]byte, bool, error)
+
+	// Close closes the reader and the databases under it.
+	Close() error
+}
+
+var _ Reader = &CDB{}
+
+// OverlayReader is a delta database layered over a base database.
+type OverlayReader struct {
+	base  *CDB
+	delta *CDB
+}
+
+var _ Reader = &OverlayReader{}
+
+// Overlay returns a reader where lookups hit delta first and fall
+// through to base; this allows shipping small deltas instead of
+// rebuilding the whole database. Tombstones in delta (see
+// Writer.Delete) hide the key in base.
+func Overlay(base, delta *CDB) *OverlayReader {
+	return &OverlayReader{base: base, delta: delta}
+}
+
+// Get returns the value for key from the delta if present there, and
+// from the base otherwise.
+func (o *OverlayReader) Get(key []byte) ([]byte, error) {
+	v, _, err := o.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found.
+func (o *OverlayReader) Lookup(key []byte) ([]byte, bool, error) {
+	v, m, err := o.delta.lookupMatch(key)
+	if err != nil || m != noMatch {
+		return v, m == matchLive, err
+	}
+	return o.base.Lookup(key)
+}
+
+// Close closes both the delta and the base.
+func (o *OverlayReader) Close() error {
+	return errors.Join(o.delta.Close(), o.base.Close())
+}
@@ -0,0 +1,361 @@
+package cdb_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"cdb"
+)
+
+func TestOverlay(t *testing.T) {
+	makeDBAt(t, "./test/base.cdb", testRecords)
+	makeDBAt(t, "./test/delta.cdb", []kw{
+		{"abc", "xyz"},
+		{"new", "key"},
+	})
+
+	base, err := cdb.Open("./test/base.cdb")
+	if err != nil {
+		t.Fatalf("Can't open base.cdb: %s", err)
+	}
+
+	delta, err := cdb.Open("./test/delta.cdb")
+	if err != nil {
+		t.Fatalf("Can't open delta.cdb: %s", err)
+	}
+
+	db := cdb.Overlay(base, delta)
+	defer db.Close()
+
+	exp := []kw{
+		{"hello", "world"},
+		{"abc", "xyz"},
+		{"123", "345"},
+		{"new", "key"},
+	}
+
+	for _, r := range exp {
+		v, err := db.Get([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't find key %s: %s", r.key, err)
+		}
+
+		if r.val != string(v) {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, string(v))
+		}
+	}
+}
+
+func makeDelta(t testing.TB, path string) {
+	wr, err := cdb.Create(path)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", path, err)
+	}
+
+	err = wr.Put([]byte("abc"), []byte("xyz"))
+	if err != nil {
+		t.Fatalf("Can't put key abc: %s", err)
+	}
+
+	err = wr.Delete([]byte("123"))
+	if err != nil {
+		t.Fatalf("Can't delete key 123: %s", err)
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close %s: %s", path, err)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	makeDBAt(t, "./test/base.cdb", testRecords)
+	makeDelta(t, "./test/delta.cdb")
+
+	delta, err := cdb.Open("./test/delta.cdb")
+	if err != nil {
+		t.Fatalf("Can't open delta.cdb: %s", err)
+	}
+
+	v, err := delta.Get([]byte("123"))
+	if err != nil || v != nil {
+		t.Fatalf("Deleted key visible in delta: %q, %v", v, err)
+	}
+
+	base, err := cdb.Open("./test/base.cdb")
+	if err != nil {
+		t.Fatalf("Can't open base.cdb: %s", err)
+	}
+
+	exp := []kw{
+		{"hello", "world"},
+		{"abc", "xyz"},
+	}
+
+	db := cdb.Overlay(base, delta)
+	v, err = db.Get([]byte("123"))
+	if err != nil || v != nil {
+		t.Fatalf("Deleted key visible in overlay: %q, %v", v, err)
+	}
+
+	err = cdb.Merge("./test/merged.cdb", []*cdb.CDB{base, delta})
+	if err != nil {
+		t.Fatalf("Can't merge: %s", err)
+	}
+	db.Close()
+
+	m, err := cdb.Open("./test/merged.cdb")
+	if err != nil {
+		t.Fatalf("Can't open merged.cdb: %s", err)
+	}
+	defer m.Close()
+
+	var n int
+	iter := m.Iter()
+	for iter.Next() {
+		n++
+	}
+	if n != len(exp) {
+		t.Fatalf("Merged db has %d records; exp %d", n, len(exp))
+	}
+
+	for _, r := range exp {
+		v, err := m.Get([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't find key %s: %s", r.key, err)
+		}
+
+		if r.val != string(v) {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, string(v))
+		}
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	makeDelta(t, "./test/delta.cdb")
+
+	db, err := cdb.Open("./test/delta.cdb")
+	if err != nil {
+		t.Fatalf("Can't open delta.cdb: %s", err)
+	}
+
+	calls := map[string]int{}
+	fallback := func(key []byte) ([]byte, error) {
+		calls[string(key)]++
+		if string(key) == "missing" {
+			return nil, nil
+		}
+		return append([]byte("fb-"), key...), nil
+	}
+
+	rd := cdb.ReadThrough(db, fallback, cdb.WithFallbackCache(2))
+	defer rd.Close()
+
+	exp := []kw{
+		{"abc", "xyz"},
+		{"hello", "fb-hello"},
+		{"hello", "fb-hello"},
+		{"missing", ""},
+		{"missing", ""},
+		{"123", ""},
+	}
+
+	for _, r := range exp {
+		v, ok, err := rd.Lookup([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't look up key %s: %s", r.key, err)
+		}
+
+		if ok != (r.val != "") || string(v) != r.val {
+			t.Fatalf("Value mismatch for key %s (exp %q, saw %q, %v)", r.key, r.val, v, ok)
+		}
+	}
+
+	// the tombstone for 123 hides it from the fallback, and the cache
+	// answers repeated lookups
+	if calls["abc"] != 0 || calls["123"] != 0 || calls["hello"] != 1 || calls["missing"] != 1 {
+		t.Fatalf("Unexpected fallback calls: %v", calls)
+	}
+
+	// the cache holds 2 keys; hello was the least recently used
+	rd.Get([]byte("other"))
+	rd.Get([]byte("hello"))
+	if calls["hello"] != 2 {
+		t.Fatalf("Expected hello to be evicted: %v", calls)
+	}
+}
+
+func TestStack(t *testing.T) {
+	makeDBAt(t, "./test/base.cdb", testRecords)
+	makeDelta(t, "./test/delta.cdb")
+	makeDBAt(t, "./test/delta2.cdb", []kw{
+		{"new", "key"},
+		{"hello", "there"},
+		{"new", "shadowed"},
+	})
+
+	var dbs []*cdb.CDB
+	for _, fn := range []string{"base", "delta", "delta2"} {
+		db, err := cdb.Open("./test/" + fn + ".cdb")
+		if err != nil {
+			t.Fatalf("Can't open %s.cdb: %s", fn, err)
+		}
+		dbs = append(dbs, db)
+	}
+
+	s := cdb.Stack(dbs...)
+	defer s.Close()
+
+	exp := []kw{
+		{"new", "key"},
+		{"hello", "there"},
+		{"abc", "xyz"},
+	}
+
+	for _, r := range exp {
+		v, err := s.Get([]byte(r.key))
+		if err != nil || string(v) != r.val {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %q, %v)", r.key, r.val, v, err)
+		}
+	}
+
+	v, ok, err := s.Lookup([]byte("123"))
+	if err != nil || ok {
+		t.Fatalf("Found deleted key 123: %q, %v", v, err)
+	}
+
+	var saw []kw
+	iter := s.Iter()
+	for iter.Next() {
+		saw = append(saw, kw{string(iter.Key()), string(iter.Value())})
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("Iterator error: %s", err)
+	}
+
+	if len(saw) != len(exp) {
+		t.Fatalf("Expected %v, saw %v", exp, saw)
+	}
+	for i := range exp {
+		if saw[i] != exp[i] {
+			t.Fatalf("Expected %v, saw %v", exp, saw)
+		}
+	}
+}
+
+// layers returns each of the readers layered over delta, with base
+// under it where the reader takes one
+func layers(base, delta *cdb.CDB) map[string]cdb.Reader {
+	fallback := func(key []byte) ([]byte, error) {
+		return nil, nil
+	}
+	return map[string]cdb.Reader{
+		"overlay":     cdb.Overlay(base, delta),
+		"stack":       cdb.Stack(base, delta),
+		"readthrough": cdb.ReadThrough(delta, fallback),
+	}
+}
+
+func TestLayeredValueTransform(t *testing.T) {
+	makeDBAt(t, "./test/base.cdb", testRecords)
+	makeDelta(t, "./test/delta.cdb")
+
+	base, err := cdb.Open("./test/base.cdb")
+	if err != nil {
+		t.Fatalf("Can't open base.cdb: %s", err)
+	}
+	defer base.Close()
+
+	var hits int
+	hook := func(key []byte, found bool, n int, d time.Duration) {
+		if found {
+			hits++
+		}
+	}
+	upper := func(key, raw []byte) ([]byte, error) {
+		return bytes.ToUpper(raw), nil
+	}
+	delta, err := cdb.Open("./test/delta.cdb", cdb.WithValueTransform(upper), cdb.WithAccessHook(hook))
+	if err != nil {
+		t.Fatalf("Can't open delta.cdb: %s", err)
+	}
+	defer delta.Close()
+
+	for name, rd := range layers(base, delta) {
+		hits = 0
+		v, ok, err := rd.Lookup([]byte("abc"))
+		if err != nil || !ok || string(v) != "XYZ" {
+			t.Fatalf("%s: exp transformed value XYZ, saw %q, %v, %v", name, v, ok, err)
+		}
+		if hits != 1 {
+			t.Fatalf("%s: access hook saw %d hits, exp 1", name, hits)
+		}
+
+		v, ok, err = rd.Lookup([]byte("123"))
+		if err != nil || ok {
+			t.Fatalf("%s: found deleted key 123: %q, %v", name, v, err)
+		}
+	}
+}
+
+func TestLayeredRecordCRC(t *testing.T) {
+	fn := "./test/delta-crc.cdb"
+	makeDBAt(t, "./test/base.cdb", testRecords)
+	makeDBAt(t, fn, []kw{{"abc", "xyz"}}, cdb.WithRecordCRC())
+
+	base, err := cdb.Open("./test/base.cdb")
+	if err != nil {
+		t.Fatalf("Can't open base.cdb: %s", err)
+	}
+	defer base.Close()
+
+	delta, err := cdb.Open(fn, cdb.WithVerifyEveryRead())
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer delta.Close()
+
+	// damage the value under the open database
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	_, err = f.WriteAt([]byte("X"), int64(bytes.Index(b, []byte("xyz"))))
+	f.Close()
+	if err != nil {
+		t.Fatalf("Can't damage %s: %s", fn, err)
+	}
+
+	for name, rd := range layers(base, delta) {
+		if v, _, err := rd.Lookup([]byte("abc")); !errors.Is(err, cdb.ErrBadRecord) {
+			t.Fatalf("%s: lookup of a damaged record: exp ErrBadRecord, saw %q, %v", name, v, err)
+		}
+	}
+}
+
+func TestMergeKeepsOptions(t *testing.T) {
+	makeDBAt(t, "./test/base.cdb", testRecords, cdb.WithExpiry())
+
+	base, err := cdb.Open("./test/base.cdb")
+	if err != nil {
+		t.Fatalf("Can't open base.cdb: %s", err)
+	}
+	defer base.Close()
+
+	// Merge adds WithExpiry; it must not land in the spare capacity
+	opts := make([]cdb.Option, 1, 4)
+	opts[0] = cdb.WithValidateOnClose()
+	if err := cdb.Merge("./test/merged.cdb", []*cdb.CDB{base}, opts...); err != nil {
+		t.Fatalf("Can't merge: %s", err)
+	}
+	if opts[:2][1] != nil {
+		t.Fatalf("Merge wrote to the caller's options")
+	}
+}
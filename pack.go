@@ -0,0 +1,356 @@
+package cdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// A pack (the cdbpack format) is a container file that holds several
+// named databases, e.g. all the databases of a release shipped as one
+// artifact. Its layout is:
+//
+//	magic [8]byte "cdbpack1"
+//	databases, each starting at a multiple of packAlign
+//	directory: count uint32, then for each database
+//	    name length uint16, name, offset uint64, size uint64
+//	footer: directory offset uint64, directory length uint64,
+//	    directory CRC-32C uint32, magic [8]byte
+//
+// all integers little-endian. The databases are stored as they are,
+// checksum included; the blob file of a database named "x", if it has
+// one, is stored as the database "x.blob".
+var packMagic = []byte("cdbpack1")
+
+const (
+	// members start at page boundaries, so that they can be mapped
+	packAlign = 4096
+
+	packFooterSize = 8 + 8 + 4 + 8
+)
+
+// ErrNoMember is returned by Pack.DB for names that aren't in the pack.
+var ErrNoMember = errors.New("cdb: no such database in pack")
+
+// packEntry is the directory entry of a database in a pack
+type packEntry struct {
+	name string
+	off  int64
+	size int64
+}
+
+// PackWriter writes a pack; see CreatePack.
+type PackWriter struct {
+	f    *os.File
+	path string
+	off  int64
+	dir  []packEntry
+	err  error
+}
+
+// CreatePack creates a pack at path to which databases are added with
+// Add and AddFile. Close writes the directory; Abort removes the pack.
+func CreatePack(path string) (*PackWriter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Write(packMagic); err != nil {
+		f.Close()
+		removeFile(path)
+		return nil, err
+	}
+	return &PackWriter{f: f, path: path, off: int64(len(packMagic))}, nil
+}
+
+// Add copies the size bytes of the database read from r into the pack
+// as name, and verifies its checksum once it is copied. Names must be
+// unique and 1 to 65535 bytes long.
+func (p *PackWriter) Add(name string, r io.Reader, size int64) error {
+	if p.err != nil {
+		return p.err
+	}
+	if name == "" || len(name) > 65535 {
+		return fmt.Errorf("cdb: pack member name must be 1 to 65535 bytes long, not %d", len(name))
+	}
+	if slices.ContainsFunc(p.dir, func(e packEntry) bool { return e.name == name }) {
+		return fmt.Errorf("cdb: pack already has a database named %q", name)
+	}
+
+	off := (p.off + packAlign - 1) &^ (packAlign - 1)
+	n, err := io.Copy(io.NewOffsetWriter(p.f, off), io.LimitReader(r, size))
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		p.err = fmt.Errorf("cdb: pack %q: %w", name, err)
+		return p.err
+	}
+
+	// the blob file has its own checksum, checked by the
+	// database that uses it
+	if !strings.HasSuffix(name, ".blob") {
+		if err := verifyChecksum(io.NewSectionReader(p.f, off, size), size); err != nil {
+			p.err = fmt.Errorf("cdb: pack %q: %w", name, err)
+			return p.err
+		}
+	}
+
+	p.dir = append(p.dir, packEntry{name: name, off: off, size: size})
+	p.off = off + size
+	return nil
+}
+
+// AddFile adds the database at path to the pack as name, with its blob
+// file if it has one.
+func (p *PackWriter) AddFile(name, path string) error {
+	for _, suffix := range []string{"", ".blob"} {
+		f, err := os.Open(path + suffix)
+		if suffix != "" && errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		st, err := f.Stat()
+		if err == nil {
+			err = p.Add(name+suffix, f, st.Size())
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the directory of the pack and closes it. If a database
+// failed to be added, the pack is closed and removed, and the error
+// returned.
+func (p *PackWriter) Close() error {
+	if p.err != nil {
+		p.Abort()
+		return p.err
+	}
+
+	var dir bytes.Buffer
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[:4], uint32(len(p.dir)))
+	dir.Write(b[:4])
+	for _, e := range p.dir {
+		binary.LittleEndian.PutUint16(b[:2], uint16(len(e.name)))
+		dir.Write(b[:2])
+		dir.WriteString(e.name)
+		binary.LittleEndian.PutUint64(b[:], uint64(e.off))
+		dir.Write(b[:])
+		binary.LittleEndian.PutUint64(b[:], uint64(e.size))
+		dir.Write(b[:])
+	}
+
+	var foot [packFooterSize]byte
+	binary.LittleEndian.PutUint64(foot[0:8], uint64(p.off))
+	binary.LittleEndian.PutUint64(foot[8:16], uint64(dir.Len()))
+	binary.LittleEndian.PutUint32(foot[16:20], crc32.Checksum(dir.Bytes(), castagnoli))
+	copy(foot[20:], packMagic)
+	dir.Write(foot[:])
+
+	_, err := p.f.WriteAt(dir.Bytes(), p.off)
+	if err == nil {
+		err = p.f.Sync()
+	}
+	if err != nil {
+		p.err = err
+		p.Abort()
+		return err
+	}
+
+	p.err = errors.New("cdb: pack is closed")
+	return p.f.Close()
+}
+
+// Abort closes and removes the pack.
+func (p *PackWriter) Abort() error {
+	p.f.Close()
+	if p.err == nil {
+		p.err = ErrAborted
+	}
+	return removeFile(p.path)
+}
+
+// Pack is a container file of named databases opened with OpenPack.
+type Pack struct {
+	r    io.ReaderAt
+	dir  []packEntry
+	opts []Option
+
+	// keeps Close from unmapping the pack under a read; nil unless the
+	// pack is memory mapped
+	gate *closeGate
+}
+
+// OpenPack opens the pack at path. The options are used to open the
+// databases in it; with WithMmap, the whole pack is mapped once and the
+// databases read from the mapping.
+func OpenPack(path string, opts ...Option) (*Pack, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	o := makeOptions(opts)
+	var r io.ReaderAt = f
+	var gate *closeGate
+	if o.mmap && st.Size() > 0 {
+		r, err = mapFile(f, st.Size(), o)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		gate = newCloseGate()
+		r = &gatedReader{g: gate, r: r}
+	}
+	if o.logger != nil {
+		opts = append(opts[:len(opts):len(opts)], WithLogger(o.logger.With("pack", path)))
+	}
+
+	dir, err := readPackDir(r, st.Size())
+	if err != nil {
+		ungated(r).(io.Closer).Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Pack{r: r, dir: dir, opts: opts, gate: gate}, nil
+}
+
+// readPackDir reads and checks the directory of the pack of the given
+// size in r, and returns it sorted by name
+func readPackDir(r io.ReaderAt, size int64) ([]packEntry, error) {
+	if size < int64(len(packMagic))+4+packFooterSize {
+		return nil, errors.New("cdb: not a pack: too small")
+	}
+
+	var foot [packFooterSize]byte
+	if _, err := r.ReadAt(foot[:], size-packFooterSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(foot[20:], packMagic) {
+		return nil, errors.New("cdb: not a pack")
+	}
+
+	off := int64(binary.LittleEndian.Uint64(foot[0:8]))
+	n := int64(binary.LittleEndian.Uint64(foot[8:16]))
+	end := size - packFooterSize
+	if off < int64(len(packMagic)) || n < 4 || off > end || n != end-off {
+		return nil, corruptAt(ErrBadIndex, size-packFooterSize, "pack directory is out of bounds").want(end-off, n)
+	}
+
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(b, castagnoli) != binary.LittleEndian.Uint32(foot[16:20]) {
+		return nil, corruptAt(ErrBadIndex, off, "pack directory CRC mismatch")
+	}
+
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	dir := make([]packEntry, 0, min(int(count), len(b)/18))
+	for range count {
+		if len(b) < 2 {
+			return nil, corruptAt(ErrBadIndex, off, "pack directory is short")
+		}
+		nl := int(binary.LittleEndian.Uint16(b))
+		if len(b) < 2+nl+16 {
+			return nil, corruptAt(ErrBadIndex, off, "pack directory is short")
+		}
+
+		e := packEntry{
+			name: string(b[2 : 2+nl]),
+			off:  int64(binary.LittleEndian.Uint64(b[2+nl:])),
+			size: int64(binary.LittleEndian.Uint64(b[2+nl+8:])),
+		}
+		if e.off < int64(len(packMagic)) || e.size < 0 || e.off > off || e.size > off-e.off {
+			return nil, corruptAt(ErrBadIndex, off, "pack member %q is out of bounds", e.name)
+		}
+		dir = append(dir, e)
+		b = b[2+nl+16:]
+	}
+
+	slices.SortFunc(dir, func(a, b packEntry) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return dir, nil
+}
+
+// Names returns the names of the databases in the pack, sorted, not
+// counting blob files.
+func (p *Pack) Names() []string {
+	var names []string
+	for _, e := range p.dir {
+		if !strings.HasSuffix(e.name, ".blob") || p.find(strings.TrimSuffix(e.name, ".blob")) == nil {
+			names = append(names, e.name)
+		}
+	}
+	return names
+}
+
+// DB opens the database name in the pack, verifying its checksum unless
+// the pack was opened WithSkipVerify. The database reads the pack file,
+// so it must be closed before the pack is.
+func (p *Pack) DB(name string) (*CDB, error) {
+	e := p.find(name)
+	if e == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoMember, name)
+	}
+
+	opts := p.opts
+	if b := p.find(name + ".blob"); b != nil {
+		opts = append(opts[:len(opts):len(opts)], WithBlobReader(io.NewSectionReader(p.r, b.off, b.size)))
+	}
+	if o := makeOptions(opts); o.logger != nil {
+		opts = append(opts[:len(opts):len(opts)], WithLogger(o.logger.With("db", name)))
+	}
+
+	db, err := NewWithSize(io.NewSectionReader(p.r, e.off, e.size), e.size, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return db, nil
+}
+
+// find returns the directory entry of name, or nil
+func (p *Pack) find(name string) *packEntry {
+	i, ok := slices.BinarySearchFunc(p.dir, name, func(e packEntry, name string) int {
+		return strings.Compare(e.name, name)
+	})
+	if !ok {
+		return nil
+	}
+	return &p.dir[i]
+}
+
+// Close closes the pack file. A memory mapped pack waits for the reads
+// of its databases in progress; later ones fail with ErrClosed.
+func (p *Pack) Close() error {
+	if p.gate != nil && !p.gate.shut() {
+		return ErrClosed
+	}
+	if c, ok := ungated(p.r).(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
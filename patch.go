@@ -0,0 +1,327 @@
+package cdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"os"
+	"sync"
+)
+
+// ErrNotPatchable is returned by Patcher for records and databases whose
+// values can't be patched in place.
+var ErrNotPatchable = errors.New("cdb: can't patch value in place")
+
+// Patcher is a CDB opened for reading and for overwriting values in
+// place, for databases of fixed width values (e.g. counters) that
+// change too often to be rebuilt every time. A patch never changes the
+// length of a value, so the layout of the database stays as it is; only
+// the value bytes and the checksum are written.
+//
+// With ChecksumCRC64, every patch updates the checksum in the file from
+// the bytes it changes. With ChecksumNone there is no checksum to update. With
+// the other algorithms the checksum can't be updated incrementally, so
+// Sync and Close recompute it by reading the whole database once,
+// however many patches were made.
+//
+// Patches are not atomic: lookups of a record being patched may see a
+// mix of the old and new value, and a crash before Sync returns may
+// leave a database whose checksum doesn't match. Readers that open the
+// file while it is being patched may fail to verify it.
+type Patcher struct {
+	*CDB
+
+	mu sync.Mutex
+	f  *os.File
+
+	// the current checksum, for CRC64
+	crc uint64
+
+	// the checksum must be recomputed
+	stale bool
+}
+
+// OpenPatcher opens the database at path for patching. Databases with
+// front coded keys, a blob file or a signature can't be patched.
+func OpenPatcher(path string, opts ...Option) (*Patcher, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't stat %s: %s", path, err)
+	}
+
+	db, err := NewWithSize(f, st.Size(), opts...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	t := &db.trailer
+	switch {
+	case t.front:
+		err = fmt.Errorf("%w: database has front coded keys", ErrNotPatchable)
+	case t.blobs != nil:
+		err = fmt.Errorf("%w: database has a blob file", ErrNotPatchable)
+	case t.sig != nil:
+		err = fmt.Errorf("%w: database is signed", ErrNotPatchable)
+	case t.crcs != nil:
+		err = fmt.Errorf("%w: database has record CRCs", ErrNotPatchable)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	p := &Patcher{CDB: db, f: f}
+	if t.checksum == ChecksumCRC64 {
+		var ck [8]byte
+		if _, err := f.ReadAt(ck[:], db.size); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: can't read checksum: %w", path, err)
+		}
+		p.crc = binary.BigEndian.Uint64(ck[:])
+	}
+	return p, nil
+}
+
+// RecordOffset returns the offset of the record Get returns for key,
+// for use with Patcher.PatchValueAt; it returns false if the key can't
+// be found.
+func (cdb *CDB) RecordOffset(key []byte) (uint32, bool, error) {
+	off, value, err := cdb.find(key)
+	if value == nil || cdb.isTombstone(off) {
+		return 0, false, err
+	}
+	return off, true, nil
+}
+
+// Patch replaces the value of key with value, which must be as long as
+// the current one; see PatchValueAt.
+func (p *Patcher) Patch(key, value []byte) error {
+	off, ok, err := p.RecordOffset(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("cdb: patch: key %q not found", key)
+	}
+	return p.PatchValueAt(off, value)
+}
+
+// PatchValueAt replaces the value of the record at offset, as returned
+// by RecordOffset, with value. value must be exactly as long as the
+// current value; the expiry time of the record, if any, is kept.
+func (p *Patcher) PatchValueAt(offset uint32, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the file must stay open until the value and checksum are written
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.leave()
+
+	off, width, err := p.valueAt(offset)
+	if err != nil {
+		return err
+	}
+	if len(value) != int(width) {
+		return fmt.Errorf("%w: value of record at %d is %d bytes, not %d", ErrNotPatchable, offset, width, len(value))
+	}
+
+	old := make([]byte, width)
+	if _, err := p.f.ReadAt(old, off); err != nil {
+		return err
+	}
+	if bytes.Equal(old, value) {
+		return nil
+	}
+
+	if _, err := p.f.WriteAt(value, off); err != nil {
+		return err
+	}
+
+	switch p.trailer.checksum {
+	case ChecksumNone:
+	case ChecksumCRC64:
+		for i := range old {
+			old[i] ^= value[i]
+		}
+		p.crc ^= crc64Shift(^crc64.Update(^uint64(0), crc64Table, old), p.hashedAfter(off+int64(width)))
+		return p.writeChecksum()
+	default:
+		p.stale = true
+	}
+	return nil
+}
+
+// valueAt checks that offset is the start of a live record in a hash
+// table, and returns the offset and width of its value, without the
+// expiry time.
+func (p *Patcher) valueAt(offset uint32) (int64, uint32, error) {
+	klen, vlen, err := readTuple(p.reader, offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := checkRecord(offset, klen, vlen, p.index[0].offset); err != nil {
+		return 0, 0, err
+	}
+
+	key := make([]byte, klen)
+	if _, err := p.reader.ReadAt(key, int64(offset)+8); err != nil {
+		return 0, 0, err
+	}
+
+	// offsets that aren't in the hash tables aren't records
+	if err := p.enter(); err != nil {
+		return 0, 0, err
+	}
+	defer p.leave()
+
+	pr := p.probe(key)
+	for {
+		off, ok, err := p.next(&pr)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			return 0, 0, fmt.Errorf("%w: no record at %d", ErrNotPatchable, offset)
+		}
+		if off == offset {
+			break
+		}
+	}
+
+	if p.isTombstone(offset) {
+		return 0, 0, fmt.Errorf("%w: record at %d is a tombstone", ErrNotPatchable, offset)
+	}
+
+	start := int64(offset) + 8 + int64(klen)
+	if p.trailer.expiry {
+		if vlen < expirySize {
+			return 0, 0, corruptAt(ErrBadRecord, int64(offset), "value too short for expiry time").want(expirySize, int64(vlen))
+		}
+		start, vlen = start+expirySize, vlen-expirySize
+	}
+	return start, vlen, nil
+}
+
+// hashedAfter returns the number of bytes the checksum covers after the
+// byte at off, which is in the data section
+func (p *Patcher) hashedAfter(off int64) int64 {
+	n := p.size - off
+	if p.trailer.version >= 2 {
+		n += p.trailer.indexSize()
+	}
+	return n
+}
+
+// Sync writes the checksum and flushes the database to disk.
+func (p *Patcher) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.leave()
+
+	if p.stale {
+		if err := p.writeChecksum(); err != nil {
+			return err
+		}
+		p.stale = false
+	}
+	return p.f.Sync()
+}
+
+func (p *Patcher) writeChecksum() error {
+	var ck [checksumSize]byte
+	if p.trailer.checksum == ChecksumCRC64 {
+		binary.BigEndian.PutUint64(ck[:], p.crc)
+	} else {
+		hh, err := newChecksum(p.trailer.checksum)
+		if err != nil {
+			return err
+		}
+
+		var t *trailer
+		if p.trailer.version > 0 {
+			t = &p.trailer
+		}
+		if err := hashFile(hh, p.f, t, p.size); err != nil {
+			return err
+		}
+		copy(ck[:], hh.Sum(nil))
+	}
+
+	_, err := p.f.WriteAt(ck[:], p.size)
+	return err
+}
+
+// Close syncs the database like Sync and closes it.
+func (p *Patcher) Close() error {
+	err := p.Sync()
+	if cerr := p.CDB.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// crc64Shift advances crc, a CRC-64 without pre- and post-conditioning,
+// over n zero bytes in O(log n) steps, as zlib's crc32_combine does.
+func crc64Shift(crc uint64, n int64) uint64 {
+	var odd, even [64]uint64
+
+	// the operator for one zero bit
+	odd[0] = crc64.ECMA
+	for i, row := 1, uint64(1); i < 64; i, row = i+1, row<<1 {
+		odd[i] = row
+	}
+
+	// two and four bits
+	gf2Square(&even, &odd)
+	gf2Square(&odd, &even)
+
+	for n > 0 {
+		// the first square is for one byte
+		gf2Square(&even, &odd)
+		if n&1 != 0 {
+			crc = gf2Times(&even, crc)
+		}
+		n >>= 1
+		if n == 0 {
+			break
+		}
+
+		gf2Square(&odd, &even)
+		if n&1 != 0 {
+			crc = gf2Times(&odd, crc)
+		}
+		n >>= 1
+	}
+	return crc
+}
+
+func gf2Times(m *[64]uint64, v uint64) uint64 {
+	var sum uint64
+	for i := 0; v != 0; i, v = i+1, v>>1 {
+		if v&1 != 0 {
+			sum ^= m[i]
+		}
+	}
+	return sum
+}
+
+func gf2Square(sq, m *[64]uint64) {
+	for i := range m {
+		sq[i] = gf2Times(m, m[i])
+	}
+}
@@ -0,0 +1,74 @@
+package cdb
+
+import (
+	"time"
+)
+
+// Record is a record sent to BuildFromChan.
+type Record struct {
+	Key   []byte
+	Value []byte
+
+	// expiry time of the record; zero means never. The database must
+	// be built WithExpiry to store it.
+	Expires time.Time
+
+	// if set, the producer failed: BuildFromChan stops and returns
+	// Err. Key and Value are ignored.
+	Err error
+}
+
+// BuildFromChan writes the records received from ch to a new database
+// at path, until ch is closed. It lets a pipeline stage feed the writer
+// without its own Put loop: the capacity of ch bounds how far the
+// producer runs ahead of the writer, and the writer's output is
+// buffered, so records are written to disk in batches.
+//
+// A record with Err set aborts the build. If the build fails, the
+// remaining records are drained from ch (so the producer never blocks
+// on a dead consumer) and the partially written database is removed;
+// BuildFromChan returns once ch is closed.
+func BuildFromChan(path string, ch <-chan Record, opts ...Option) error {
+	wr, err := Create(path, opts...)
+	if err != nil {
+		drain(ch)
+		return err
+	}
+
+	err = buildFromChan(wr, ch)
+	if err == nil {
+		err = wr.Close()
+	} else {
+		drain(ch)
+	}
+
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+	return nil
+}
+
+func buildFromChan(wr *Writer, ch <-chan Record) error {
+	for r := range ch {
+		var err error
+		switch {
+		case r.Err != nil:
+			err = r.Err
+		case r.Expires.IsZero():
+			err = wr.Put(r.Key, r.Value)
+		default:
+			err = wr.PutTTL(r.Key, r.Value, r.Expires)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain discards the records left in ch until it is closed
+func drain(ch <-chan Record) {
+	for range ch {
+	}
+}
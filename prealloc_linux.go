@@ -0,0 +1,21 @@
+package cdb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocate(2) mode: don't change the file size
+const fallocKeepSize = 0x01
+
+// preallocate allocates disk blocks for the first size bytes of f
+// without changing its size; truncating the file releases the blocks
+// past its end. Filesystems that can't preallocate are not an error.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cdb
+
+import (
+	"os"
+)
+
+// preallocate is a no-op where fallocate(2) isn't available; space is
+// allocated as the file is written.
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package cdb
+
+import (
+	"bytes"
+	"time"
+)
+
+// PrefixReader is a view of the records of a database whose keys start
+// with a prefix, for files that hold several datasets under different
+// key prefixes. Keys passed to it are looked up with the prefix
+// prepended, and keys it returns have the prefix stripped.
+type PrefixReader struct {
+	db     *CDB
+	prefix []byte
+}
+
+// WithPrefix returns a view of the records whose keys start with p.
+// Unlike Namespace, it adds p to keys as it is, without a length; any
+// bytes will do, but a prefix that is itself a prefix of another (say
+// "user" and "users") sees the records of both.
+func (cdb *CDB) WithPrefix(p []byte) *PrefixReader {
+	return &PrefixReader{db: cdb, prefix: bytes.Clone(p)}
+}
+
+// WithPrefix returns a view of the records of the view whose keys,
+// without its prefix, start with p.
+func (r *PrefixReader) WithPrefix(p []byte) *PrefixReader {
+	return r.db.WithPrefix(r.key(p))
+}
+
+// Prefix returns the prefix of the view.
+func (r *PrefixReader) Prefix() []byte {
+	return bytes.Clone(r.prefix)
+}
+
+// Get returns the value for the prefix followed by key, or nil if it
+// can't be found.
+func (r *PrefixReader) Get(key []byte) ([]byte, error) {
+	v, _, err := r.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found.
+func (r *PrefixReader) Lookup(key []byte) ([]byte, bool, error) {
+	return r.db.Lookup(r.key(key))
+}
+
+// Iter returns an iterator over the records whose keys start with the
+// prefix, in file order, with the prefix removed from the keys. It
+// scans the whole database, skipping the other records.
+func (r *PrefixReader) Iter() *PrefixIterator {
+	return &PrefixIterator{iter: r.db.Iter(), prefix: r.prefix}
+}
+
+func (r *PrefixReader) key(key []byte) []byte {
+	return append(r.prefix[:len(r.prefix):len(r.prefix)], key...)
+}
+
+// PrefixIterator iterates the records whose keys start with a prefix.
+type PrefixIterator struct {
+	iter   *Iterator
+	prefix []byte
+	err    error
+}
+
+// Next advances the iterator to the next record with the prefix. It
+// returns false when there are no more records or on error; see Err.
+func (it *PrefixIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for it.iter.Next() {
+		if bytes.HasPrefix(it.iter.key, it.prefix) {
+			return true
+		}
+	}
+	it.err = it.iter.Err()
+	return false
+}
+
+// Key returns the current key, without the prefix.
+func (it *PrefixIterator) Key() []byte {
+	return it.iter.Key()[len(it.prefix):]
+}
+
+// Value returns the current value.
+func (it *PrefixIterator) Value() []byte {
+	return it.iter.Value()
+}
+
+// Expires returns the expiry time of the current record, or the zero
+// time if it never expires.
+func (it *PrefixIterator) Expires() time.Time {
+	return it.iter.Expires()
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *PrefixIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,65 @@
+package cdb
+
+import (
+	"fmt"
+)
+
+// WithProbeLimit makes the writer check, when it finalizes the
+// database, how far lookups have to probe: the longest distance, in
+// slots, from where a probe starts to the record it finds. Long chains
+// come from adversarial keys, from many records with the same key or
+// from a poor hash function, and make every lookup in their table
+// slow.
+//
+// If the longest chain exceeds limit, warn is called with a
+// description of the worst table, and the database is built anyway;
+// if warn is nil, Close and Freeze fail with the *ProbeWarning
+// instead. Databases built WithMPH don't probe and aren't checked.
+func WithProbeLimit(limit int, warn func(*ProbeWarning)) Option {
+	return func(o *options) {
+		o.probeLimit = limit
+		o.probeWarn = warn
+	}
+}
+
+// ProbeWarning describes the hash table with the longest probe chain
+// of a database built WithProbeLimit, when it exceeds the limit.
+type ProbeWarning struct {
+	Table   int
+	Slots   uint32
+	Records uint32
+
+	// the longest distance, in slots, from where a probe starts to
+	// the record it finds, and the limit it exceeds
+	MaxProbe uint32
+	Limit    int
+}
+
+func (w *ProbeWarning) Error() string {
+	return fmt.Sprintf("cdb: table %d (%d records) has a probe chain of %d slots, over the limit of %d; try another hash or seed (WithHash, WithHashSeed), more tables (WithTables), or fewer duplicate keys",
+		w.Table, w.Records, w.MaxProbe, w.Limit)
+}
+
+// probeDist returns the distance from slot start to slot s of a table
+// of length l, wrapping around its end.
+func probeDist(start, s, l uint32) uint32 {
+	if s >= start {
+		return s - start
+	}
+	return s + l - start
+}
+
+// checkProbe reports the worst table w, if its longest probe chain is
+// over the limit set with WithProbeLimit.
+func (cdb *Writer) checkProbe(w *ProbeWarning) error {
+	if cdb.probeLimit <= 0 || w == nil || int(w.MaxProbe) <= cdb.probeLimit {
+		return nil
+	}
+
+	w.Limit = cdb.probeLimit
+	if cdb.probeWarn == nil {
+		return w
+	}
+	cdb.probeWarn(w)
+	return nil
+}
@@ -0,0 +1,181 @@
+package cdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// WithProbeStats makes the reader count the hash table slots read by
+// every lookup; see CDB.ProbeStats. Use it to find out whether lookups
+// suffer from long probe chains, e.g. because of a poor hash function
+// or adversarial keys. Counting costs a few atomic adds per lookup.
+func WithProbeStats() Option {
+	return func(o *options) {
+		o.probeStats = true
+	}
+}
+
+// ProbeHistSize is the number of buckets in ProbeStats.Probes
+const ProbeHistSize = 17
+
+// ProbeStats summarizes the hash table probes made by lookups since
+// the database was opened.
+type ProbeStats struct {
+	// number of lookups
+	Lookups uint64
+
+	// Probes[i] is the number of lookups that read i slots; the last
+	// bucket counts lookups that read ProbeHistSize-1 slots or more.
+	// Lookups answered by the Bloom filter read no slots.
+	Probes [ProbeHistSize]uint64
+
+	// total number of slots read
+	Slots uint64
+
+	// number of records read whose hash matched that of the key
+	// looked up but whose key didn't
+	Collisions uint64
+
+	// the longest probe seen, in slots
+	Max uint64
+
+	// per hash table counts
+	Tables []TableProbes
+}
+
+// TableProbes counts the probes of a single hash table.
+type TableProbes struct {
+	Lookups uint64
+	Slots   uint64
+}
+
+// Mean returns the mean number of slots read per lookup.
+func (s *ProbeStats) Mean() float64 {
+	if s.Lookups == 0 {
+		return 0
+	}
+	return float64(s.Slots) / float64(s.Lookups)
+}
+
+// Hot returns up to n hash tables with the highest mean probe length,
+// longest first.
+func (s *ProbeStats) Hot(n int) []int {
+	var hot []int
+	for i, t := range s.Tables {
+		if t.Lookups > 0 {
+			hot = append(hot, i)
+		}
+	}
+
+	mean := func(i int) float64 {
+		t := s.Tables[i]
+		return float64(t.Slots) / float64(t.Lookups)
+	}
+	sort.SliceStable(hot, func(i, j int) bool {
+		return mean(hot[i]) > mean(hot[j])
+	})
+
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+// String returns a histogram of probe lengths, followed by the tables
+// with the longest probes.
+func (s *ProbeStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d lookups, %.2f slots/lookup, max %d, %d collisions\n",
+		s.Lookups, s.Mean(), s.Max, s.Collisions)
+
+	var most uint64
+	for _, n := range s.Probes {
+		most = max(most, n)
+	}
+
+	for i, n := range s.Probes {
+		if n == 0 {
+			continue
+		}
+
+		label := fmt.Sprintf("%3d", i)
+		if i == ProbeHistSize-1 {
+			label += "+"
+		}
+		bar := strings.Repeat("#", int((50*n+most-1)/most))
+		fmt.Fprintf(&b, "%-4s %10d %s\n", label, n, bar)
+	}
+
+	for _, i := range s.Hot(5) {
+		t := s.Tables[i]
+		fmt.Fprintf(&b, "table %3d: %d lookups, %.2f slots/lookup\n",
+			i, t.Lookups, float64(t.Slots)/float64(t.Lookups))
+	}
+	return b.String()
+}
+
+// probeStats holds the live counters behind ProbeStats
+type probeStats struct {
+	lookups    atomic.Uint64
+	probes     [ProbeHistSize]atomic.Uint64
+	slots      atomic.Uint64
+	collisions atomic.Uint64
+	max        atomic.Uint64
+	tables     []struct{ lookups, slots atomic.Uint64 }
+}
+
+func newProbeStats(ntables int) *probeStats {
+	return &probeStats{
+		tables: make([]struct{ lookups, slots atomic.Uint64 }, ntables),
+	}
+}
+
+// add counts the probe p, which is complete
+func (ps *probeStats) add(p *prober) {
+	n := uint64(p.slots)
+	ps.lookups.Add(1)
+	ps.probes[min(n, ProbeHistSize-1)].Add(1)
+	ps.slots.Add(n)
+	ps.collisions.Add(uint64(p.collisions))
+
+	for {
+		m := ps.max.Load()
+		if n <= m || ps.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+
+	t := &ps.tables[tableFor(p.hash, len(ps.tables))]
+	t.lookups.Add(1)
+	t.slots.Add(n)
+}
+
+// ProbeStats returns the probe counts of the lookups made so far, or
+// nil unless the database was opened WithProbeStats. Lookups made
+// while it runs may be partially counted.
+func (cdb *CDB) ProbeStats() *ProbeStats {
+	ps := cdb.stats
+	if ps == nil {
+		return nil
+	}
+
+	s := &ProbeStats{
+		Lookups:    ps.lookups.Load(),
+		Slots:      ps.slots.Load(),
+		Collisions: ps.collisions.Load(),
+		Max:        ps.max.Load(),
+		Tables:     make([]TableProbes, len(ps.tables)),
+	}
+	for i := range ps.probes {
+		s.Probes[i] = ps.probes[i].Load()
+	}
+	for i := range ps.tables {
+		s.Tables[i] = TableProbes{
+			Lookups: ps.tables[i].lookups.Load(),
+			Slots:   ps.tables[i].slots.Load(),
+		}
+	}
+	return s
+}
@@ -0,0 +1,53 @@
+package cdb
+
+import (
+	"fmt"
+	"io"
+)
+
+// PutReader adds a record whose value is the length bytes read from r,
+// streaming it to the output instead of holding it in memory. It is
+// otherwise like Put, and the value goes to the blob file if it is
+// longer than the WithBlobs threshold. With WithDeterministic or
+// WithSchema, the value is read into memory like any other.
+//
+// If r returns fewer than length bytes or an error, the record is only
+// partially written: PutReader returns the error, and so do all later
+// calls and Close. Abort removes the output.
+func (cdb *Writer) PutReader(key []byte, length uint32, r io.Reader) error {
+	var hdr []byte
+	if cdb.trailer.expiry {
+		hdr = make([]byte, expirySize)
+	}
+
+	if cdb.deterministic || cdb.schema != nil {
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return fmt.Errorf("cdb: PutReader: %w", err)
+		}
+		if err := cdb.checkSchema(key, value); err != nil {
+			return err
+		}
+		return cdb.put(key, hdr, value)
+	}
+
+	key = cdb.storeKey(key)
+	if err := cdb.putRecord(cdb.hasher(key), key, hdr, nil, r, int64(length)); err != nil {
+		return err
+	}
+
+	cdb.sizes.add(len(key), int64(length))
+	return nil
+}
+
+// copyValue copies the n bytes of a value from r to the output
+func (cdb *Writer) copyValue(r io.Reader, n int64) error {
+	c, err := io.CopyN(cdb.bufferedWriter, r, n)
+	if c != n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("value: read %d of %d bytes: %w", c, n, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package cdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// size of the read buffer for sequential scans
+const scanBufSize = 1 << 20
+
+// Range calls fn for every record in the database, in the order they
+// were written, until fn returns false. Tombstones, expired records and
+// records shadowed by an earlier record for the same key are skipped.
+//
+// Range reads the data section sequentially through one large buffer,
+// which makes it the fastest way to scan a database. The key and value
+// passed to fn are only valid until fn returns; copy them to retain
+// them.
+func (cdb *CDB) Range(fn func(key, value []byte) bool) error {
+	return cdb.scan(cdb.dataStart(), cdb.index[0].offset, fn)
+}
+
+// scan calls fn for the live records in [start, end) of the data
+// section. start must be the offset of a record.
+func (cdb *CDB) scan(start, end uint32, fn func(key, value []byte) bool) error {
+	var err error
+	werr := cdb.walkAt(start, end, func(off uint32, key, value []byte, dead bool) bool {
+		if dead {
+			return true
+		}
+
+		// a later record for a key, possibly one that was deleted,
+		// is not what Get returns
+		var first uint32
+		first, _, err = cdb.findStored(key)
+		if err != nil {
+			return false
+		}
+		return first != off || fn(key, value)
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+// walk is scan, but also calls fn for tombstones and expired records,
+// with dead set and a nil value.
+func (cdb *CDB) walk(start, end uint32, fn func(key, value []byte, dead bool) bool) error {
+	return cdb.walkAt(start, end, func(_ uint32, key, value []byte, dead bool) bool {
+		return fn(key, value, dead)
+	})
+}
+
+// walkAt is walk, but also passes fn the offset of each record.
+func (cdb *CDB) walkAt(start, end uint32, fn func(off uint32, key, value []byte, dead bool) bool) error {
+	sr := io.NewSectionReader(cdb.reader, int64(start), int64(end-start))
+	br := bufio.NewReaderSize(sr, scanBufSize)
+
+	now := time.Now()
+	var hdr [8]byte
+	var buf []byte
+	var front frontDecoder
+	for off := start; off < end; {
+		_, err := io.ReadFull(br, hdr[:])
+		if err != nil {
+			return fmt.Errorf("cdb: record at %d: %w", off, err)
+		}
+
+		klen := binary.LittleEndian.Uint32(hdr[:4])
+		vlen := binary.LittleEndian.Uint32(hdr[4:])
+		if err := checkRecord(off, klen, vlen, end); err != nil {
+			return err
+		}
+
+		n := int(klen) + int(vlen)
+		if n > cap(buf) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+
+		_, err = io.ReadFull(br, buf)
+		if err != nil {
+			return fmt.Errorf("cdb: record at %d: %w", off, err)
+		}
+
+		rec := off
+		off += 8 + uint32(n)
+		if cdb.isPadding(rec) {
+			continue
+		}
+
+		var v []byte
+		dead := cdb.isTombstone(rec)
+		if !dead {
+			var exp int64
+			exp, v, err = cdb.splitExpiry(rec, buf[klen:])
+			if err != nil {
+				return err
+			}
+			dead = expired(exp, now)
+		}
+
+		key := buf[:klen]
+		if cdb.trailer.front {
+			key, err = front.decode(cdb.reader, rec, end, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		if dead {
+			v = nil
+		}
+		if !fn(rec, key, v, dead) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// RangeParallel is like Range, but splits the data section into n parts
+// and scans them concurrently; fn is called from n goroutines at once
+// and must be safe for concurrent use. Records are visited in order
+// within a part, but not across parts. When fn returns false, all the
+// scans stop (records being visited concurrently may still be passed to
+// fn).
+//
+// The parts are aligned to record boundaries using the record offsets
+// in the hash tables, which costs one sequential read of the tables.
+func (cdb *CDB) RangeParallel(n int, fn func(key, value []byte) bool) error {
+	start, end := cdb.dataStart(), cdb.index[0].offset
+	if n <= 1 || end-start < uint32(n) {
+		return cdb.Range(fn)
+	}
+
+	bounds, err := cdb.splitData(start, end, n)
+	if err != nil {
+		return err
+	}
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	errs := make([]error, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		if bounds[i] == bounds[i+1] {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cdb.scan(bounds[i], bounds[i+1], func(k, v []byte) bool {
+				if stop.Load() || !fn(k, v) {
+					stop.Store(true)
+					return false
+				}
+				return true
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// splitData returns n+1 record aligned offsets dividing [start, end)
+// into n parts of roughly equal size.
+func (cdb *CDB) splitData(start, end uint32, n int) ([]uint32, error) {
+	// targets[i] is the ideal start of part i; bounds[i] will be the
+	// offset of the first record at or after it.
+	targets := make([]uint32, n)
+	bounds := make([]uint32, n+1)
+	step := (end - start) / uint32(n)
+	for i := range targets {
+		targets[i] = start + uint32(i)*step
+		bounds[i] = end
+	}
+	bounds[0] = start
+	bounds[n] = end
+
+	br := bufio.NewReaderSize(io.NewSectionReader(cdb.reader, int64(end), int64(cdb.tableBytes())), scanBufSize)
+
+	var slot [8]byte
+	for _, t := range cdb.tables() {
+		for s := uint32(0); s < t.length; s++ {
+			if _, err := io.ReadFull(br, slot[:]); err != nil {
+				return nil, fmt.Errorf("cdb: reading hash tables: %w", err)
+			}
+
+			off := binary.LittleEndian.Uint32(slot[4:])
+			if off == 0 {
+				continue
+			}
+
+			// the last part whose ideal start is <= off
+			i := sort.Search(n, func(i int) bool { return targets[i] > off }) - 1
+			if i > 0 && off < bounds[i] {
+				bounds[i] = off
+			}
+		}
+	}
+
+	// a part with no record starting in it is empty
+	for i := n - 1; i > 0; i-- {
+		if bounds[i] > bounds[i+1] {
+			bounds[i] = bounds[i+1]
+		}
+	}
+	return bounds, nil
+}
+
+// tables returns the hash tables; for a database built WithMPH, that is
+// the MPH slot table.
+func (cdb *CDB) tables() []table {
+	if x := cdb.trailer.mph; x != nil {
+		return []table{{offset: x.off, length: x.m}}
+	}
+	return cdb.index
+}
+
+// tableBytes returns the total size of the hash tables
+func (cdb *CDB) tableBytes() uint64 {
+	var n uint64
+	for _, t := range cdb.tables() {
+		n += 8 * uint64(t.length)
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package cdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// WithFallbackCache makes a ReadThroughReader keep the results of up
+// to n fallback calls in memory, evicting the least recently used. Keys
+// the fallback didn't find are cached as well.
+func WithFallbackCache(n int) Option {
+	return func(o *options) {
+		o.fallbackCache = n
+	}
+}
+
+// ReadThroughReader is a database backed by a fallback source for the
+// keys it doesn't have.
+type ReadThroughReader struct {
+	db       *CDB
+	fallback func(key []byte) ([]byte, error)
+
+	// LRU of fallback results; nil if not caching
+	mu    sync.Mutex
+	max   int
+	lru   *list.List
+	cache map[string]*list.Element
+}
+
+var _ Reader = &ReadThroughReader{}
+
+type fallbackResult struct {
+	key   string
+	value []byte
+}
+
+// ReadThrough returns a reader where lookups hit db first and call
+// fallback for keys that aren't there; this makes db the bottom tier of
+// a lookup hierarchy, e.g. in front of a slower database or service.
+// fallback returns nil for keys it doesn't have. Tombstones in db (see
+// Writer.Delete) hide the key from fallback; expired records don't.
+//
+// Results of fallback are not cached unless WithFallbackCache is
+// given; errors are never cached. fallback may be called concurrently.
+func ReadThrough(db *CDB, fallback func(key []byte) ([]byte, error), opts ...Option) *ReadThroughReader {
+	o := makeOptions(opts)
+	r := &ReadThroughReader{db: db, fallback: fallback}
+	if o.fallbackCache > 0 {
+		r.max = o.fallbackCache
+		r.lru = list.New()
+		r.cache = make(map[string]*list.Element)
+	}
+	return r
+}
+
+// Get returns the value for key from the database if present there,
+// and from the fallback otherwise.
+func (r *ReadThroughReader) Get(key []byte) ([]byte, error) {
+	v, _, err := r.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found. An
+// empty non-nil value from the fallback counts as found.
+func (r *ReadThroughReader) Lookup(key []byte) ([]byte, bool, error) {
+	v, m, err := r.db.lookupMatch(key)
+	if err != nil || m == matchLive || m == matchDeleted {
+		return v, m == matchLive, err
+	}
+
+	if v, ok := r.cached(key); ok {
+		return v, v != nil, nil
+	}
+
+	v, err = r.fallback(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.remember(key, v)
+	return v, v != nil, nil
+}
+
+// cached returns the cached fallback result for key, if any
+func (r *ReadThroughReader) cached(key []byte) ([]byte, bool) {
+	if r.lru == nil {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.cache[string(key)]
+	if !ok {
+		return nil, false
+	}
+
+	r.lru.MoveToFront(e)
+	return e.Value.(*fallbackResult).value, true
+}
+
+// remember caches a fallback result
+func (r *ReadThroughReader) remember(key, value []byte) {
+	if r.lru == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.cache[string(key)]; ok {
+		e.Value.(*fallbackResult).value = value
+		r.lru.MoveToFront(e)
+		return
+	}
+
+	res := &fallbackResult{key: string(key), value: value}
+	r.cache[res.key] = r.lru.PushFront(res)
+
+	for r.lru.Len() > r.max {
+		e := r.lru.Back()
+		r.lru.Remove(e)
+		delete(r.cache, e.Value.(*fallbackResult).key)
+	}
+}
+
+// Close closes the database. The fallback is not told.
+func (r *ReadThroughReader) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,106 @@
+package cdb
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"slices"
+)
+
+// WithRecordCRC makes the writer store a CRC-32C of every record in the
+// trailer, 8 bytes per record, so that readers opened WithVerifyEveryRead
+// can check each record they return. The CRC covers the record as it is
+// stored: for a value in the blob file, that is the pointer to it, and
+// the blob file is covered by its own checksum. Readers that don't
+// verify reads ignore the CRCs.
+func WithRecordCRC() Option {
+	return func(o *options) {
+		o.recordCRC = true
+	}
+}
+
+// WithVerifyEveryRead makes the reader check the CRC of every record a
+// lookup returns before returning it, so that a record damaged after
+// the database was opened (e.g. by failing memory or a failing disk
+// under an evicted page) is reported as a *CorruptError with Kind
+// ErrBadRecord instead of being returned. The database must have been
+// built WithRecordCRC. Lookups read values whole, and compute a CRC;
+// Range and the iterators don't check records.
+func WithVerifyEveryRead() Option {
+	return func(o *options) {
+		o.verifyReads = true
+	}
+}
+
+// ErrNoRecordCRC is returned by WithVerifyEveryRead readers of a
+// database built without WithRecordCRC.
+var ErrNoRecordCRC = errors.New("cdb: database has no record CRCs")
+
+// recordCRC is the CRC of the record at off
+type recordCRC struct {
+	off uint32
+	sum uint32
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// crcHash returns a hash for the CRC of the next record, or nil if
+// the writer doesn't store CRCs
+func (cdb *Writer) crcHash() hash.Hash32 {
+	if cdb.trailer.crcs == nil {
+		return nil
+	}
+	return crc32.New(castagnoli)
+}
+
+// checkCRC verifies the CRC of the record at off, whose key and value
+// are in rec.
+func (cdb *CDB) checkCRC(off, klen, vlen uint32, rec []byte) error {
+	i, ok := slices.BinarySearchFunc(cdb.trailer.crcs, off, func(c recordCRC, off uint32) int {
+		return cmp.Compare(c.off, off)
+	})
+	if !ok {
+		return corruptAt(ErrBadRecord, int64(off), "record has no CRC")
+	}
+
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:4], klen)
+	binary.LittleEndian.PutUint32(hdr[4:], vlen)
+	sum := crc32.Update(crc32.Update(0, castagnoli, hdr[:]), castagnoli, rec)
+
+	if want := cdb.trailer.crcs[i].sum; sum != want {
+		return corruptAt(ErrBadRecord, int64(off), "record CRC mismatch").want(int64(want), int64(sum))
+	}
+	return nil
+}
+
+// marshalCRCs returns the payload of the record CRC section: the
+// offset and CRC of every record, in increasing order of offset.
+func marshalCRCs(crcs []recordCRC) []byte {
+	b := make([]byte, 8*len(crcs))
+	for i, c := range crcs {
+		binary.LittleEndian.PutUint32(b[8*i:], c.off)
+		binary.LittleEndian.PutUint32(b[8*i+4:], c.sum)
+	}
+	return b
+}
+
+func unmarshalCRCs(t *trailer, b []byte) error {
+	if len(b)%8 != 0 {
+		return fmt.Errorf("malformed record CRC section")
+	}
+
+	t.crcs = make([]recordCRC, len(b)/8)
+	for i := range t.crcs {
+		c := &t.crcs[i]
+		c.off = binary.LittleEndian.Uint32(b[8*i:])
+		c.sum = binary.LittleEndian.Uint32(b[8*i+4:])
+		if i > 0 && c.off <= t.crcs[i-1].off {
+			return fmt.Errorf("record CRCs out of order at %d", c.off)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Rehash makes the database at path pass this package's checksum
+// verification without rebuilding it. It handles:
+//
+//   - a database whose checksum is wrong or was zeroed, e.g. after its
+//     records were patched in place: the checksum is recomputed with
+//     the algorithm its trailer names and rewritten;
+//   - a database truncated right after its trailer: the checksum is
+//     appended;
+//   - a database written by another cdb implementation, which has no
+//     trailer: a trailer is appended, recording the hash function
+//     selected with WithHash (HashClassic by default) and the checksum
+//     selected with WithChecksum, followed by the checksum;
+//   - a database in the old layout with a checksum but no trailer: the
+//     checksum is recomputed.
+//
+// The records and hash tables are never modified, so Rehash can't make
+// an inconsistent database sound; use Validate or OpenStrict for that.
+// A signature in the trailer is kept, but no longer verifies if the
+// checksum changed; only a rebuild can sign the database again. Anything
+// else, including a database whose trailer was cut short, is refused:
+// the tombstones, expiry times and hash function it recorded can't be
+// recovered from the records, and Salvage is the way to rescue one.
+func Rehash(path string, opts ...Option) error {
+	o := makeOptions(opts)
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	err = rehash(f, o)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func rehash(f *os.File, o *options) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := st.Size()
+	if size < minIndexSize {
+		return errors.New("cdb too small")
+	}
+
+	// a trailer followed by a checksum
+	if size >= minIndexSize+checksumSize {
+		t, err := readTrailer(f, minIndexSize, size-checksumSize)
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			return writeChecksum(f, t, size-checksumSize)
+		}
+	}
+
+	// a trailer whose checksum is missing
+	t, err := readTrailer(f, minIndexSize, size)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		return writeChecksum(f, t, size)
+	}
+
+	end, err := classicEnd(f, size)
+	if err != nil {
+		return err
+	}
+
+	switch end {
+	case size - checksumSize:
+		return writeChecksum(f, nil, end)
+	case size:
+		return appendTrailer(f, o, size)
+	}
+	return errors.New("cdb: can't find the end of the database; its trailer may be damaged")
+}
+
+// appendTrailer appends a trailer to the classic database of the given
+// size, for the hash and checksum chosen by o, and then the checksum.
+func appendTrailer(f *os.File, o *options, size int64) error {
+	id := o.hash
+	if id == 0 {
+		id = HashClassic
+	}
+	if id == HashCustom {
+		return errors.New("cdb: a custom hash can't be recorded in the trailer")
+	}
+
+	if _, err := o.hasher(id); err != nil {
+		return err
+	}
+
+	t := &trailer{
+		hash:     id,
+		count:    -1,
+		checksum: o.checksum,
+		cover:    &coverage{index: indexSize},
+	}
+	if id == HashSiphash && o.sipKey != nil {
+		t.sipFP = sipFingerprint(*o.sipKey)
+	}
+	if id == HashFasthash && o.hashSeed != nil {
+		t.hashSeed = o.hashSeed
+	}
+
+	// the coverage section has a fixed size; see Writer.finalize
+	t.cover.end = size + int64(len(t.marshal()))
+	t.version = trailerVersion
+
+	if _, err := f.WriteAt(t.marshal(), size); err != nil {
+		return err
+	}
+	return writeChecksum(f, t, t.cover.end)
+}
+
+// writeChecksum computes the checksum of the datasz bytes of f described
+// by the trailer t, which may be nil, and writes it at datasz.
+func writeChecksum(f *os.File, t *trailer, datasz int64) error {
+	alg := ChecksumSHA256
+	if t != nil {
+		alg = t.checksum
+		if err := t.checkCoverage(datasz); err != nil {
+			return err
+		}
+	}
+
+	hh, err := newChecksum(alg)
+	if err != nil {
+		return err
+	}
+
+	ck := make([]byte, checksumSize)
+	if hh != nil {
+		if err := hashFile(hh, f, t, datasz); err != nil {
+			return err
+		}
+		copy(ck, hh.Sum(nil))
+	}
+
+	if _, err := f.WriteAt(ck, datasz); err != nil {
+		return err
+	}
+	if err := f.Truncate(datasz + checksumSize); err != nil {
+		return err
+	}
+	return f.Sync()
+}
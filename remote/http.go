@@ -0,0 +1,89 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"cdb"
+)
+
+// HTTP fetches byte ranges of a URL with HTTP Range requests.
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+var _ Fetcher = &HTTP{}
+
+// NewHTTP returns a Fetcher for url; if client is nil,
+// http.DefaultClient is used.
+func NewHTTP(url string, client *http.Client) *HTTP {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTP{url: url, client: client}
+}
+
+// Size returns the size of the remote object via a HEAD request.
+func (h *HTTP) Size() (int64, error) {
+	resp, err := h.client.Head(h.url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("remote: HEAD %s: %s", h.url, resp.Status)
+	}
+
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("remote: HEAD %s: unknown content length", h.url)
+	}
+	return resp.ContentLength, nil
+}
+
+// Fetch implements Fetcher.
+func (h *HTTP) Fetch(off, n int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// A server that ignores Range would send us the whole object
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("remote: GET %s range %d+%d: %s", h.url, off, n, resp.Status)
+	}
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(resp.Body, buf)
+	if err != nil {
+		return nil, fmt.Errorf("remote: GET %s range %d+%d: %w", h.url, off, n, err)
+	}
+	return buf, nil
+}
+
+// OpenHTTP opens the cdb at url for reading over HTTP Range requests.
+// The checksum is not verified (that would read the whole file) unless
+// verify is true. opts are passed on to cdb.NewWithSize.
+func OpenHTTP(url string, client *http.Client, verify bool, opts ...cdb.Option) (*cdb.CDB, error) {
+	h := NewHTTP(url, client)
+	sz, err := h.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	if !verify {
+		opts = append(opts[:len(opts):len(opts)], cdb.WithSkipVerify())
+	}
+
+	r := NewReader(h, sz, 0, 0)
+	return cdb.NewWithSize(r, sz, opts...)
+}
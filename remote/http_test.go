@@ -0,0 +1,91 @@
+package remote_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"cdb"
+	"cdb/remote"
+)
+
+func TestHTTP(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "remote.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	recs := map[string]string{"hello": "world", "abc": "def", "123": "345"}
+	for k, v := range recs {
+		if err := wr.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Can't put key %s: %s", k, err)
+		}
+	}
+
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	var gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets++
+		}
+		http.ServeFile(w, r, fn)
+	}))
+	defer srv.Close()
+
+	db, err := remote.OpenHTTP(srv.URL, nil, true)
+	if err != nil {
+		t.Fatalf("Can't open remote db: %s", err)
+	}
+	defer db.Close()
+
+	for k, v := range recs {
+		val, err := db.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("Can't get key %s: %s", k, err)
+		}
+
+		if string(val) != v {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", k, v, string(val))
+		}
+	}
+
+	// the whole file fits in one block; everything after the first
+	// fetch must come from the cache
+	if gets != 1 {
+		t.Fatalf("Expected 1 range request, saw %d", gets)
+	}
+}
+
+func TestOpenHTTPKeepsOptions(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "remote.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, fn)
+	}))
+	defer srv.Close()
+
+	// OpenHTTP adds WithSkipVerify; it must not land in the spare capacity
+	opts := make([]cdb.Option, 1, 4)
+	opts[0] = cdb.WithExpiry()
+	db, err := remote.OpenHTTP(srv.URL, nil, false, opts...)
+	if err != nil {
+		t.Fatalf("Can't open remote db: %s", err)
+	}
+	db.Close()
+
+	if opts[:2][1] != nil {
+		t.Fatalf("OpenHTTP wrote to the caller's options")
+	}
+}
@@ -0,0 +1,144 @@
+// Package remote provides io.ReaderAt implementations over remote
+// storage (HTTP servers supporting Range requests, S3) with a block
+// cache, so that a large cdb can be queried without downloading it.
+//
+// A cdb lookup touches the index (once, at open) and then two small
+// regions of the file; with a block cache in front, most lookups cost
+// one or two round trips.
+package remote
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Fetcher reads byte ranges of a remote object.
+type Fetcher interface {
+	// Fetch returns exactly n bytes starting at off
+	Fetch(off, n int64) ([]byte, error)
+}
+
+// Default cache geometry: 64 KiB blocks, 1024 of them (64 MiB)
+const (
+	DefaultBlockSize   = 65536
+	DefaultCacheBlocks = 1024
+)
+
+// Reader is an io.ReaderAt over a Fetcher with an LRU block cache. It
+// is safe for concurrent use.
+type Reader struct {
+	f     Fetcher
+	size  int64
+	bsize int64
+	max   int
+
+	mu     sync.Mutex
+	lru    *list.List
+	blocks map[int64]*list.Element
+}
+
+type block struct {
+	n   int64
+	buf []byte
+}
+
+// NewReader returns a Reader for the size bytes of f, caching up to
+// nblocks blocks of bsize bytes each. Zero values select the defaults.
+func NewReader(f Fetcher, size int64, bsize, nblocks int) *Reader {
+	if bsize <= 0 {
+		bsize = DefaultBlockSize
+	}
+	if nblocks <= 0 {
+		nblocks = DefaultCacheBlocks
+	}
+
+	return &Reader{
+		f:      f,
+		size:   size,
+		bsize:  int64(bsize),
+		max:    nblocks,
+		lru:    list.New(),
+		blocks: make(map[int64]*list.Element),
+	}
+}
+
+// Size returns the size of the remote object.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("remote: negative offset %d", off)
+	}
+
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	want := int64(len(p))
+	if off+want > r.size {
+		want = r.size - off
+	}
+
+	var n int64
+	for n < want {
+		bn := (off + n) / r.bsize
+		b, err := r.block(bn)
+		if err != nil {
+			return int(n), err
+		}
+
+		boff := (off + n) - bn*r.bsize
+		n += int64(copy(p[n:want], b[boff:]))
+	}
+
+	if want < int64(len(p)) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+// block returns the contents of block bn, fetching it on a miss.
+func (r *Reader) block(bn int64) ([]byte, error) {
+	r.mu.Lock()
+	if e, ok := r.blocks[bn]; ok {
+		r.lru.MoveToFront(e)
+		buf := e.Value.(*block).buf
+		r.mu.Unlock()
+		return buf, nil
+	}
+	r.mu.Unlock()
+
+	// Fetch without holding the lock; concurrent misses on the same
+	// block may fetch it twice, which is harmless.
+	off := bn * r.bsize
+	n := r.bsize
+	if off+n > r.size {
+		n = r.size - off
+	}
+
+	buf, err := r.f.Fetch(off, n)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) != n {
+		return nil, fmt.Errorf("remote: short fetch at %d: want %d bytes, got %d", off, n, len(buf))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.blocks[bn]; !ok {
+		r.blocks[bn] = r.lru.PushFront(&block{bn, buf})
+		for r.lru.Len() > r.max {
+			e := r.lru.Back()
+			r.lru.Remove(e)
+			delete(r.blocks, e.Value.(*block).n)
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,75 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"cdb"
+)
+
+// S3API is the subset of an S3 client needed to read objects. It is
+// small enough to wrap any SDK client (e.g. aws-sdk-go-v2's HeadObject
+// and GetObject with a Range header) without this package depending
+// on it.
+type S3API interface {
+	// HeadObjectSize returns the size of the object
+	HeadObjectSize(ctx context.Context, bucket, key string) (int64, error)
+
+	// GetObjectRange returns a reader for n bytes of the object at off
+	GetObjectRange(ctx context.Context, bucket, key string, off, n int64) (io.ReadCloser, error)
+}
+
+// S3 fetches byte ranges of an S3 object.
+type S3 struct {
+	ctx    context.Context
+	client S3API
+	bucket string
+	key    string
+}
+
+var _ Fetcher = &S3{}
+
+// NewS3 returns a Fetcher for the object bucket/key. All requests use ctx.
+func NewS3(ctx context.Context, client S3API, bucket, key string) *S3 {
+	return &S3{ctx: ctx, client: client, bucket: bucket, key: key}
+}
+
+// Size returns the size of the object.
+func (s *S3) Size() (int64, error) {
+	return s.client.HeadObjectSize(s.ctx, s.bucket, s.key)
+}
+
+// Fetch implements Fetcher.
+func (s *S3) Fetch(off, n int64) ([]byte, error) {
+	rc, err := s.client.GetObjectRange(s.ctx, s.bucket, s.key, off, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(rc, buf)
+	if err != nil {
+		return nil, fmt.Errorf("remote: s3://%s/%s range %d+%d: %w", s.bucket, s.key, off, n, err)
+	}
+	return buf, nil
+}
+
+// OpenS3 opens the cdb in bucket/key for reading with ranged GETs. The
+// checksum is not verified (that would read the whole object) unless
+// verify is true. opts are passed on to cdb.NewWithSize.
+func OpenS3(ctx context.Context, client S3API, bucket, key string, verify bool, opts ...cdb.Option) (*cdb.CDB, error) {
+	s := NewS3(ctx, client, bucket, key)
+	sz, err := s.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	if !verify {
+		opts = append(opts[:len(opts):len(opts)], cdb.WithSkipVerify())
+	}
+
+	r := NewReader(s, sz, 0, 0)
+	return cdb.NewWithSize(r, sz, opts...)
+}
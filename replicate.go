@@ -0,0 +1,396 @@
+package cdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// ErrDeltaBase is returned by Apply when the change stream was made
+// against a different database than the one it is applied to.
+var ErrDeltaBase = errors.New("cdb: change stream doesn't apply to this database")
+
+// A change stream is:
+//
+//	header: "cdbdelta", version (uint32), flags (uint32), fingerprint
+//	        of the old database (32 bytes)
+//	ops:    'P' uvarint(klen) uvarint(vlen) varint(expiry) key value
+//	        'D' uvarint(klen) key
+//	end:    'E' uvarint(number of ops) SHA256 of everything before it
+//
+// Integers are little-endian.
+const deltaVersion = 1
+
+var deltaMagic = []byte("cdbdelta")
+
+// header flags
+const (
+	deltaExpiry = 1 << iota
+)
+
+// stream ops
+const (
+	opPut    = 'P'
+	opDelete = 'D'
+	opEnd    = 'E'
+)
+
+// Export writes to w the changes that turn since into this database:
+// a put for every key that is new or has a new value (or expiry time),
+// and a delete for every key that is gone. Apply replays them on a copy
+// of since to rebuild this database; this lets replicas fetch small
+// deltas instead of whole files.
+//
+// Like DiffFunc, Export scans each database once and probes the other
+// for every key; only the first value of a key is considered, and
+// expired records are treated as absent.
+func (cdb *CDB) Export(w io.Writer, since *CDB) error {
+	fp, err := since.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	dw := &deltaWriter{sum: sha256.New()}
+	dw.w = bufio.NewWriterSize(io.MultiWriter(w, dw.sum), 65536)
+
+	var flags uint32
+	if cdb.trailer.expiry {
+		flags |= deltaExpiry
+	}
+
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], deltaVersion)
+	binary.LittleEndian.PutUint32(hdr[4:8], flags)
+	dw.w.Write(deltaMagic)
+	dw.w.Write(hdr[:])
+	dw.w.Write(fp)
+
+	// pass 1: keys in since that are gone
+	iter := since.Iter()
+	for iter.Next() {
+		_, _, ok, err := cdb.lookupExpiry(iter.key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			dw.delete(iter.key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	// pass 2: keys that are new or changed
+	iter = cdb.Iter()
+	for iter.Next() {
+		v, exp, ok, err := since.lookupExpiry(iter.key)
+		if err != nil {
+			return err
+		}
+		if !ok || exp != iter.expires || !bytes.Equal(v, iter.value) {
+			dw.put(iter.key, iter.value, iter.expires)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return dw.finish()
+}
+
+// deltaWriter writes the ops of a change stream
+type deltaWriter struct {
+	w   *bufio.Writer
+	sum hash.Hash
+	ops uint64
+}
+
+func (dw *deltaWriter) put(key, value []byte, exp int64) {
+	var buf [1 + 3*binary.MaxVarintLen64]byte
+	b := append(buf[:0], opPut)
+	b = binary.AppendUvarint(b, uint64(len(key)))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	b = binary.AppendVarint(b, exp)
+	dw.w.Write(b)
+	dw.w.Write(key)
+	dw.w.Write(value)
+	dw.ops++
+}
+
+func (dw *deltaWriter) delete(key []byte) {
+	var buf [1 + binary.MaxVarintLen64]byte
+	b := append(buf[:0], opDelete)
+	b = binary.AppendUvarint(b, uint64(len(key)))
+	dw.w.Write(b)
+	dw.w.Write(key)
+	dw.ops++
+}
+
+// finish writes the end marker and flushes the stream; write errors
+// surface here.
+func (dw *deltaWriter) finish() error {
+	var buf [1 + binary.MaxVarintLen64]byte
+	b := append(buf[:0], opEnd)
+	b = binary.AppendUvarint(b, dw.ops)
+	dw.w.Write(b)
+
+	// the checksum covers everything up to here
+	if err := dw.w.Flush(); err != nil {
+		return err
+	}
+	dw.w.Write(dw.sum.Sum(nil))
+	return dw.w.Flush()
+}
+
+// deltaOp is a decoded op of a change stream
+type deltaOp struct {
+	del   bool
+	value []byte
+	exp   int64
+
+	// set once written to the new database
+	done bool
+}
+
+// Apply writes a new database at dst holding base with the changes read
+// from r, which must have been written by Export with base as since.
+// The stream is read and checked in full before dst is created, and is
+// held in memory. The records of base keep their order, followed by the
+// new keys. The new database is read like base and stores expiry times
+// if the exporting one did; opts apply to creating dst. On error, the
+// partially written database is removed.
+func Apply(r io.Reader, base *CDB, dst string, opts ...Option) error {
+	keys, ops, flags, err := readDelta(r, base)
+	if err != nil {
+		return err
+	}
+
+	wopts := base.inheritOptions()
+	if flags&deltaExpiry != 0 {
+		wopts = append(wopts, WithExpiry())
+	}
+	wopts = append(wopts, opts...)
+
+	wr, err := Create(dst, wopts...)
+	if err != nil {
+		return err
+	}
+
+	err = apply(wr, base, keys, ops)
+	if err == nil {
+		err = wr.Close()
+	} else {
+		wr.Close()
+	}
+
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func apply(wr *Writer, base *CDB, keys []string, ops map[string]*deltaOp) error {
+	put := func(key, value []byte, exp int64) error {
+		if wr.trailer.expiry {
+			var t time.Time
+			if exp != 0 {
+				t = time.Unix(exp, 0)
+			}
+			return wr.PutTTL(key, value, t)
+		}
+		return wr.Put(key, value)
+	}
+
+	iter := base.Iter()
+	for iter.Next() {
+		var err error
+		if op, ok := ops[string(iter.key)]; !ok {
+			err = put(iter.key, iter.value, iter.expires)
+		} else if !op.del {
+			err = put(iter.key, op.value, op.exp)
+			op.done = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	// the keys not in base
+	for _, k := range keys {
+		if op := ops[k]; !op.del && !op.done {
+			if err := put([]byte(k), op.value, op.exp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deltaReader reads a change stream, summing the bytes consumed
+type deltaReader struct {
+	br  *bufio.Reader
+	sum hash.Hash
+}
+
+func (dr *deltaReader) Read(p []byte) (int, error) {
+	n, err := dr.br.Read(p)
+	dr.sum.Write(p[:n])
+	return n, err
+}
+
+func (dr *deltaReader) ReadByte() (byte, error) {
+	c, err := dr.br.ReadByte()
+	if err == nil {
+		dr.sum.Write([]byte{c})
+	}
+	return c, err
+}
+
+// readDelta reads and checks a change stream made against base. It
+// returns the keys in stream order, the op for each and the header
+// flags.
+func readDelta(r io.Reader, base *CDB) ([]string, map[string]*deltaOp, uint32, error) {
+	dr := &deltaReader{br: bufio.NewReaderSize(r, 65536), sum: sha256.New()}
+
+	bad := func(err error) error {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("cdb: reading change stream: %w", err)
+	}
+
+	hdr := make([]byte, len(deltaMagic)+8+sha256.Size)
+	if _, err := io.ReadFull(dr, hdr); err != nil {
+		return nil, nil, 0, bad(err)
+	}
+
+	if !bytes.Equal(hdr[:len(deltaMagic)], deltaMagic) {
+		return nil, nil, 0, fmt.Errorf("cdb: not a change stream")
+	}
+	hdr = hdr[len(deltaMagic):]
+
+	vers := binary.LittleEndian.Uint32(hdr[0:4])
+	if vers != deltaVersion {
+		return nil, nil, 0, fmt.Errorf("cdb: change stream version %d not supported (want %d)", vers, deltaVersion)
+	}
+	flags := binary.LittleEndian.Uint32(hdr[4:8])
+
+	fp, err := base.fingerprint()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if !bytes.Equal(fp, hdr[8:]) {
+		return nil, nil, 0, ErrDeltaBase
+	}
+
+	var keys []string
+	ops := make(map[string]*deltaOp)
+	for n := uint64(0); ; n++ {
+		op, err := dr.ReadByte()
+		if err != nil {
+			return nil, nil, 0, bad(err)
+		}
+
+		if op == opEnd {
+			count, err := binary.ReadUvarint(dr)
+			if err != nil {
+				return nil, nil, 0, bad(err)
+			}
+			if count != n {
+				return nil, nil, 0, fmt.Errorf("cdb: change stream has %d ops, expected %d", n, count)
+			}
+			break
+		}
+
+		var klen, vlen uint64
+		var exp int64
+		switch op {
+		case opPut:
+			klen, err = binary.ReadUvarint(dr)
+			if err == nil {
+				vlen, err = binary.ReadUvarint(dr)
+			}
+			if err == nil {
+				exp, err = binary.ReadVarint(dr)
+			}
+
+		case opDelete:
+			klen, err = binary.ReadUvarint(dr)
+
+		default:
+			return nil, nil, 0, fmt.Errorf("cdb: change stream: unknown op %#x", op)
+		}
+		if err != nil {
+			return nil, nil, 0, bad(err)
+		}
+		if klen > math.MaxUint32 || vlen > math.MaxUint32 {
+			return nil, nil, 0, fmt.Errorf("cdb: change stream: record too large")
+		}
+
+		buf := make([]byte, klen+vlen)
+		if _, err := io.ReadFull(dr, buf); err != nil {
+			return nil, nil, 0, bad(err)
+		}
+
+		k := string(buf[:klen])
+		if _, ok := ops[k]; !ok {
+			keys = append(keys, k)
+		}
+		ops[k] = &deltaOp{del: op == opDelete, value: buf[klen:], exp: exp}
+	}
+
+	want := dr.sum.Sum(nil)
+	var got [sha256.Size]byte
+	if _, err := io.ReadFull(dr.br, got[:]); err != nil {
+		return nil, nil, 0, bad(err)
+	}
+	if !bytes.Equal(want, got[:]) {
+		return nil, nil, 0, fmt.Errorf("cdb: change stream checksum failed")
+	}
+	return keys, ops, flags, nil
+}
+
+// lookupExpiry is lookupStored that also returns the expiry time of the
+// value
+func (cdb *CDB) lookupExpiry(key []byte) ([]byte, int64, bool, error) {
+	off, raw, err := cdb.findStored(key)
+	if raw == nil || err != nil || cdb.isTombstone(off) {
+		return nil, 0, false, err
+	}
+
+	exp, v, err := cdb.splitExpiry(off, raw)
+	if err != nil || expired(exp, time.Now()) {
+		return nil, 0, false, err
+	}
+	return v, exp, true, nil
+}
+
+// fingerprint identifies the database for Apply: it is the SHA256 of
+// the index and the checksum at the end of the file.
+func (cdb *CDB) fingerprint() ([]byte, error) {
+	h := sha256.New()
+	err := readRange(h, cdb.reader, 0, int64(cdb.dataStart()))
+	if err != nil {
+		return nil, err
+	}
+
+	if cdb.trailer.version > 0 && cdb.size > 0 {
+		err = readRange(h, cdb.reader, cdb.size, checksumSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
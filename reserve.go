@@ -0,0 +1,63 @@
+package cdb
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+)
+
+// Reserve prepares the writer for nRecords more records holding
+// dataBytes of keys and values in total. It sizes the in-memory hash
+// table entries up front, so that they aren't copied as they grow, and
+// if the output is an *os.File, allocates disk space for the finished
+// database (on Linux, with fallocate(2)); a disk too small for it fails
+// here rather than hours into a build. Space the database doesn't use
+// is released when it is finalized.
+//
+// The reservation is a hint: writing more than reserved works as
+// usual, and front coding or the trailer may make the database smaller
+// or larger than estimated.
+func (cdb *Writer) Reserve(nRecords int, dataBytes int64) error {
+	if nRecords < 0 || dataBytes < 0 {
+		return fmt.Errorf("cdb: can't reserve %d records of %d bytes", nRecords, dataBytes)
+	}
+
+	// every record has a length tuple and two hash table slots
+	per := int64(8 + 16)
+	if cdb.trailer.expiry {
+		per += expirySize
+	}
+
+	size := cdb.bufferedOffset + dataBytes + per*int64(nRecords)
+	if size+cdb.estimatedFooterSize > math.MaxUint32 {
+		return ErrTooMuchData
+	}
+
+	if cdb.deterministic {
+		cdb.held = slices.Grow(cdb.held, nRecords)
+	}
+
+	if cdb.mph {
+		cdb.mphKeys = slices.Grow(cdb.mphKeys, nRecords)
+	} else if cdb.spill == nil {
+		// allow for the tables filling unevenly
+		n := nRecords / len(cdb.entries)
+		n += n/8 + 1
+		for i := range cdb.entries {
+			cdb.entries[i] = slices.Grow(cdb.entries[i], n)
+		}
+	}
+
+	f, ok := cdb.writer.(*os.File)
+	if !ok {
+		return nil
+	}
+
+	size += cdb.estimatedFooterSize + checksumSize
+	if err := preallocate(f, size); err != nil {
+		return writeErr(StageReserve, cdb.bufferedOffset, err)
+	}
+	cdb.reserved = max(cdb.reserved, size)
+	return nil
+}
@@ -0,0 +1,150 @@
+package cdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrChanged is reported by Reverify when the database on disk no
+// longer matches its checksum, or was truncated.
+var ErrChanged = errors.New("cdb: database changed on disk")
+
+// reverifyConf configures Reverify
+type reverifyConf struct {
+	every  time.Duration
+	rate   int64
+	report func(error)
+}
+
+// WithReverify configures Reverify to recompute the checksum of the
+// database every interval, reading at most rateLimit bytes per second
+// (or as fast as possible if rateLimit <= 0), and to call report with
+// the error of the first pass that fails.
+func WithReverify(every time.Duration, rateLimit int64, report func(error)) Option {
+	return func(o *options) {
+		o.reverify = &reverifyConf{every: every, rate: rateLimit, report: report}
+	}
+}
+
+// Reverify starts a goroutine that re-reads the database and checks it
+// against its checksum as configured by WithReverify, so that a
+// long-running server notices when the file under it rots or is
+// truncated or overwritten. Reverify returns at once, and does nothing
+// without WithReverify.
+//
+// The goroutine waits for the interval before every pass, and stops when
+// ctx is canceled, when the database is closed, or after reporting a
+// failed pass. errors.Is matches the error reported against ErrChanged
+// if the file was changed or truncated; other errors are read
+// errors, or a trailer that no longer parses. Databases without a
+// checksum (see ChecksumNone) only have their size checked.
+//
+// The file is read through the page cache, so rot on the disk under
+// cached pages goes unnoticed until they are evicted. A memory mapped
+// database whose file is truncated between the size check and the read
+// of a pass faults instead, like any other read of the mapping.
+func (cdb *CDB) Reverify(ctx context.Context) {
+	rv := cdb.reverify
+	if rv == nil {
+		return
+	}
+
+	go func() {
+		t := time.NewTimer(rv.every)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			err := cdb.recheck(ctx, rv.rate)
+			switch {
+			case err == nil:
+				t.Reset(rv.every)
+			case ctx.Err() != nil, errors.Is(err, ErrClosed):
+				return
+			default:
+				if rv.report != nil {
+					rv.report(err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// recheck verifies the size and checksum of the database once, reading
+// it at up to rate bytes per second
+func (cdb *CDB) recheck(ctx context.Context, rate int64) error {
+	size := cdb.size + checksumSize
+	st, err := cdb.stat()
+	if err != nil {
+		return err
+	}
+	if st != nil && st.Size() < size {
+		return fmt.Errorf("%w: truncated to %d of %d bytes", ErrChanged, st.Size(), size)
+	}
+
+	r := &pacedReader{ctx: ctx, r: cdb.reader, rate: rate, begin: time.Now()}
+	_, _, err = checkFile(r, size)
+	if errors.Is(err, errChecksum) {
+		return fmt.Errorf("%w: %s", ErrChanged, err)
+	}
+	return err
+}
+
+// stat returns the FileInfo of the database file, or nil if it isn't
+// read from a file
+func (cdb *CDB) stat() (os.FileInfo, error) {
+	f, ok := ungated(cdb.reader).(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return nil, nil
+	}
+
+	if err := cdb.enter(); err != nil {
+		return nil, err
+	}
+	defer cdb.leave()
+	return f.Stat()
+}
+
+// pacedReader is an io.ReaderAt that reads at most rate bytes per
+// second in total, and fails once ctx is done
+type pacedReader struct {
+	ctx   context.Context
+	r     io.ReaderAt
+	rate  int64
+	begin time.Time
+	n     int64
+}
+
+func (p *pacedReader) ReadAt(b []byte, off int64) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.r.ReadAt(b, off)
+	p.n += int64(n)
+	if p.rate <= 0 {
+		return n, err
+	}
+
+	due := time.Duration(float64(p.n) / float64(p.rate) * float64(time.Second))
+	if d := due - time.Since(p.begin); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-p.ctx.Done():
+			return n, p.ctx.Err()
+		case <-t.C:
+		}
+	}
+	return n, err
+}
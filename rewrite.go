@@ -0,0 +1,136 @@
+package cdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Rewrite builds a new database at dst from the records of the database
+// at src; this is the classic way of updating a cdb. apply is called
+// once for every record of src, with the iterator positioned on it, and
+// decides what goes into the new database: it can copy the record with
+// w.Put(it.Key(), it.Value()), write a replacement, or skip the record
+// by writing nothing. After the last record, apply is called once more
+// with a nil iterator to add new records.
+//
+// The new database is written to a temporary file next to dst and
+// renamed into place once complete, so readers never see a partial
+// database; src and dst may be the same path. The new database uses the
+// same hash function as src and stores expiry times if src does; opts
+// apply to both opening src and creating dst. Records dropped by Filter
+// are not passed to apply.
+func Rewrite(src, dst string, apply func(w *Writer, it *Iterator) error, opts ...Option) error {
+	db, err := Open(src, opts...)
+	if err != nil {
+		return err
+	}
+
+	wopts := db.inheritOptions()
+	wopts = append(wopts, opts...)
+
+	tmp := fmt.Sprintf("%s.tmp.%d", dst, os.Getpid())
+	wr, err := Create(tmp, wopts...)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	err = rewrite(wr, db, apply, makeOptions(opts))
+	if err == nil {
+		err = wr.Close()
+	} else {
+		wr.Close()
+	}
+
+	// src must be closed before it can be replaced on Windows
+	db.Close()
+
+	// the blob file moves along with the database
+	if err == nil && wr.blob != nil && wr.blob.owned != nil {
+		err = os.Rename(tmp+".blob", dst+".blob")
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		os.Remove(tmp + ".blob")
+		return fmt.Errorf("rewrite %s: %w", filepath.Base(dst), err)
+	}
+	return nil
+}
+
+func rewrite(wr *Writer, db *CDB, apply func(w *Writer, it *Iterator) error, o *options) error {
+	now := time.Now()
+	iter := db.rawIter()
+	for iter.Next() {
+		// a record shadowed by a tombstone must not come back to life
+		if dup, err := iter.shadowed(); err != nil {
+			return err
+		} else if dup || iter.deleted || expired(iter.expires, now) {
+			continue
+		}
+		if !o.keep(iter.Key(), iter.Value()) {
+			continue
+		}
+		if err := apply(wr, iter); err != nil {
+			return err
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	return apply(wr, nil)
+}
+
+// inheritOptions returns the writer options needed to build a database
+// that is read the same way as this one.
+func (cdb *CDB) inheritOptions() []Option {
+	var opts []Option
+	if cdb.trailer.hash != HashCustom {
+		opts = append(opts, WithHash(cdb.trailer.hash))
+	}
+	if s := cdb.trailer.hashSeed; s != nil {
+		opts = append(opts, WithHashSeed(*s))
+	}
+	if cdb.trailer.expiry {
+		opts = append(opts, WithExpiry())
+	}
+	if cdb.trailer.mph != nil {
+		opts = append(opts, WithMPH())
+	}
+	if n := len(cdb.index); n != defaultTables {
+		opts = append(opts, WithTables(n))
+	}
+	if bi := cdb.trailer.blobs; bi != nil {
+		opts = append(opts, WithBlobs(int(bi.threshold)))
+	}
+	if cdb.trailer.front {
+		opts = append(opts, WithFrontCoding())
+	}
+	if a := cdb.trailer.alignment; a != 0 {
+		opts = append(opts, WithAlignment(int(a)))
+	}
+	if cdb.trailer.compact {
+		opts = append(opts, WithCompactIndex())
+	}
+	if cdb.trailer.crcs != nil {
+		opts = append(opts, WithRecordCRC())
+	}
+	if cdb.trailer.checksum != ChecksumSHA256 {
+		opts = append(opts, WithChecksum(cdb.trailer.checksum))
+	}
+	if cdb.keyFn != nil {
+		opts = append(opts, WithKeyTransform(cdb.trailer.keyTransform, cdb.keyFn))
+	}
+	if n := cdb.trailer.keyFP; n != 0 {
+		opts = append(opts, withStoredFingerprints(int(n)))
+	}
+	return opts
+}
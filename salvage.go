@@ -0,0 +1,220 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// SalvageReport describes what Salvage recovered from a database.
+type SalvageReport struct {
+	// Records is the number of records written to the new database,
+	// including tombstones
+	Records int
+
+	// Damaged lists the regions of the data section that didn't parse
+	// and were skipped, in file order
+	Damaged []SalvageRegion
+
+	// Trailer is false if the trailer couldn't be read; the records
+	// were then read as plain cdb records
+	Trailer bool
+}
+
+// SalvageRegion is a damaged region of a database
+type SalvageRegion struct {
+	Offset int64
+	Length int64
+}
+
+// Salvage writes the readable records of the damaged database at src
+// to a new database at dst, in their original order. It reads neither
+// the checksum nor the hash tables: the data section is parsed record
+// by record, and where a record doesn't parse, Salvage steps forward a
+// byte at a time until it finds a plausible length tuple, one whose
+// record fits in the data section and is followed by another such
+// tuple or by the end of the data. Records with an empty key and value
+// are taken to be zeroed space.
+//
+// Resynchronizing is a heuristic: a damaged region can still yield
+// records that were never written. Check the keys you care about in
+// the result.
+//
+// The new database inherits the settings of src where its trailer is
+// readable; opts apply to both reading src and creating dst. On
+// error, the partially written database is removed.
+func Salvage(src, dst string, opts ...Option) (*SalvageReport, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("can't stat %s: %s", src, err)
+	}
+
+	db, rep, err := salvageDB(f, st.Size(), makeOptions(opts))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+
+	// without the blob file, records with blobs count as damaged
+	if db.trailer.blobs != nil {
+		if bf, err := os.Open(src + ".blob"); err == nil {
+			defer bf.Close()
+			db.blobs = bf
+		}
+	}
+
+	wopts := db.inheritOptions()
+	wopts = append(wopts, opts...)
+	wr, err := Create(dst, wopts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = salvage(wr, db, rep)
+	if err == nil {
+		err = wr.Close()
+	}
+
+	if err != nil {
+		wr.Abort()
+		return nil, err
+	}
+	return rep, nil
+}
+
+// salvageDB sets up a reader for the damaged database in the first
+// size bytes of f, trusting as little of it as it can.
+func salvageDB(f *os.File, size int64, o *options) (*CDB, *SalvageReport, error) {
+	if size < minIndexSize {
+		return nil, nil, fmt.Errorf("cdb too small")
+	}
+
+	db := &CDB{reader: f, size: size - checksumSize}
+	rep := &SalvageReport{}
+
+	// the end of the data section: from the trailer, failing that
+	// the index, failing that the end of the file
+	end := size - checksumSize
+	t, err := readTrailer(f, minIndexSize, end)
+	if err == nil && t != nil {
+		db.trailer = *t
+		rep.Trailer = true
+	} else {
+		db.trailer.count = -1
+		db.trailer.expiry = o.expiry
+		db.trailer.front = o.front
+		end = size
+	}
+
+	var buf [4]byte
+	if _, err := f.ReadAt(buf[:], 0); err != nil {
+		return nil, nil, err
+	}
+	db.index = make(index, db.trailer.indexSize()/8)
+	if data := int64(binary.LittleEndian.Uint32(buf[:])); data >= int64(db.dataStart()) && data <= end {
+		end = data
+	}
+	end = min(end, math.MaxUint32)
+	db.index[0].offset = uint32(end)
+
+	id := o.hash
+	if rep.Trailer {
+		id = db.trailer.hash
+	}
+	if id == HashCustom {
+		id = HashFasthash
+	}
+	db.trailer.hash = id
+
+	db.hasher, err = o.hasher(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, rep, nil
+}
+
+// salvage copies the records of db that parse to wr
+func salvage(wr *Writer, db *CDB, rep *SalvageReport) error {
+	end := db.index[0].offset
+	iter := db.rawIter()
+
+	bad := int64(-1)
+	for iter.pos < end {
+		ok, err := iter.plausible(bad >= 0)
+		if err != nil {
+			return err
+		}
+		if ok {
+			ok = iter.next()
+		}
+
+		if !ok {
+			if bad < 0 {
+				bad = int64(iter.pos)
+			}
+			iter.err = nil
+			iter.pos++
+			continue
+		}
+
+		if bad >= 0 {
+			rep.Damaged = append(rep.Damaged, SalvageRegion{Offset: bad, Length: int64(iter.cur) - bad})
+			bad = -1
+		}
+
+		switch {
+		case db.isTombstone(iter.cur):
+			err = wr.Delete(iter.key)
+		case wr.trailer.expiry:
+			err = wr.PutTTL(iter.key, iter.value, iter.Expires())
+		default:
+			err = wr.Put(iter.key, iter.value)
+		}
+		if err != nil {
+			return err
+		}
+		rep.Records++
+	}
+
+	if bad >= 0 {
+		rep.Damaged = append(rep.Damaged, SalvageRegion{Offset: bad, Length: int64(end) - bad})
+	}
+	return nil
+}
+
+// plausible returns true if the length tuple at the iterator's position
+// could start a record: one that isn't empty and fits in the data
+// section. While resynchronizing, it must also be followed by the end
+// of the data or another tuple that fits.
+func (iter *Iterator) plausible(resync bool) (bool, error) {
+	fits := func(off uint32) (uint32, bool, error) {
+		if int64(off)+8 > int64(iter.endPos) {
+			return 0, false, nil
+		}
+
+		klen, vlen, err := readTuple(iter.db.reader, off)
+		if err != nil {
+			return 0, false, err
+		}
+
+		rend := int64(off) + 8 + int64(klen) + int64(vlen)
+		if (klen == 0 && vlen == 0) || rend > int64(iter.endPos) {
+			return 0, false, nil
+		}
+		return uint32(rend), true, nil
+	}
+
+	next, ok, err := fits(iter.pos)
+	if err != nil || !ok || !resync || next == iter.endPos {
+		return ok, err
+	}
+
+	_, ok, err = fits(next)
+	return ok, err
+}
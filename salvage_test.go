@@ -0,0 +1,90 @@
+package cdb_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"cdb"
+)
+
+func TestSalvage(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 100; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key%03d", i), fmt.Sprintf("value%03d", i)})
+	}
+	makeDBAt(t, "./test/salvage.cdb", recs, cdb.WithExpiry())
+
+	buf, err := os.ReadFile("./test/salvage.cdb")
+	if err != nil {
+		t.Fatalf("Can't read salvage.cdb: %s", err)
+	}
+
+	// every record is 8+6+8+8 bytes; wreck the length of the 11th and
+	// the footer
+	const recSize = 30
+	bad := 2048 + 10*recSize
+	copy(buf[bad:], []byte{0xff, 0xff, 0xff, 0x7f})
+	copy(buf[len(buf)-32-8:], "garbage!")
+
+	err = os.WriteFile("./test/salvage-bad.cdb", buf, 0600)
+	if err != nil {
+		t.Fatalf("Can't write salvage-bad.cdb: %s", err)
+	}
+
+	_, err = cdb.Open("./test/salvage-bad.cdb")
+	if err == nil {
+		t.Fatalf("Opened a damaged database")
+	}
+
+	rep, err := cdb.Salvage("./test/salvage-bad.cdb", "./test/salvaged.cdb", cdb.WithExpiry())
+	if err != nil {
+		t.Fatalf("Can't salvage: %s", err)
+	}
+
+	if rep.Records != 99 || rep.Trailer {
+		t.Fatalf("Expected 99 records without a trailer, saw %+v", rep)
+	}
+	if len(rep.Damaged) != 1 || rep.Damaged[0] != (cdb.SalvageRegion{Offset: int64(bad), Length: recSize}) {
+		t.Fatalf("Expected one damaged record at %d, saw %+v", bad, rep.Damaged)
+	}
+
+	db, err := cdb.Open("./test/salvaged.cdb")
+	if err != nil {
+		t.Fatalf("Can't open salvaged.cdb: %s", err)
+	}
+	defer db.Close()
+
+	for i, r := range recs {
+		v, err := db.Get([]byte(r.key))
+		if err != nil {
+			t.Fatalf("Can't get key %s: %s", r.key, err)
+		}
+
+		if i == 10 {
+			if v != nil {
+				t.Fatalf("Found damaged key %s", r.key)
+			}
+		} else if string(v) != r.val {
+			t.Fatalf("Value mismatch for key %s (exp %s, saw %s)", r.key, r.val, v)
+		}
+	}
+
+	// on error, neither the database nor its blob file is left behind
+	reject := func(key, value []byte) error {
+		if string(key) == "key050" {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+	_, err = cdb.Salvage("./test/salvage-bad.cdb", "./test/salvage-failed.cdb", cdb.WithExpiry(), cdb.WithBlobs(4), cdb.WithSchema(reject))
+	if err == nil {
+		t.Fatalf("Salvage didn't fail")
+	}
+	for _, fn := range []string{"./test/salvage-failed.cdb", "./test/salvage-failed.cdb.blob"} {
+		if _, err := os.Stat(fn); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s left behind: %v", fn, err)
+		}
+	}
+}
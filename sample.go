@@ -0,0 +1,127 @@
+package cdb
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Sample returns n records picked uniformly at random, without
+// replacement, from the live records of the database: the ones Get
+// returns, so tombstones, expired records and records shadowed by an
+// earlier one with the same key are never picked. It returns all the
+// live records, shuffled, if there are no more than n. A nil rng uses
+// the global generator.
+//
+// Records are picked by probing random hash table slots, so a sample
+// costs a few random reads per record regardless of the size of the
+// database. Databases without hash tables (see WithMPH) are sampled in
+// one sequential scan.
+func (cdb *CDB) Sample(n int, rng *rand.Rand) ([]Record, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	intN := rand.IntN
+	if rng != nil {
+		intN = rng.IntN
+	}
+
+	var slots int
+	for _, t := range cdb.index {
+		slots += int(t.length)
+	}
+
+	if slots == 0 || n >= cdb.Len() {
+		return cdb.sampleScan(n, intN)
+	}
+
+	// Half the slots are empty, and a few records are dead; give up
+	// and scan if the probes keep missing.
+	now := time.Now()
+	seen := make(map[uint32]bool, n)
+	recs := make([]Record, 0, n)
+	for tries := 0; len(recs) < n; tries++ {
+		if tries > 16*n+1024 {
+			return cdb.sampleScan(n, intN)
+		}
+
+		s := intN(slots)
+		var t table
+		for _, t = range cdb.index {
+			if s < int(t.length) {
+				break
+			}
+			s -= int(t.length)
+		}
+
+		_, off, err := readTuple(cdb.reader, t.offset+8*uint32(s))
+		if err != nil {
+			return nil, err
+		}
+		if off == 0 || seen[off] || cdb.isTombstone(off) {
+			continue
+		}
+		seen[off] = true
+
+		r, ok, err := cdb.recordAt(off, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			recs = append(recs, r)
+		}
+	}
+	return recs, nil
+}
+
+// recordAt returns the record at off, and false if it has expired or
+// Get would return another record for its key.
+func (cdb *CDB) recordAt(off uint32, now time.Time) (Record, bool, error) {
+	end := cdb.index[0].offset
+	key, err := readKey(cdb.reader, off, end, cdb.trailer.front)
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	first, raw, err := cdb.findStored(key)
+	if err != nil || first != off {
+		return Record{}, false, err
+	}
+
+	exp, v, err := cdb.splitExpiry(off, raw)
+	if err != nil || expired(exp, now) {
+		return Record{}, false, err
+	}
+
+	r := Record{Key: key, Value: v}
+	if exp != 0 {
+		r.Expires = time.Unix(exp, 0)
+	}
+	return r, true, nil
+}
+
+// sampleScan picks n of the live records in one scan of the database,
+// with reservoir sampling.
+func (cdb *CDB) sampleScan(n int, intN func(int) int) ([]Record, error) {
+	var recs []Record
+	var i int
+	iter := cdb.Iter()
+	for iter.Next() {
+		r := Record{Key: iter.Key(), Value: iter.Value(), Expires: iter.Expires()}
+		if len(recs) < n {
+			recs = append(recs, r)
+		} else if j := intN(i + 1); j < n {
+			recs[j] = r
+		}
+		i++
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	for k := len(recs) - 1; k > 0; k-- {
+		j := intN(k + 1)
+		recs[k], recs[j] = recs[j], recs[k]
+	}
+	return recs, nil
+}
@@ -0,0 +1,57 @@
+package cdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// WithSchema makes the writer check every value with validator before
+// it is written, so that malformed values (a protobuf that doesn't
+// parse, JSON of the wrong shape) fail the build instead of surprising
+// a reader in production. Put, PutTTL, PutHashed, PutBucket and
+// PutReader return a *SchemaError naming the key when validator
+// rejects a value, and write nothing; the writer stays usable.
+// validator gets keys as they are passed to Put, before any key
+// transform. Tombstones are not validated. PutReader reads the value into memory
+// to validate it.
+func WithSchema(validator func(key, value []byte) error) Option {
+	return func(o *options) {
+		o.schema = validator
+	}
+}
+
+// SchemaError reports a value rejected by the validator given to
+// WithSchema.
+type SchemaError struct {
+	Key []byte
+	Err error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("cdb: invalid value for key %q: %s", e.Key, e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// ValidJSON is a validator for WithSchema that accepts values that are
+// well-formed JSON.
+func ValidJSON(_, value []byte) error {
+	if !json.Valid(value) {
+		return errors.New("not valid JSON")
+	}
+	return nil
+}
+
+// checkSchema validates a value about to be put
+func (cdb *Writer) checkSchema(key, value []byte) error {
+	if cdb.schema == nil {
+		return nil
+	}
+	if err := cdb.schema(key, value); err != nil {
+		return &SchemaError{Key: append([]byte(nil), key...), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cdb
+
+import (
+	"bytes"
+	"iter"
+)
+
+// All returns an iterator over the records of the database, in the
+// order they were written, for use with range:
+//
+//	for k, v := range db.All() {
+//		...
+//	}
+//	if err := db.IterErr(); err != nil {
+//		...
+//	}
+//
+// Tombstones, expired and shadowed records are skipped, as by Iter. The key and
+// value are only valid until the loop moves to the next record. Breaking
+// out of the loop stops the scan. An error stops it too; IterErr
+// returns it.
+func (cdb *CDB) All() iter.Seq2[[]byte, []byte] {
+	return cdb.Match(nil)
+}
+
+// Match is like All, but only yields the records whose keys start with
+// prefix. It scans the whole database.
+func (cdb *CDB) Match(prefix []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func(key, value []byte) bool) {
+		cdb.iterErr.Store(iterResult{})
+
+		it := cdb.Iter()
+		for it.Next() {
+			if !bytes.HasPrefix(it.key, prefix) {
+				continue
+			}
+			if !yield(it.key, it.value) {
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			cdb.iterErr.Store(iterResult{err})
+		}
+	}
+}
+
+// IterErr returns the error that stopped the last range loop over All
+// or Match, or nil if it ran to the end or was stopped by its body.
+// Loops that run concurrently over the same database share it; use
+// Iter to get the error of each.
+func (cdb *CDB) IterErr() error {
+	r, _ := cdb.iterErr.Load().(iterResult)
+	return r.err
+}
+
+// iterResult is what IterErr returns; atomic.Value can't hold a nil
+// error
+type iterResult struct {
+	err error
+}
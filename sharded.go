@@ -0,0 +1,213 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/opencoff/go-lib/fasthash"
+)
+
+// seed of the hash that routes keys to shards; it differs from the
+// seeds of the table hashes, so the keys of a shard still spread
+// evenly over its tables
+const shardSeed = 0x5bd1e9955bd1e995
+
+// shardOf returns the shard of key among n
+func shardOf(key []byte, n int) int {
+	h := fasthash.Hash64(shardSeed, key)
+	return int((h >> 32) * uint64(n) >> 32)
+}
+
+// ShardWriter spreads the records of one logical database over several
+// files, each under the 4GB limit of a single database.
+type ShardWriter struct {
+	shards []*Writer
+	paths  []string
+}
+
+// ShardedWriter creates n databases at pathFn(0) ... pathFn(n-1) and
+// returns a writer that routes every key to one of them by a hash of
+// the key. opts apply to every shard. Read the set with OpenSharded
+// and the same n and pathFn, or with the reader returned by Freeze.
+func ShardedWriter(n int, pathFn func(i int) string, opts ...Option) (*ShardWriter, error) {
+	if n < 1 || n > 65536 {
+		return nil, fmt.Errorf("cdb: shard count %d out of range", n)
+	}
+
+	s := &ShardWriter{
+		shards: make([]*Writer, 0, n),
+		paths:  make([]string, 0, n),
+	}
+	for i := 0; i < n; i++ {
+		path := pathFn(i)
+		wr, err := Create(path, opts...)
+		if err != nil {
+			s.abort()
+			return nil, err
+		}
+		s.shards = append(s.shards, wr)
+		s.paths = append(s.paths, path)
+	}
+	return s, nil
+}
+
+// shard returns the writer for key, and the key as the writers
+// normalize it
+func (s *ShardWriter) shard(key []byte) (*Writer, []byte) {
+	key = s.shards[0].normKey(key)
+	return s.shards[shardOf(key, len(s.shards))], key
+}
+
+// Put adds a key/value pair to the shard of key.
+func (s *ShardWriter) Put(key, value []byte) error {
+	wr, key := s.shard(key)
+	return wr.Put(key, value)
+}
+
+// PutTTL adds a key/value pair that expires at expiresAt to the shard
+// of key; see Writer.PutTTL.
+func (s *ShardWriter) PutTTL(key, value []byte, expiresAt time.Time) error {
+	wr, key := s.shard(key)
+	return wr.PutTTL(key, value, expiresAt)
+}
+
+// Delete adds a tombstone for key to the shard of key; see
+// Writer.Delete.
+func (s *ShardWriter) Delete(key []byte) error {
+	wr, key := s.shard(key)
+	return wr.Delete(key)
+}
+
+// SetMetadata sets the metadata of every shard; see Writer.SetMetadata.
+func (s *ShardWriter) SetMetadata(meta map[string][]byte) {
+	for _, wr := range s.shards {
+		wr.SetMetadata(meta)
+	}
+}
+
+// Close finalizes the shards in parallel. If any of them fails, all
+// the shard files are removed, so that a failed build never leaves a
+// partial set behind.
+func (s *ShardWriter) Close() error {
+	errs := make([]error, len(s.shards))
+
+	var wg sync.WaitGroup
+	for i, wr := range s.shards {
+		wg.Add(1)
+		go func(i int, wr *Writer) {
+			defer wg.Done()
+			if err := wr.Close(); err != nil {
+				errs[i] = fmt.Errorf("shard %d: %w", i, err)
+			}
+		}(i, wr)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		s.remove()
+		return err
+	}
+	return nil
+}
+
+// Freeze closes the writer like Close and opens the shards for reading.
+func (s *ShardWriter) Freeze(opts ...Option) (*ShardReader, error) {
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+	return openShards(s.paths, opts)
+}
+
+// abort closes and removes the shards created so far
+func (s *ShardWriter) abort() {
+	for _, wr := range s.shards {
+		wr.Abort()
+	}
+}
+
+func (s *ShardWriter) remove() {
+	for _, path := range s.paths {
+		os.Remove(path)
+		os.Remove(path + ".blob")
+	}
+}
+
+// ShardReader reads a database written by ShardedWriter.
+type ShardReader struct {
+	shards []*CDB
+
+	// goroutines per MultiGet; see WithMultiGetWorkers
+	workers int
+}
+
+var _ Reader = &ShardReader{}
+
+// OpenSharded opens the n shards written by ShardedWriter at
+// pathFn(0) ... pathFn(n-1). n must be the number of shards they were
+// written with: keys are looked up in the shard their hash routes them
+// to.
+func OpenSharded(n int, pathFn func(i int) string, opts ...Option) (*ShardReader, error) {
+	if n < 1 || n > 65536 {
+		return nil, fmt.Errorf("cdb: shard count %d out of range", n)
+	}
+
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = pathFn(i)
+	}
+	return openShards(paths, opts)
+}
+
+func openShards(paths []string, opts []Option) (*ShardReader, error) {
+	s := &ShardReader{
+		shards:  make([]*CDB, 0, len(paths)),
+		workers: makeOptions(opts).multiGetWorkers,
+	}
+	for _, path := range paths {
+		db, err := Open(path, opts...)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards = append(s.shards, db)
+	}
+	return s, nil
+}
+
+// Shards returns the databases of the shards, in order.
+func (s *ShardReader) Shards() []*CDB {
+	return append([]*CDB(nil), s.shards...)
+}
+
+// Get returns the value for key, or nil if it can't be found.
+func (s *ShardReader) Get(key []byte) ([]byte, error) {
+	v, _, err := s.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found.
+func (s *ShardReader) Lookup(key []byte) ([]byte, bool, error) {
+	key = s.shards[0].normKey(key)
+	return s.shards[shardOf(key, len(s.shards))].Lookup(key)
+}
+
+// Len returns the number of records in all the shards.
+func (s *ShardReader) Len() int {
+	var n int
+	for _, db := range s.shards {
+		n += db.Len()
+	}
+	return n
+}
+
+// Close closes all the shards.
+func (s *ShardReader) Close() error {
+	var errs []error
+	for _, db := range s.shards {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,131 @@
+package cdb
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrBadSignature is returned by OpenVerified for databases that are
+// not signed, or not signed by the expected key.
+var ErrBadSignature = errors.New("cdb: bad or missing signature")
+
+// Sign makes the writer sign the database with priv when it is
+// finalized. The signature covers the checksum, which covers the
+// whole file (the signature itself is hashed as zeros), so consumers
+// can check with OpenVerified that a database came from the holder of
+// priv and wasn't modified since. Sign must be called before Close or
+// Freeze, and needs a cryptographic checksum: SHA256 (the default) or
+// BLAKE3.
+func (cdb *Writer) Sign(priv ed25519.PrivateKey) error {
+	if len(priv) != ed25519.PrivateKeySize {
+		return fmt.Errorf("cdb: invalid ed25519 private key")
+	}
+
+	switch cdb.trailer.checksum {
+	case ChecksumSHA256, ChecksumBLAKE3:
+	default:
+		return fmt.Errorf("cdb: can't sign a database with a %s checksum", cdb.trailer.checksum)
+	}
+
+	if cdb.signKey == nil {
+		cdb.estimatedFooterSize += 8 + ed25519.SignatureSize
+	}
+	cdb.signKey = priv
+	cdb.trailer.sig = make([]byte, ed25519.SignatureSize)
+	return nil
+}
+
+// writeSignature signs the checksum ck and writes the signature into
+// its place in the trailer.
+func (cdb *Writer) writeSignature(ck []byte) error {
+	sig := ed25519.Sign(cdb.signKey, ck)
+
+	_, err := cdb.writer.Seek(cdb.trailer.sigOff, io.SeekStart)
+	if err != nil {
+		return writeErr(StageSignature, cdb.trailer.sigOff, err)
+	}
+
+	_, err = cdb.writer.Write(sig)
+	if err != nil {
+		return writeErr(StageSignature, cdb.trailer.sigOff, err)
+	}
+
+	cdb.trailer.sig = sig
+	return nil
+}
+
+// OpenVerified is like Open, but also checks that the database was
+// signed by the holder of the private key for pub (see Writer.Sign).
+// The checksum, and that of the blob file if there is one, are always
+// verified, even if WithSkipVerify is given.
+func OpenVerified(path string, pub ed25519.PublicKey, opts ...Option) (*CDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't stat %s: %s", path, err)
+	}
+
+	opts, bf := withBlobFile(path, opts)
+	db, err := newVerified(f, st.Size(), pub, opts)
+	if err != nil {
+		f.Close()
+		if bf != nil {
+			bf.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// not needed after all
+	if bf != nil && db.blobs == nil {
+		bf.Close()
+	}
+	return db, nil
+}
+
+func newVerified(r io.ReaderAt, size int64, pub ed25519.PublicKey, opts []Option) (*CDB, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("cdb: invalid ed25519 public key")
+	}
+
+	ck, t, err := checkFile(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if ck == nil || t == nil || t.sig == nil {
+		return nil, ErrBadSignature
+	}
+
+	switch t.checksum {
+	case ChecksumSHA256, ChecksumBLAKE3:
+	default:
+		return nil, ErrBadSignature
+	}
+
+	if !ed25519.Verify(pub, ck, t.sig) {
+		return nil, ErrBadSignature
+	}
+
+	// the file has just been verified; the blob file is checked
+	// against the checksum it records
+	opts = append(opts[:len(opts):len(opts)], withVerifiedChecksum())
+	return NewWithSize(r, size, opts...)
+}
+
+// withVerifiedChecksum makes the reader skip verifying the checksum of
+// the database, which the caller has just done, but not that of the
+// blob file, whatever WithSkipVerify says
+func withVerifiedChecksum() Option {
+	return func(o *options) {
+		o.skipVerify = false
+		o.checksumVerified = true
+	}
+}
@@ -0,0 +1,92 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// SizeHistSize is the number of buckets in SizeStats.Keys and
+// SizeStats.Values
+const SizeHistSize = 33
+
+// SizeStats summarizes the lengths of the keys and values of the live
+// records in a database, e.g. to decide whether compressing values or
+// aligning them (see WithAlignment) is worthwhile.
+type SizeStats struct {
+	// number of records counted
+	Records int64
+
+	// total length of the keys and values
+	KeyBytes   int64
+	ValueBytes int64
+
+	// the longest key and value
+	MaxKey   int
+	MaxValue int
+
+	// Keys[i] and Values[i] are the number of keys and values whose
+	// length is in [2^(i-1), 2^i); bucket 0 counts empty ones.
+	Keys   [SizeHistSize]int64
+	Values [SizeHistSize]int64
+}
+
+// MeanKey returns the mean key length.
+func (s *SizeStats) MeanKey() float64 {
+	if s.Records == 0 {
+		return 0
+	}
+	return float64(s.KeyBytes) / float64(s.Records)
+}
+
+// MeanValue returns the mean value length.
+func (s *SizeStats) MeanValue() float64 {
+	if s.Records == 0 {
+		return 0
+	}
+	return float64(s.ValueBytes) / float64(s.Records)
+}
+
+// add counts a record with a key and value of the given lengths
+func (s *SizeStats) add(klen int, vlen int64) {
+	s.Records++
+	s.KeyBytes += int64(klen)
+	s.ValueBytes += vlen
+	s.MaxKey = max(s.MaxKey, klen)
+	s.MaxValue = max(s.MaxValue, int(vlen))
+	s.Keys[bits.Len32(uint32(klen))]++
+	s.Values[bits.Len64(uint64(vlen))]++
+}
+
+// SizeStats reads the data section once, sequentially, and returns the
+// length histograms of the live records' keys and values. Tombstones,
+// expired records and alignment padding aren't counted; keys are counted
+// as stored (after WithKeyTransform or WithKeyFingerprint) and values
+// without their expiry time, at their full length if they are in the
+// blob file.
+func (cdb *CDB) SizeStats() (*SizeStats, error) {
+	s := &SizeStats{}
+	err := cdb.walkAt(cdb.dataStart(), cdb.index[0].offset, func(off uint32, key, value []byte, dead bool) bool {
+		if dead {
+			return true
+		}
+
+		vlen := int64(len(value))
+		if cdb.isBlob(off) && len(value) == blobPtrSize {
+			vlen = int64(binary.LittleEndian.Uint64(value[8:16]))
+		}
+		s.add(len(key), vlen)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SizeStats returns the length histograms of the keys and values added
+// so far, counted the same way as CDB.SizeStats but including records
+// that will have expired by the time the database is read. Tombstones
+// aren't counted.
+func (cdb *Writer) SizeStats() SizeStats {
+	return cdb.sizes
+}
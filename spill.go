@@ -0,0 +1,160 @@
+package cdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// number of hash table entries a spilling writer holds in memory
+// before it writes them out
+const spillEntries = 1 << 18
+
+// WithSpill bounds the memory the writer needs for the hash tables of
+// very large builds: instead of holding every entry (8 bytes per
+// record) until the database is finalized, it writes them to a
+// temporary file in dir (os.TempDir() if dir is "") whenever 2MB of
+// them have piled up, and reads them back one table at a time while
+// writing the tables. The entries of each flush are written as one
+// segment per table, so a table is read back with a few large reads.
+// The temporary file is removed when the writer is finalized. Finalize
+// still holds one table in memory, so use WithTables to spread very
+// large builds over more tables. WithSpill can't be used with WithMPH,
+// which needs all the keys in memory.
+func WithSpill(dir string) Option {
+	return func(o *options) {
+		o.spill = true
+		o.spillDir = dir
+	}
+}
+
+// spill holds the hash table entries written out by a spilling writer
+type spill struct {
+	dir string
+	f   *os.File
+	off int64
+
+	// segments of the entries of each table, in order
+	segs [][]segment
+
+	// entries held in memory
+	held int
+}
+
+type segment struct {
+	off int64
+	n   int
+}
+
+func newSpill(dir string, ntables int) *spill {
+	return &spill{dir: dir, segs: make([][]segment, ntables)}
+}
+
+// addEntry adds e to table t, spilling the entries held if there are
+// too many
+func (cdb *Writer) addEntry(t uint32, e entry) error {
+	cdb.entries[t] = append(cdb.entries[t], e)
+
+	s := cdb.spill
+	if s == nil {
+		return nil
+	}
+	if s.held++; s.held < spillEntries {
+		return nil
+	}
+
+	if err := s.write(cdb.entries); err != nil {
+		cdb.failed = fmt.Errorf("cdb: spill: %w", err)
+		return cdb.failed
+	}
+	return nil
+}
+
+// write writes out the entries of all the tables, and empties them
+func (s *spill) write(entries [][]entry) error {
+	if s.f == nil {
+		f, err := os.CreateTemp(s.dir, "cdb-spill-*")
+		if err != nil {
+			return err
+		}
+		s.f = f
+	}
+
+	var buf []byte
+	for t, tab := range entries {
+		if len(tab) == 0 {
+			continue
+		}
+
+		buf = buf[:0]
+		for _, e := range tab {
+			buf = binary.LittleEndian.AppendUint32(buf, e.hash)
+			buf = binary.LittleEndian.AppendUint32(buf, e.offset)
+		}
+		if _, err := s.f.WriteAt(buf, s.off); err != nil {
+			return err
+		}
+
+		s.segs[t] = append(s.segs[t], segment{s.off, len(tab)})
+		s.off += int64(len(buf))
+		entries[t] = tab[:0]
+	}
+	s.held = 0
+	return nil
+}
+
+// table returns the entries of table t: those spilled, followed by mem,
+// the ones still in memory
+func (s *spill) table(t int, mem []entry) ([]entry, error) {
+	n := len(mem)
+	for _, sg := range s.segs[t] {
+		n += sg.n
+	}
+
+	all := make([]entry, 0, n)
+	var buf []entry
+	for _, sg := range s.segs[t] {
+		var err error
+		buf, err = s.readSegment(sg, buf)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, buf...)
+	}
+	return append(all, mem...), nil
+}
+
+// readSegment reads spilled segment sg into buf, and returns it
+func (s *spill) readSegment(sg segment, buf []entry) ([]entry, error) {
+	b := make([]byte, 8*sg.n)
+	if _, err := s.f.ReadAt(b, sg.off); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("cdb: spill: %w", err)
+	}
+
+	buf = buf[:0]
+	for i := 0; i < len(b); i += 8 {
+		buf = append(buf, entry{
+			hash:   binary.LittleEndian.Uint32(b[i:]),
+			offset: binary.LittleEndian.Uint32(b[i+4:]),
+		})
+	}
+	return buf, nil
+}
+
+// close removes the spill file
+func (s *spill) close() {
+	if s != nil && s.f != nil {
+		s.f.Close()
+		os.Remove(s.f.Name())
+		s.f = nil
+	}
+}
+
+// tableEntries returns the entries of table t
+func (cdb *Writer) tableEntries(t int) ([]entry, error) {
+	if cdb.spill == nil {
+		return cdb.entries[t], nil
+	}
+	return cdb.spill.table(t, cdb.entries[t])
+}
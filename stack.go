@@ -0,0 +1,132 @@
+package cdb
+
+import (
+	"errors"
+	"time"
+)
+
+// StackReader is a stack of databases read as one: a base snapshot and
+// the incremental databases built on top of it.
+type StackReader struct {
+	// oldest first
+	dbs []*CDB
+}
+
+var _ Reader = &StackReader{}
+
+// Stack returns a reader over dbs, which are given oldest first (as for
+// Merge). Lookups try the newest database first and stop at the first
+// one that has the key; a tombstone (see Writer.Delete) hides the key
+// in all the databases before it.
+func Stack(dbs ...*CDB) *StackReader {
+	return &StackReader{dbs: dbs}
+}
+
+// Get returns the value for key from the newest database that has it.
+func (s *StackReader) Get(key []byte) ([]byte, error) {
+	v, _, err := s.Lookup(key)
+	return v, err
+}
+
+// Lookup is like Get, but also returns whether the key was found.
+func (s *StackReader) Lookup(key []byte) ([]byte, bool, error) {
+	for i := len(s.dbs) - 1; i >= 0; i-- {
+		v, m, err := s.dbs[i].lookupMatch(key)
+		if err != nil || m != noMatch {
+			return v, m == matchLive, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Close closes all the databases.
+func (s *StackReader) Close() error {
+	var errs []error
+	for _, db := range s.dbs {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// StackIterator iterates the union of the databases of a stack.
+type StackIterator struct {
+	s    *StackReader
+	i    int
+	iter *Iterator
+	now  time.Time
+	err  error
+}
+
+// Iter returns an iterator over the records visible through the stack:
+// each key is returned once, with the value Get returns for it. The
+// records of the newest database come first, each database in file
+// order. Tombstones and expired records are skipped.
+//
+// Every record is probed for in the newer databases; nothing is held
+// in memory.
+func (s *StackReader) Iter() *StackIterator {
+	return &StackIterator{s: s, i: len(s.dbs), now: time.Now()}
+}
+
+// Next advances the iterator to the next visible record. It returns
+// false when there are no more records or on error; see Err.
+func (it *StackIterator) Next() bool {
+	for it.err == nil {
+		if it.iter == nil {
+			if it.i == 0 {
+				return false
+			}
+			it.i--
+			it.iter = it.s.dbs[it.i].rawIter()
+		}
+
+		if !it.iter.Next() {
+			it.err = it.iter.Err()
+			it.iter = nil
+			continue
+		}
+
+		ok, err := it.visible()
+		if err != nil {
+			it.err = err
+		} else if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns true if the current record is the one Get returns
+func (it *StackIterator) visible() (bool, error) {
+	iter := it.iter
+	if dup, err := iter.shadowed(); err != nil || dup {
+		return false, err
+	}
+
+	newer, err := hasKey(it.s.dbs[it.i+1:], iter.key)
+	if err != nil || newer {
+		return false, err
+	}
+	return !iter.deleted && !expired(iter.expires, it.now), nil
+}
+
+// Key returns the current key.
+func (it *StackIterator) Key() []byte {
+	return it.iter.Key()
+}
+
+// Value returns the current value.
+func (it *StackIterator) Value() []byte {
+	return it.iter.Value()
+}
+
+// Expires returns the expiry time of the current record, or the zero
+// time if it never expires.
+func (it *StackIterator) Expires() time.Time {
+	return it.iter.Expires()
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *StackIterator) Err() error {
+	return it.err
+}
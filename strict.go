@@ -0,0 +1,91 @@
+package cdb
+
+import (
+	"fmt"
+)
+
+// OpenStrict is Open, followed by checks of the layout that Open
+// doesn't make: the hash tables must follow each other in order,
+// without gaps or overlaps, from the end of the data section to the
+// trailer (or the end of the file, for databases without one); there
+// must be two slots per record; and the tombstones and blobs recorded
+// in the trailer must lie in the data section. A file that passes its
+// checksum can still fail these checks if it was built by a buggy or
+// hostile writer; long running servers can use OpenStrict to refuse
+// such files up front rather than misbehave later. Errors are
+// *CorruptError.
+//
+// The checks only read the index and the trailer; see Validate for
+// checking the records and hash table slots.
+func OpenStrict(path string, opts ...Option) (*CDB, error) {
+	db, err := Open(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.checkLayout(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// checkLayout makes the checks of OpenStrict
+func (cdb *CDB) checkLayout() error {
+	data := cdb.index[0].offset
+
+	var slots int64
+	end := int64(data)
+	for i, t := range cdb.index {
+		off := int64(t.offset)
+		switch {
+		case off < end && i > 0:
+			return corruptAt(ErrBadIndex, int64(8*i), "table %d overlaps table %d", i, i-1).want(end, off)
+		case off > end:
+			return corruptAt(ErrBadIndex, int64(8*i), "gap before table %d", i).want(end, off)
+		}
+		end = off + 8*int64(t.length)
+		slots += int64(t.length)
+	}
+
+	if x := cdb.trailer.mph; x != nil {
+		if slots != 0 {
+			return corruptAt(ErrBadIndex, 0, "hash tables alongside the mph table").want(0, slots)
+		}
+		if int64(x.off) != end {
+			return corruptAt(ErrBadIndex, int64(x.off), "mph table doesn't follow the hash tables").want(end, int64(x.off))
+		}
+		end += 8 * int64(x.m)
+	} else if n := cdb.trailer.count; n >= 0 && slots != 2*n {
+		return corruptAt(ErrBadIndex, 0, "hash tables don't have two slots per record").want(2*n, slots)
+	}
+
+	// the trailer follows the tables, or the file ends with them
+	tail := cdb.size
+	if cdb.trailer.version > 0 {
+		tail = cdb.trailer.start
+	}
+	if end != tail {
+		return corruptAt(ErrBadIndex, end, "hash tables don't end where the trailer starts").want(tail, end)
+	}
+
+	start := cdb.dataStart()
+	for _, off := range cdb.trailer.tombstones {
+		if off < start || off >= data {
+			return corruptAt(ErrBadTrailer, int64(off), "tombstone outside the data section").want(int64(data), int64(off))
+		}
+	}
+	for _, off := range cdb.trailer.padding {
+		if off < start || off >= data {
+			return corruptAt(ErrBadTrailer, int64(off), "filler record outside the data section").want(int64(data), int64(off))
+		}
+	}
+	if bi := cdb.trailer.blobs; bi != nil {
+		for _, off := range bi.recs {
+			if off < start || off >= data {
+				return corruptAt(ErrBadTrailer, int64(off), "blob record outside the data section").want(int64(data), int64(off))
+			}
+		}
+	}
+	return nil
+}
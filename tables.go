@@ -0,0 +1,60 @@
+package cdb
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// WithTables sets the number of hash tables of a new database: a power
+// of two from 64 to 65536, with 256 being the default and the only
+// count classic cdb readers understand. Each table costs 8 bytes of
+// index, and a key's table is picked by the low bits of its hash. Fewer
+// tables make tiny databases smaller; more make probes of huge
+// databases touch smaller, better cached tables.
+//
+// The count is recorded in the trailer and used by the reader.
+func WithTables(n int) Option {
+	return func(o *options) {
+		o.tables = n
+	}
+}
+
+// bounds on the number of hash tables
+const (
+	defaultTables = 256
+	minTables     = 64
+	maxTables     = 65536
+)
+
+// the smallest head a database can have, the header of a compact
+// index (see WithCompactIndex); no record lies before it
+const minIndexSize = compactHeadSize
+
+// checkTables returns an error unless n is a valid number of tables
+func checkTables(n int) error {
+	if n < minTables || n > maxTables || n&(n-1) != 0 {
+		return fmt.Errorf("cdb: %d hash tables; want a power of two from %d to %d", n, minTables, maxTables)
+	}
+	return nil
+}
+
+// tableFor returns the table for hash in an index of n tables
+func tableFor(hash uint32, n int) uint32 {
+	return hash & uint32(n-1)
+}
+
+// probeStart returns the slot of a table of length l, in an index of n
+// tables, where a probe for hash starts. It uses the hash bits above
+// those that picked the table.
+func probeStart(hash uint32, n int, l uint32) uint32 {
+	return (hash >> bits.Len(uint(n-1))) % l
+}
+
+// dataStart returns the offset of the first record, right after the
+// index
+func (cdb *CDB) dataStart() uint32 {
+	if cdb.trailer.compact {
+		return compactHeadSize
+	}
+	return uint32(8 * len(cdb.index))
+}
@@ -0,0 +1,63 @@
+package cdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ToMap loads the whole database into a map, in one sequential pass
+// over the data section. As with Get, the first record for a key wins,
+// and deleted and expired keys are left out. The map is sized from the
+// record count in the trailer, so it is not grown while it is filled.
+func (cdb *CDB) ToMap() (map[string][]byte, error) {
+	return ToTypedMap(cdb, func(key, value []byte) (string, []byte, error) {
+		return string(key), bytes.Clone(value), nil
+	})
+}
+
+// ToTypedMap is like ToMap, but decodes every record with decode, e.g.
+// to parse the values into structs once at startup. The key and value
+// passed to decode are only valid until it returns. Records are
+// deduplicated by their decoded keys; a key that decodes to the same
+// key as an earlier record is dropped. If decode fails, ToTypedMap
+// stops and returns its error.
+func ToTypedMap[K comparable, V any](db *CDB, decode func(key, value []byte) (K, V, error)) (map[K]V, error) {
+	m := make(map[K]V, db.Len())
+
+	// keys whose first record is a tombstone or has expired
+	var dead map[string]struct{}
+
+	var err error
+	werr := db.walk(db.dataStart(), db.index[0].offset, func(key, value []byte, isDead bool) bool {
+		if _, ok := dead[string(key)]; ok {
+			return true
+		}
+
+		if isDead {
+			if dead == nil {
+				dead = make(map[string]struct{})
+			}
+			dead[string(key)] = struct{}{}
+			return true
+		}
+
+		var k K
+		var v V
+		if k, v, err = decode(key, value); err != nil {
+			err = fmt.Errorf("cdb: ToTypedMap: key %q: %w", key, err)
+			return false
+		}
+
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+		return true
+	})
+	if werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
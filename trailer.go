@@ -0,0 +1,599 @@
+package cdb
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The trailer is a non-standard extension to CDB. It sits between the
+// hash tables and the checksum:
+//
+//	[index][records][hash tables][sections...][footer][checksum]
+//
+// Each section is a little-endian (tag uint32, length uint32) header
+// followed by length bytes of payload. The footer is a fixed 16 bytes:
+// the total length of the sections (uint32), the trailer version
+// (uint32) and an 8 byte magic string. Files without the magic string
+// are treated as having no trailer.
+//
+// Sections are optional unless the feature bitmap (tagFeatures) says
+// otherwise: readers skip sections they don't know, and refuse files
+// with feature bits they don't know.
+//
+// Version 1 files are checksummed in file order. From version 2, the
+// checksum covers everything after the index and then the index, so
+// that the writer can hash the data as it goes and the index, written
+// last, at the end. From version 3, the trailer records the range the
+// checksum covers (tagCoverage) and readers refuse files where it
+// doesn't span exactly the bytes before the checksum; anything written
+// to the file outside the hashed stream shows up as a mismatch rather
+// than as silently unchecked bytes.
+const (
+	trailerVersion = 3
+	footerSize     = 16
+)
+
+var trailerMagic = []byte("cdbtrail")
+
+// section tags
+const (
+	tagHash   uint32 = 1
+	tagSipKey uint32 = 2
+
+	// offsets of tombstone records; see Writer.Delete
+	tagTombstones uint32 = 3
+
+	// values are prefixed with an expiry time; see WithExpiry
+	tagExpiry uint32 = 4
+
+	// user metadata; see Writer.SetMetadata
+	tagMetadata uint32 = 5
+
+	// bitmap of features needed to read the file; see Feature
+	tagFeatures uint32 = 6
+
+	// number of records, including tombstones
+	tagCount uint32 = 7
+
+	// Bloom filter over the key hashes; see WithBloom
+	tagBloom uint32 = 8
+
+	// minimal perfect hash index; see WithMPH
+	tagMPH uint32 = 9
+
+	// keys are front coded; see WithFrontCoding
+	tagFrontCoding uint32 = 10
+
+	// checksum algorithm, if not SHA256; see WithChecksum
+	tagChecksum uint32 = 11
+
+	// ed25519 signature of the checksum; see Writer.Sign. It is always
+	// the first section.
+	tagSignature uint32 = 12
+
+	// number of hash tables, if not 256; see WithTables
+	tagTables uint32 = 13
+
+	// values stored in a blob file; see WithBlobs
+	tagBlobs uint32 = 14
+
+	// directory of namespaces; see Writer.Namespace
+	tagNamespaces uint32 = 15
+
+	// name of the key transform; see WithKeyTransform
+	tagKeyTransform uint32 = 16
+
+	// size of the index, and the offset of the checksum: the checksum
+	// covers [index size, offset) and then [0, index size)
+	tagCoverage uint32 = 17
+
+	// seed of the fasthash function; see WithHashSeed
+	tagHashSeed uint32 = 18
+
+	// size of the key fingerprints stored instead of the keys; see
+	// WithKeyFingerprint
+	tagKeyFingerprint uint32 = 19
+
+	// the alignment of the values and the offsets of the filler
+	// records; see WithAlignment
+	tagAlignment uint32 = 20
+
+	// the lengths of the non-empty hash tables; see WithCompactIndex
+	tagCompactIndex uint32 = 21
+
+	// the offset and CRC of every record; see WithRecordCRC
+	tagRecordCRC uint32 = 22
+)
+
+type trailer struct {
+	// format version, as read from the footer
+	version uint32
+
+	hash HashID
+
+	// fingerprint of the siphash key; 0 if the default key is used
+	sipFP uint64
+
+	// offsets of tombstone records, in increasing order
+	tombstones []uint32
+
+	// every value is prefixed with its expiry time
+	expiry bool
+
+	meta map[string][]byte
+
+	// number of records, including tombstones; -1 if unknown
+	count int64
+
+	bloom *bloom
+
+	// replaces the hash tables if set
+	mph *mphIndex
+
+	// keys are front coded
+	front bool
+
+	checksum Checksum
+
+	// signature, and the file offset of its section's payload
+	sig    []byte
+	sigOff int64
+
+	// number of hash tables; 0 for the default
+	tables uint32
+
+	// the blob file, if any
+	blobs *blobInfo
+
+	// namespace directory, sorted by name
+	namespaces []NamespaceStats
+
+	// name of the key transform, if any
+	keyTransform string
+
+	// the bytes covered by the checksum; nil before version 3
+	cover *coverage
+
+	// seed of the hash function, if not the default
+	hashSeed *uint64
+
+	// size of the key fingerprints stored instead of the keys, if any
+	keyFP uint32
+
+	// file offset of the trailer
+	start int64
+
+	// values start at a multiple of alignment, if set; the offsets
+	// of the filler records that pad them, in increasing order
+	alignment uint32
+	padding   []uint32
+
+	// the index is a header, and the lengths of the non-empty tables
+	// are here
+	compact bool
+	lengths []tableLen
+
+	// the CRCs of the records, in increasing order of offset, if
+	// they were recorded (even if there are no records)
+	crcs []recordCRC
+}
+
+// coverage is the byte range covered by the checksum
+type coverage struct {
+	// the index, hashed last
+	index int64
+
+	// offset of the checksum: everything before it is hashed
+	end int64
+}
+
+// indexSize returns the size of the index described by the trailer,
+// which may be nil.
+func (t *trailer) indexSize() int64 {
+	if t != nil && t.compact {
+		return compactHeadSize
+	}
+	if t == nil || t.tables == 0 {
+		return indexSize
+	}
+	return 8 * int64(t.tables)
+}
+
+// marshal returns the serialized sections and footer
+func (t *trailer) marshal() []byte {
+	var b bytes.Buffer
+
+	if t.sig != nil {
+		putSection(&b, tagSignature, t.sig)
+	}
+
+	if f := t.features(); f != 0 {
+		var fb [8]byte
+		binary.LittleEndian.PutUint64(fb[:], uint64(f))
+		putSection(&b, tagFeatures, fb[:])
+	}
+
+	var h [4]byte
+	binary.LittleEndian.PutUint32(h[:], uint32(t.hash))
+	putSection(&b, tagHash, h[:])
+
+	if t.sipFP != 0 {
+		var fp [8]byte
+		binary.LittleEndian.PutUint64(fp[:], t.sipFP)
+		putSection(&b, tagSipKey, fp[:])
+	}
+
+	if t.hashSeed != nil {
+		var s [8]byte
+		binary.LittleEndian.PutUint64(s[:], *t.hashSeed)
+		putSection(&b, tagHashSeed, s[:])
+	}
+
+	if len(t.tombstones) > 0 {
+		ts := make([]byte, 4*len(t.tombstones))
+		for i, off := range t.tombstones {
+			binary.LittleEndian.PutUint32(ts[i*4:], off)
+		}
+		putSection(&b, tagTombstones, ts)
+	}
+
+	if t.expiry {
+		putSection(&b, tagExpiry, nil)
+	}
+
+	if len(t.meta) > 0 {
+		putSection(&b, tagMetadata, marshalMeta(t.meta))
+	}
+
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(t.count))
+	putSection(&b, tagCount, n[:])
+
+	if t.bloom != nil {
+		putSection(&b, tagBloom, t.bloom.marshal())
+	}
+
+	if t.mph != nil {
+		putSection(&b, tagMPH, t.mph.marshal())
+	}
+
+	if t.front {
+		putSection(&b, tagFrontCoding, nil)
+	}
+
+	if t.checksum != ChecksumSHA256 {
+		var c [4]byte
+		binary.LittleEndian.PutUint32(c[:], uint32(t.checksum))
+		putSection(&b, tagChecksum, c[:])
+	}
+
+	if t.tables != 0 {
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], t.tables)
+		putSection(&b, tagTables, n[:])
+	}
+
+	if t.blobs != nil {
+		putSection(&b, tagBlobs, t.blobs.marshal())
+	}
+
+	if len(t.namespaces) > 0 {
+		putSection(&b, tagNamespaces, marshalNamespaces(t.namespaces))
+	}
+
+	if t.keyTransform != "" {
+		putSection(&b, tagKeyTransform, []byte(t.keyTransform))
+	}
+
+	if t.keyFP != 0 {
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], t.keyFP)
+		putSection(&b, tagKeyFingerprint, n[:])
+	}
+
+	if t.alignment != 0 {
+		putSection(&b, tagAlignment, marshalAlignment(t.alignment, t.padding))
+	}
+
+	if t.compact {
+		putSection(&b, tagCompactIndex, marshalLengths(t.lengths))
+	}
+
+	if t.crcs != nil {
+		putSection(&b, tagRecordCRC, marshalCRCs(t.crcs))
+	}
+
+	if t.cover != nil {
+		var c [16]byte
+		binary.LittleEndian.PutUint64(c[0:8], uint64(t.cover.index))
+		binary.LittleEndian.PutUint64(c[8:16], uint64(t.cover.end))
+		putSection(&b, tagCoverage, c[:])
+	}
+
+	var f [footerSize]byte
+	binary.LittleEndian.PutUint32(f[0:4], uint32(b.Len()))
+	binary.LittleEndian.PutUint32(f[4:8], trailerVersion)
+	copy(f[8:], trailerMagic)
+	b.Write(f[:])
+	return b.Bytes()
+}
+
+func putSection(b *bytes.Buffer, tag uint32, payload []byte) {
+	var h [8]byte
+	binary.LittleEndian.PutUint32(h[0:4], tag)
+	binary.LittleEndian.PutUint32(h[4:8], uint32(len(payload)))
+	b.Write(h[:])
+	b.Write(payload)
+}
+
+// readTrailer reads the trailer that ends at offset 'end' of r. It
+// returns nil if there is no trailer. 'start' is the smallest offset
+// at which the trailer may begin.
+func readTrailer(r io.ReaderAt, start, end int64) (*trailer, error) {
+	if end-start < footerSize {
+		return nil, nil
+	}
+
+	var f [footerSize]byte
+	_, err := r.ReadAt(f[:], end-footerSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(f[8:], trailerMagic) {
+		return nil, nil
+	}
+
+	vers := binary.LittleEndian.Uint32(f[4:8])
+	if vers < 1 || vers > trailerVersion {
+		return nil, fmt.Errorf("cdb: file format version %d not supported (want %d)", vers, trailerVersion)
+	}
+
+	size := int64(binary.LittleEndian.Uint32(f[0:4]))
+	if size > end-start-footerSize {
+		return nil, corruptAt(ErrBadTrailer, end-footerSize, "trailer size exceeds file").want(end-start-footerSize, size)
+	}
+
+	pos := end - footerSize - size
+	buf := make([]byte, size)
+	_, err = r.ReadAt(buf, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	var features Feature
+	t := &trailer{version: vers, count: -1, start: pos}
+	for len(buf) > 0 {
+		if len(buf) < 8 {
+			return nil, corruptAt(ErrBadTrailer, pos, "truncated section")
+		}
+
+		tag := binary.LittleEndian.Uint32(buf[0:4])
+		n := binary.LittleEndian.Uint32(buf[4:8])
+		buf = buf[8:]
+		if uint64(n) > uint64(len(buf)) {
+			return nil, corruptAt(ErrBadTrailer, pos, "section %d too long", tag).want(int64(len(buf)), int64(n))
+		}
+
+		switch tag {
+		case tagFeatures:
+			if n != 8 {
+				return nil, corruptAt(ErrBadTrailer, pos, "malformed feature section")
+			}
+			features = Feature(binary.LittleEndian.Uint64(buf))
+
+		case tagSignature:
+			if n != ed25519.SignatureSize {
+				return nil, corruptAt(ErrBadTrailer, pos, "malformed signature section")
+			}
+			t.sig = buf[:n]
+			t.sigOff = pos + 8
+
+		default:
+			if err := t.parseSection(tag, buf[:n]); err != nil {
+				return nil, corruptAt(ErrBadTrailer, pos, "%w", err)
+			}
+		}
+		buf = buf[n:]
+		pos += 8 + int64(n)
+	}
+
+	if f := features &^ supportedFeatures; f != 0 {
+		return nil, &FeatureError{f}
+	}
+	return t, nil
+}
+
+// parseSection decodes one section; unknown tags are skipped so that
+// older readers can open files with newer, optional sections.
+func (t *trailer) parseSection(tag uint32, b []byte) error {
+	switch tag {
+	case tagHash:
+		if len(b) != 4 {
+			return fmt.Errorf("malformed hash section")
+		}
+		t.hash = HashID(binary.LittleEndian.Uint32(b))
+
+	case tagSipKey:
+		if len(b) != 8 {
+			return fmt.Errorf("malformed siphash key section")
+		}
+		t.sipFP = binary.LittleEndian.Uint64(b)
+
+	case tagHashSeed:
+		if len(b) != 8 {
+			return fmt.Errorf("malformed hash seed section")
+		}
+		seed := binary.LittleEndian.Uint64(b)
+		t.hashSeed = &seed
+
+	case tagTombstones:
+		if len(b)%4 != 0 {
+			return fmt.Errorf("malformed tombstone section")
+		}
+		t.tombstones = make([]uint32, len(b)/4)
+		for i := range t.tombstones {
+			t.tombstones[i] = binary.LittleEndian.Uint32(b[i*4:])
+		}
+
+	case tagExpiry:
+		t.expiry = true
+
+	case tagCount:
+		if len(b) != 8 {
+			return fmt.Errorf("malformed count section")
+		}
+		t.count = int64(binary.LittleEndian.Uint64(b))
+
+	case tagBloom:
+		bf, err := unmarshalBloom(b)
+		if err != nil {
+			return err
+		}
+		t.bloom = bf
+
+	case tagMPH:
+		x, err := unmarshalMPH(b)
+		if err != nil {
+			return err
+		}
+		t.mph = x
+
+	case tagFrontCoding:
+		t.front = true
+
+	case tagChecksum:
+		if len(b) != 4 {
+			return fmt.Errorf("malformed checksum section")
+		}
+		t.checksum = Checksum(binary.LittleEndian.Uint32(b))
+
+	case tagTables:
+		if len(b) != 4 {
+			return fmt.Errorf("malformed tables section")
+		}
+		t.tables = binary.LittleEndian.Uint32(b)
+		if err := checkTables(int(t.tables)); err != nil {
+			return err
+		}
+
+	case tagBlobs:
+		bi, err := unmarshalBlobs(b)
+		if err != nil {
+			return err
+		}
+		t.blobs = bi
+
+	case tagKeyTransform:
+		if len(b) == 0 {
+			return fmt.Errorf("malformed key transform section")
+		}
+		t.keyTransform = string(b)
+
+	case tagKeyFingerprint:
+		if len(b) != 4 {
+			return fmt.Errorf("malformed key fingerprint section")
+		}
+		t.keyFP = binary.LittleEndian.Uint32(b)
+		if t.keyFP != 8 && t.keyFP != 16 {
+			return fmt.Errorf("%d byte key fingerprints", t.keyFP)
+		}
+
+	case tagAlignment:
+		if err := unmarshalAlignment(t, b); err != nil {
+			return err
+		}
+
+	case tagCompactIndex:
+		if err := unmarshalLengths(t, b); err != nil {
+			return err
+		}
+
+	case tagRecordCRC:
+		if err := unmarshalCRCs(t, b); err != nil {
+			return err
+		}
+
+	case tagCoverage:
+		if len(b) != 16 {
+			return fmt.Errorf("malformed coverage section")
+		}
+		t.cover = &coverage{
+			index: int64(binary.LittleEndian.Uint64(b[0:8])),
+			end:   int64(binary.LittleEndian.Uint64(b[8:16])),
+		}
+
+	case tagNamespaces:
+		dir, err := unmarshalNamespaces(b)
+		if err != nil {
+			return err
+		}
+		t.namespaces = dir
+
+	case tagMetadata:
+		m, err := unmarshalMeta(b)
+		if err != nil {
+			return err
+		}
+		t.meta = m
+	}
+	return nil
+}
+
+// marshalMeta encodes m as a sequence of length prefixed name/value
+// pairs, sorted by name.
+func marshalMeta(m map[string][]byte) []byte {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	var n [4]byte
+	for _, k := range names {
+		binary.LittleEndian.PutUint32(n[:], uint32(len(k)))
+		b.Write(n[:])
+		b.WriteString(k)
+
+		v := m[k]
+		binary.LittleEndian.PutUint32(n[:], uint32(len(v)))
+		b.Write(n[:])
+		b.Write(v)
+	}
+	return b.Bytes()
+}
+
+func unmarshalMeta(b []byte) (map[string][]byte, error) {
+	m := make(map[string][]byte)
+	next := func() ([]byte, error) {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated metadata")
+		}
+		n := binary.LittleEndian.Uint32(b)
+		b = b[4:]
+		if uint64(n) > uint64(len(b)) {
+			return nil, fmt.Errorf("truncated metadata")
+		}
+		v := b[:n:n]
+		b = b[n:]
+		return v, nil
+	}
+
+	for len(b) > 0 {
+		k, err := next()
+		if err != nil {
+			return nil, err
+		}
+		v, err := next()
+		if err != nil {
+			return nil, err
+		}
+		m[string(k)] = v
+	}
+	return m, nil
+}
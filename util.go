@@ -3,10 +3,37 @@ package cdb
 import (
 	"encoding/binary"
 	"io"
+	"sync"
 )
 
+// Buffers handed to io.ReaderAt escape to the heap; the reader recycles
+// them through these pools rather than allocating one per read.
+const scratchSize = 4096
+
+var tuplePool = sync.Pool{
+	New: func() interface{} { return new([8]byte) },
+}
+
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, scratchSize)
+		return &b
+	},
+}
+
+func getScratch() *[]byte {
+	return scratchPool.Get().(*[]byte)
+}
+
+func putScratch(b *[]byte) {
+	scratchPool.Put(b)
+}
+
 func readTuple(r io.ReaderAt, offset uint32) (uint32, uint32, error) {
-	tuple := make([]byte, 8)
+	tp := tuplePool.Get().(*[8]byte)
+	defer tuplePool.Put(tp)
+
+	tuple := tp[:]
 	_, err := r.ReadAt(tuple, int64(offset))
 	if err != nil {
 		return 0, 0, err
@@ -25,3 +52,14 @@ func writeTuple(w io.Writer, first, second uint32) error {
 	_, err := w.Write(tuple)
 	return err
 }
+
+// size of the buffer for reading ranges without mmap
+const copyBufSize = 1 << 20
+
+// readRange writes the sz bytes of r at off to w using chunked reads.
+// It works everywhere; copyRange uses mmap where it can.
+func readRange(w io.Writer, r io.ReaderAt, off, sz int64) error {
+	buf := make([]byte, copyBufSize)
+	_, err := io.CopyBuffer(w, io.NewSectionReader(r, off, sz), buf)
+	return err
+}
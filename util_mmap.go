@@ -0,0 +1,18 @@
+//go:build unix
+
+package cdb
+
+import (
+	"io"
+	"os"
+
+	"github.com/opencoff/go-utils"
+)
+
+// copyRange writes the sz bytes of r at off to w. Files are mmap'd.
+func copyRange(w io.Writer, r io.ReaderAt, off, sz int64) error {
+	if f, ok := r.(*os.File); ok {
+		return utils.MmapReader(f, off, sz, w)
+	}
+	return readRange(w, r, off, sz)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package cdb
+
+import (
+	"io"
+)
+
+// copyRange writes the sz bytes of r at off to w. There is no mmap
+// here; files are read in chunks like any other io.ReaderAt.
+func copyRange(w io.Writer, r io.ReaderAt, off, sz int64) error {
+	return readRange(w, r, off, sz)
+}
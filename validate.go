@@ -0,0 +1,120 @@
+package cdb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// WithValidateOnClose makes Writer.Close (and Freeze) re-read the
+// finished database and check its structure with Validate before
+// returning success. This roughly doubles the cost of finalizing; use it
+// where shipping a bad file is worse than a failed build.
+func WithValidateOnClose() Option {
+	return func(o *options) {
+		o.validate = true
+	}
+}
+
+// Validate checks the structure of the database: every record in the
+// data section must parse, and every hash table slot must point to a
+// record whose key hashes to that slot's hash and table. Each record
+// must be reachable from exactly one slot. For a database built
+// WithMPH, the records must map to the slots holding them and every
+// slot must be used; duplicate keys are not reachable.
+//
+// Validate reads the entire database; it keeps 4 bytes per record in
+// memory.
+func (cdb *CDB) Validate() error {
+	end := cdb.index[0].offset
+
+	// pass 1: parse the records and note their offsets
+	var recs []uint32
+	iter := cdb.rawIter()
+	for iter.next() {
+		recs = append(recs, iter.cur)
+	}
+	if err := iter.err; err != nil {
+		if errors.Is(err, ErrCorrupt) {
+			return err
+		}
+		return fmt.Errorf("cdb: record at %d: %w", iter.pos, err)
+	}
+	if iter.pos != end {
+		return corruptAt(ErrBadIndex, 0, "data section ends before the tables").want(int64(end), int64(iter.pos))
+	}
+
+	// pass 2: resolve every slot
+	seen := make([]bool, len(recs))
+	var used int
+	empty := int64(-1)
+	tableOff := end
+	x := cdb.trailer.mph
+	for i, t := range cdb.tables() {
+		if t.offset != tableOff {
+			return corruptAt(ErrBadIndex, int64(8*i), "table %d is misplaced", i).want(int64(tableOff), int64(t.offset))
+		}
+
+		for s := uint32(0); s < t.length; s++ {
+			slotOff := t.offset + 8*s
+			hash, off, err := readTuple(cdb.reader, slotOff)
+			if err != nil {
+				return fmt.Errorf("cdb: table %d slot %d: %w", i, s, err)
+			}
+
+			if hash == 0 && off == 0 {
+				if empty < 0 {
+					empty = int64(slotOff)
+				}
+				continue
+			}
+
+			j := sort.Search(len(recs), func(j int) bool { return recs[j] >= off })
+			if j == len(recs) || recs[j] != off {
+				return corruptAt(ErrBadSlot, int64(slotOff), "no record at %d", off)
+			}
+			if seen[j] {
+				return corruptAt(ErrBadSlot, int64(slotOff), "record at %d referenced twice", off)
+			}
+			seen[j] = true
+			used++
+
+			key, err := readKey(cdb.reader, off, end, cdb.trailer.front)
+			if err != nil {
+				if errors.Is(err, ErrCorrupt) {
+					return err
+				}
+				return fmt.Errorf("cdb: record at %d: %w", off, err)
+			}
+
+			h := cdb.hasher(key)
+			if h != hash {
+				return corruptAt(ErrBadSlot, int64(slotOff), "hash mismatch for record at %d", off).want(int64(h), int64(hash))
+			}
+
+			if n := len(cdb.index); x == nil && tableFor(h, n) != uint32(i) {
+				return corruptAt(ErrBadSlot, int64(slotOff), "record at %d in the wrong table", off).want(int64(tableFor(h, n)), int64(i))
+			} else if x != nil {
+				if ms, ok := x.slot(mphHash(key)); !ok || ms != s {
+					return corruptAt(ErrBadSlot, int64(slotOff), "record at %d maps to a different mph slot", off).want(int64(ms), int64(s))
+				}
+			}
+		}
+
+		tableOff += 8 * t.length
+	}
+
+	if x != nil {
+		if used != int(x.m) {
+			return corruptAt(ErrBadSlot, empty, "empty mph slot").want(int64(x.m), int64(used))
+		}
+		return nil
+	}
+
+	for j, ok := range seen {
+		if !ok {
+			return corruptAt(ErrBadRecord, int64(recs[j]), "record not reachable from the hash tables")
+		}
+	}
+	return nil
+}
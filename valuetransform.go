@@ -0,0 +1,94 @@
+package cdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// WithValueTransform makes the reader pass every value found by Get,
+// Lookup, GetInto and GetAll through fn before returning it, e.g. to
+// decrypt or decompress values or strip an envelope from them; key is
+// the key looked up. An error returned by fn fails the lookup. fn must
+// be safe for concurrent use and must not modify raw, which may be
+// backed by the mapped file.
+//
+// Iterators, Range and the functions that copy databases (Rewrite,
+// Merge and the like) see the raw values, so that copies keep the
+// values as they were written.
+func WithValueTransform(fn func(key, raw []byte) ([]byte, error)) Option {
+	return func(o *options) {
+		o.valueFn = fn
+	}
+}
+
+// match is what a lookup found for a key; the readers layered over
+// several databases need to tell deleted and expired keys from missing
+// ones.
+type match int
+
+const (
+	// no record for the key
+	noMatch match = iota
+
+	// the value of the key was found
+	matchLive
+
+	// the key was deleted by a tombstone
+	matchDeleted
+
+	// the record of the key has expired
+	matchExpired
+)
+
+// get is lookup, with the value checked and transformed
+func (cdb *CDB) get(key []byte) ([]byte, bool, error) {
+	value, m, err := cdb.getMatch(key)
+	return value, m == matchLive, err
+}
+
+// getMatch is get, returning what it found for key
+func (cdb *CDB) getMatch(key []byte) ([]byte, match, error) {
+	value, m, err := cdb.lookup(key)
+	if m != matchLive {
+		return nil, m, err
+	}
+	if !cdb.checkFingerprint(key, value) {
+		return nil, noMatch, nil
+	}
+	if cdb.valueFn == nil {
+		return value, matchLive, nil
+	}
+
+	value, err = cdb.transform(key, value)
+	if err != nil {
+		return nil, noMatch, err
+	}
+	return value, matchLive, nil
+}
+
+// lookupMatch is Lookup for the readers layered over several databases
+func (cdb *CDB) lookupMatch(key []byte) ([]byte, match, error) {
+	if cdb.hook == nil {
+		return cdb.getMatch(key)
+	}
+
+	start := time.Now()
+	value, m, err := cdb.getMatch(key)
+	cdb.hook(key, m == matchLive, len(value), time.Since(start))
+	return value, m, err
+}
+
+// wholeValues returns true if values must be read whole before they
+// are returned
+func (cdb *CDB) wholeValues() bool {
+	return cdb.trailer.front || cdb.valueFn != nil || cdb.keyFPCheck != nil || cdb.verifyReads
+}
+
+// transform applies the value transform to the value of key
+func (cdb *CDB) transform(key, raw []byte) ([]byte, error) {
+	v, err := cdb.valueFn(key, raw)
+	if err != nil {
+		return nil, fmt.Errorf("cdb: value transform: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,59 @@
+package cdb
+
+import (
+	"time"
+)
+
+// size of the reads made by Warm and WarmAll
+const warmChunk = 1 << 20
+
+// Warm reads the header and the hash tables, and looks up keys, so that
+// the pages they live on are in the page cache (or faulted in, for
+// memory mapped databases) before the database takes traffic. Use it
+// with a list of hot keys when the whole file is too big to preload
+// with WarmAll.
+func (cdb *CDB) Warm(keys [][]byte) error {
+	if err := cdb.warmRange(0, int64(cdb.dataStart()), 0); err != nil {
+		return err
+	}
+	if err := cdb.warmRange(int64(cdb.index[0].offset), cdb.size, 0); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if _, _, err := cdb.lookup(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WarmAll reads the whole database through once to pull it into the
+// page cache, reading at most rateLimit bytes per second so as not to
+// starve a live service of disk bandwidth; a rateLimit <= 0 reads as
+// fast as possible.
+func (cdb *CDB) WarmAll(rateLimit int64) error {
+	return cdb.warmRange(0, cdb.size, rateLimit)
+}
+
+// warmRange reads [start, end) of the file at up to rate bytes per
+// second.
+func (cdb *CDB) warmRange(start, end int64, rate int64) error {
+	buf := make([]byte, min(warmChunk, max(end-start, 0)))
+	begin := time.Now()
+	for off := start; off < end; {
+		n := min(int64(len(buf)), end-off)
+		if _, err := cdb.reader.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+		off += n
+
+		if rate > 0 {
+			due := time.Duration(float64(off-start) / float64(rate) * float64(time.Second))
+			if d := due - time.Since(begin); d > 0 {
+				time.Sleep(d)
+			}
+		}
+	}
+	return nil
+}
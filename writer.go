@@ -2,16 +2,18 @@ package cdb
 
 import (
 	"bufio"
-	"crypto/sha256"
+	"crypto/ed25519"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
 	"math"
 	"os"
 	"sync"
-
-	// Sudhi's utility library
-	"github.com/opencoff/go-utils"
+	"time"
 )
 
 var ErrTooMuchData = errors.New("CDB files are limited to 4GB of data")
@@ -19,13 +21,100 @@ var ErrTooMuchData = errors.New("CDB files are limited to 4GB of data")
 // Writer provides an API for creating a CDB database record by record.
 //
 // Close or Freeze must be called to finalize the database, or the resulting
-// file will be invalid.
+// file will be invalid; Abort discards it instead.
 type Writer struct {
 	hasher       func(b []byte) uint32
-	writer       *os.File
-	entries      [256][]entry
+	writer       io.WriteSeeker
+	entries      [][]entry
 	finalizeOnce sync.Once
 
+	// error returned by finalize, or ErrAborted
+	finalErr error
+
+	// path of the file made by Create, removed by Abort
+	path string
+
+	// the files made by CreateAnonymous
+	anon *anonymous
+
+	// the output has been closed
+	released bool
+
+	// trailer being built up
+	trailer trailer
+
+	// validate the database when finalizing
+	validate bool
+
+	// bits per key of the Bloom filter; 0 for none
+	bloomBits int
+
+	// build a minimal perfect hash; the records are collected in
+	// mphKeys instead of entries
+	mph     bool
+	mphKeys []mphKey
+
+	// key to sign the database with; see Sign
+	signKey ed25519.PrivateKey
+
+	// last key stored in full and its offset, when front coding keys
+	frontKey []byte
+	frontOff uint32
+
+	// checksum of everything written after the index; nil for
+	// ChecksumNone
+	checksum *countingHash
+
+	// file size preallocated by Reserve
+	reserved int64
+
+	// values longer than blobThreshold go to blob; see WithBlobs
+	blob          *blobFile
+	blobThreshold int
+	blobRecs      []uint32
+
+	// set when a record was partially written; the database can't be
+	// finalized
+	failed error
+
+	// normalizes keys; see WithKeyTransform
+	keyFn func([]byte) []byte
+
+	// the keys given are already fingerprints; see withStoredFingerprints
+	keyFPStored bool
+
+	// validates values; see WithSchema
+	schema func(key, value []byte) error
+
+	// records written to each namespace; see Namespace
+	namespaces map[string]*NamespaceStats
+
+	// hash table entries written out to a temporary file; see
+	// WithSpill
+	spill *spill
+
+	// build the hash tables with an external sort; see
+	// WithExternalSort
+	extSort bool
+
+	// longest probe chain accepted; see WithProbeLimit
+	probeLimit int
+	probeWarn  func(*ProbeWarning)
+
+	// debug logs; see WithLogger
+	log *slog.Logger
+
+	// passed on to the database returned by Freeze; see WithReverify
+	reverify *reverifyConf
+
+	// key and value lengths; see SizeStats
+	sizes SizeStats
+
+	// records held until finalize to be written in key order; see
+	// WithDeterministic
+	deterministic bool
+	held          []heldRecord
+
 	bufferedWriter      *bufio.Writer
 	bufferedOffset      int64
 	estimatedFooterSize int64
@@ -38,32 +127,106 @@ type entry struct {
 
 // Create opens a CDB database at the given path. If the file exists, it will
 // be overwritten.
-func Create(path string) (*Writer, error) {
+func Create(path string, opts ...Option) (*Writer, error) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewWriter(f, nil)
+	o := makeOptions(opts)
+	if o.logger != nil {
+		opts = append(opts[:len(opts):len(opts)], WithLogger(o.logger.With("path", path)))
+	}
+
+	var bf *os.File
+	if o.blobThreshold > 0 && o.blobWriter == nil {
+		bf, err = os.OpenFile(path+".blob", os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0600)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		opts = append(opts[:len(opts):len(opts)], WithBlobWriter(bf))
+	}
+
+	w, err := NewWriter(f, nil, opts...)
+	if err != nil {
+		f.Close()
+		if bf != nil {
+			bf.Close()
+		}
+		return nil, err
+	}
+
+	if bf != nil {
+		w.blob.owned = bf
+	}
+	w.path = path
+	return w, nil
 }
 
-// NewWriter opens a CDB database for the given io.WriteSeeker.
+// NewWriter opens a CDB database for the given io.WriteSeeker. The
+// checksum is computed as the data is written, so the output is never
+// read back, except by Freeze, WithValidateOnClose and WithMPH, which
+// need an io.ReaderAt. *os.File is all of these.
 //
-// If hasher is nil, it will default to the CDB hash function.
-func NewWriter(writer *os.File, hasher hash.Hash32) (*Writer, error) {
-	// Leave 256 * 8 bytes for the index at the head of the file.
-	_, err := writer.Seek(0, os.SEEK_SET)
-	if err != nil {
+// If hasher is nil, it will default to the hash function selected by
+// WithHash, or the default hash function. A non-nil hasher is recorded
+// as HashCustom; such databases must be read back with New().
+func NewWriter(writer io.WriteSeeker, hasher hash.Hash32, opts ...Option) (*Writer, error) {
+	o := makeOptions(opts)
+
+	ntables := defaultTables
+	if o.tables != 0 {
+		if err := checkTables(o.tables); err != nil {
+			return nil, err
+		}
+		ntables = o.tables
+	}
+	if err := o.checkKeyTransform(); err != nil {
+		return nil, err
+	}
+	if err := o.checkKeyFingerprint(); err != nil {
 		return nil, err
 	}
+	if err := o.checkAlignment(); err != nil {
+		return nil, err
+	}
+	if o.spill && o.mph {
+		return nil, errors.New("cdb: WithSpill can't be used with WithMPH")
+	}
+	if o.compactIndex && o.mph {
+		return nil, errors.New("cdb: WithCompactIndex can't be used with WithMPH")
+	}
 
-	_, err = writer.Write(make([]byte, indexSize))
+	// Leave 8 bytes per table for the index at the head of the file,
+	// or room for the header of a compact index.
+	head := 8 * ntables
+	if o.compactIndex {
+		head = compactHeadSize
+	}
+
+	_, err := writer.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, writeErr(StageIndex, 0, err)
+	}
+
+	_, err = writer.Write(make([]byte, head))
+	if err != nil {
+		return nil, writeErr(StageIndex, 0, err)
+	}
+
+	id := o.hash
+	if id == HashCustom {
+		id = HashFasthash
+	}
+
+	hf, err := o.hasher(id)
 	if err != nil {
 		return nil, err
 	}
 
-	var hf func(b []byte) uint32 = Hash32
 	if hasher != nil {
+		id = HashCustom
 		hf = func(b []byte) uint32 {
 			hasher.Reset()
 			hasher.Write(b)
@@ -71,93 +234,454 @@ func NewWriter(writer *os.File, hasher hash.Hash32) (*Writer, error) {
 		}
 	}
 
-	return &Writer{
+	h, err := newChecksum(o.checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	// everything after the index is hashed on its way out
+	var out io.Writer = writer
+	var hh *countingHash
+	if h != nil {
+		hh = &countingHash{Hash: h}
+		out = io.MultiWriter(writer, hh)
+	}
+
+	w := &Writer{
 		hasher:         hf,
 		writer:         writer,
-		bufferedWriter: bufio.NewWriterSize(writer, 65536),
-		bufferedOffset: indexSize,
-	}, nil
+		entries:        make([][]entry, ntables),
+		checksum:       hh,
+		bufferedWriter: bufio.NewWriterSize(out, o.bufferSize()),
+		bufferedOffset: int64(head),
+	}
+	w.trailer.checksum = o.checksum
+	if ntables != defaultTables {
+		w.trailer.tables = uint32(ntables)
+	}
+
+	w.trailer.hash = id
+	if id == HashSiphash && o.sipKey != nil {
+		w.trailer.sipFP = sipFingerprint(*o.sipKey)
+	}
+	if id == HashFasthash && o.hashSeed != nil {
+		w.trailer.hashSeed = o.hashSeed
+	}
+
+	if o.blobThreshold > 0 {
+		if o.blobWriter == nil {
+			return nil, errors.New("cdb: WithBlobs needs a blob file; use WithBlobWriter")
+		}
+
+		w.blob, err = newBlobFile(o.blobWriter, o.checksum)
+		if err != nil {
+			return nil, err
+		}
+		w.blobThreshold = o.blobThreshold
+	}
+
+	w.validate = o.validate
+	w.bloomBits = o.bloomBits
+	w.mph = o.mph
+	w.deterministic = o.deterministic
+	w.probeLimit, w.probeWarn = o.probeLimit, o.probeWarn
+	w.log = o.logger
+	w.reverify = o.reverify
+	if o.spill {
+		w.spill = newSpill(o.spillDir, ntables)
+		w.extSort = o.extSort
+	}
+	w.schema = o.schema
+	if o.keyFn != nil {
+		w.keyFn = o.keyFn
+		w.trailer.keyTransform = o.keyName
+	}
+	w.trailer.front = o.front
+	w.trailer.compact = o.compactIndex
+	if o.recordCRC {
+		w.trailer.crcs = []recordCRC{}
+	}
+	if o.alignment > 1 {
+		w.trailer.alignment = uint32(o.alignment)
+	}
+	w.trailer.keyFP = uint32(o.keyFPBits / 8)
+	w.keyFPStored = o.keyFPStored
+	if o.expiry {
+		w.trailer.expiry = true
+		w.estimatedFooterSize += 8
+	}
+	return w, nil
+}
+
+// Delete adds a tombstone for key to the database. Get treats the key as
+// absent; when the database is used as a delta in Overlay or Merge, the
+// tombstone deletes the key from the databases under it.
+//
+// A tombstone is stored as a record with an empty value; readers that
+// don't understand the trailer see it as such.
+func (cdb *Writer) Delete(key []byte) error {
+	key = cdb.storeKey(key)
+	return cdb.deleteHashed(cdb.hasher(key), key)
+}
+
+// deleteHashed is Delete for a stored key whose hash is already known
+func (cdb *Writer) deleteHashed(hash uint32, key []byte) error {
+	if cdb.deterministic {
+		cdb.hold(hash, key, nil, nil, true)
+		return nil
+	}
+
+	var hdr []byte
+	if cdb.trailer.expiry {
+		hdr = make([]byte, expirySize)
+	}
+
+	off := uint32(cdb.bufferedOffset)
+	err := cdb.putRecord(hash, key, hdr, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	cdb.trailer.tombstones = append(cdb.trailer.tombstones, off)
+	cdb.estimatedFooterSize += 4
+	return nil
 }
 
 // Put adds a key/value pair to the database. If the amount of data written
 // would exceed the limit, Put returns ErrTooMuchData.
 func (cdb *Writer) Put(key, value []byte) error {
-	entrySize := int64(8 + len(key) + len(value))
+	if err := cdb.checkSchema(key, value); err != nil {
+		return err
+	}
+
+	if cdb.trailer.expiry {
+		var never [8]byte
+		return cdb.put(key, never[:], value)
+	}
+	return cdb.put(key, nil, value)
+}
+
+// put writes a record whose value is hdr followed by value
+func (cdb *Writer) put(key, hdr, value []byte) error {
+	key = cdb.storeKey(key)
+	return cdb.putHashed(cdb.hasher(key), key, hdr, value)
+}
+
+// putHashed is put for a key whose hash is already known
+func (cdb *Writer) putHashed(hash uint32, key, hdr, value []byte) error {
+	if cdb.deterministic {
+		cdb.hold(hash, key, hdr, value, false)
+	} else if err := cdb.putRecord(hash, key, hdr, value, nil, int64(len(value))); err != nil {
+		return err
+	}
+
+	cdb.sizes.add(len(key), int64(len(value)))
+	return nil
+}
+
+// putRecord writes a record whose value is hdr followed by value, or if
+// r is not nil, by the vlen bytes read from r.
+func (cdb *Writer) putRecord(hash uint32, key, hdr, value []byte, r io.Reader, vlen int64) error {
+	if cdb.failed != nil {
+		return cdb.failed
+	}
+
+	size := vlen
+	blob := cdb.blob != nil && vlen > int64(cdb.blobThreshold)
+	if blob {
+		size = blobPtrSize
+	}
+
+	if cdb.trailer.alignment != 0 && !blob && size > 0 {
+		if err := cdb.pad(int64(len(key) + len(hdr))); err != nil {
+			return err
+		}
+	}
+
+	off := uint32(cdb.bufferedOffset)
+	stored := key
+	if cdb.trailer.front {
+		stored = cdb.frontCode(key, off)
+	}
+
+	entrySize := 8 + int64(len(stored)+len(hdr)) + size
 	if (cdb.bufferedOffset + entrySize + cdb.estimatedFooterSize + 16) > math.MaxUint32 {
 		return ErrTooMuchData
 	}
 
-	// Record the entry in the hash table, to be written out at the end.
-	hash := cdb.hasher(key)
-	table := hash & 0xff
+	if blob {
+		var ptr []byte
+		var err error
+		if r != nil {
+			ptr, err = cdb.blob.copy(r, vlen)
+			if err != nil {
+				cdb.failed = err
+			}
+		} else {
+			ptr, err = cdb.blob.write(value)
+		}
+		if err != nil {
+			return err
+		}
+		value, r = ptr, nil
+	}
 
-	entry := entry{hash: hash, offset: uint32(cdb.bufferedOffset)}
-	cdb.entries[table] = append(cdb.entries[table], entry)
+	// Record the entry in the hash table, to be written out at the end.
+	if cdb.mph {
+		cdb.mphKeys = append(cdb.mphKeys, cdb.newMPHKey(hash, key, off))
+	} else {
+		table := tableFor(hash, len(cdb.entries))
+		if err := cdb.addEntry(table, entry{hash: hash, offset: off}); err != nil {
+			return err
+		}
+	}
 
 	// Write the key length, then value length, then key, then value.
-	err := writeTuple(cdb.bufferedWriter, uint32(len(key)), uint32(len(value)))
+	err := writeTuple(cdb.bufferedWriter, uint32(len(stored)), uint32(int64(len(hdr))+size))
 	if err != nil {
-		return err
+		return writeErr(StageData, cdb.bufferedOffset, err)
+	}
+
+	crc := cdb.crcHash()
+	if crc != nil {
+		writeTuple(crc, uint32(len(stored)), uint32(int64(len(hdr))+size))
+		crc.Write(stored)
+		crc.Write(hdr)
+		if r != nil {
+			r = io.TeeReader(r, crc)
+		} else {
+			crc.Write(value)
+		}
 	}
 
-	_, err = cdb.bufferedWriter.Write(key)
+	_, err = cdb.bufferedWriter.Write(stored)
 	if err != nil {
-		return err
+		return writeErr(StageData, cdb.bufferedOffset, err)
 	}
 
-	_, err = cdb.bufferedWriter.Write(value)
+	_, err = cdb.bufferedWriter.Write(hdr)
 	if err != nil {
-		return err
+		return writeErr(StageData, cdb.bufferedOffset, err)
+	}
+
+	if r != nil {
+		err = cdb.copyValue(r, vlen)
+		if err != nil {
+			cdb.failed = writeErr(StageData, cdb.bufferedOffset, err)
+		}
+	} else {
+		_, err = cdb.bufferedWriter.Write(value)
+	}
+	if err != nil {
+		return writeErr(StageData, cdb.bufferedOffset, err)
+	}
+
+	// a key stored in full is the base for the next front coded keys
+	if cdb.trailer.front && stored[0] == 0 {
+		cdb.frontKey = append(cdb.frontKey[:0], key...)
+		cdb.frontOff = off
+	}
+
+	if blob {
+		cdb.blobRecs = append(cdb.blobRecs, off)
+		cdb.estimatedFooterSize += 4
+	}
+
+	if crc != nil {
+		cdb.trailer.crcs = append(cdb.trailer.crcs, recordCRC{off: off, sum: crc.Sum32()})
+		cdb.estimatedFooterSize += 8
 	}
 
 	cdb.bufferedOffset += entrySize
 	cdb.estimatedFooterSize += 16
+	cdb.trailer.count++
 	return nil
 }
 
-// Close finalizes the database, then closes it to further writes.
+// Close finalizes the database, then closes it to further writes. If
+// finalizing fails, or a record failed to be written, the output is
+// closed all the same and the error returned, as it is by every later
+// call; the partially written file is left for Abort to remove.
 //
 // Close or Freeze must be called to finalize the database, or the resulting
 // file will be invalid.
 func (cdb *Writer) Close() error {
-	var err error
-	cdb.finalizeOnce.Do(func() {
-		_, err = cdb.finalize()
-	})
-
-	if err != nil {
-		return err
-	}
-
-	return cdb.writer.Close()
+	_, err := cdb.finish()
+	return errors.Join(err, cdb.release())
 }
 
 // Freeze finalizes the database, then opens it for reads. If the stream cannot
-// be converted to a io.ReaderAt, Freeze will return os.ErrInvalid.
+// be converted to a io.ReaderAt, Freeze will return os.ErrInvalid. The
+// database returned can be rechecked with Reverify if the writer was
+// made WithReverify.
 //
 // Close or Freeze must be called to finalize the database, or the resulting
 // file will be invalid.
 func (cdb *Writer) Freeze() (*CDB, error) {
-	var err error
+	index, err := cdb.finish()
+	if err != nil {
+		return nil, err
+	}
+
+	readerAt, ok := cdb.writer.(io.ReaderAt)
+	if !ok {
+		return nil, os.ErrInvalid
+	}
+
+	// the checksum follows the trailer
+	size := cdb.trailer.cover.end
+	db := &CDB{reader: readerAt, index: index, hasher: cdb.hasher, trailer: cdb.trailer, size: size, blobs: cdb.blob.reader(), keyFn: cdb.keyFn, anon: cdb.anon, reverify: cdb.reverify}
+	db.gate()
+	return db, nil
+}
+
+// ErrAborted is returned by the writer after Abort.
+var ErrAborted = errors.New("cdb: writer aborted")
+
+// Abort discards the database: the records buffered are dropped, the
+// output is closed, and for a writer made by Create, the partially
+// written file and its blob file are removed so that they can't be
+// mistaken for a database later. Every later call on the writer fails
+// with ErrAborted.
+//
+// Abort can be called at any time before the database is finalized,
+// including after Close or Freeze failed; a database finalized
+// successfully is left alone and Abort returns an error. The output of
+// NewWriter is closed if it is an io.Closer, but not removed.
+func (cdb *Writer) Abort() error {
+	cdb.finalizeOnce.Do(func() {
+		cdb.finalErr = ErrAborted
+	})
+	if cdb.finalErr == nil {
+		return errors.New("cdb: can't abort a finalized database")
+	}
+
+	cdb.failed = ErrAborted
+	cdb.entries, cdb.mphKeys, cdb.held = nil, nil, nil
+	cdb.bufferedWriter = nil
+	cdb.spill.close()
+
+	err := cdb.release()
+	if cdb.path != "" {
+		err = errors.Join(err, removeFile(cdb.path))
+		if cdb.blob != nil && cdb.blob.owned != nil {
+			err = errors.Join(err, removeFile(cdb.path+".blob"))
+		}
+		cdb.path = ""
+	}
+	return err
+}
+
+// finish finalizes the database once; later calls return the error of
+// the first.
+func (cdb *Writer) finish() (index, error) {
 	var index index
 	cdb.finalizeOnce.Do(func() {
-		index, err = cdb.finalize()
+		index, cdb.finalErr = cdb.finalize()
 	})
+	return index, cdb.finalErr
+}
 
-	if err != nil {
-		return nil, err
+// release closes the output and the blob file, once
+func (cdb *Writer) release() error {
+	if cdb.released {
+		return nil
 	}
+	cdb.released = true
 
-	readerAt := cdb.writer
-	return &CDB{reader: readerAt, index: index, hasher: cdb.hasher}, nil
+	var err error
+	if cdb.blob != nil && cdb.blob.owned != nil {
+		err = cdb.blob.owned.Close()
+	}
+
+	if c, ok := cdb.writer.(io.Closer); ok {
+		err = errors.Join(c.Close(), err)
+	}
+
+	// an anonymous database can't be linked once closed
+	if cdb.anon != nil {
+		err = errors.Join(err, cdb.anon.discard())
+	}
+	return err
+}
+
+// removeFile removes path; it is not an error if it is already gone.
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// writeSlots writes the slots of a hash table as one block; writing
+// them one at a time dominates finalize for large tables.
+func (cdb *Writer) writeSlots(slots []entry) error {
+	buf := make([]byte, 8*len(slots))
+	for i, e := range slots {
+		binary.LittleEndian.PutUint32(buf[8*i:], e.hash)
+		binary.LittleEndian.PutUint32(buf[8*i+4:], e.offset)
+	}
+
+	if cdb.bufferedOffset+int64(len(buf)) > math.MaxUint32 {
+		return ErrTooMuchData
+	}
+
+	_, err := cdb.bufferedWriter.Write(buf)
+	if err != nil {
+		return writeErr(StageTable, cdb.bufferedOffset, err)
+	}
+	cdb.bufferedOffset += int64(len(buf))
+	return nil
 }
 
 func (cdb *Writer) finalize() (index, error) {
-	var index index
+	defer cdb.spill.close()
+
+	if cdb.failed != nil {
+		return nil, cdb.failed
+	}
+	if err := cdb.writeHeld(); err != nil {
+		return nil, err
+	}
+
+	began := time.Now()
+	cdb.trace("cdb: finalizing", "records", cdb.trailer.count, "bytes", cdb.bufferedOffset)
+
+	n := len(cdb.entries)
+	index := make(index, n)
+
+	var bf *bloom
+	if cdb.bloomBits > 0 {
+		bf = newBloom(int(cdb.trailer.count), cdb.bloomBits)
+	}
+
+	// the table with the longest probe chain
+	var worst *ProbeWarning
+	worse := func(i int, t table, probe uint32) {
+		if worst == nil || probe > worst.MaxProbe {
+			worst = &ProbeWarning{Table: i, Slots: t.length, Records: t.length / 2, MaxProbe: probe}
+		}
+	}
 
 	// Write the hashtables out, one by one, at the end of the file.
-	for i := 0; i < 256; i++ {
-		tableEntries := cdb.entries[i]
+	for i := 0; i < n; i++ {
+		if cdb.extSort {
+			t, probe, err := cdb.writeSortedTable(i, bf)
+			if err != nil {
+				return index, err
+			}
+			index[i] = t
+			worse(i, t, probe)
+			continue
+		}
+
+		tableEntries, err := cdb.tableEntries(i)
+		if err != nil {
+			return index, err
+		}
+		cdb.entries[i] = nil
+
 		tableSize := uint32(len(tableEntries) << 1)
 
 		index[i] = table{
@@ -165,82 +689,194 @@ func (cdb *Writer) finalize() (index, error) {
 			length: tableSize,
 		}
 
+		var probe uint32
 		sorted := make([]entry, tableSize)
 		for _, entry := range tableEntries {
-			slot := (entry.hash >> 8) % tableSize
+			if bf != nil {
+				bf.add(entry.hash)
+			}
+
+			start := probeStart(entry.hash, n, tableSize)
+			slot := start
 
 			for {
-				if sorted[slot].hash == 0 {
+				// records never start at offset 0, which is
+				// the index; any hash, 0 included, is valid
+				if sorted[slot].offset == 0 {
 					sorted[slot] = entry
 					break
 				}
 
 				slot = (slot + 1) % tableSize
 			}
+			probe = max(probe, probeDist(start, slot, tableSize))
 		}
 
-		for _, entry := range sorted {
-			err := writeTuple(cdb.bufferedWriter, entry.hash, entry.offset)
-			if err != nil {
-				return index, err
-			}
+		if err := cdb.writeSlots(sorted); err != nil {
+			return index, err
+		}
+		worse(i, index[i], probe)
+	}
+
+	cdb.trace("cdb: wrote hash tables", "tables", n, "duration", time.Since(began))
+	if err := cdb.checkProbe(worst); err != nil {
+		return index, err
+	}
+
+	// With an MPH, the hash tables above are all empty and the slot
+	// table follows them.
+	if cdb.mph {
+		if err := cdb.writeMPH(); err != nil {
+			return index, err
+		}
+		cdb.trace("cdb: wrote mph", "duration", time.Since(began))
+	}
 
-			cdb.bufferedOffset += 8
-			if cdb.bufferedOffset > math.MaxUint32 {
-				return index, ErrTooMuchData
+	if bf != nil {
+		for _, k := range cdb.mphKeys {
+			bf.add(k.hash)
+		}
+		cdb.trailer.bloom = bf
+	}
+
+	if cdb.blob != nil {
+		size, sum, err := cdb.blob.finish()
+		if err != nil {
+			return index, err
+		}
+
+		if len(cdb.blobRecs) > 0 {
+			cdb.trailer.blobs = &blobInfo{
+				threshold: uint32(min(cdb.blobThreshold, math.MaxUint32)),
+				size:      size,
+				sum:       sum,
+				recs:      cdb.blobRecs,
 			}
 		}
 	}
 
+	if len(cdb.namespaces) > 0 {
+		cdb.trailer.namespaces = cdb.nsDirectory()
+	}
+
+	if cdb.trailer.compact {
+		cdb.trailer.lengths = compactIndex(index)
+	}
+
+	// Append the trailer after the hash tables. The signature section
+	// comes first and is filled in once the checksum is known.
+	if cdb.trailer.sig != nil {
+		cdb.trailer.sigOff = cdb.bufferedOffset + 8
+	}
+
+	// The checksum covers everything up to the end of the trailer; the
+	// trailer records where that is. The coverage section has a fixed
+	// size, so its contents don't change the trailer's length.
+	cover := &coverage{index: cdb.trailer.indexSize()}
+	cdb.trailer.cover = cover
+	cover.end = cdb.bufferedOffset + int64(len(cdb.trailer.marshal()))
+
+	// as a reader would find it; see Freeze
+	cdb.trailer.version = trailerVersion
+	cdb.trailer.start = cdb.bufferedOffset
+
+	_, err := cdb.bufferedWriter.Write(cdb.trailer.marshal())
+	if err != nil {
+		return index, writeErr(StageTrailer, cdb.bufferedOffset, err)
+	}
+
 	// We're done with the buffer.
-	err := cdb.bufferedWriter.Flush()
+	err = cdb.bufferedWriter.Flush()
 	cdb.bufferedWriter = nil
 	if err != nil {
-		return index, err
+		return index, writeErr(StageTrailer, cdb.bufferedOffset, err)
 	}
+	cdb.trace("cdb: wrote trailer", "offset", cdb.bufferedOffset, "bytes", cover.end-cdb.bufferedOffset)
 
 	// Seek to the beginning of the file and write out the index.
-	_, err = cdb.writer.Seek(0, os.SEEK_SET)
+	_, err = cdb.writer.Seek(0, io.SeekStart)
 	if err != nil {
-		return index, err
+		return index, writeErr(StageIndex, 0, err)
 	}
 
-	buf := make([]byte, indexSize)
+	buf := make([]byte, 8*n)
 	for i, table := range index {
 		off := i * 8
 		binary.LittleEndian.PutUint32(buf[off:off+4], table.offset)
 		binary.LittleEndian.PutUint32(buf[off+4:off+8], table.length)
 	}
+	if cdb.trailer.compact {
+		buf = compactHead(index[0].offset)
+	}
 
 	_, err = cdb.writer.Write(buf)
 	if err != nil {
-		return index, err
+		return index, writeErr(StageIndex, 0, err)
+	}
+	cdb.trace("cdb: wrote index", "bytes", len(buf))
+
+	// Finally append the checksum to the end of the file. Everything
+	// after the index has already been hashed on its way out; the
+	// index comes last.
+	ck := make([]byte, checksumSize)
+	if cdb.checksum != nil {
+		cdb.checksum.Write(buf)
+		copy(ck, cdb.checksum.Sum(nil))
 	}
-
-	// Finally calculate a checksum and append it to the end of the
-	// file
-	hh := sha256.New()
-
-	var sz int64
 
 	// Go to the end to append the checksum. As a consequence, we
 	// also get the current file size.
-	sz, err = cdb.writer.Seek(0, os.SEEK_END)
+	sz, err := cdb.writer.Seek(0, io.SeekEnd)
 	if err != nil {
-		return index, err
+		return index, writeErr(StageChecksum, 0, err)
 	}
 
-	err = utils.MmapReader(cdb.writer, 0, sz, hh)
-	if err != nil {
-		return index, err
+	// Every byte before the checksum must have been hashed exactly
+	// once, and the trailer must say so.
+	if sz != cover.end || (cdb.checksum != nil && cdb.checksum.n != cover.end) {
+		return index, fmt.Errorf("cdb: checksum covers %d bytes, file has %d", cover.end, sz)
 	}
 
-	ck := hh.Sum(nil)
-
 	_, err = cdb.writer.Write(ck)
 	if err != nil {
-		return index, err
+		return index, writeErr(StageChecksum, sz, err)
+	}
+	cdb.trace("cdb: wrote checksum", "checksum", cdb.trailer.checksum, "offset", sz)
+
+	// truncating releases the space preallocated past the end
+	if f, ok := cdb.writer.(*os.File); ok && cdb.reserved > 0 {
+		err = f.Truncate(sz + int64(len(ck)))
+		if err != nil {
+			return index, writeErr(StageReserve, sz+int64(len(ck)), err)
+		}
+	}
+
+	if cdb.signKey != nil {
+		err = cdb.writeSignature(ck)
+		if err != nil {
+			return index, err
+		}
+	}
+
+	if cdb.validate {
+		ra, ok := cdb.writer.(io.ReaderAt)
+		if !ok {
+			return index, errors.New("cdb: validation needs an io.ReaderAt output")
+		}
+
+		err = verifyChecksum(ra, sz+int64(len(ck)))
+		if err == nil {
+			db := &CDB{reader: ra, index: index, hasher: cdb.hasher, trailer: cdb.trailer, size: sz, blobs: cdb.blob.reader(), keyFn: cdb.keyFn}
+			err = db.Validate()
+		}
+		if err != nil {
+			return index, fmt.Errorf("cdb: validation failed: %w", err)
+		}
+		cdb.trace("cdb: validated", "duration", time.Since(began))
 	}
 
+	if cdb.log != nil {
+		cdb.log.Debug("cdb: finalized", "records", cdb.trailer.count, "bytes", sz+int64(len(ck)), "duration", time.Since(began))
+	}
 	return index, nil
 }
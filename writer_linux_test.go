@@ -0,0 +1,95 @@
+package cdb_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"cdb"
+)
+
+func TestWriteErrorENOSPC(t *testing.T) {
+	f, err := os.OpenFile("/dev/full", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no /dev/full: %s", err)
+	}
+	defer f.Close()
+
+	_, err = cdb.NewWriter(f, nil)
+	if err == nil {
+		t.Fatalf("Wrote to /dev/full")
+	}
+
+	var we *cdb.WriteError
+	if !errors.As(err, &we) {
+		t.Fatalf("Expected a WriteError, saw %T: %s", err, err)
+	}
+
+	if we.Stage != cdb.StageIndex || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Expected ENOSPC writing the index, saw %s", err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "reserve.cdb")
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	blocks := func() int64 {
+		var st syscall.Stat_t
+		if err := syscall.Stat(fn, &st); err != nil {
+			t.Fatalf("Can't stat %s: %s", fn, err)
+		}
+		return st.Blocks * 512
+	}
+
+	const N = 1000
+	err = wr.Reserve(N, 64<<20)
+	if err != nil {
+		t.Fatalf("Can't reserve: %s", err)
+	}
+	if blocks() < 64<<20 {
+		t.Skipf("no preallocation on this filesystem")
+	}
+
+	for i := 0; i < N; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if err := wr.Put([]byte(k), []byte(k)); err != nil {
+			t.Fatalf("Can't put key %s: %s", k, err)
+		}
+	}
+
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	// the unused space is released
+	if b := blocks(); b > 1<<20 {
+		t.Fatalf("Expected the reservation to be released, %d bytes allocated", b)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	if err := db.Validate(); err != nil || db.Len() != N {
+		t.Fatalf("Bad database after Reserve: %d records, %v", db.Len(), err)
+	}
+
+	wr, err = cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	defer wr.Close()
+
+	if err := wr.Reserve(N, 1<<32); !errors.Is(err, cdb.ErrTooMuchData) {
+		t.Fatalf("Expected ErrTooMuchData, saw %v", err)
+	}
+}
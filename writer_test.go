@@ -0,0 +1,1445 @@
+package cdb_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cdb"
+)
+
+func TestValidateOnClose(t *testing.T) {
+	makeDBAt(t, "./test/valid.cdb", testRecords, cdb.WithValidateOnClose())
+
+	wr, err := cdb.Create("./test/valid.cdb", cdb.WithValidateOnClose(), cdb.WithExpiry())
+	if err != nil {
+		t.Fatalf("Can't create valid.cdb: %s", err)
+	}
+
+	for _, r := range testRecords {
+		err = wr.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Delete([]byte("gone"))
+	if err != nil {
+		t.Fatalf("Can't delete: %s", err)
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze valid.cdb: %s", err)
+	}
+	defer db.Close()
+
+	err = db.Validate()
+	if err != nil {
+		t.Fatalf("Validate failed: %s", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	makeDBAt(t, "./test/rewrite.cdb", testRecords)
+
+	err := cdb.Rewrite("./test/rewrite.cdb", "./test/rewrite.cdb", func(w *cdb.Writer, it *cdb.Iterator) error {
+		if it == nil {
+			return w.Put([]byte("new"), []byte("key"))
+		}
+
+		switch string(it.Key()) {
+		case "abc":
+			return nil
+		case "hello":
+			return w.Put(it.Key(), []byte("there"))
+		}
+		return w.Put(it.Key(), it.Value())
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %s", err)
+	}
+
+	db, err := cdb.Open("./test/rewrite.cdb")
+	if err != nil {
+		t.Fatalf("Can't open rewrite.cdb: %s", err)
+	}
+	defer db.Close()
+
+	exp := map[string]string{
+		"hello": "there",
+		"abc":   "",
+		"123":   "345",
+		"new":   "key",
+	}
+	for k, val := range exp {
+		v, err := db.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("Can't get key %s: %s", k, err)
+		}
+
+		if string(v) != val {
+			t.Fatalf("Value mismatch for key %s (exp %q, saw %q)", k, val, string(v))
+		}
+	}
+}
+
+// TestRewriteDeleted rewrites a database where a key was deleted before
+// it was written again; the record behind the tombstone must stay dead.
+func TestRewriteDeleted(t *testing.T) {
+	fn := "./test/rewrite-del.cdb"
+	wr, err := cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	for _, r := range testRecords {
+		err = wr.Put([]byte(r.key), []byte(r.val))
+		if err != nil {
+			t.Fatalf("Can't put key %s: %s", r.key, err)
+		}
+	}
+
+	err = wr.Delete([]byte("gone"))
+	if err != nil {
+		t.Fatalf("Can't delete key gone: %s", err)
+	}
+	err = wr.Put([]byte("gone"), []byte("back"))
+	if err != nil {
+		t.Fatalf("Can't put key gone: %s", err)
+	}
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	src, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	err = src.Range(func(k, v []byte) bool {
+		if string(k) == "gone" {
+			t.Fatalf("Range yields deleted key")
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Range failed: %s", err)
+	}
+	keys := src.Keys()
+	for keys.Next() {
+		if string(keys.Key()) == "gone" {
+			t.Fatalf("Keys yields deleted key")
+		}
+	}
+	if err := keys.Err(); err != nil {
+		t.Fatalf("Keys failed: %s", err)
+	}
+	src.Close()
+
+	err = cdb.Rewrite(fn, fn, func(w *cdb.Writer, it *cdb.Iterator) error {
+		if it == nil {
+			return nil
+		}
+		return w.Put(it.Key(), it.Value())
+	})
+	if err != nil {
+		t.Fatalf("Rewrite failed: %s", err)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	v, err := db.Get([]byte("gone"))
+	if err != nil || v != nil {
+		t.Fatalf("Deleted key came back: %q, %v", v, err)
+	}
+
+	var n int
+	iter := db.Iter()
+	for iter.Next() {
+		if string(iter.Key()) == "gone" {
+			t.Fatalf("Iter yields deleted key")
+		}
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("Iter failed: %s", err)
+	}
+	if n != len(testRecords) {
+		t.Fatalf("Rewritten db has %d records; exp %d", n, len(testRecords))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	makeDBAt(t, "./test/filter-a.cdb", []kw{
+		{"tmp:1", "x"},
+		{"keep", "old"},
+		{"stale", "old"},
+		{"secret", "password=hunter2"},
+	})
+	makeDBAt(t, "./test/filter-b.cdb", []kw{
+		{"tmp:2", "y"},
+		{"stale", "password=old"},
+		{"new", "value"},
+	})
+
+	a, err := cdb.Open("./test/filter-a.cdb")
+	if err != nil {
+		t.Fatalf("Can't open filter-a.cdb: %s", err)
+	}
+	defer a.Close()
+
+	b, err := cdb.Open("./test/filter-b.cdb")
+	if err != nil {
+		t.Fatalf("Can't open filter-b.cdb: %s", err)
+	}
+	defer b.Close()
+
+	// the latest record of "stale" is dropped, and takes the older one
+	// with it
+	err = cdb.Merge("./test/filtered.cdb", []*cdb.CDB{a, b},
+		cdb.Filter(cdb.DropPrefix([]byte("tmp:"))),
+		cdb.Filter(cdb.DropValueMatching(regexp.MustCompile(`^password=`))))
+	if err != nil {
+		t.Fatalf("Merge failed: %s", err)
+	}
+
+	db, err := cdb.Open("./test/filtered.cdb")
+	if err != nil {
+		t.Fatalf("Can't open filtered.cdb: %s", err)
+	}
+	m, err := db.ToMap()
+	db.Close()
+	if err != nil {
+		t.Fatalf("Can't read filtered.cdb: %s", err)
+	}
+
+	exp := map[string][]byte{"keep": []byte("old"), "new": []byte("value")}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("Merge: exp %q, saw %q", exp, m)
+	}
+
+	// samples are stable, and nested
+	var recs []kw
+	for i := 0; i < 1000; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), "v"})
+	}
+	makeDBAt(t, "./test/sample.cdb", recs)
+
+	sample := func(p float64) map[string][]byte {
+		err := cdb.Rewrite("./test/sample.cdb", "./test/sampled.cdb", func(w *cdb.Writer, it *cdb.Iterator) error {
+			if it == nil {
+				return nil
+			}
+			return w.Put(it.Key(), it.Value())
+		}, cdb.Filter(cdb.KeepSample(p)))
+		if err != nil {
+			t.Fatalf("Rewrite failed: %s", err)
+		}
+
+		db, err := cdb.Open("./test/sampled.cdb")
+		if err != nil {
+			t.Fatalf("Can't open sampled.cdb: %s", err)
+		}
+		defer db.Close()
+
+		m, err := db.ToMap()
+		if err != nil {
+			t.Fatalf("Can't read sampled.cdb: %s", err)
+		}
+		return m
+	}
+
+	half, quarter := sample(0.5), sample(0.25)
+	if n := len(half); n < 400 || n > 600 {
+		t.Fatalf("KeepSample(0.5) kept %d of 1000 keys", n)
+	}
+	if n := len(quarter); n < 150 || n > 350 {
+		t.Fatalf("KeepSample(0.25) kept %d of 1000 keys", n)
+	}
+	for k := range quarter {
+		if _, ok := half[k]; !ok {
+			t.Fatalf("%s: kept at 0.25 but not at 0.5", k)
+		}
+	}
+	if !reflect.DeepEqual(sample(0.5), half) {
+		t.Fatalf("KeepSample(0.5) isn't stable")
+	}
+	if len(sample(0)) != 0 || len(sample(1)) != 1000 {
+		t.Fatalf("KeepSample(0) or KeepSample(1) kept the wrong keys")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	recs := []kw{
+		{"hello", "world"},
+		{"abc", "def"},
+		{"dup", "first"},
+		{"123", "345"},
+		{"dup", "second"},
+	}
+
+	build := func(fn string, order []int, opts ...cdb.Option) []byte {
+		wr, err := cdb.Create(fn, opts...)
+		if err != nil {
+			t.Fatalf("Can't create %s: %s", fn, err)
+		}
+
+		for _, i := range order {
+			if err := wr.Put([]byte(recs[i].key), []byte(recs[i].val)); err != nil {
+				t.Fatalf("Can't put key %s: %s", recs[i].key, err)
+			}
+		}
+		if err := wr.Delete([]byte("gone")); err != nil {
+			t.Fatalf("Can't delete: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Can't close %s: %s", fn, err)
+		}
+
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", fn, err)
+		}
+		return b
+	}
+
+	// the same records in a different order; the duplicates keep theirs
+	fwd := []int{0, 1, 2, 3, 4}
+	rev := []int{3, 2, 1, 4, 0}
+
+	a := build("./test/det-a.cdb", fwd, cdb.WithDeterministic())
+	b := build("./test/det-b.cdb", rev, cdb.WithDeterministic())
+	if !bytes.Equal(a, b) {
+		t.Fatalf("Deterministic builds differ")
+	}
+
+	if bytes.Equal(build("./test/det-c.cdb", fwd), build("./test/det-d.cdb", rev)) {
+		t.Fatalf("Builds in different orders are identical without WithDeterministic")
+	}
+
+	db, err := cdb.Open("./test/det-b.cdb")
+	if err != nil {
+		t.Fatalf("Can't open det-b.cdb: %s", err)
+	}
+	defer db.Close()
+
+	exp := map[string]string{"hello": "world", "abc": "def", "dup": "first", "123": "345"}
+	for k, v := range exp {
+		got, err := db.Get([]byte(k))
+		if err != nil || string(got) != v {
+			t.Fatalf("Get %s: exp %s, saw %s, %v", k, v, got, err)
+		}
+	}
+	if _, ok, _ := db.Lookup([]byte("gone")); ok {
+		t.Fatalf("Deleted key found")
+	}
+
+	var keys []string
+	iter := db.Iter()
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if !slices.IsSorted(keys) {
+		t.Fatalf("Records not in key order: %q", keys)
+	}
+}
+
+func TestPutReader(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithExpiry()}, {cdb.WithBlobs(1024)}} {
+		wr, err := cdb.Create("./test/reader.cdb", opts...)
+		if err != nil {
+			t.Fatalf("Can't create reader.cdb: %s", err)
+		}
+
+		err = wr.PutReader([]byte("big"), uint32(len(big)), bytes.NewReader(big))
+		if err != nil {
+			t.Fatalf("PutReader failed: %s", err)
+		}
+		err = wr.Put([]byte("small"), []byte("value"))
+		if err != nil {
+			t.Fatalf("Can't put key small: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Can't close reader.cdb: %s", err)
+		}
+
+		db, err := cdb.Open("./test/reader.cdb")
+		if err != nil {
+			t.Fatalf("Can't open reader.cdb: %s", err)
+		}
+
+		v, err := db.Get([]byte("big"))
+		if err != nil || !bytes.Equal(v, big) {
+			t.Fatalf("Get big: value mismatch (%d bytes), %v", len(v), err)
+		}
+		v, err = db.Get([]byte("small"))
+		if err != nil || string(v) != "value" {
+			t.Fatalf("Get small: exp value, saw %q, %v", v, err)
+		}
+		db.Close()
+	}
+
+	// a short value makes the writer unusable
+	wr, err := cdb.Create("./test/reader.cdb")
+	if err != nil {
+		t.Fatalf("Can't create reader.cdb: %s", err)
+	}
+	err = wr.PutReader([]byte("short"), 100, bytes.NewReader(big[:10]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("PutReader of short value: exp ErrUnexpectedEOF, saw %v", err)
+	}
+	if err := wr.Put([]byte("next"), []byte("x")); err == nil {
+		t.Fatalf("Put after failed PutReader succeeded")
+	}
+	if err := wr.Close(); err == nil {
+		t.Fatalf("Close after failed PutReader succeeded")
+	}
+}
+
+// TestAbort checks that Abort removes the partial database, and that
+// Close after an error closes the output and keeps failing.
+func TestAbort(t *testing.T) {
+	fn := "./test/abort.cdb"
+	wr, err := cdb.Create(fn, cdb.WithBlobs(4))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := wr.Put([]byte("key"), []byte("a value for the blob file")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := wr.Abort(); err != nil {
+		t.Fatalf("Abort failed: %s", err)
+	}
+	for _, f := range []string{fn, fn + ".blob"} {
+		if _, err := os.Stat(f); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Abort left %s behind: %v", f, err)
+		}
+	}
+	if err := wr.Put([]byte("more"), []byte("x")); !errors.Is(err, cdb.ErrAborted) {
+		t.Fatalf("Put after Abort: exp ErrAborted, saw %v", err)
+	}
+	if err := wr.Close(); !errors.Is(err, cdb.ErrAborted) {
+		t.Fatalf("Close after Abort: exp ErrAborted, saw %v", err)
+	}
+
+	// a failed record fails Close, every time, and Abort cleans up
+	wr, err = cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := wr.PutReader([]byte("short"), 100, bytes.NewReader(nil)); err == nil {
+		t.Fatalf("PutReader of short value succeeded")
+	}
+	err1, err2 := wr.Close(), wr.Close()
+	if !errors.Is(err1, io.ErrUnexpectedEOF) || !errors.Is(err2, io.ErrUnexpectedEOF) {
+		t.Fatalf("Close after failed PutReader: saw %v, then %v", err1, err2)
+	}
+	if err := wr.Abort(); err != nil {
+		t.Fatalf("Abort after failed Close: %s", err)
+	}
+	if _, err := os.Stat(fn); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Abort left %s behind: %v", fn, err)
+	}
+
+	// a finalized database stays
+	makeDBAt(t, fn, testRecords)
+	wr, err = cdb.Create(fn)
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	if err := wr.Abort(); err == nil {
+		t.Fatalf("aborted a finalized database")
+	}
+	if _, err := os.Stat(fn); err != nil {
+		t.Fatalf("Abort removed a finalized database: %s", err)
+	}
+}
+
+// TestCreateAnonymous checks that an anonymous database leaves nothing
+// behind until it is linked.
+func TestCreateAnonymous(t *testing.T) {
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithBlobs(4)}} {
+		dir := t.TempDir()
+		fn := filepath.Join(dir, "anon.cdb")
+
+		wr, err := cdb.CreateAnonymous(dir, opts...)
+		if err != nil {
+			t.Fatalf("CreateAnonymous failed: %s", err)
+		}
+		for _, r := range testRecords {
+			if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+
+		db, err := wr.Freeze()
+		if err != nil {
+			t.Fatalf("Freeze failed: %s", err)
+		}
+		if err := db.LinkAt(fn); err != nil {
+			t.Fatalf("LinkAt failed: %s", err)
+		}
+		if err := db.LinkAt(fn); !errors.Is(err, os.ErrExist) {
+			t.Fatalf("LinkAt over an existing file: exp ErrExist, saw %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		db, err = cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		for _, r := range testRecords {
+			v, err := db.Get([]byte(r.key))
+			if err != nil || string(v) != r.val {
+				t.Fatalf("Get %q: exp %q, saw %q, %v", r.key, r.val, v, err)
+			}
+		}
+		db.Close()
+		os.Remove(fn)
+		os.Remove(fn + ".blob")
+
+		// closed without being linked
+		wr, err = cdb.CreateAnonymous(dir, opts...)
+		if err != nil {
+			t.Fatalf("CreateAnonymous failed: %s", err)
+		}
+		if err := wr.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+		if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 0 {
+			t.Fatalf("anonymous database left %q behind", names)
+		}
+		if names, _ := filepath.Glob(filepath.Join(dir, ".*")); len(names) != 0 {
+			t.Fatalf("anonymous database left %q behind", names)
+		}
+	}
+}
+
+// TestAlignment checks that WithAlignment starts every value at the
+// alignment, and that the filler records are invisible to readers.
+func TestAlignment(t *testing.T) {
+	const align = 4096
+
+	var recs []kw
+	for i := 0; i < 50; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i*37), string(bytes.Repeat([]byte{byte('a' + i%26)}, i*100+1))})
+	}
+
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithExpiry()}} {
+		fn := "./test/align.cdb"
+		makeDBAt(t, fn, recs, append(opts, cdb.WithAlignment(align))...)
+
+		db, err := cdb.OpenStrict(fn)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		if err := db.Validate(); err != nil {
+			t.Fatalf("Validate failed: %s", err)
+		}
+		if db.Features()&cdb.FeatureAlignment == 0 {
+			t.Fatalf("alignment isn't recorded: %s", db.Features())
+		}
+
+		for _, r := range recs {
+			off, ok, err := db.RecordOffset([]byte(r.key))
+			if err != nil || !ok {
+				t.Fatalf("RecordOffset %q: %v, %v", r.key, ok, err)
+			}
+			rec, err := db.RawRecord(off)
+			if err != nil {
+				t.Fatalf("RawRecord %d: %s", off, err)
+			}
+
+			// the value proper ends the record
+			voff := int64(off) + int64(len(rec.Bytes)-len(r.val))
+			if voff%align != 0 {
+				t.Fatalf("value of %q starts at %d", r.key, voff)
+			}
+
+			v, err := db.Get([]byte(r.key))
+			if err != nil || string(v) != r.val {
+				t.Fatalf("Get %q: %d bytes, %v", r.key, len(v), err)
+			}
+		}
+
+		var n int
+		iter := db.Iter()
+		for ; iter.Next(); n++ {
+			if string(iter.Key()) != recs[n].key || string(iter.Value()) != recs[n].val {
+				t.Fatalf("record %d: exp %q, saw %q", n, recs[n].key, iter.Key())
+			}
+		}
+		if err := iter.Err(); err != nil || n != len(recs) {
+			t.Fatalf("iterated %d of %d records: %v", n, len(recs), err)
+		}
+
+		n = 0
+		keys := db.Keys()
+		for keys.Next() {
+			n++
+		}
+		if err := keys.Err(); err != nil || n != len(recs) {
+			t.Fatalf("Keys returned %d of %d keys: %v", n, len(recs), err)
+		}
+
+		n = 0
+		err = db.Range(func(k, v []byte) bool {
+			n++
+			return true
+		})
+		if err != nil || n != len(recs) {
+			t.Fatalf("Range visited %d of %d records: %v", n, len(recs), err)
+		}
+		db.Close()
+	}
+
+	for _, opts := range [][]cdb.Option{{cdb.WithAlignment(1000)}, {cdb.WithAlignment(4096), cdb.WithFrontCoding()}} {
+		if _, err := cdb.Create("./test/align.cdb", opts...); err == nil {
+			t.Fatalf("Create with %d options succeeded", len(opts))
+		}
+	}
+}
+
+// TestCompactIndex checks that a database with a compact index is
+// smaller and reads like one with a dense index.
+func TestCompactIndex(t *testing.T) {
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithTables(64)}, {cdb.WithExpiry(), cdb.WithBloom(10)}} {
+		dense, compact := "./test/dense.cdb", "./test/compact.cdb"
+		makeDBAt(t, dense, testRecords, opts...)
+		makeDBAt(t, compact, testRecords, append(opts, cdb.WithCompactIndex())...)
+
+		ds, err := os.Stat(dense)
+		if err != nil {
+			t.Fatalf("Can't stat %s: %s", dense, err)
+		}
+		cs, err := os.Stat(compact)
+		if err != nil {
+			t.Fatalf("Can't stat %s: %s", compact, err)
+		}
+		if cs.Size() >= ds.Size()-256 {
+			t.Fatalf("compact index is %d bytes, dense %d", cs.Size(), ds.Size())
+		}
+
+		db, err := cdb.OpenStrict(compact)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", compact, err)
+		}
+		if err := db.Validate(); err != nil {
+			t.Fatalf("Validate failed: %s", err)
+		}
+		if db.Features()&cdb.FeatureCompactIndex == 0 {
+			t.Fatalf("compact index isn't recorded: %s", db.Features())
+		}
+		for _, r := range testRecords {
+			v, err := db.Get([]byte(r.key))
+			if err != nil || string(v) != r.val {
+				t.Fatalf("Get %q: exp %q, saw %q, %v", r.key, r.val, v, err)
+			}
+		}
+		if _, ok, err := db.Lookup([]byte("missing")); ok || err != nil {
+			t.Fatalf("Lookup missing: %v, %v", ok, err)
+		}
+
+		var n int
+		iter := db.Iter()
+		for ; iter.Next(); n++ {
+		}
+		if err := iter.Err(); err != nil || n != len(testRecords) {
+			t.Fatalf("iterated %d of %d records: %v", n, len(testRecords), err)
+		}
+		db.Close()
+	}
+
+	// the header flags must agree with the trailer
+	fn := "./test/compact.cdb"
+	makeDBAt(t, fn, testRecords, cdb.WithCompactIndex())
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", fn, err)
+	}
+	b[4] = 0
+	if err := os.WriteFile(fn, b, 0644); err != nil {
+		t.Fatalf("Can't write %s: %s", fn, err)
+	}
+	if _, err := cdb.Open(fn, cdb.WithSkipVerify()); !errors.Is(err, cdb.ErrCorrupt) {
+		t.Fatalf("Open with bad header flags: %v", err)
+	}
+
+	if _, err := cdb.Create(fn, cdb.WithCompactIndex(), cdb.WithMPH()); err == nil {
+		t.Fatalf("Create WithCompactIndex and WithMPH succeeded")
+	}
+}
+
+// TestProbeLimit checks that the writer reports the probe chains that
+// duplicate keys make.
+func TestProbeLimit(t *testing.T) {
+	recs := append([]kw(nil), testRecords...)
+	for i := 0; i < 100; i++ {
+		recs = append(recs, kw{"dup", fmt.Sprint(i)})
+	}
+
+	fn := "./test/probe.cdb"
+	var warned []*cdb.ProbeWarning
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithExternalSort(t.TempDir())}} {
+		makeDBAt(t, fn, recs, append(opts, cdb.WithProbeLimit(10, func(w *cdb.ProbeWarning) {
+			warned = append(warned, w)
+		}))...)
+
+		db, err := cdb.Open(fn)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		l, err := db.Layout()
+		db.Close()
+		if err != nil {
+			t.Fatalf("Layout failed: %s", err)
+		}
+
+		w := warned[len(warned)-1]
+		if got := l.Tables[w.Table].MaxProbe; w.MaxProbe != got || w.MaxProbe < 50 || w.Limit != 10 {
+			t.Fatalf("warning for table %d: probe %d, limit %d; layout says %d", w.Table, w.MaxProbe, w.Limit, got)
+		}
+	}
+	if len(warned) != 2 {
+		t.Fatalf("warned %d times", len(warned))
+	}
+
+	// no hook: the build fails
+	wr, err := cdb.Create(fn, cdb.WithProbeLimit(10, nil))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	for _, r := range recs {
+		if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	var pw *cdb.ProbeWarning
+	if err := wr.Close(); !errors.As(err, &pw) {
+		t.Fatalf("Close: exp a ProbeWarning, saw %v", err)
+	}
+	wr.Abort()
+
+	// under the limit
+	makeDBAt(t, fn, testRecords, cdb.WithProbeLimit(10, nil))
+}
+
+// TestBatch checks that the records of a batch are added only when it
+// is committed.
+func TestBatch(t *testing.T) {
+	fn := "./test/batch.cdb"
+	wr, err := cdb.Create(fn, cdb.WithSchema(cdb.ValidJSON), cdb.WithExpiry())
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	b := wr.Batch()
+	if err := b.Put([]byte("a"), []byte(`"a"`)); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := b.PutTTL([]byte("b"), []byte(`"b"`), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PutTTL failed: %s", err)
+	}
+	if err := b.Delete([]byte("c")); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if err := b.Commit(); err != nil || b.Len() != 0 {
+		t.Fatalf("Commit failed: %v, %d left", err, b.Len())
+	}
+	if err := b.Put([]byte("d"), []byte(`"d"`)); !errors.Is(err, cdb.ErrBatchDone) {
+		t.Fatalf("Put after Commit: exp ErrBatchDone, saw %v", err)
+	}
+
+	// a unit that fails part way is dropped
+	b = wr.Batch()
+	if err := b.Put([]byte("e"), []byte(`"e"`)); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	var se *cdb.SchemaError
+	if err := b.Put([]byte("f"), []byte("not json")); !errors.As(err, &se) {
+		t.Fatalf("Put of bad value: exp a SchemaError, saw %v", err)
+	}
+	b.Discard()
+
+	if err := wr.Put([]byte("c"), []byte(`"c"`)); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+	for k, exp := range map[string]bool{"a": true, "b": true, "c": false, "e": false, "f": false} {
+		if _, ok, err := db.Lookup([]byte(k)); ok != exp || err != nil {
+			t.Fatalf("Lookup %q: exp %v, saw %v, %v", k, exp, ok, err)
+		}
+	}
+
+	// tombstones of a deterministic writer storing key fingerprints
+	wr, err = cdb.Create(fn, cdb.WithDeterministic(), cdb.WithKeyFingerprint(64))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	b = wr.Batch()
+	b.Delete([]byte("x"))
+	b.Put([]byte("x"), []byte("1"))
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	db2, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db2.Close()
+	if _, ok, err := db2.Lookup([]byte("x")); ok || err != nil {
+		t.Fatalf("Lookup of deleted key: %v, %v", ok, err)
+	}
+}
+
+func TestSizeStats(t *testing.T) {
+	fn := "./test/sizestats.cdb"
+	wr, err := cdb.Create(fn, cdb.WithBlobs(64))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	puts := []kw{
+		{"a", ""},
+		{"bb", "x"},
+		{"ccc", "yyyy"},
+		{"dddd", strings.Repeat("z", 100)},
+	}
+	for _, r := range puts {
+		if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err := wr.PutReader([]byte("e"), 3, strings.NewReader("abc")); err != nil {
+		t.Fatalf("PutReader failed: %s", err)
+	}
+	if err := wr.Delete([]byte("a")); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+
+	ws := wr.SizeStats()
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	if ws.Records != 5 || ws.KeyBytes != 11 || ws.ValueBytes != 108 || ws.MaxKey != 4 || ws.MaxValue != 100 {
+		t.Fatalf("writer stats: saw %+v", ws)
+	}
+	// values of 0, 1, 4, 100 and 3 bytes
+	exp := [cdb.SizeHistSize]int64{0: 1, 1: 1, 2: 1, 3: 1, 7: 1}
+	if ws.Values != exp {
+		t.Fatalf("writer value histogram: exp %v, saw %v", exp, ws.Values)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	rs, err := db.SizeStats()
+	if err != nil {
+		t.Fatalf("SizeStats failed: %s", err)
+	}
+	if *rs != ws {
+		t.Fatalf("reader stats: exp %+v, saw %+v", ws, *rs)
+	}
+}
+
+func TestRecordCRC(t *testing.T) {
+	fn := "./test/crc.cdb"
+	for _, opts := range [][]cdb.Option{nil, {cdb.WithExpiry()}, {cdb.WithFrontCoding()}} {
+		wr, err := cdb.Create(fn, append(opts, cdb.WithRecordCRC())...)
+		if err != nil {
+			t.Fatalf("Can't create %s: %s", fn, err)
+		}
+		for _, r := range testRecords {
+			if err := wr.Put([]byte(r.key), []byte(r.val)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+		if err := wr.PutReader([]byte("streamed"), 5, strings.NewReader("value")); err != nil {
+			t.Fatalf("PutReader failed: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		db, err := cdb.Open(fn, cdb.WithVerifyEveryRead())
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		for _, r := range append(testRecords, kw{"streamed", "value"}) {
+			v, err := db.Get([]byte(r.key))
+			if err != nil || string(v) != r.val {
+				t.Fatalf("Get %q: exp %q, saw %q, %v", r.key, r.val, v, err)
+			}
+		}
+
+		// damage a value under the open database
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", fn, err)
+		}
+		f, err := os.OpenFile(fn, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("Can't open %s: %s", fn, err)
+		}
+		_, err = f.WriteAt([]byte("V"), int64(bytes.Index(b, []byte("value"))))
+		f.Close()
+		if err != nil {
+			t.Fatalf("Can't damage %s: %s", fn, err)
+		}
+
+		var ce *cdb.CorruptError
+		if _, err := db.Get([]byte("streamed")); !errors.As(err, &ce) || !errors.Is(err, cdb.ErrBadRecord) {
+			t.Fatalf("Get of a damaged record: exp ErrBadRecord, saw %v", err)
+		}
+		if _, _, err := db.GetInto([]byte("streamed"), make([]byte, 64)); !errors.Is(err, cdb.ErrBadRecord) {
+			t.Fatalf("GetInto of a damaged record: exp ErrBadRecord, saw %v", err)
+		}
+		db.Close()
+	}
+
+	makeDBAt(t, fn, testRecords)
+	if _, err := cdb.Open(fn, cdb.WithVerifyEveryRead()); !errors.Is(err, cdb.ErrNoRecordCRC) {
+		t.Fatalf("Open without CRCs: exp ErrNoRecordCRC, saw %v", err)
+	}
+}
+
+func TestBuildFromSeq(t *testing.T) {
+	fn := "./test/seq.cdb"
+	const n = 10000
+	seq := func(yield func(k, v []byte) bool) {
+		var k, v []byte
+		for i := range n {
+			k = fmt.Appendf(k[:0], "key%d", i)
+			v = fmt.Appendf(v[:0], "value%d", i)
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+
+	var reports []cdb.Progress
+	err := cdb.BuildFromSeq(fn, seq, cdb.WithProgress(n, 0, func(p *cdb.Progress) {
+		reports = append(reports, *p)
+	}))
+	if err != nil {
+		t.Fatalf("BuildFromSeq failed: %s", err)
+	}
+
+	if len(reports) < 2 {
+		t.Fatalf("saw %d progress reports", len(reports))
+	}
+	mid, last := reports[0], reports[len(reports)-1]
+	if eta, ok := mid.ETA(); !ok || eta < 0 || mid.Done {
+		t.Fatalf("first report: %+v, ETA %s, %v", mid, eta, ok)
+	}
+	if !last.Done || last.Records != n || last.Total != n {
+		t.Fatalf("last report: %+v", last)
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open %s: %s", fn, err)
+	}
+	defer db.Close()
+	if v, err := db.Get([]byte("key1234")); err != nil || string(v) != "value1234" {
+		t.Fatalf("Get key1234: saw %q, %v", v, err)
+	}
+
+	// a failed build stops the iterator and removes the output
+	wrote := 0
+	bad := func(yield func(k, v []byte) bool) {
+		for _, k := range []string{"a", "b", "c"} {
+			wrote++
+			if !yield([]byte(k), []byte("not json")) {
+				return
+			}
+		}
+	}
+	err = cdb.BuildFromSeq(fn, bad, cdb.WithSchema(cdb.ValidJSON))
+	if err == nil || wrote != 1 {
+		t.Fatalf("BuildFromSeq with bad values: %d yielded, %v", wrote, err)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("failed build left %s behind: %v", fn, err)
+	}
+}
+
+func TestShardedWriter(t *testing.T) {
+	path := func(i int) string {
+		return fmt.Sprintf("./test/shard-%d.cdb", i)
+	}
+
+	sw, err := cdb.ShardedWriter(4, path)
+	if err != nil {
+		t.Fatalf("ShardedWriter failed: %s", err)
+	}
+	for i := 0; i < 1000; i++ {
+		k, v := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+		if err := sw.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Put %s failed: %s", k, err)
+		}
+	}
+
+	sr, err := sw.Freeze()
+	if err != nil {
+		t.Fatalf("Freeze failed: %s", err)
+	}
+	defer sr.Close()
+
+	if sr.Len() != 1000 {
+		t.Fatalf("Sharded db has %d records, exp 1000", sr.Len())
+	}
+
+	// every shard gets a share of the keys
+	for i, db := range sr.Shards() {
+		if n := db.Len(); n < 150 {
+			t.Fatalf("Shard %d has only %d records", i, n)
+		}
+	}
+
+	rd, err := cdb.OpenSharded(4, path)
+	if err != nil {
+		t.Fatalf("OpenSharded failed: %s", err)
+	}
+	defer rd.Close()
+
+	for i := 0; i < 1000; i++ {
+		k, exp := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+		v, err := rd.Get([]byte(k))
+		if err != nil || string(v) != exp {
+			t.Fatalf("Get %s: exp %s, saw %s, %v", k, exp, v, err)
+		}
+	}
+
+	if _, ok, err := rd.Lookup([]byte("not there")); ok || err != nil {
+		t.Fatalf("Lookup of missing key: %v, %v", ok, err)
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	path := func(i int) string {
+		return fmt.Sprintf("./test/multiget-%d.cdb", i)
+	}
+
+	sw, err := cdb.ShardedWriter(4, path)
+	if err != nil {
+		t.Fatalf("ShardedWriter failed: %s", err)
+	}
+	for i := 0; i < 1000; i++ {
+		k, v := fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)
+		if err := sw.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatalf("Put %s failed: %s", k, err)
+		}
+	}
+
+	sr, err := sw.Freeze(cdb.WithMultiGetWorkers(2))
+	if err != nil {
+		t.Fatalf("Freeze failed: %s", err)
+	}
+	defer sr.Close()
+
+	var keys [][]byte
+	for i := 999; i >= 0; i -= 3 {
+		keys = append(keys, []byte(fmt.Sprintf("key-%d", i)))
+	}
+	keys = append(keys, []byte("not there"))
+
+	res := sr.MultiGet(keys)
+	if len(res) != len(keys) {
+		t.Fatalf("MultiGet returned %d results for %d keys", len(res), len(keys))
+	}
+	for i, r := range res[:len(res)-1] {
+		exp := "value-" + string(keys[i][len("key-"):])
+		if r.Err != nil || !r.Found || string(r.Value) != exp {
+			t.Fatalf("MultiGet %s: exp %s, saw %s, %v, %v", keys[i], exp, r.Value, r.Found, r.Err)
+		}
+	}
+	if r := res[len(res)-1]; r.Found || r.Err != nil {
+		t.Fatalf("MultiGet of missing key: %v, %v", r.Found, r.Err)
+	}
+
+	// a failing shard fails only its own keys
+	sr.Shards()[1].Close()
+	var failed int
+	for i, r := range sr.MultiGet(keys) {
+		if r.Err != nil {
+			if !errors.Is(r.Err, cdb.ErrClosed) {
+				t.Fatalf("MultiGet %s: %v", keys[i], r.Err)
+			}
+			failed++
+		} else if !r.Found && i < len(keys)-1 {
+			t.Fatalf("MultiGet %s: not found", keys[i])
+		}
+	}
+	if failed == 0 || failed == len(keys) {
+		t.Fatalf("MultiGet with a closed shard: %d of %d keys failed", failed, len(keys))
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	var out [][]byte
+	for _, n := range []int{0, 1, 1 << 20} {
+		wr, err := cdb.Create("./test/bufsize.cdb", cdb.WithBufferSize(n))
+		if err != nil {
+			t.Fatalf("Can't create bufsize.cdb: %s", err)
+		}
+		for i := 0; i < 10000; i++ {
+			k := fmt.Sprintf("key-%d", i)
+			if err := wr.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatalf("Put %s failed: %s", k, err)
+			}
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		b, err := os.ReadFile("./test/bufsize.cdb")
+		if err != nil {
+			t.Fatalf("Can't read bufsize.cdb: %s", err)
+		}
+		out = append(out, b)
+	}
+
+	// the buffer size doesn't change the output
+	for i := 1; i < len(out); i++ {
+		if !bytes.Equal(out[0], out[i]) {
+			t.Fatalf("Output %d differs", i)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 100; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), fmt.Sprintf("%08d", i)})
+	}
+
+	for _, c := range []cdb.Checksum{cdb.ChecksumSHA256, cdb.ChecksumCRC64, cdb.ChecksumNone, cdb.ChecksumXXH3} {
+		for _, expiry := range []bool{false, true} {
+			fn := fmt.Sprintf("./test/patch-%s-%v.cdb", c, expiry)
+			opts := []cdb.Option{cdb.WithChecksum(c)}
+			if expiry {
+				opts = append(opts, cdb.WithExpiry())
+			}
+			makeDBAt(t, fn, recs, opts...)
+
+			p, err := cdb.OpenPatcher(fn)
+			if err != nil {
+				t.Fatalf("%s: can't open patcher: %s", fn, err)
+			}
+
+			for i := 0; i < len(recs); i += 3 {
+				k, v := []byte(recs[i].key), []byte(fmt.Sprintf("%08d", -i))
+				if err := p.Patch(k, v); err != nil {
+					t.Fatalf("%s: can't patch %s: %s", fn, k, err)
+				}
+				if got, err := p.Get(k); err != nil || !bytes.Equal(got, v) {
+					t.Fatalf("%s: %s: exp %q after patch, saw %q, %v", fn, k, v, got, err)
+				}
+			}
+
+			// a CRC64 checksum is kept up to date by every patch
+			if c == cdb.ChecksumCRC64 {
+				db, err := cdb.Open(fn)
+				if err != nil {
+					t.Fatalf("%s: can't open while patching: %s", fn, err)
+				}
+				db.Close()
+			}
+
+			off, ok, err := p.RecordOffset([]byte("key-1"))
+			if err != nil || !ok {
+				t.Fatalf("%s: can't find key-1: %v, %v", fn, ok, err)
+			}
+			if err := p.PatchValueAt(off, []byte("short")); !errors.Is(err, cdb.ErrNotPatchable) {
+				t.Fatalf("%s: patch with a shorter value: exp ErrNotPatchable, saw %v", fn, err)
+			}
+			if err := p.PatchValueAt(off+1, []byte("00000000")); err == nil {
+				t.Fatalf("%s: patched a value at a bad offset", fn)
+			}
+
+			if err := p.Close(); err != nil {
+				t.Fatalf("%s: can't close patcher: %s", fn, err)
+			}
+
+			db, err := cdb.Open(fn)
+			if err != nil {
+				t.Fatalf("%s: can't open after patching: %s", fn, err)
+			}
+			for i, r := range recs {
+				exp := r.val
+				if i%3 == 0 {
+					exp = fmt.Sprintf("%08d", -i)
+				}
+				if v, err := db.Get([]byte(r.key)); err != nil || string(v) != exp {
+					t.Fatalf("%s: %s: exp %q, saw %q, %v", fn, r.key, exp, v, err)
+				}
+			}
+			db.Close()
+		}
+	}
+
+	makeDBAt(t, "./test/patch-front.cdb", recs, cdb.WithFrontCoding())
+	if _, err := cdb.OpenPatcher("./test/patch-front.cdb"); !errors.Is(err, cdb.ErrNotPatchable) {
+		t.Fatalf("front coded database: exp ErrNotPatchable, saw %v", err)
+	}
+}
+
+// TestPatchClose closes a Patcher while values are being patched; every
+// patch must either land or fail with ErrClosed, never write to a
+// closed file.
+func TestPatchClose(t *testing.T) {
+	var recs []kw
+	for i := 0; i < 100; i++ {
+		recs = append(recs, kw{fmt.Sprintf("key-%d", i), fmt.Sprintf("%08d", i)})
+	}
+
+	fn := "./test/patch-close.cdb"
+	for round := 0; round < 20; round++ {
+		makeDBAt(t, fn, recs, cdb.WithChecksum(cdb.ChecksumCRC64))
+
+		p, err := cdb.OpenPatcher(fn)
+		if err != nil {
+			t.Fatalf("Can't open patcher: %s", err)
+		}
+
+		errs := make(chan error, 4)
+		for g := 0; g < 4; g++ {
+			go func(g int) {
+				for i := 0; ; i++ {
+					k := []byte(recs[i%len(recs)].key)
+					if err := p.Patch(k, []byte(fmt.Sprintf("%08d", -g))); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(g)
+		}
+
+		time.Sleep(time.Millisecond)
+		if err := p.Close(); err != nil {
+			t.Fatalf("Can't close patcher: %s", err)
+		}
+		for g := 0; g < 4; g++ {
+			if err := <-errs; !errors.Is(err, cdb.ErrClosed) {
+				t.Fatalf("Patch after close: exp ErrClosed, saw %v", err)
+			}
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	fn := "./test/schema.cdb"
+	wr, err := cdb.Create(fn, cdb.WithSchema(cdb.ValidJSON), cdb.WithExpiry())
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+
+	if err := wr.Put([]byte("good"), []byte(`{"a": 1}`)); err != nil {
+		t.Fatalf("Can't put valid JSON: %s", err)
+	}
+
+	var se *cdb.SchemaError
+	bad := []byte(`{"a": `)
+	puts := map[string]func() error{
+		"Put":       func() error { return wr.Put([]byte("bad"), bad) },
+		"PutTTL":    func() error { return wr.PutTTL([]byte("bad"), bad, time.Time{}) },
+		"PutHashed": func() error { return wr.PutHashed(cdb.Hash32([]byte("bad")), []byte("bad"), bad) },
+		"PutReader": func() error { return wr.PutReader([]byte("bad"), uint32(len(bad)), bytes.NewReader(bad)) },
+	}
+	for name, put := range puts {
+		if err := put(); !errors.As(err, &se) || string(se.Key) != "bad" {
+			t.Fatalf("%s of invalid JSON: exp a SchemaError for key bad, saw %v", name, err)
+		}
+	}
+
+	// tombstones aren't values
+	if err := wr.Delete([]byte("gone")); err != nil {
+		t.Fatalf("Can't delete: %s", err)
+	}
+
+	v := []byte(`[1, 2]`)
+	if err := wr.PutReader([]byte("read"), uint32(len(v)), bytes.NewReader(v)); err != nil {
+		t.Fatalf("Can't put valid JSON with PutReader: %s", err)
+	}
+
+	db, err := wr.Freeze()
+	if err != nil {
+		t.Fatalf("Can't freeze %s: %s", fn, err)
+	}
+	defer db.Close()
+
+	if n := db.Len(); n != 2 {
+		t.Fatalf("Exp 2 records, saw %d", n)
+	}
+	if v, ok, err := db.Lookup([]byte("bad")); ok || err != nil {
+		t.Fatalf("Rejected value was written: %q, %v", v, err)
+	}
+	if v, err := db.Get([]byte("read")); err != nil || string(v) != "[1, 2]" {
+		t.Fatalf("read: exp [1, 2], saw %q, %v", v, err)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	// enough entries to spill twice
+	const n = 600000
+
+	dir := t.TempDir()
+	build := func(fn string, opts ...cdb.Option) []byte {
+		wr, err := cdb.Create(fn, opts...)
+		if err != nil {
+			t.Fatalf("Can't create %s: %s", fn, err)
+		}
+
+		for i := 0; i < n; i++ {
+			k := fmt.Sprintf("key-%d", i%(n-1000))
+			if err := wr.Put([]byte(k), []byte(fmt.Sprintf("val-%d", i))); err != nil {
+				t.Fatalf("Can't put key %s: %s", k, err)
+			}
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatalf("Can't close %s: %s", fn, err)
+		}
+
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("Can't read %s: %s", fn, err)
+		}
+		return b
+	}
+
+	a := build("./test/spill-a.cdb", cdb.WithBloom(10))
+	b := build("./test/spill-b.cdb", cdb.WithBloom(10), cdb.WithSpill(dir))
+	if !bytes.Equal(a, b) {
+		t.Fatalf("Spilled build differs")
+	}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", dir, err)
+	}
+	if len(ents) != 0 {
+		t.Fatalf("Spill files left behind: %d", len(ents))
+	}
+
+	db, err := cdb.Open("./test/spill-b.cdb")
+	if err != nil {
+		t.Fatalf("Can't open: %s", err)
+	}
+	defer db.Close()
+
+	// the duplicates must be found in the order they were written
+	vals, err := db.GetAll([]byte("key-7"))
+	if err != nil {
+		t.Fatalf("GetAll: %s", err)
+	}
+	want := [][]byte{[]byte("val-7"), []byte(fmt.Sprintf("val-%d", n-1000+7))}
+	if !reflect.DeepEqual(vals, want) {
+		t.Fatalf("GetAll: got %q, want %q", vals, want)
+	}
+
+	if _, err := cdb.Create("./test/spill-c.cdb", cdb.WithSpill(dir), cdb.WithMPH()); err == nil {
+		t.Fatalf("WithSpill and WithMPH accepted together")
+	}
+}
+
+func TestExternalSort(t *testing.T) {
+	// enough entries to spill twice
+	const n = 600000
+	const dups = 1000
+
+	fn := "./test/extsort.cdb"
+	dir := t.TempDir()
+	wr, err := cdb.Create(fn, cdb.WithExternalSort(dir), cdb.WithBloom(10))
+	if err != nil {
+		t.Fatalf("Can't create %s: %s", fn, err)
+	}
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("key-%d", i%(n-dups))
+		if err := wr.Put([]byte(k), []byte(fmt.Sprintf("val-%d", i))); err != nil {
+			t.Fatalf("Can't put key %s: %s", k, err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Can't close %s: %s", fn, err)
+	}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Can't read %s: %s", dir, err)
+	}
+	if len(ents) != 0 {
+		t.Fatalf("Spill files left behind: %d", len(ents))
+	}
+
+	db, err := cdb.Open(fn)
+	if err != nil {
+		t.Fatalf("Can't open: %s", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < n-dups; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		v, err := db.Get([]byte(k))
+		if err != nil {
+			t.Fatalf("Get %s: %s", k, err)
+		}
+		if want := fmt.Sprintf("val-%d", i); string(v) != want {
+			t.Fatalf("Get %s: got %q, want %q", k, v, want)
+		}
+	}
+
+	vals, err := db.GetAll([]byte("key-7"))
+	if err != nil {
+		t.Fatalf("GetAll: %s", err)
+	}
+	want := [][]byte{[]byte("val-7"), []byte(fmt.Sprintf("val-%d", n-dups+7))}
+	if !reflect.DeepEqual(vals, want) {
+		t.Fatalf("GetAll: got %q, want %q", vals, want)
+	}
+
+	if v, ok, err := db.Lookup([]byte("missing")); err != nil || ok {
+		t.Fatalf("Lookup missing: got %q, %v", v, err)
+	}
+}